@@ -1,8 +1,27 @@
 import React, { createContext, useContext, useState, useEffect, useCallback, useRef } from 'react';
 import { DeviceEventEmitter, Platform, AppState, AppStateStatus } from 'react-native';
-import { EcoWebSocket, NativeClipboard, NativeCall, ecoEventEmitter, isNativeAvailable } from '@/services';
+import { EcoWebSocket, NativeClipboard, NativeCall, NativeNotifications, ecoEventEmitter, isNativeAvailable } from '@/services';
 import { requestAndroidPermissions } from '@/services/permissions';
-import type { ConnectionState, LogEntry, Message, ClipboardPayload, ClipboardEvent, CallStateEvent, NotificationEvent } from '@/types';
+import type {
+  ConnectionState,
+  LogEntry,
+  Message,
+  MessageType,
+  ClipboardPayload,
+  ClipboardEvent,
+  CallStateEvent,
+  NotificationEvent,
+  NotificationRemovedEvent,
+  NotificationDismissPayload,
+  NotificationReplyPayload,
+} from '@/types';
+
+// The message sent to the desktop for each phone call state
+const callMessageTypes: Record<CallStateEvent['state'], MessageType> = {
+  ringing: 'call.incoming',
+  offhook: 'call.answer',
+  idle: 'call.hangup',
+};
 
 const getDefaultUrl = () => {
   if (Platform.OS === 'android') {
@@ -37,6 +56,9 @@ export function EcoProvider({ children }: { children: React.ReactNode }) {
   const [secret, setSecret] = useState('');
   const [nativeEnabled, setNativeEnabled] = useState(false);
   const wsRef = useRef<EcoWebSocket | null>(null);
+  const lastClipboardRef = useRef<string>('');
+  // Keys of notifications the desktop dismissed, so their removal isn't sent back
+  const dismissedRef = useRef<Set<string>>(new Set());
 
   const setConfig = useCallback((url: string, devId: string, sec: string) => {
     setServerUrl(url);
@@ -116,9 +138,40 @@ export function EcoProvider({ children }: { children: React.ReactNode }) {
     ws.onLog((log) => setLogs((prev) => [log, ...prev].slice(0, 100)));
     ws.onMessage((message) => {
       console.log('Received message:', message.type);
-      if (message.type === 'clipboard.set') {
+      if (message.type === 'clipboard.set' || message.type === 'clipboard.changed') {
         const payload = message.payload as ClipboardPayload;
-        NativeClipboard.setText(payload.data);
+        if (payload.transfer_id) {
+          // Too large to send inline; the image arrives as a transfer
+          console.log('Desktop copied an image sent as transfer', payload.transfer_id);
+        } else if (payload.encoding === 'base64' && payload.mime?.startsWith('image/')) {
+          NativeClipboard.setImage(payload.data);
+        } else if (payload.mime === 'text/uri-list') {
+          // Files on the desktop can't be pasted here; they arrive as transfers
+          console.log('Desktop copied files:', payload.files?.map((f) => f.name).join(', '));
+        } else {
+          // Remember it first so the clipboard poll doesn't send it straight back
+          lastClipboardRef.current = payload.data;
+          NativeClipboard.setText(payload.data);
+        }
+      }
+      if (message.type === 'clipboard.get') {
+        NativeClipboard.getText().then((text) => ws.reply(message, 'clipboard.content', { data: text }));
+      }
+      if (message.type === 'call.answer') {
+        NativeCall.answerCall();
+      }
+      if (message.type === 'call.hangup') {
+        NativeCall.rejectCall();
+      }
+      if (message.type === 'notification.dismiss') {
+        const payload = message.payload as NotificationDismissPayload;
+        // Remember it first so the removal isn't reported straight back
+        dismissedRef.current.add(payload.id);
+        NativeNotifications.cancel(payload.id);
+      }
+      if (message.type === 'notification.reply') {
+        const payload = message.payload as NotificationReplyPayload;
+        NativeNotifications.reply(payload.id, payload.text);
       }
     });
 
@@ -144,8 +197,6 @@ export function EcoProvider({ children }: { children: React.ReactNode }) {
     return wsRef.current.send('clipboard.changed', { data: text });
   }, [state]);
 
-  const lastClipboardRef = useRef<string>('');
-
   useEffect(() => {
     if (state !== 'connected') return;
 
@@ -173,9 +224,11 @@ export function EcoProvider({ children }: { children: React.ReactNode }) {
     if (!isNativeAvailable || !ecoEventEmitter) return;
 
     const callSub = ecoEventEmitter.addListener('EcoCallStateChanged', (event: CallStateEvent) => {
-      addLog('call.incoming', 'sent', { number: event.phoneNumber ?? '' });
+      // The desktop shows ringing calls and withdraws them once answered or over
+      const type = callMessageTypes[event.state];
+      addLog(type, 'sent', { number: event.phoneNumber ?? '' });
       if (wsRef.current && state === 'connected') {
-        wsRef.current.send('call.incoming', { number: event.phoneNumber ?? '' });
+        wsRef.current.send(type, { number: event.phoneNumber ?? '' });
       }
     });
 
@@ -183,16 +236,26 @@ export function EcoProvider({ children }: { children: React.ReactNode }) {
       addLog('notification.push', 'sent', { app: event.packageName, title: event.title, body: event.text });
       if (wsRef.current && state === 'connected') {
         wsRef.current.send('notification.push', {
+          id: event.key,
           app: event.packageName,
           title: event.title,
           body: event.text,
+          replyable: event.replyable,
         });
       }
     });
 
+    const removedSub = DeviceEventEmitter.addListener('EcoNotificationRemoved', (event: NotificationRemovedEvent) => {
+      if (dismissedRef.current.delete(event.key)) return;
+      if (wsRef.current && state === 'connected') {
+        wsRef.current.send('notification.dismiss', { id: event.key });
+      }
+    });
+
     return () => {
       callSub.remove();
       notifSub.remove();
+      removedSub.remove();
     };
   }, [state, addLog]);
 
@@ -0,0 +1,234 @@
+// XChaCha20-Poly1305 payload encryption, matching crypto.PayloadCipher on the daemon.
+// Like hmac.ts this is plain TypeScript because React Native has no WebCrypto.
+
+import { hmacSha256, utf8, fromHex, toHex } from './hmac';
+import type { Message, SealedPayload } from '@/types';
+
+// The cleartext message fields bound into the seal
+export type Envelope = Pick<Message, 'type' | 'device_id' | 'id' | 'seq' | 'ts' | 'reply_to'>;
+
+export const CIPHER_XCHACHA20POLY1305 = 'xchacha20poly1305';
+export const SESSION_NONCE_SIZE = 32;
+
+const SESSION_KEY_CONTEXT = 'eco-payload-v1';
+
+// Returns null when the runtime has no secure random source; the client then
+// doesn't offer encryption rather than sealing with predictable nonces.
+export function randomBytes(n: number): Uint8Array | null {
+  const c = (globalThis as { crypto?: { getRandomValues?: (a: Uint8Array) => Uint8Array } }).crypto;
+  if (!c?.getRandomValues) {
+    return null;
+  }
+  return c.getRandomValues(new Uint8Array(n));
+}
+
+function concat(...parts: Uint8Array[]): Uint8Array {
+  const out = new Uint8Array(parts.reduce((n, p) => n + p.length, 0));
+  let off = 0;
+  for (const p of parts) {
+    out.set(p, off);
+    off += p.length;
+  }
+  return out;
+}
+
+export function hkdfSha256(ikm: Uint8Array, salt: Uint8Array, info: Uint8Array, length: number): Uint8Array {
+  const prk = hmacSha256(salt, ikm);
+  const out = new Uint8Array(length);
+  let prev = new Uint8Array(0);
+  for (let i = 1, off = 0; off < length; i++) {
+    prev = hmacSha256(prk, concat(prev, info, new Uint8Array([i])));
+    out.set(prev.subarray(0, Math.min(prev.length, length - off)), off);
+    off += prev.length;
+  }
+  return out;
+}
+
+// --- ChaCha20 (RFC 8439) ---
+
+const SIGMA = [0x61707865, 0x3320646e, 0x79622d32, 0x6b206574];
+
+const rotl = (x: number, n: number) => (x << n) | (x >>> (32 - n));
+
+function rounds(s: Uint32Array) {
+  const qr = (a: number, b: number, c: number, d: number) => {
+    s[a] += s[b]; s[d] = rotl(s[d] ^ s[a], 16);
+    s[c] += s[d]; s[b] = rotl(s[b] ^ s[c], 12);
+    s[a] += s[b]; s[d] = rotl(s[d] ^ s[a], 8);
+    s[c] += s[d]; s[b] = rotl(s[b] ^ s[c], 7);
+  };
+  for (let i = 0; i < 10; i++) {
+    qr(0, 4, 8, 12); qr(1, 5, 9, 13); qr(2, 6, 10, 14); qr(3, 7, 11, 15);
+    qr(0, 5, 10, 15); qr(1, 6, 11, 12); qr(2, 7, 8, 13); qr(3, 4, 9, 14);
+  }
+}
+
+function initState(key: Uint8Array, words: Uint8Array, counter?: number): Uint32Array {
+  const s = new Uint32Array(16);
+  const kv = new DataView(key.buffer, key.byteOffset, key.byteLength);
+  const nv = new DataView(words.buffer, words.byteOffset, words.byteLength);
+  s.set(SIGMA);
+  for (let i = 0; i < 8; i++) s[4 + i] = kv.getUint32(i * 4, true);
+  if (counter === undefined) {
+    // HChaCha20: 16-byte nonce fills words 12..15
+    for (let i = 0; i < 4; i++) s[12 + i] = nv.getUint32(i * 4, true);
+  } else {
+    s[12] = counter;
+    for (let i = 0; i < 3; i++) s[13 + i] = nv.getUint32(i * 4, true);
+  }
+  return s;
+}
+
+function chachaBlock(key: Uint8Array, nonce: Uint8Array, counter: number): Uint8Array {
+  const init = initState(key, nonce, counter);
+  const s = init.slice();
+  rounds(s);
+  const out = new Uint8Array(64);
+  const ov = new DataView(out.buffer);
+  for (let i = 0; i < 16; i++) ov.setUint32(i * 4, (s[i] + init[i]) >>> 0, true);
+  return out;
+}
+
+function hchacha20(key: Uint8Array, nonce: Uint8Array): Uint8Array {
+  const s = initState(key, nonce);
+  rounds(s);
+  const out = new Uint8Array(32);
+  const ov = new DataView(out.buffer);
+  for (let i = 0; i < 4; i++) {
+    ov.setUint32(i * 4, s[i], true);
+    ov.setUint32(16 + i * 4, s[12 + i], true);
+  }
+  return out;
+}
+
+function chacha20Xor(key: Uint8Array, nonce: Uint8Array, counter: number, data: Uint8Array): Uint8Array {
+  const out = new Uint8Array(data.length);
+  for (let off = 0; off < data.length; off += 64, counter++) {
+    const block = chachaBlock(key, nonce, counter);
+    for (let i = 0; i < 64 && off + i < data.length; i++) out[off + i] = data[off + i] ^ block[i];
+  }
+  return out;
+}
+
+// --- Poly1305 ---
+
+const P1305 = (1n << 130n) - 5n;
+
+function leToBigInt(b: Uint8Array): bigint {
+  let n = 0n;
+  for (let i = b.length - 1; i >= 0; i--) n = (n << 8n) | BigInt(b[i]);
+  return n;
+}
+
+function poly1305(key: Uint8Array, msg: Uint8Array): Uint8Array {
+  const r = leToBigInt(key.subarray(0, 16)) & 0x0ffffffc0ffffffc0ffffffc0fffffffn;
+  const s = leToBigInt(key.subarray(16, 32));
+  let acc = 0n;
+  for (let off = 0; off < msg.length; off += 16) {
+    const block = msg.subarray(off, Math.min(off + 16, msg.length));
+    acc = ((acc + leToBigInt(block) + (1n << BigInt(8 * block.length))) * r) % P1305;
+  }
+  acc = (acc + s) & ((1n << 128n) - 1n);
+  const tag = new Uint8Array(16);
+  for (let i = 0; i < 16; i++) {
+    tag[i] = Number(acc & 0xffn);
+    acc >>= 8n;
+  }
+  return tag;
+}
+
+function le64(n: number): Uint8Array {
+  const b = new Uint8Array(8);
+  new DataView(b.buffer).setBigUint64(0, BigInt(n), true);
+  return b;
+}
+
+const pad16 = (n: number) => new Uint8Array((16 - (n % 16)) % 16);
+
+function macData(ad: Uint8Array, ct: Uint8Array): Uint8Array {
+  return concat(ad, pad16(ad.length), ct, pad16(ct.length), le64(ad.length), le64(ct.length));
+}
+
+// --- XChaCha20-Poly1305 ---
+
+function subkey(key: Uint8Array, nonce: Uint8Array): [Uint8Array, Uint8Array] {
+  const chachaNonce = new Uint8Array(12);
+  chachaNonce.set(nonce.subarray(16, 24), 4);
+  return [hchacha20(key, nonce.subarray(0, 16)), chachaNonce];
+}
+
+export function xchachaSeal(key: Uint8Array, nonce: Uint8Array, plaintext: Uint8Array, ad: Uint8Array): Uint8Array {
+  const [k, n] = subkey(key, nonce);
+  const polyKey = chachaBlock(k, n, 0).subarray(0, 32);
+  const ct = chacha20Xor(k, n, 1, plaintext);
+  return concat(ct, poly1305(polyKey, macData(ad, ct)));
+}
+
+export function xchachaOpen(key: Uint8Array, nonce: Uint8Array, sealed: Uint8Array, ad: Uint8Array): Uint8Array | null {
+  if (sealed.length < 16) return null;
+  const [k, n] = subkey(key, nonce);
+  const ct = sealed.subarray(0, sealed.length - 16);
+  const expected = poly1305(chachaBlock(k, n, 0).subarray(0, 32), macData(ad, ct));
+  let diff = 0;
+  for (let i = 0; i < 16; i++) diff |= expected[i] ^ sealed[ct.length + i];
+  if (diff !== 0) return null;
+  return chacha20Xor(k, n, 1, ct);
+}
+
+// --- Payload envelope ---
+
+const toBase64 = (b: Uint8Array) => btoa(String.fromCharCode(...b));
+const fromBase64 = (s: string) => Uint8Array.from(atob(s), (c) => c.charCodeAt(0));
+
+function be64(n: number): Uint8Array {
+  const b = new Uint8Array(8);
+  new DataView(b.buffer).setBigUint64(0, BigInt(n));
+  return b;
+}
+
+// Matches Message.header on the daemon: type, device_id, id and reply_to each
+// followed by a zero byte, then seq, ts and the counter as big-endian u64
+function additionalData(env: Envelope, counter: number): Uint8Array {
+  const zero = new Uint8Array([0]);
+  const fields = [env.type, env.device_id, env.id ?? '', env.reply_to ?? ''];
+  return concat(...fields.flatMap((f) => [utf8(f), zero]), be64(env.seq ?? 0), be64(env.ts ?? 0), be64(counter));
+}
+
+// Device end of a session: keys come from the shared secret and the nonces
+// exchanged in device.hello, one key per direction.
+export class PayloadCipher {
+  private sendKey: Uint8Array;
+  private recvKey: Uint8Array;
+  private sendCounter = 0;
+  private recvCounter = 0;
+
+  constructor(secret: string, deviceId: string, deviceNonceHex: string, daemonNonceHex: string) {
+    const salt = concat(fromHex(deviceNonceHex), fromHex(daemonNonceHex));
+    const keys = hkdfSha256(utf8(secret), salt, utf8(SESSION_KEY_CONTEXT + deviceId), 64);
+    this.sendKey = keys.slice(0, 32);
+    this.recvKey = keys.slice(32);
+  }
+
+  seal(env: Envelope, payload: unknown): SealedPayload | null {
+    const nonce = randomBytes(24);
+    if (!nonce) return null;
+    const counter = ++this.sendCounter;
+    const plaintext = utf8(JSON.stringify(payload ?? null));
+    const ct = xchachaSeal(this.sendKey, nonce, plaintext, additionalData(env, counter));
+    return { counter, nonce: toHex(nonce), ciphertext: toBase64(ct) };
+  }
+
+  // Returns the decoded payload, or undefined if the message is forged or replayed
+  open(env: Envelope, sealed: SealedPayload): unknown {
+    if (sealed.counter <= this.recvCounter) return undefined;
+    const plaintext = xchachaOpen(
+      this.recvKey,
+      fromHex(sealed.nonce),
+      fromBase64(sealed.ciphertext),
+      additionalData(env, sealed.counter)
+    );
+    if (!plaintext) return undefined;
+    this.recvCounter = sealed.counter;
+    return plaintext.length ? JSON.parse(new TextDecoder().decode(plaintext)) : null;
+  }
+}
@@ -11,7 +11,12 @@ interface EcoCallInterface {
   removeListeners(count: number): void;
 }
 
-const { EcoCall } = NativeModules;
+interface EcoNotificationsInterface {
+  cancel(key: string): Promise<boolean>;
+  reply(key: string, text: string): Promise<boolean>;
+}
+
+const { EcoCall, EcoNotifications } = NativeModules;
 
 console.log('NativeModules.EcoCall:', NativeModules.EcoCall);
 
@@ -37,6 +42,16 @@ export const NativeClipboard = {
       return false;
     }
   },
+  // base64 is the image without a data: prefix
+  setImage: async (base64: string): Promise<boolean> => {
+    try {
+      await Clipboard.setImageAsync(base64);
+      return true;
+    } catch (e) {
+      console.error('Clipboard setImage error:', e);
+      return false;
+    }
+  },
   getText: async (): Promise<string> => {
     try {
       const text = await Clipboard.getStringAsync();
@@ -86,6 +101,21 @@ export const NativeCall = {
   },
 };
 
+const NotificationsModule = EcoNotifications as EcoNotificationsInterface | undefined;
+
+export const NativeNotifications = {
+  // Dismisses the posted notification with key
+  cancel: async (key: string): Promise<boolean> => {
+    if (!NotificationsModule) return false;
+    return NotificationsModule.cancel(key);
+  },
+  // Answers the notification with key through its RemoteInput action
+  reply: async (key: string, text: string): Promise<boolean> => {
+    if (!NotificationsModule) return false;
+    return NotificationsModule.reply(key, text);
+  },
+};
+
 export type ClipboardEvent = {
   text: string;
   timestamp: number;
@@ -93,9 +123,18 @@ export type ClipboardEvent = {
 };
 
 export type NotificationEvent = {
+  // StatusBarNotification key, stable while the notification is posted
+  key?: string;
   packageName: string;
   title: string;
   text: string;
+  // The notification has a RemoteInput reply action
+  replyable?: boolean;
+  timestamp: number;
+};
+
+export type NotificationRemovedEvent = {
+  key: string;
   timestamp: number;
 };
 
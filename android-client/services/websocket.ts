@@ -1,11 +1,52 @@
-import { DeviceEventEmitter } from 'react-native';
-import type { Message, ConnectionState, LogEntry, MessageType } from '@/types';
+import { DeviceEventEmitter, Platform } from 'react-native';
+import Constants from 'expo-constants';
+import type {
+  Capability,
+  Message,
+  ConnectionState,
+  LogEntry,
+  MessageType,
+  AuthChallengePayload,
+  AuthResultPayload,
+  DevicePayload,
+  DisconnectPayload,
+} from '@/types';
+import { computeAuthProof, toHex } from './hmac';
+import { CIPHER_XCHACHA20POLY1305, PayloadCipher, SESSION_NONCE_SIZE, randomBytes } from './aead';
 
 type MessageHandler = (message: Message) => void;
 type StateHandler = (state: ConnectionState) => void;
 
 const generateId = () => Math.random().toString(36).substring(2, 15);
 
+// Message ids match the daemon's: 16 hex characters
+const messageId = () => {
+  const b = randomBytes(8);
+  return b ? toHex(b) : generateId();
+};
+
+export const PROTOCOL_VERSION = 1;
+
+const CAPABILITIES: Capability[] = ['clipboard', 'notifications', 'calls'];
+
+// What a daemon from before version negotiation supports
+const LEGACY_CAPABILITIES: Capability[] = ['clipboard', 'notifications', 'calls'];
+
+const CAPABILITY_PREFIXES: Record<string, Capability> = {
+  clipboard: 'clipboard',
+  notification: 'notifications',
+  call: 'calls',
+  file: 'files',
+  input: 'input',
+};
+
+// The capability a message type belongs to; handshake, ping and ack have none
+export const capabilityOf = (type: MessageType): Capability | undefined =>
+  CAPABILITY_PREFIXES[type.split('.')[0]];
+
+// Requests are answered by a response from the app rather than an ack
+const REQUEST_TYPES: ReadonlySet<MessageType> = new Set<MessageType>(['clipboard.get']);
+
 export class EcoWebSocket {
   private ws: WebSocket | null = null;
   private url: string;
@@ -22,6 +63,10 @@ export class EcoWebSocket {
   private _state: ConnectionState = 'disconnected';
   private isConnecting = false;
   private shouldReconnect = false;
+  private cipher: PayloadCipher | null = null;
+  private helloNonce: string | null = null;
+  private sendSeq = 0;
+  private capabilities: Capability[] = [];
 
   constructor(url: string, deviceId: string, secret: string, deviceName: string) {
     this.url = url;
@@ -71,21 +116,84 @@ export class EcoWebSocket {
       try {
         this.ws = new WebSocket(this.url);
 
+        this.sendSeq = 0;
         this.ws.onopen = () => {
-          console.log('WebSocket opened');
-          this.isConnecting = false;
-          this.reconnectAttempts = 0;
-          this.sendHello();
-          this.startPing();
-          this.setState('connected');
-          this.setupNativeListeners();
-          resolve();
+          // Wait for auth.challenge before sending anything else
+          console.log('WebSocket opened, waiting for challenge');
         };
 
         this.ws.onmessage = (event) => {
           try {
             const message = JSON.parse(event.data) as Message;
+
+            if (message.sealed) {
+              const payload = this.cipher?.open(message, message.sealed);
+              if (payload === undefined) {
+                console.warn('Dropping message that failed to decrypt:', message.type);
+                return;
+              }
+              message.payload = payload;
+              delete message.sealed;
+            }
             this.addLog(message.type, 'received', message.payload);
+
+            if (message.type === 'auth.challenge') {
+              const { nonce } = message.payload as AuthChallengePayload;
+              this.sendRaw('auth.response', {
+                proof: computeAuthProof(this.secret, nonce, this.deviceId),
+              });
+              return;
+            }
+
+            if (message.type === 'auth.result') {
+              const result = message.payload as AuthResultPayload;
+              if (!result.ok) {
+                this.isConnecting = false;
+                this.shouldReconnect = false;
+                this.setState('error');
+                reject(new Error(result.error || 'Authentication failed'));
+                return;
+              }
+              this.sendHello();
+              return;
+            }
+
+            if (message.type === 'device.hello' && this._state !== 'connected') {
+              const hello = message.payload as DevicePayload;
+              if (this.helloNonce) {
+                // We offered encryption: a plain text answer is a downgrade, refuse it
+                if (!hello.encryption?.includes(CIPHER_XCHACHA20POLY1305) || !hello.nonce) {
+                  this.shouldReconnect = false;
+                  this.ws?.close();
+                  this.setState('error');
+                  reject(new Error('Server did not agree to encrypt the connection'));
+                  return;
+                }
+                this.cipher = new PayloadCipher(this.secret, this.deviceId, this.helloNonce, hello.nonce);
+              }
+              const offered = hello.protocol_version ? hello.capabilities ?? [] : LEGACY_CAPABILITIES;
+              this.capabilities = CAPABILITIES.filter((c) => offered.includes(c));
+              this.isConnecting = false;
+              this.reconnectAttempts = 0;
+              this.setState('connected');
+              this.startPing();
+              this.setupNativeListeners();
+              resolve();
+              return;
+            }
+
+            if (message.type === 'device.disconnect' && this._state !== 'connected') {
+              const { reason } = (message.payload ?? {}) as DisconnectPayload;
+              this.isConnecting = false;
+              this.shouldReconnect = false;
+              this.setState('error');
+              reject(new Error(reason || 'Connection refused'));
+              return;
+            }
+
+            if (message.id && !message.reply_to && message.type !== 'ack' && !REQUEST_TYPES.has(message.type)) {
+              this.reply(message, 'ack', { ok: true });
+            }
             this.messageHandlers.forEach((h) => h(message));
           } catch (e) {
             console.error('Failed to parse message:', e);
@@ -101,6 +209,8 @@ export class EcoWebSocket {
         this.ws.onclose = () => {
           console.log('WebSocket closed');
           this.isConnecting = false;
+          this.cipher = null;
+          this.capabilities = [];
           this.stopPing();
           this.setState('disconnected');
           
@@ -116,8 +226,28 @@ export class EcoWebSocket {
     });
   }
 
+  // Offers payload encryption with a fresh nonce; the daemon's device.hello
+  // answer completes the connection
   private sendHello() {
-    this.send('device.hello', { device_name: this.deviceName });
+    this.cipher = null;
+    const nonce = randomBytes(SESSION_NONCE_SIZE);
+    this.helloNonce = nonce ? toHex(nonce) : null;
+    if (!this.helloNonce) {
+      console.warn('No secure random source, connecting without payload encryption');
+    }
+
+    const hello: DevicePayload = {
+      device_name: this.deviceName,
+      protocol_version: PROTOCOL_VERSION,
+      app_version: Constants.expoConfig?.version,
+      platform: Platform.OS,
+      capabilities: CAPABILITIES,
+    };
+    if (this.helloNonce) {
+      hello.encryption = [CIPHER_XCHACHA20POLY1305];
+      hello.nonce = this.helloNonce;
+    }
+    this.sendRaw('device.hello', hello);
   }
 
   private startPing() {
@@ -143,17 +273,54 @@ export class EcoWebSocket {
     }
   }
 
+  // Whether both sides agreed on capability in the hello
+  supports(capability: Capability): boolean {
+    return this.capabilities.includes(capability);
+  }
+
   send<T>(type: MessageType, payload: T): boolean {
+    if (this._state !== 'connected') {
+      return false;
+    }
+    const capability = capabilityOf(type);
+    if (capability && !this.supports(capability)) {
+      return false;
+    }
+    return this.sendRaw(type, payload);
+  }
+
+  // Answers request with a message whose reply_to is the request's id
+  reply<T>(request: Message, type: MessageType, payload: T): boolean {
+    if (this._state !== 'connected' || !request.id) {
+      return false;
+    }
+    return this.sendRaw(type, payload, request.id);
+  }
+
+  private sendRaw<T>(type: MessageType, payload: T, replyTo?: string): boolean {
     if (!this.ws || this.ws.readyState !== WebSocket.OPEN) {
       return false;
     }
 
-    const message: Message<T> = {
+    const message: Message<T | null> = {
       type,
       device_id: this.deviceId,
-      secret: this.secret,
+      id: messageId(),
+      seq: ++this.sendSeq,
+      ts: Date.now(),
       payload,
     };
+    if (replyTo) {
+      message.reply_to = replyTo;
+    }
+    if (this.cipher) {
+      const sealed = this.cipher.seal(message, payload);
+      if (!sealed) {
+        return false;
+      }
+      message.payload = null;
+      message.sealed = sealed;
+    }
 
     try {
       this.ws.send(JSON.stringify(message));
@@ -169,15 +336,18 @@ export class EcoWebSocket {
     try {
       DeviceEventEmitter.addListener('EcoCallStateChanged', (event) => {
         console.log('Call state changed event received:', event);
-        this.send('call.incoming', { number: event.phoneNumber || '' });
+        const type = event.state === 'idle' ? 'call.hangup' : event.state === 'offhook' ? 'call.answer' : 'call.incoming';
+        this.send(type, { number: event.phoneNumber || '' });
       });
 
       DeviceEventEmitter.addListener('EcoNotificationPosted', (event) => {
         console.log('Notification posted event received:', event);
         this.send('notification.push', {
+          id: event.key,
           app: event.packageName,
           title: event.title,
           body: event.text,
+          replyable: event.replyable,
         });
       });
       console.log('Native event listeners setup complete');
@@ -2,36 +2,313 @@ export type MessageType =
   | 'clipboard.changed'
   | 'clipboard.set'
   | 'notification.push'
+  | 'notification.action'
+  | 'notification.closed'
+  | 'notification.dismiss'
+  | 'notification.reply'
   | 'call.incoming'
   | 'call.answer'
   | 'call.hangup'
+  | 'sms.received'
+  | 'sms.send'
+  | 'sms.sync'
+  | 'file.offer'
+  | 'file.accept'
+  | 'file.progress'
+  | 'file.cancel'
+  | 'input.key'
+  | 'input.text'
+  | 'input.mouse'
+  | 'input.button'
+  | 'input.scroll'
+  | 'mobile.open_app'
+  | 'mobile.open_url'
+  | 'mobile.ring'
+  | 'mobile.lock'
   | 'device.hello'
   | 'device.ping'
-  | 'device.disconnect';
+  | 'device.disconnect'
+  | 'auth.challenge'
+  | 'auth.response'
+  | 'auth.result'
+  | 'pair.start'
+  | 'pair.reply'
+  | 'pair.confirm'
+  | 'pair.complete'
+  | 'ack'
+  | 'clipboard.get'
+  | 'clipboard.content';
 
+// Messages never carry the shared secret. The connection is authenticated once
+// by answering auth.challenge with an HMAC proof (see AuthResponsePayload).
+//
+// id, seq, ts and reply_to are optional so older peers keep working. A message
+// with an id is answered with an ack, or a response, whose reply_to is that id.
+// seq counts the messages sent on one connection and ts is Unix milliseconds.
 export interface Message<T = unknown> {
   type: MessageType;
   device_id: string;
-  secret: string;
+  id?: string;
+  seq?: number;
+  ts?: number;
+  reply_to?: string;
   payload: T;
+  // Set instead of payload once the session negotiated encryption
+  sealed?: SealedPayload;
+}
+
+// XChaCha20-Poly1305 sealed payload: nonce is hex, ciphertext is base64.
+// The envelope fields and counter are authenticated with the ciphertext.
+export interface SealedPayload {
+  counter: number;
+  nonce: string;
+  ciphertext: string;
+}
+
+export interface AckPayload {
+  ok: boolean;
+  error?: string;
+}
+
+export interface ClipboardFile {
+  name: string;
+  size: number;
+  // File transfer carrying the file, offered with file.offer
+  transfer_id?: string;
 }
 
 export interface ClipboardPayload {
   data: string;
+  // MIME type of data, text/plain when missing (also text/uri-list, image/*)
+  mime?: string;
+  // 'base64' when data holds binary content such as an image
+  encoding?: 'base64';
+  // text/html version of text content
+  html?: string;
+  // Files listed by text/uri-list content
+  files?: ClipboardFile[];
+  // File transfer carrying an image too large to send inline, offered with
+  // file.offer; data is empty when set
+  transfer_id?: string;
 }
 
 export interface NotificationPayload {
   app: string;
   title: string;
   body: string;
+  icon?: string;
+  urgency?: 'low' | 'normal' | 'critical';
+  // The sender's stable key, e.g. the StatusBarNotification key; a push with
+  // the same id replaces the notification
+  id?: string;
+  // freedesktop category, e.g. 'im.received'
+  category?: string;
+  actions?: NotificationAction[];
+  // Set for messages that can be answered; replies come as notification.reply
+  replyable?: boolean;
+}
+
+export interface NotificationAction {
+  id: string;
+  label: string;
+}
+
+// Sent by the desktop as notification.action when an action is invoked and
+// as notification.closed, with a reason, when the notification goes away
+// without the user dismissing it
+export interface NotificationEventPayload {
+  id: string;
+  action?: string;
+  reason?: 'expired' | 'closed' | 'undefined';
+}
+
+// Sent both ways when the user dismisses a mirrored notification
+export interface NotificationDismissPayload {
+  id: string;
+}
+
+// Sent by the desktop with the reply typed into a replyable notification
+export interface NotificationReplyPayload {
+  id: string;
+  text: string;
 }
 
 export interface CallPayload {
   number: string;
 }
 
+// Sent as sms.received for every new message, received or sent, and in
+// batches in the sms.sync answer. time is in Unix milliseconds.
+export interface SMSMessage {
+  id: string;
+  thread_id: string;
+  address: string;
+  contact_name?: string;
+  body: string;
+  time: number;
+  sent?: boolean;
+  read?: boolean;
+}
+
+export interface SMSSendPayload {
+  address: string;
+  body: string;
+}
+
+// The desktop asks for the messages newer than since; the answer carries them
+export interface SMSSyncPayload {
+  since?: number;
+  messages?: SMSMessage[];
+}
+
+// The data of a transfer goes over HTTP. The device downloads files the
+// desktop offers with GET /download/{id} and uploads the ones it offered with
+// POST /upload/{id} once accepted, sending its device id in X-Eco-Device and
+// the token in "Authorization: Bearer <token>". Uploads may be split into
+// chunks with Content-Range; answers carry the received length in
+// Upload-Offset. Downloads resume with "Range: bytes=N-".
+//
+// A folder moves as a tar stream of its contents, named relative to the
+// folder and zstd compressed for 'tar+zstd'; size and sha256 describe the
+// stream.
+export interface FileOfferPayload {
+  id: string;
+  name: string;
+  size: number;
+  sha256: string;
+  // Set on offers from the desktop, for the download
+  token?: string;
+  // Set for folders
+  archive?: 'tar' | 'tar+zstd';
+  // The folder's files and their size before archiving
+  files?: number;
+  total_size?: number;
+}
+
+// From the desktop, carries the token for the upload and the offset to
+// resume from
+export interface FileAcceptPayload {
+  id: string;
+  token?: string;
+  offset?: number;
+}
+
+export interface FileProgressPayload {
+  id: string;
+  bytes: number;
+  size: number;
+  state: 'transferring' | 'complete' | 'failed' | 'cancelled';
+  error?: string;
+  // The file of a folder being transferred or unpacked, and how many of the
+  // folder's files came before it
+  file?: string;
+  files_done?: number;
+}
+
+export interface FileCancelPayload {
+  id: string;
+  reason?: string;
+}
+
+// Input is only taken from devices allowed with 'eco input allow'. Keys and
+// buttons are pressed and released unless action is 'down' or 'up'.
+export type InputAction = 'press' | 'down' | 'up';
+
+export interface InputKeyPayload {
+  // e.g. 'a', 'enter', 'f5' or 'volumeup'
+  key: string;
+  modifiers?: Array<'ctrl' | 'shift' | 'alt' | 'meta'>;
+  action?: InputAction;
+}
+
+// Typed as if on a US keyboard, at most 4096 characters
+export interface InputTextPayload {
+  text: string;
+}
+
+export interface InputMousePayload {
+  dx: number;
+  dy: number;
+}
+
+export interface InputButtonPayload {
+  button: 'left' | 'right' | 'middle';
+  action?: InputAction;
+}
+
+// Notches of the scroll wheel; positive dy scrolls up
+export interface InputScrollPayload {
+  dx: number;
+  dy: number;
+}
+
+// Requests from the desktop ('eco mobile'), answered with an ack once done or
+// a negative ack with the reason. mobile.ring and mobile.lock have no payload.
+export interface MobileOpenAppPayload {
+  // Android package name, e.g. 'com.spotify.music'
+  package: string;
+}
+
+// Absolute URL, opened with the app for its scheme (https, tel, geo, ...)
+export interface MobileOpenURLPayload {
+  url: string;
+}
+
+// Hex encoded random nonce sent by the server on connect
+export interface AuthChallengePayload {
+  nonce: string;
+}
+
+// proof = hex(HMAC-SHA256(secret, "eco-auth-v1" || nonce bytes || device_id))
+export interface AuthResponsePayload {
+  proof: string;
+}
+
+export interface AuthResultPayload {
+  ok: boolean;
+  error?: string;
+}
+
+// Pairing over /pair: the user types the daemon's one-time code and both sides
+// run SPAKE2 (edwards25519, RFC 9382 transcript). All byte fields are hex.
+export interface PairStartPayload {
+  device_name: string;
+  message: string;
+}
+
+export interface PairReplyPayload {
+  message: string;
+  confirm: string;
+}
+
+export interface PairConfirmPayload {
+  confirm: string;
+}
+
+// On success the device stores device_id and the derived key as its secret
+export interface PairCompletePayload {
+  ok: boolean;
+  device_id?: string;
+  error?: string;
+}
+
+export type Capability = 'clipboard' | 'notifications' | 'calls' | 'files' | 'input' | 'mobile' | 'sms';
+
+// The device offers ciphers and a hex nonce; the daemon answers with the
+// cipher it picked and its own nonce, or no encryption for a plain session.
+// Both sides describe themselves, and only capabilities both list are used.
 export interface DevicePayload {
   device_name: string;
+  encryption?: string[];
+  nonce?: string;
+  protocol_version?: number;
+  app_version?: string;
+  platform?: string;
+  capabilities?: Capability[];
+}
+
+export interface DisconnectPayload {
+  reason?: string;
 }
 
 export type ConnectionState = 'disconnected' | 'connecting' | 'connected' | 'error';
@@ -51,9 +328,18 @@ export type ClipboardEvent = {
 };
 
 export type NotificationEvent = {
+  // StatusBarNotification key, stable while the notification is posted
+  key?: string;
   packageName: string;
   title: string;
   text: string;
+  // The notification has a RemoteInput reply action
+  replyable?: boolean;
+  timestamp: number;
+};
+
+export type NotificationRemovedEvent = {
+  key: string;
   timestamp: number;
 };
 
@@ -0,0 +1,65 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+
+	"eco/internal/control"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	rootCmd.AddCommand(callCmd)
+	callCmd.AddCommand(callAnswerCmd)
+	callCmd.AddCommand(callHangupCmd)
+
+	callCmd.PersistentFlags().StringP("device", "d", "", "Device with the call (default: the only device with one)")
+}
+
+var callCmd = &cobra.Command{
+	Use:   "call",
+	Short: "Answer or hang up phone calls",
+	Long: `Answer or hang up a call on a connected phone, as the Answer and
+Decline buttons of the incoming call notification do.`,
+}
+
+var callAnswerCmd = &cobra.Command{
+	Use:   "answer",
+	Short: "Answer the ringing call",
+	Run: func(cmd *cobra.Command, args []string) {
+		runCallAction(cmd, control.MethodCallAnswer, "Answered")
+	},
+}
+
+var callHangupCmd = &cobra.Command{
+	Use:   "hangup",
+	Short: "Decline the ringing call or end the call in progress",
+	Run: func(cmd *cobra.Command, args []string) {
+		runCallAction(cmd, control.MethodCallHangup, "Hung up")
+	},
+}
+
+// runCallAction calls method for the device chosen with --device
+func runCallAction(cmd *cobra.Command, method, done string) {
+	deviceID, _ := cmd.Flags().GetString("device")
+
+	var call control.CallInfo
+	if err := control.Call(method, &control.CallParams{DeviceID: deviceID}, &call); err != nil {
+		if errors.Is(err, control.ErrDaemonNotRunning) {
+			fmt.Println("Daemon is not running. Run 'eco daemon start' first.")
+			return
+		}
+		fmt.Printf("Error: %s\n", err)
+		return
+	}
+
+	caller := call.Number
+	if caller == "" {
+		caller = "unknown number"
+	}
+	device := call.DeviceName
+	if device == "" {
+		device = call.DeviceID
+	}
+	fmt.Printf("✓ %s call from %s on %s\n", done, caller, device)
+}
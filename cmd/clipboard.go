@@ -0,0 +1,231 @@
+package cmd
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"eco/internal/control"
+	"eco/internal/protocol"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	rootCmd.AddCommand(clipboardCmd)
+	clipboardCmd.AddCommand(clipboardPullCmd)
+	clipboardCmd.AddCommand(clipboardPushCmd)
+	clipboardCmd.AddCommand(clipboardHistoryCmd)
+	clipboardCmd.AddCommand(clipboardGetCmd)
+	clipboardCmd.AddCommand(clipboardClearCmd)
+
+	clipboardPullCmd.Flags().StringP("device", "d", "", "Device to read from (default: the only connected device)")
+	clipboardPullCmd.Flags().BoolP("no-newline", "n", false, "Do not print a newline after the content")
+	clipboardHistoryCmd.Flags().IntP("limit", "l", 0, "Show only the newest entries")
+	clipboardGetCmd.Flags().BoolP("no-newline", "n", false, "Do not print a newline after the content")
+}
+
+var clipboardCmd = &cobra.Command{
+	Use:   "clipboard",
+	Short: "Work with the clipboard of connected devices and its history",
+}
+
+var clipboardPullCmd = &cobra.Command{
+	Use:   "pull",
+	Short: "Print the clipboard of a connected device",
+	Long: `Ask a connected device for its current clipboard and print it.
+
+The daemon must be running and the device connected. When more than one
+device is connected, choose one with --device.
+
+Images are written as raw bytes, so redirect them to a file:
+
+  eco clipboard pull > screenshot.png`,
+	Run: func(cmd *cobra.Command, args []string) {
+		deviceID, _ := cmd.Flags().GetString("device")
+		noNewline, _ := cmd.Flags().GetBool("no-newline")
+
+		var content control.ClipboardContent
+		err := control.Call(control.MethodClipboardPull, &control.ClipboardPullParams{DeviceID: deviceID}, &content)
+		if err != nil {
+			if errors.Is(err, control.ErrDaemonNotRunning) {
+				fmt.Println("Daemon is not running. Run 'eco daemon start' first.")
+				return
+			}
+			fmt.Printf("Error reading the device clipboard: %s\n", err)
+			return
+		}
+
+		if content.Encoding == protocol.EncodingBase64 {
+			writeBinaryClipboard(content.MIME, content.Data)
+			return
+		}
+
+		fmt.Print(content.Data)
+		if !noNewline {
+			fmt.Println()
+		}
+	},
+}
+
+var clipboardPushCmd = &cobra.Command{
+	Use:   "push [text]",
+	Short: "Send text to the clipboard of connected devices",
+	Long: `Send text to the clipboard of every connected device. Without an
+argument the text is read from standard input:
+
+  echo hello | eco clipboard push
+  eco clipboard push < notes.txt`,
+	Args: cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		var text string
+		if len(args) == 1 {
+			text = args[0]
+		} else {
+			data, err := io.ReadAll(os.Stdin)
+			if err != nil {
+				fmt.Printf("Error reading standard input: %s\n", err)
+				return
+			}
+			text = string(data)
+		}
+		if text == "" {
+			fmt.Println("Nothing to send.")
+			return
+		}
+
+		err := control.Call(control.MethodClipboardPush, &control.ClipboardParams{Data: text}, nil)
+		if err != nil {
+			if errors.Is(err, control.ErrDaemonNotRunning) {
+				fmt.Println("Daemon is not running. Run 'eco daemon start' first.")
+				return
+			}
+			fmt.Printf("Error sending the clipboard: %s\n", err)
+			return
+		}
+		fmt.Printf("✓ Sent %d bytes\n", len(text))
+	},
+}
+
+var clipboardHistoryCmd = &cobra.Command{
+	Use:   "history",
+	Short: "List recent clipboard changes",
+	Long: `List the clipboard changes the daemon has seen, newest first, from the
+desktop and from devices. Print one with 'eco clipboard get N'.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		limit, _ := cmd.Flags().GetInt("limit")
+
+		var entries []control.ClipboardEntry
+		if err := control.Call(control.MethodClipboardHistory, nil, &entries); err != nil {
+			if errors.Is(err, control.ErrDaemonNotRunning) {
+				fmt.Println("Daemon is not running. Run 'eco daemon start' first.")
+				return
+			}
+			fmt.Printf("Error reading the clipboard history: %s\n", err)
+			return
+		}
+
+		if len(entries) == 0 {
+			fmt.Println("The clipboard history is empty.")
+			return
+		}
+		if limit > 0 && len(entries) > limit {
+			entries = entries[:limit]
+		}
+		for _, e := range entries {
+			fmt.Printf("%3d  %s  %-12s  %s\n", e.Index, e.Time.Local().Format(time.DateTime), entrySource(&e), entrySummary(&e))
+		}
+	},
+}
+
+var clipboardGetCmd = &cobra.Command{
+	Use:   "get N",
+	Short: "Print an entry of the clipboard history",
+	Long: `Print entry N of the clipboard history, 1 being the newest, as listed by
+'eco clipboard history'. Images are written as raw bytes, so redirect them
+to a file.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		noNewline, _ := cmd.Flags().GetBool("no-newline")
+
+		index, err := strconv.Atoi(args[0])
+		if err != nil || index < 1 {
+			fmt.Printf("Invalid entry %q: use a number from 'eco clipboard history'\n", args[0])
+			return
+		}
+
+		var entry control.ClipboardEntry
+		if err := control.Call(control.MethodClipboardGet, &control.ClipboardEntryParams{Index: index}, &entry); err != nil {
+			if errors.Is(err, control.ErrDaemonNotRunning) {
+				fmt.Println("Daemon is not running. Run 'eco daemon start' first.")
+				return
+			}
+			fmt.Printf("Error reading the clipboard history: %s\n", err)
+			return
+		}
+
+		if entry.Encoding == protocol.EncodingBase64 {
+			writeBinaryClipboard(entry.MIME, entry.Data)
+			return
+		}
+		fmt.Print(entry.Data)
+		if !noNewline {
+			fmt.Println()
+		}
+	},
+}
+
+var clipboardClearCmd = &cobra.Command{
+	Use:   "clear",
+	Short: "Forget the clipboard history",
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := control.Call(control.MethodClipboardClear, nil, nil); err != nil {
+			if errors.Is(err, control.ErrDaemonNotRunning) {
+				fmt.Println("Daemon is not running. Run 'eco daemon start' first.")
+				return
+			}
+			fmt.Printf("Error clearing the clipboard history: %s\n", err)
+			return
+		}
+		fmt.Println("✓ Clipboard history cleared")
+	},
+}
+
+// entrySource names where a history entry came from
+func entrySource(e *control.ClipboardEntry) string {
+	switch {
+	case e.Source == "":
+		return "desktop"
+	case e.SourceName != "":
+		return e.SourceName
+	default:
+		return e.Source
+	}
+}
+
+// entrySummary is the preview of text entries, or the type and size of others
+func entrySummary(e *control.ClipboardEntry) string {
+	if e.Preview != "" && !strings.HasPrefix(e.MIME, "image/") {
+		return e.Preview
+	}
+	return fmt.Sprintf("[%s, %d bytes]", e.MIME, e.Size)
+}
+
+// writeBinaryClipboard writes base64 encoded content, such as an image, to
+// stdout unless stdout is a terminal
+func writeBinaryClipboard(mime, encoded string) {
+	data, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		fmt.Printf("Error decoding the device clipboard: %s\n", err)
+		return
+	}
+	if info, err := os.Stdout.Stat(); err == nil && info.Mode()&os.ModeCharDevice != 0 {
+		fmt.Printf("The clipboard holds %s (%d bytes). Redirect the output to a file to save it.\n", mime, len(data))
+		return
+	}
+	os.Stdout.Write(data)
+}
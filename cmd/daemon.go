@@ -2,16 +2,24 @@ package cmd
 
 import (
 	"fmt"
+	"log"
 	"os"
 	"os/signal"
 	"path/filepath"
 	"syscall"
+	"time"
 
+	"eco/internal/bus"
 	"eco/internal/clipboard"
 	"eco/internal/config"
-	"eco/internal/events"
+	"eco/internal/control"
+	"eco/internal/input"
 	"eco/internal/notifications"
+	"eco/internal/queue"
 	"eco/internal/server"
+	"eco/internal/sms"
+	"eco/internal/tlscert"
+	"eco/internal/transfer"
 
 	"github.com/spf13/cobra"
 )
@@ -19,6 +27,8 @@ import (
 func init() {
 	rootCmd.AddCommand(daemonCmd)
 	daemonCmd.AddCommand(daemonStartCmd)
+	daemonStartCmd.Flags().Bool("log-events", false, "Log every event published on the event bus")
+	daemonStartCmd.Flags().String("clipboard", clipboard.BackendAuto, "Clipboard backend: auto, wayland, x11 or memory (also set by "+clipboard.BackendEnv+")")
 }
 
 var daemonCmd = &cobra.Command{
@@ -33,10 +43,13 @@ var daemonStartCmd = &cobra.Command{
 	
 The daemon will:
   1. Load configuration from ~/.config/eco/config.json
-  2. Start WebSocket server on port 4949
-  3. Listen for clipboard changes (Wayland)
+  2. Start WebSocket server on port 4949 (wss://, with a self-signed
+     certificate kept in ~/.config/eco, unless DisableTLS is set)
+  3. Listen for clipboard changes (Wayland or X11, detected from the
+     session; without either it keeps an in-memory clipboard)
   4. Accept connections from authorized mobile devices
-  5. Display notifications from mobile (using notify-send)
+  5. Display notifications from mobile on the desktop (over D-Bus)
+  6. Forward desktop notifications to devices (from the D-Bus session bus)
 
 Run 'eco init' first if you haven't initialized the system.`,
 	Run: func(cmd *cobra.Command, args []string) {
@@ -46,6 +59,8 @@ Run 'eco init' first if you haven't initialized the system.`,
 		//      - Check if initialized (cfg.IsInitialized())
 		//      - If not initialized, print error and exit with instructions to run 'eco init'
 
+		startedAt := time.Now()
+
 		cfg, err := config.Load()
 		if err != nil {
 			fmt.Printf("Error loading configuration: %s\n", err)
@@ -57,23 +72,108 @@ Run 'eco init' first if you haven't initialized the system.`,
 			return
 		}
 
-		//   2. Create and start event router
-		//      - eventRouter := events.NewRouter()
-		//      - eventRouter.Start()
-		//      - This routes system events to the connected device
+		//   2. Claim the control socket
+		//      - Fails if another daemon is already running
+		//      - 'eco status', 'eco devices' and 'eco stop' talk to it
 
-		eventRouter := events.NewRouter()
-		err = eventRouter.Start()
+		ctl := control.NewServer(control.SocketPath())
+		err = ctl.Start()
 		if err != nil {
-			fmt.Printf("Error starting event router: %s\n", err)
+			fmt.Printf("Error starting control socket: %s\n", err)
 			return
 		}
 
-		//   3. Create and start WebSocket server
-		//      - server := server.NewServer(cfg)
+		//   3. Create the event bus
+		//      - eventBus := bus.New()
+		//      - Shared by the server (device fan-out), the clipboard
+		//        listener and any other event source
+
+		eventBus := bus.New()
+
+		if logEvents, _ := cmd.Flags().GetBool("log-events"); logEvents {
+			go logBusEvents(eventBus.Subscribe("log", 64))
+		}
+
+		//   4. Create and start WebSocket server
+		//      - server := server.NewServer(cfg, eventBus)
 		//      - server.Start() (run in goroutine since it blocks)
 
-		srv := server.NewServer(cfg)
+		srv := server.NewServer(cfg, eventBus)
+		srv.SetAppVersion(Version)
+
+		// Pick the clipboard for this session, or an in-memory one when headless
+		backendName, _ := cmd.Flags().GetString("clipboard")
+		clipboardBackend, err := clipboard.New(backendName)
+		if err != nil {
+			if backendName != "" && backendName != clipboard.BackendAuto {
+				fmt.Printf("Error opening clipboard: %s\n", err)
+				ctl.Stop()
+				return
+			}
+			fmt.Printf("WARNING: %s\n", err)
+			fmt.Println("Using an in-memory clipboard; desktop clipboard sync is disabled.")
+			clipboardBackend = clipboard.NewMemoryBackend()
+		}
+		// Both sides share the tracker so changes from a device aren't echoed back
+		clipboardTracker := clipboard.NewTracker()
+		srv.SetClipboard(clipboardBackend, clipboardTracker)
+
+		// Keep secrets on the clipboard from reaching devices
+		clipboardFilter, err := clipboard.NewFilter(cfg.ClipboardDenyPatterns, !cfg.DisableDefaultClipboardPatterns)
+		if err != nil {
+			fmt.Printf("Error in ClipboardDenyPatterns: %s\n", err)
+			ctl.Stop()
+			return
+		}
+
+		// Show notifications from devices through the desktop notification
+		// server; devices get an error back when there is none
+		notifier := notifications.NewNotifier("")
+		srv.SetNotifier(notifier)
+		if err := notifier.Connect(); err != nil {
+			fmt.Printf("WARNING: notifications from devices will not be shown: %s\n", err)
+		}
+
+		// Keep the text messages of phones for 'eco sms'
+		smsStore, err := openSMSStore()
+		if err != nil {
+			fmt.Printf("WARNING: text messages unavailable: %s\n", err)
+		} else {
+			srv.SetSMSStore(smsStore)
+		}
+
+		// Move files to and from devices for 'eco send' and 'eco receive'
+		transfers, err := openTransfers(cfg)
+		if err != nil {
+			fmt.Printf("Error setting up file transfers: %s\n", err)
+			ctl.Stop()
+			return
+		}
+		srv.SetTransfers(transfers)
+
+		// Let devices the user allowed act as keyboard and mouse
+		var injector *input.Injector
+		if uinput, err := input.OpenUinput(); err != nil {
+			fmt.Printf("WARNING: input from devices unavailable: %s\n", err)
+		} else {
+			injector = input.NewInjector(uinput)
+			srv.SetInput(injector)
+		}
+
+		// Keep events for offline devices until they reconnect
+		if offlineQueue, err := openOfflineQueue(); err != nil {
+			fmt.Printf("WARNING: offline queue unavailable, events for offline devices will be dropped: %s\n", err)
+		} else {
+			srv.SetOfflineQueue(offlineQueue)
+		}
+
+		// Keep recent clipboard changes for 'eco clipboard history'
+		clipboardHistory, err := openClipboardHistory(cfg)
+		if err != nil {
+			fmt.Printf("WARNING: clipboard history unavailable: %s\n", err)
+		} else if clipboardHistory != nil {
+			clipboardHistory.Start(eventBus)
+		}
 
 		// Find and set PWA static path
 		pwaPath := findPWAPath()
@@ -84,53 +184,94 @@ Run 'eco init' first if you haven't initialized the system.`,
 			fmt.Println("WARNING: PWA not found, serving only API endpoints")
 		}
 
+		// Serve TLS with the daemon's self-signed certificate unless disabled
+		webScheme, wsScheme := "http", "ws"
+		if !cfg.DisableTLS {
+			certPath, keyPath, err := tlscert.Paths()
+			if err != nil {
+				fmt.Printf("Error locating TLS certificate: %s\n", err)
+				ctl.Stop()
+				return
+			}
+			store, err := tlscert.NewStore(certPath, keyPath)
+			if err != nil {
+				fmt.Printf("Error loading TLS certificate: %s\n", err)
+				ctl.Stop()
+				return
+			}
+			srv.SetTLS(store)
+			webScheme, wsScheme = "https", "wss"
+		}
+
 		// Print connection info
 		fmt.Println("\n=== Eco Daemon Started ===")
-		fmt.Println("WebSocket: ws://localhost:4949/ws")
-		fmt.Printf("QR Code: http://localhost:4949/qr\n")
-		fmt.Println("PWA: http://localhost:4949/")
-		fmt.Println("============================\n")
+		fmt.Printf("WebSocket: %s://localhost:4949/ws\n", wsScheme)
+		fmt.Printf("PWA: %s://localhost:4949/\n", webScheme)
+		if fp := srv.CertFingerprint(); fp != "" {
+			fmt.Printf("Certificate: SHA256 %s\n", tlscert.FormatFingerprint(fp))
+		}
+		fmt.Println("============================")
+		fmt.Println()
 
 		go srv.Start()
 
-		//   4. Create and start clipboard listener
-		//      - clipboardListener := clipboard.NewListener(func(content string) {
-		//          - eventRouter.RouteClipboardChange(content)
-		//        })
+		//   5. Create and start clipboard listener
+		//      - clipboardListener := clipboard.NewListener(eventBus, clipboardBackend, clipboardTracker)
 		//      - clipboardListener.Start()
-		//      - Handle Wayland/X11 detection errors
+		//      - The daemon keeps running without it, devices just don't
+		//        hear about desktop clipboard changes
 
-		clipboardListener := clipboard.NewListener(func(content string) {
-			eventRouter.RouteClipboardChange(content)
-		})
-		err = clipboardListener.Start()
-		if err != nil {
-			fmt.Printf("Error starting clipboard listener: %s\n", err)
-			return
+		clipboardListener := clipboard.NewListener(eventBus, clipboardBackend, clipboardTracker)
+		clipboardListener.SetFilter(clipboardFilter)
+		if err := clipboardListener.Start(); err != nil {
+			fmt.Printf("WARNING: clipboard listener unavailable: %s\n", err)
+		} else {
+			fmt.Printf("Clipboard: %s\n", clipboardBackend.Name())
 		}
 
-		if !notifications.IsAvailable() {
-			fmt.Println("notify-send command not found. Notifications will not be available.")
+		// Forward desktop notifications; without a session bus devices just
+		// don't get them
+		notificationMonitor := notifications.NewMonitor(eventBus, "")
+		notificationMonitor.SetAppFilter(notifications.NewAppFilter(cfg.NotificationAllowApps, cfg.NotificationDenyApps))
+		if err := notificationMonitor.Start(); err != nil {
+			fmt.Printf("WARNING: desktop notifications will not be forwarded: %s\n", err)
 		}
 
-		notifications.Send("Eco", "Eco daemon started")
-
-		// Write PID file for stop command
-		err = os.WriteFile("/tmp/eco.pid", []byte(fmt.Sprintf("%d", os.Getpid())), 0644)
-		if err != nil {
-			fmt.Printf("Error writing PID file: %s\n", err)
-		}
+		notifier.ShowStatus("Eco daemon started", "")
 
 		var gracefulStop = make(chan os.Signal, 1)
 		signal.Notify(gracefulStop, syscall.SIGINT, syscall.SIGTERM)
 
+		state := &daemonState{
+			startedAt: startedAt,
+			server:    srv,
+			bus:       eventBus,
+			clipboard: clipboardListener,
+			history:   clipboardHistory,
+			monitor:   notificationMonitor,
+			sms:       smsStore,
+			transfers: transfers,
+			shutdown:  gracefulStop,
+		}
+		state.registerControlHandlers(ctl)
+		fmt.Printf("Control socket: %s\n", ctl.Path())
+
 		go func() {
 			sig := <-gracefulStop
 			fmt.Printf("\nReceived signal: %v\n", sig)
 			fmt.Println("Shutting down...")
+			ctl.Stop()
 			clipboardListener.Stop()
+			if clipboardHistory != nil {
+				clipboardHistory.Stop()
+			}
+			notificationMonitor.Stop()
 			srv.Stop()
-			eventRouter.Stop()
+			if injector != nil {
+				injector.Close()
+			}
+			notifier.Disconnect()
+			eventBus.Close()
 			os.Exit(0)
 		}()
 
@@ -138,6 +279,68 @@ Run 'eco init' first if you haven't initialized the system.`,
 	},
 }
 
+// logBusEvents prints every event delivered to sub
+func logBusEvents(sub *bus.Subscription) {
+	for event := range sub.Events() {
+		log.Printf("Event: %s from %s", event.Type, event.Source)
+	}
+}
+
+// openOfflineQueue opens the on-disk queue for devices that are not connected
+func openOfflineQueue() (*queue.Queue, error) {
+	path, err := queue.DefaultPath()
+	if err != nil {
+		return nil, err
+	}
+	return queue.New(path, queue.DefaultMaxPerDevice)
+}
+
+// openSMSStore opens the conversation store next to the config file
+func openSMSStore() (*sms.Store, error) {
+	path, err := sms.DefaultPath()
+	if err != nil {
+		return nil, err
+	}
+	return sms.Open(path, sms.DefaultMaxPerThread)
+}
+
+// openTransfers creates the transfer manager, saving received files in the
+// configured download directory with the configured conflict policy
+func openTransfers(cfg *config.Config) (*transfer.Manager, error) {
+	conflict, err := transfer.ParseConflict(cfg.DownloadConflict)
+	if err != nil {
+		return nil, fmt.Errorf("invalid DownloadConflict: %w", err)
+	}
+	dir := cfg.DownloadDir
+	if dir == "" {
+		if dir, err = transfer.DefaultDir(); err != nil {
+			return nil, err
+		}
+	}
+	m := transfer.NewManager(dir)
+	m.SetConflict(conflict)
+	return m, nil
+}
+
+// openClipboardHistory opens the clipboard history configured in cfg, or
+// returns nil if it is turned off
+func openClipboardHistory(cfg *config.Config) (*clipboard.History, error) {
+	if cfg.ClipboardHistorySize < 0 {
+		return nil, nil
+	}
+	historyPath, keyPath, err := clipboard.HistoryPaths()
+	if err != nil {
+		return nil, err
+	}
+	var key []byte
+	if cfg.EncryptClipboardHistory {
+		if key, err = clipboard.LoadHistoryKey(keyPath); err != nil {
+			return nil, err
+		}
+	}
+	return clipboard.OpenHistory(historyPath, cfg.ClipboardHistorySize, key)
+}
+
 func findPWAPath() string {
 	// Get current working directory
 	cwd, err := os.Getwd()
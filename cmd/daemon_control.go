@@ -0,0 +1,654 @@
+package cmd
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+	"unicode/utf8"
+
+	"eco/internal/bus"
+	"eco/internal/clipboard"
+	"eco/internal/config"
+	"eco/internal/control"
+	"eco/internal/device"
+	"eco/internal/dispatch"
+	"eco/internal/events"
+	"eco/internal/notifications"
+	"eco/internal/protocol"
+	"eco/internal/server"
+	"eco/internal/sms"
+	"eco/internal/transfer"
+)
+
+// clipboardPullTimeout bounds how long 'eco clipboard pull' waits for the phone
+const clipboardPullTimeout = 8 * time.Second
+
+// mobileTimeout bounds how long 'eco mobile' waits for the phone to do as asked
+const mobileTimeout = 8 * time.Second
+
+// clipboardPreviewLength is how many characters of text history entries show
+const clipboardPreviewLength = 60
+
+// smsSnippetLength is how many characters of the newest message conversations
+// show
+const smsSnippetLength = 50
+
+// errSMSDisabled is returned by the sms methods when the daemon has no
+// conversation store
+var errSMSDisabled = errors.New("text messages are not available in this daemon")
+
+// errHistoryDisabled is returned by the history methods when the history is
+// turned off or failed to open
+var errHistoryDisabled = errors.New("clipboard history is not enabled in this daemon")
+
+// daemonState holds the running daemon's subsystems for the control socket
+type daemonState struct {
+	startedAt time.Time
+	server    *server.Server
+	bus       *bus.Bus
+	clipboard *clipboard.Listener
+	// history is nil when the clipboard history is turned off
+	history *clipboard.History
+	monitor *notifications.Monitor
+	// sms is nil when the conversation store couldn't be opened
+	sms       *sms.Store
+	transfers *transfer.Manager
+	shutdown  chan os.Signal
+}
+
+// registerControlHandlers exposes the daemon's state and actions on ctl
+func (d *daemonState) registerControlHandlers(ctl *control.Server) {
+	ctl.Handle(control.MethodStatus, func(params json.RawMessage) (any, error) {
+		return d.status(), nil
+	})
+
+	ctl.Handle(control.MethodDevices, func(params json.RawMessage) (any, error) {
+		return d.deviceStatuses(), nil
+	})
+
+	ctl.Handle(control.MethodDeviceDisconnect, func(params json.RawMessage) (any, error) {
+		var p control.DeviceParams
+		if err := control.DecodeParams(params, &p); err != nil {
+			return nil, err
+		}
+		if err := d.server.DisconnectDevice(p.DeviceID); err != nil {
+			return nil, control.Errorf(control.CodeInvalidParams, "%v", err)
+		}
+		return true, nil
+	})
+
+	ctl.Handle(control.MethodDeviceAdd, func(params json.RawMessage) (any, error) {
+		var p control.DeviceAddParams
+		if err := control.DecodeParams(params, &p); err != nil {
+			return nil, err
+		}
+		dev := &config.Device{ID: p.DeviceID, Name: p.Name, Secret: p.Secret, Enabled: true}
+		if err := d.server.AddDevice(dev); err != nil {
+			return nil, control.Errorf(control.CodeInvalidParams, "%v", err)
+		}
+		return true, nil
+	})
+
+	ctl.Handle(control.MethodDeviceRemove, func(params json.RawMessage) (any, error) {
+		var p control.DeviceParams
+		if err := control.DecodeParams(params, &p); err != nil {
+			return nil, err
+		}
+		if err := d.server.RemoveDevice(p.DeviceID); err != nil {
+			return nil, control.Errorf(control.CodeInvalidParams, "%v", err)
+		}
+		return true, nil
+	})
+
+	ctl.Handle(control.MethodDeviceRename, func(params json.RawMessage) (any, error) {
+		var p control.DeviceRenameParams
+		if err := control.DecodeParams(params, &p); err != nil {
+			return nil, err
+		}
+		if err := d.server.RenameDevice(p.DeviceID, p.Name); err != nil {
+			return nil, control.Errorf(control.CodeInvalidParams, "%v", err)
+		}
+		return true, nil
+	})
+
+	ctl.Handle(control.MethodDeviceEnable, func(params json.RawMessage) (any, error) {
+		var p control.DeviceEnableParams
+		if err := control.DecodeParams(params, &p); err != nil {
+			return nil, err
+		}
+		if err := d.server.EnableDevice(p.DeviceID, p.Enabled); err != nil {
+			return nil, control.Errorf(control.CodeInvalidParams, "%v", err)
+		}
+		return true, nil
+	})
+
+	ctl.Handle(control.MethodDeviceClipboard, func(params json.RawMessage) (any, error) {
+		var p control.DeviceClipboardParams
+		if err := control.DecodeParams(params, &p); err != nil {
+			return nil, err
+		}
+		if err := d.server.SyncClipboard(p.DeviceID, p.Sync); err != nil {
+			return nil, control.Errorf(control.CodeInvalidParams, "%v", err)
+		}
+		return true, nil
+	})
+
+	ctl.Handle(control.MethodClipboardPush, func(params json.RawMessage) (any, error) {
+		var p control.ClipboardParams
+		if err := control.DecodeParams(params, &p); err != nil {
+			return nil, err
+		}
+		err := d.bus.Publish(bus.Event{
+			Type:    protocol.MessageTypeClipboardChanged,
+			Payload: &protocol.ClipboardPayload{Data: p.Data},
+			Source:  "control",
+		})
+		if err != nil {
+			return nil, err
+		}
+		return true, nil
+	})
+
+	ctl.Handle(control.MethodClipboardPull, func(params json.RawMessage) (any, error) {
+		var p control.ClipboardPullParams
+		if len(params) > 0 {
+			if err := control.DecodeParams(params, &p); err != nil {
+				return nil, err
+			}
+		}
+		resp, err := d.server.RequestFromDevice(p.DeviceID, protocol.MessageTypeClipboardGet, nil, clipboardPullTimeout)
+		if err != nil {
+			return nil, err
+		}
+		if resp.Type != protocol.MessageTypeClipboardContent {
+			return nil, fmt.Errorf("device answered with %s", resp.Type)
+		}
+		var payload protocol.ClipboardPayload
+		if err := json.Unmarshal(resp.Payload, &payload); err != nil {
+			return nil, fmt.Errorf("invalid clipboard content: %w", err)
+		}
+		return &control.ClipboardContent{
+			DeviceID: resp.DeviceID,
+			Data:     payload.Data,
+			MIME:     payload.MIME,
+			Encoding: payload.Encoding,
+		}, nil
+	})
+
+	ctl.Handle(control.MethodClipboardHistory, func(params json.RawMessage) (any, error) {
+		if d.history == nil {
+			return nil, errHistoryDisabled
+		}
+		names := d.deviceNames()
+		entries := []control.ClipboardEntry{}
+		for i, e := range d.history.List() {
+			entries = append(entries, historyEntry(i+1, &e, names))
+		}
+		return entries, nil
+	})
+
+	ctl.Handle(control.MethodClipboardGet, func(params json.RawMessage) (any, error) {
+		if d.history == nil {
+			return nil, errHistoryDisabled
+		}
+		var p control.ClipboardEntryParams
+		if err := control.DecodeParams(params, &p); err != nil {
+			return nil, err
+		}
+		e, ok := d.history.Get(p.Index)
+		if !ok {
+			return nil, control.Errorf(control.CodeInvalidParams, "no entry %d in the clipboard history (it has %d)", p.Index, d.history.Len())
+		}
+		entry := historyEntry(p.Index, &e, d.deviceNames())
+		if e.Content().IsImage() {
+			entry.Data = base64.StdEncoding.EncodeToString(e.Data)
+			entry.Encoding = protocol.EncodingBase64
+		} else {
+			entry.Data = string(e.Data)
+		}
+		return &entry, nil
+	})
+
+	ctl.Handle(control.MethodClipboardClear, func(params json.RawMessage) (any, error) {
+		if d.history == nil {
+			return nil, errHistoryDisabled
+		}
+		if err := d.history.Clear(); err != nil {
+			return nil, err
+		}
+		return true, nil
+	})
+
+	ctl.Handle(control.MethodCallAnswer, func(params json.RawMessage) (any, error) {
+		return d.callAction(params, d.server.AnswerCall)
+	})
+
+	ctl.Handle(control.MethodCallHangup, func(params json.RawMessage) (any, error) {
+		return d.callAction(params, d.server.HangupCall)
+	})
+
+	ctl.Handle(control.MethodSMSList, func(params json.RawMessage) (any, error) {
+		if d.sms == nil {
+			return nil, errSMSDisabled
+		}
+		names := d.deviceNames()
+		threads := []control.SMSThread{}
+		for _, t := range d.sms.Threads() {
+			threads = append(threads, smsThread(&t, names))
+		}
+		return threads, nil
+	})
+
+	ctl.Handle(control.MethodSMSRead, func(params json.RawMessage) (any, error) {
+		if d.sms == nil {
+			return nil, errSMSDisabled
+		}
+		var p control.SMSThreadParams
+		if err := control.DecodeParams(params, &p); err != nil {
+			return nil, err
+		}
+		t, err := d.sms.Lookup(p.Thread, p.DeviceID)
+		if err != nil {
+			return nil, control.Errorf(control.CodeInvalidParams, "%v", err)
+		}
+		thread := smsThread(&t, d.deviceNames())
+		messages := t.Messages
+		if p.Limit > 0 && len(messages) > p.Limit {
+			messages = messages[len(messages)-p.Limit:]
+		}
+		for _, m := range messages {
+			thread.Messages = append(thread.Messages, control.SMSMessage{
+				Time: time.UnixMilli(m.Time),
+				Sent: m.Sent,
+				Read: m.Read,
+				Body: m.Body,
+			})
+		}
+		return &thread, nil
+	})
+
+	ctl.Handle(control.MethodSMSSend, func(params json.RawMessage) (any, error) {
+		var p control.SMSSendParams
+		if err := control.DecodeParams(params, &p); err != nil {
+			return nil, err
+		}
+		sent := control.SMSSent{DeviceID: p.DeviceID, Address: p.To}
+		// Names are looked up in the conversations; numbers are sent as is
+		if d.sms != nil && !isPhoneNumber(p.To) {
+			t, err := d.sms.Lookup(p.To, p.DeviceID)
+			if err != nil {
+				return nil, control.Errorf(control.CodeInvalidParams, "%v", err)
+			}
+			sent = control.SMSSent{DeviceID: t.DeviceID, Address: t.Address, ContactName: t.ContactName}
+		}
+
+		deviceID, err := d.server.SendSMS(sent.DeviceID, sent.Address, p.Body)
+		if err != nil {
+			return nil, err
+		}
+		sent.DeviceID = deviceID
+		sent.DeviceName = d.deviceNames()[deviceID]
+		return &sent, nil
+	})
+
+	ctl.Handle(control.MethodFileSend, func(params json.RawMessage) (any, error) {
+		var p control.FileSendParams
+		if err := control.DecodeParams(params, &p); err != nil {
+			return nil, err
+		}
+		if !filepath.IsAbs(p.Path) {
+			return nil, control.Errorf(control.CodeInvalidParams, "path %q is not absolute", p.Path)
+		}
+		t, err := d.server.SendFile(p.DeviceID, p.Path, p.Compress)
+		if err != nil {
+			return nil, err
+		}
+		return fileTransfer(t, d.deviceNames()), nil
+	})
+
+	ctl.Handle(control.MethodFileReceive, func(params json.RawMessage) (any, error) {
+		var p control.FileReceiveParams
+		if len(params) > 0 {
+			if err := control.DecodeParams(params, &p); err != nil {
+				return nil, err
+			}
+		}
+		// An empty conflict policy leaves the configured one
+		var conflict transfer.Conflict
+		if p.Conflict != "" {
+			var err error
+			if conflict, err = transfer.ParseConflict(p.Conflict); err != nil {
+				return nil, control.Errorf(control.CodeInvalidParams, "%v", err)
+			}
+		}
+		ids := p.IDs
+		if len(ids) == 0 {
+			for _, t := range d.transfers.List() {
+				if t.Direction == transfer.Incoming && t.State == transfer.StateOffered {
+					ids = append(ids, t.ID)
+				}
+			}
+		}
+		names := d.deviceNames()
+		accepted := []control.Transfer{}
+		for _, id := range ids {
+			t, err := d.server.AcceptFile(id, p.Dir, conflict)
+			if err != nil {
+				return nil, err
+			}
+			accepted = append(accepted, fileTransfer(t, names))
+		}
+		return accepted, nil
+	})
+
+	ctl.Handle(control.MethodFileList, func(params json.RawMessage) (any, error) {
+		names := d.deviceNames()
+		transfers := []control.Transfer{}
+		for _, t := range d.transfers.List() {
+			transfers = append(transfers, fileTransfer(t, names))
+		}
+		return transfers, nil
+	})
+
+	ctl.Handle(control.MethodFileStatus, func(params json.RawMessage) (any, error) {
+		var p control.FileParams
+		if err := control.DecodeParams(params, &p); err != nil {
+			return nil, err
+		}
+		t, err := d.transfers.Get(p.ID)
+		if err != nil {
+			return nil, control.Errorf(control.CodeInvalidParams, "%v", err)
+		}
+		return fileTransfer(t, d.deviceNames()), nil
+	})
+
+	ctl.Handle(control.MethodFileCancel, func(params json.RawMessage) (any, error) {
+		var p control.FileParams
+		if err := control.DecodeParams(params, &p); err != nil {
+			return nil, err
+		}
+		t, err := d.transfers.Cancel("", p.ID, "cancelled on the desktop")
+		if err != nil {
+			return nil, control.Errorf(control.CodeInvalidParams, "%v", err)
+		}
+		return fileTransfer(t, d.deviceNames()), nil
+	})
+
+	ctl.Handle(control.MethodInputAllow, func(params json.RawMessage) (any, error) {
+		var p control.InputAllowParams
+		if err := control.DecodeParams(params, &p); err != nil {
+			return nil, err
+		}
+		if err := d.server.AllowInput(p.DeviceID, p.Allow); err != nil {
+			return nil, control.Errorf(control.CodeInvalidParams, "%v", err)
+		}
+		return true, nil
+	})
+
+	ctl.Handle(control.MethodMobileOpen, func(params json.RawMessage) (any, error) {
+		return d.mobileRequest(params, protocol.MessageTypeMobileOpenApp, func(p *control.MobileParams) dispatch.Validator {
+			return &protocol.MobileOpenAppPayload{Package: p.Package}
+		})
+	})
+
+	ctl.Handle(control.MethodMobileURL, func(params json.RawMessage) (any, error) {
+		return d.mobileRequest(params, protocol.MessageTypeMobileOpenURL, func(p *control.MobileParams) dispatch.Validator {
+			return &protocol.MobileOpenURLPayload{URL: p.URL}
+		})
+	})
+
+	ctl.Handle(control.MethodMobileRing, func(params json.RawMessage) (any, error) {
+		return d.mobileRequest(params, protocol.MessageTypeMobileRing, nil)
+	})
+
+	ctl.Handle(control.MethodMobileLock, func(params json.RawMessage) (any, error) {
+		return d.mobileRequest(params, protocol.MessageTypeMobileLock, nil)
+	})
+
+	ctl.Handle(control.MethodPairBegin, func(params json.RawMessage) (any, error) {
+		var p control.PairParams
+		if len(params) > 0 {
+			if err := control.DecodeParams(params, &p); err != nil {
+				return nil, err
+			}
+		}
+		return d.server.Pairing().Begin(p.Name, time.Duration(p.TTLSeconds)*time.Second)
+	})
+
+	ctl.Handle(control.MethodPairStatus, func(params json.RawMessage) (any, error) {
+		var p control.PairParams
+		if err := control.DecodeParams(params, &p); err != nil {
+			return nil, err
+		}
+		return d.server.Pairing().Status(p.Code)
+	})
+
+	ctl.Handle(control.MethodPairCancel, func(params json.RawMessage) (any, error) {
+		var p control.PairParams
+		if err := control.DecodeParams(params, &p); err != nil {
+			return nil, err
+		}
+		if err := d.server.Pairing().Cancel(p.Code); err != nil {
+			return nil, err
+		}
+		return true, nil
+	})
+
+	ctl.Handle(control.MethodTLSReload, func(params json.RawMessage) (any, error) {
+		if err := d.server.ReloadCertificate(); err != nil {
+			return nil, err
+		}
+		return &control.TLSStatus{Fingerprint: d.server.CertFingerprint()}, nil
+	})
+
+	ctl.Handle(control.MethodDaemonStop, func(params json.RawMessage) (any, error) {
+		// Shut down after the response has had a chance to go out
+		go func() {
+			time.Sleep(100 * time.Millisecond)
+			d.shutdown <- syscall.SIGTERM
+		}()
+		return true, nil
+	})
+}
+
+// callAction answers or hangs up the call selected by params with action
+func (d *daemonState) callAction(params json.RawMessage, action func(deviceID string) (events.Call, error)) (any, error) {
+	var p control.CallParams
+	if len(params) > 0 {
+		if err := control.DecodeParams(params, &p); err != nil {
+			return nil, err
+		}
+	}
+	call, err := action(p.DeviceID)
+	if err != nil {
+		return nil, err
+	}
+	return &control.CallInfo{
+		DeviceID:   call.DeviceID,
+		DeviceName: d.deviceNames()[call.DeviceID],
+		Number:     call.Number,
+	}, nil
+}
+
+// mobileRequest asks the device selected by params to carry out a mobile.*
+// request, with the payload made from params if there is one
+func (d *daemonState) mobileRequest(params json.RawMessage, msgType protocol.MessageType, payload func(p *control.MobileParams) dispatch.Validator) (any, error) {
+	var p control.MobileParams
+	if len(params) > 0 {
+		if err := control.DecodeParams(params, &p); err != nil {
+			return nil, err
+		}
+	}
+	var body any
+	if payload != nil {
+		v := payload(&p)
+		if err := v.Validate(); err != nil {
+			return nil, control.Errorf(control.CodeInvalidParams, "%v", err)
+		}
+		body = v
+	}
+
+	resp, err := d.server.RequestFromDevice(p.DeviceID, msgType, body, mobileTimeout)
+	if err != nil {
+		return nil, deviceError(err)
+	}
+	return &control.MobileResult{
+		DeviceID:   resp.DeviceID,
+		DeviceName: d.deviceNames()[resp.DeviceID],
+	}, nil
+}
+
+// deviceError gives the errors of requests to devices a code telling scripts
+// whether no device could take the request, it refused or it didn't answer
+func deviceError(err error) error {
+	var rejected *device.RejectedError
+	switch {
+	case errors.As(err, &rejected):
+		return control.Errorf(control.CodeDeviceRejected, "%v", err)
+	case errors.Is(err, device.ErrRequestTimeout):
+		return control.Errorf(control.CodeDeviceTimeout, "%v", err)
+	case errors.Is(err, server.ErrNoDevice), errors.Is(err, server.ErrNotConnected),
+		errors.Is(err, server.ErrUnsupported), errors.Is(err, device.ErrConnectionClosed):
+		return control.Errorf(control.CodeDeviceUnavailable, "%v", err)
+	}
+	return err
+}
+
+func (d *daemonState) status() *control.Status {
+	listeners := []string{"websocket", "control"}
+	clipboardName := ""
+	if d.clipboard != nil {
+		clipboardName = d.clipboard.Backend().Name()
+		if d.clipboard.IsRunning() {
+			listeners = append(listeners, "clipboard")
+		}
+	}
+	if d.monitor != nil && d.monitor.IsRunning() {
+		listeners = append(listeners, "notifications")
+	}
+
+	return &control.Status{
+		PID:       os.Getpid(),
+		StartedAt: d.startedAt,
+		Uptime:    time.Since(d.startedAt).Seconds(),
+		Listeners: listeners,
+		Devices:   d.deviceStatuses(),
+		QueueDepths: map[string]int{
+			"events":  d.server.QueueDepth(),
+			"offline": d.server.PendingTotal(),
+		},
+		LastSync:       d.server.LastSync(),
+		TLSFingerprint: d.server.CertFingerprint(),
+		Clipboard:      clipboardName,
+		Messages:       d.messageStats(),
+	}
+}
+
+func (d *daemonState) messageStats() map[string]control.MessageStats {
+	stats := make(map[string]control.MessageStats)
+	for msgType, s := range d.server.HandlerStats() {
+		stats[string(msgType)] = control.MessageStats{Handled: s.Handled, Failed: s.Failed}
+	}
+	return stats
+}
+
+// deviceNames maps the IDs of paired devices to their names
+func (d *daemonState) deviceNames() map[string]string {
+	names := make(map[string]string)
+	for _, dev := range d.server.PairedDevices() {
+		names[dev.ID] = dev.Name
+	}
+	return names
+}
+
+// fileTransfer describes t with the name of its device
+func fileTransfer(t transfer.Transfer, names map[string]string) control.Transfer {
+	return control.Transfer{Transfer: t, DeviceName: names[t.DeviceID]}
+}
+
+// smsThread describes conversation t without its messages
+func smsThread(t *sms.Thread, names map[string]string) control.SMSThread {
+	last := t.Last()
+	return control.SMSThread{
+		Key:         t.Key(),
+		DeviceID:    t.DeviceID,
+		DeviceName:  names[t.DeviceID],
+		Address:     t.Address,
+		ContactName: t.ContactName,
+		Time:        time.UnixMilli(last.Time),
+		Snippet:     preview(last.Body, smsSnippetLength),
+		Count:       len(t.Messages),
+		Unread:      t.Unread(),
+	}
+}
+
+// isPhoneNumber reports whether s is a number rather than a contact name
+func isPhoneNumber(s string) bool {
+	return s != "" && strings.Trim(s, "+0123456789 -().") == ""
+}
+
+// historyEntry describes history entry e, at position index, without its data
+func historyEntry(index int, e *clipboard.HistoryEntry, names map[string]string) control.ClipboardEntry {
+	return control.ClipboardEntry{
+		Index:      index,
+		Source:     e.Source,
+		SourceName: names[e.Source],
+		Time:       e.Time,
+		MIME:       e.MIME,
+		Size:       len(e.Data),
+		Preview:    clipboardPreview(e),
+	}
+}
+
+// clipboardPreview is the first line of text entries, shortened to
+// clipboardPreviewLength characters
+func clipboardPreview(e *clipboard.HistoryEntry) string {
+	content := e.Content()
+	if content.IsImage() {
+		return ""
+	}
+	text := content.Text()
+	if text == "" {
+		text = content.HTML
+	}
+	return preview(text, clipboardPreviewLength)
+}
+
+// preview is the first line of text, shortened to length characters
+func preview(text string, length int) string {
+	line, _, more := strings.Cut(strings.TrimSpace(text), "\n")
+	if utf8.RuneCountInString(line) > length {
+		line = string([]rune(line)[:length])
+		more = true
+	}
+	if more {
+		line += "…"
+	}
+	return line
+}
+
+func (d *daemonState) deviceStatuses() []control.DeviceStatus {
+	var statuses []control.DeviceStatus
+	for _, dev := range d.server.PairedDevices() {
+		status := control.DeviceStatus{
+			ID:       dev.ID,
+			Name:     dev.Name,
+			Enabled:  dev.Enabled,
+			LastSeen: dev.LastSeen,
+			Pending:  d.server.PendingMessages(dev.ID),
+		}
+		if conn := d.server.GetDeviceConnection(dev.ID); conn != nil && conn.IsConnected() {
+			status.Connected = true
+			status.Encrypted = conn.IsEncrypted()
+			status.QueueDepth = conn.QueueLen()
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses
+}
@@ -1,58 +1,350 @@
 package cmd
 
 import (
+	"errors"
 	"fmt"
+	"strings"
+	"time"
 
 	"eco/internal/config"
+	"eco/internal/control"
+	"eco/internal/crypto"
+	"eco/internal/tlscert"
 	"github.com/spf13/cobra"
 )
 
 func init() {
 	rootCmd.AddCommand(devicesCmd)
+	devicesCmd.AddCommand(devicesListCmd)
+	devicesCmd.AddCommand(devicesAddCmd)
+	devicesCmd.AddCommand(devicesRemoveCmd)
+	devicesCmd.AddCommand(devicesRenameCmd)
+	devicesCmd.AddCommand(devicesEnableCmd)
+	devicesCmd.AddCommand(devicesDisableCmd)
+	devicesCmd.AddCommand(devicesClipboardCmd)
+	devicesCmd.AddCommand(devicesDisconnectCmd)
 }
 
 var devicesCmd = &cobra.Command{
 	Use:   "devices",
 	Short: "Show registered device information",
-	Long: `Display information about the registered mobile device.
-	
-This command shows:
-  - Registered device ID
-  - Connection status (if daemon is running)
+	Long: `Display information about the paired mobile devices.
+
+This command shows, for every paired device:
+  - Device ID and name
+  - Whether the device is enabled
+  - When it was paired and last seen
   - Device credentials (optional, with --show-secret flag)
 
-For security, the shared secret is not displayed by default.`,
+For security, shared secrets are not displayed by default.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		listDevices(cmd)
+	},
+}
+
+var devicesListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List paired devices",
+	Run: func(cmd *cobra.Command, args []string) {
+		listDevices(cmd)
+	},
+}
+
+var devicesAddCmd = &cobra.Command{
+	Use:   "add",
+	Short: "Pair a new device",
+	Long: `Generate credentials for a new mobile device and add it to the registry.
+
+The device ID and secret are printed once; enter them in the Eco mobile app.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		secret, err := crypto.GenerateSecret()
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+
+		name, _ := cmd.Flags().GetString("name")
+		dev := &config.Device{
+			ID:      crypto.GenerateDeviceID(),
+			Name:    name,
+			Secret:  secret,
+			Enabled: true,
+		}
+
+		params := &control.DeviceAddParams{DeviceID: dev.ID, Name: dev.Name, Secret: dev.Secret}
+		err = changeDevices(control.MethodDeviceAdd, params, func(cfg *config.Config) error {
+			return cfg.AddDevice(dev)
+		})
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+
+		fmt.Println("✓ Device added")
+		fmt.Println("")
+		fmt.Printf("Name:      %s\n", dev.Name)
+		fmt.Printf("Device ID: %s\n", dev.ID)
+		fmt.Printf("Secret:    %s\n", dev.Secret)
+		fmt.Println("")
+		fmt.Println("Enter these credentials in the Eco mobile app to connect.")
+	},
+}
+
+var devicesRemoveCmd = &cobra.Command{
+	Use:   "remove <device-id>",
+	Short: "Unpair a device",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		err := changeDevices(control.MethodDeviceRemove, &control.DeviceParams{DeviceID: args[0]}, func(cfg *config.Config) error {
+			if err := cfg.RemoveDevice(args[0]); err != nil {
+				return err
+			}
+			// Don't keep what was queued for the device around
+			q, err := openOfflineQueue()
+			if err != nil {
+				return err
+			}
+			return q.Clear(args[0])
+		})
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+
+		fmt.Printf("✓ Device %s removed\n", args[0])
+	},
+}
+
+var devicesRenameCmd = &cobra.Command{
+	Use:   "rename <device-id> <name>",
+	Short: "Rename a paired device",
+	Args:  cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		params := &control.DeviceRenameParams{DeviceID: args[0], Name: args[1]}
+		err := changeDevices(control.MethodDeviceRename, params, func(cfg *config.Config) error {
+			return cfg.RenameDevice(args[0], args[1])
+		})
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+
+		fmt.Printf("✓ Device %s renamed to %q\n", args[0], args[1])
+	},
+}
+
+var devicesEnableCmd = &cobra.Command{
+	Use:   "enable <device-id>",
+	Short: "Let a disabled device connect again",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := enableDevice(args[0], true); err != nil {
+			fmt.Println(err)
+			return
+		}
+		fmt.Printf("✓ Device %s enabled\n", args[0])
+	},
+}
+
+var devicesDisableCmd = &cobra.Command{
+	Use:   "disable <device-id>",
+	Short: "Keep a device from connecting without unpairing it",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := enableDevice(args[0], false); err != nil {
+			fmt.Println(err)
+			return
+		}
+		fmt.Printf("✓ Device %s disabled\n", args[0])
+	},
+}
+
+var devicesClipboardCmd = &cobra.Command{
+	Use:   "clipboard <device-id> on|off",
+	Short: "Turn sending desktop clipboard changes to a device on or off",
+	Args:  cobra.ExactArgs(2),
 	Run: func(cmd *cobra.Command, args []string) {
-		cfg, err := config.Load()
+		var sync bool
+		switch args[1] {
+		case "on":
+			sync = true
+		case "off":
+		default:
+			fmt.Printf("Expected on or off, got %q\n", args[1])
+			return
+		}
+
+		params := &control.DeviceClipboardParams{DeviceID: args[0], Sync: sync}
+		err := changeDevices(control.MethodDeviceClipboard, params, func(cfg *config.Config) error {
+			return cfg.SyncDeviceClipboard(args[0], sync)
+		})
 		if err != nil {
 			fmt.Println(err)
 			return
 		}
 
-		if !cfg.IsInitialized() {
-			fmt.Println("No device registered. Run 'eco init' to register a device.")
+		if sync {
+			fmt.Printf("✓ Clipboard changes are sent to device %s\n", args[0])
+		} else {
+			fmt.Printf("✓ Clipboard changes are no longer sent to device %s\n", args[0])
+		}
+	},
+}
+
+var devicesDisconnectCmd = &cobra.Command{
+	Use:   "disconnect <device-id>",
+	Short: "Disconnect a device from the running daemon",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		err := control.Call(control.MethodDeviceDisconnect, &control.DeviceParams{DeviceID: args[0]}, nil)
+		if err != nil {
+			fmt.Printf("Error disconnecting device: %s\n", err)
 			return
 		}
+		fmt.Printf("✓ Device %s disconnected\n", args[0])
+	},
+}
+
+// enableDevice lets deviceID connect, or disconnects it and keeps it out
+func enableDevice(deviceID string, enabled bool) error {
+	params := &control.DeviceEnableParams{DeviceID: deviceID, Enabled: enabled}
+	return changeDevices(control.MethodDeviceEnable, params, func(cfg *config.Config) error {
+		return cfg.EnableDevice(deviceID, enabled)
+	})
+}
+
+// changeDevices changes the paired devices through the daemon with method,
+// so it applies at once and isn't overwritten when the daemon saves its
+// config, or with change in the config file when the daemon isn't running
+func changeDevices(method string, params any, change func(cfg *config.Config) error) error {
+	err := control.Call(method, params, nil)
+	if !errors.Is(err, control.ErrDaemonNotRunning) {
+		return err
+	}
+
+	cfg, err := config.Load()
+	if err != nil {
+		return err
+	}
+	if err := change(cfg); err != nil {
+		return err
+	}
+	return cfg.Save()
+}
 
-		fmt.Println("Registered Device")
-		fmt.Println("=================")
-		fmt.Println("Device ID: " + cfg.DeviceID)
+func listDevices(cmd *cobra.Command) {
+	cfg, err := config.Load()
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	if !cfg.IsInitialized() {
+		fmt.Println("No device registered. Run 'eco init' to register a device.")
+		return
+	}
+
+	showSecret, _ := cmd.Flags().GetBool("show-secret")
+
+	// Connection state is only known while the daemon is running
+	var statuses []control.DeviceStatus
+	daemonErr := control.Call(control.MethodDevices, nil, &statuses)
+
+	fmt.Println("Registered Devices")
+	fmt.Println("==================")
+	fmt.Println("Daemon certificate: " + certificateFingerprint(cfg))
+	for _, d := range cfg.Devices {
+		fmt.Println("")
+		fmt.Println("Name:      " + d.Name)
+		fmt.Println("Device ID: " + d.ID)
+		fmt.Printf("Enabled:   %t\n", d.Enabled)
+		fmt.Println("Status:    " + connectionState(d.ID, statuses, daemonErr))
+		fmt.Println("Software:  " + deviceSoftware(d))
+		fmt.Println("Features:  " + deviceFeatures(d))
+		if d.NeverSyncClipboard {
+			fmt.Println("Clipboard: never sent to this device")
+		}
+		if d.AllowInput {
+			fmt.Println("Input:     allowed to type and move the pointer")
+		}
+		fmt.Println("Paired:    " + formatTime(d.CreatedAt))
+		fmt.Println("Last seen: " + formatTime(d.LastSeen))
 
-		if cmd.Flags().Changed("show-secret") {
-			fmt.Println("Secret: " + cfg.SharedSecret)
+		if showSecret {
+			fmt.Println("Secret:    " + d.Secret)
 		} else {
 			fmt.Println("Secret:    ******** (use --show-secret to reveal)")
 		}
+	}
+}
 
-		//   5. Display connection status (optional)
-		//      - Check if daemon is running
-		//      - Print: "Status: connected / disconnected / unknown"
-		//      - Note: Actual connection status requires IPC with daemon
+// connectionState describes whether deviceID is connected to the daemon
+func connectionState(deviceID string, statuses []control.DeviceStatus, daemonErr error) string {
+	if daemonErr != nil {
+		return "unknown (daemon not running)"
+	}
+	for _, s := range statuses {
+		if s.ID == deviceID && s.Connected {
+			if !s.Encrypted {
+				return "connected (unencrypted)"
+			}
+			return "connected (encrypted)"
+		}
+	}
+	return "disconnected"
+}
 
-		// fmt.Println("eco devices")
-	},
+// deviceSoftware describes the app a device reported in its last hello
+func deviceSoftware(d *config.Device) string {
+	if d.Platform == "" && d.AppVersion == "" && d.ProtocolVersion == 0 {
+		if d.Capabilities == nil {
+			return "unknown (not connected yet)"
+		}
+		return "unknown (app predates version negotiation)"
+	}
+	software := strings.TrimSpace(d.Platform + " " + d.AppVersion)
+	if software == "" {
+		software = "unknown app"
+	}
+	return fmt.Sprintf("%s (protocol %d)", software, d.ProtocolVersion)
+}
+
+// deviceFeatures lists the capabilities a device advertised in its last hello
+func deviceFeatures(d *config.Device) string {
+	switch {
+	case d.Capabilities == nil:
+		return "unknown (not connected yet)"
+	case len(d.Capabilities) == 0:
+		return "none"
+	}
+	return strings.Join(d.Capabilities, ", ")
+}
+
+// certificateFingerprint describes the certificate devices should pin
+func certificateFingerprint(cfg *config.Config) string {
+	if cfg.DisableTLS {
+		return "none (TLS disabled)"
+	}
+	certPath, _, err := tlscert.Paths()
+	if err != nil {
+		return "unknown"
+	}
+	fp, err := tlscert.ReadFingerprint(certPath)
+	if err != nil {
+		return "not generated yet (start the daemon)"
+	}
+	return "SHA256 " + tlscert.FormatFingerprint(fp)
+}
+
+// formatTime renders t for CLI output, or "never" for the zero time
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return "never"
+	}
+	return t.Local().Format(time.DateTime)
 }
 
 func init() {
-	devicesCmd.Flags().Bool("show-secret", false, "Show the shared secret")
+	devicesCmd.PersistentFlags().Bool("show-secret", false, "Show the shared secret")
+	devicesAddCmd.Flags().String("name", "mobile", "Display name for the device")
 }
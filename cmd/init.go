@@ -10,6 +10,8 @@ import (
 
 func init() {
 	rootCmd.AddCommand(initCmd)
+	initCmd.Flags().String("name", "default", "Display name for the first paired device")
+	initCmd.Flags().Bool("require-encryption", false, "Refuse devices that can't encrypt message payloads")
 }
 
 var initCmd = &cobra.Command{
@@ -23,7 +25,8 @@ This command will:
   3. Save configuration to ~/.config/eco/config.json
   4. Display the device ID and secret for mobile pairing
 
-The secret must be entered manually on the mobile device to establish a secure connection.`,
+The secret must be entered manually on the mobile device to establish a secure connection.
+Use 'eco devices add' to pair additional devices afterwards.`,
 	Run: func(cmd *cobra.Command, args []string) {
 		// TODO: Implement init command
 		// Steps:
@@ -56,12 +59,21 @@ The secret must be entered manually on the mobile device to establish a secure c
 		//      - cfg := &config.Config{...}
 		//      - err := cfg.Save()
 
-		newConfig := &config.Config{
-			DeviceID:     deviceID,
-			SharedSecret: secret,
+		name, _ := cmd.Flags().GetString("name")
+		err = cfg.AddDevice(&config.Device{
+			ID:      deviceID,
+			Name:    name,
+			Secret:  secret,
+			Enabled: true,
+		})
+		if err != nil {
+			fmt.Println(err)
+			return
 		}
 
-		cfgSaveErr := newConfig.Save()
+		cfg.RequireEncryption, _ = cmd.Flags().GetBool("require-encryption")
+
+		cfgSaveErr := cfg.Save()
 		if cfgSaveErr != nil {
 			fmt.Println(cfgSaveErr)
 			return
@@ -0,0 +1,60 @@
+package cmd
+
+import (
+	"fmt"
+
+	"eco/internal/config"
+	"eco/internal/control"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	rootCmd.AddCommand(inputCmd)
+	inputCmd.AddCommand(inputAllowCmd)
+	inputCmd.AddCommand(inputDenyCmd)
+}
+
+var inputCmd = &cobra.Command{
+	Use:   "input",
+	Short: "Let devices act as keyboard and mouse",
+	Long: `Allow or deny a paired device to type and move the pointer on this
+desktop through a virtual keyboard and mouse.
+
+Devices can't send input until allowed, since a device that can type can do
+anything you can. The daemon needs write access to /dev/uinput, usually given
+by a udev rule and membership of the input group.`,
+}
+
+var inputAllowCmd = &cobra.Command{
+	Use:   "allow <device-id>",
+	Short: "Let a device type and move the pointer",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := allowInput(args[0], true); err != nil {
+			fmt.Printf("Error allowing input: %s\n", err)
+			return
+		}
+		fmt.Printf("✓ Device %s may now type and move the pointer\n", args[0])
+	},
+}
+
+var inputDenyCmd = &cobra.Command{
+	Use:   "deny <device-id>",
+	Short: "Stop a device from typing and moving the pointer",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := allowInput(args[0], false); err != nil {
+			fmt.Printf("Error denying input: %s\n", err)
+			return
+		}
+		fmt.Printf("✓ Device %s may no longer type or move the pointer\n", args[0])
+	},
+}
+
+// allowInput changes the input permission of deviceID
+func allowInput(deviceID string, allow bool) error {
+	params := &control.InputAllowParams{DeviceID: deviceID, Allow: allow}
+	return changeDevices(control.MethodInputAllow, params, func(cfg *config.Config) error {
+		return cfg.AllowDeviceInput(deviceID, allow)
+	})
+}
@@ -10,6 +10,8 @@ import (
 	"strings"
 	"testing"
 	"time"
+
+	"eco/internal/control"
 )
 
 // TestCLIIntegration performs end-to-end testing of the CLI
@@ -113,11 +115,21 @@ func TestCLIIntegration(t *testing.T) {
 		// Wait for daemon to start
 		time.Sleep(2 * time.Second)
 
-		// Check PID file exists
-		pidFile := "/tmp/eco.pid"
-		if _, err := os.Stat(pidFile); os.IsNotExist(err) {
+		// Check control socket exists
+		socketPath := control.SocketPath()
+		if _, err := os.Stat(socketPath); os.IsNotExist(err) {
+			startCmd.Process.Kill()
+			t.Fatal("Daemon control socket not created")
+		}
+
+		statusCmd := exec.Command(binaryPath, "status")
+		statusOutput, err := statusCmd.CombinedOutput()
+		if err != nil {
 			startCmd.Process.Kill()
-			t.Fatal("Daemon PID file not created")
+			t.Fatalf("status command failed: %v\nOutput: %s", err, statusOutput)
+		}
+		if !strings.Contains(string(statusOutput), "Daemon:      running") {
+			t.Errorf("status output missing running daemon: %s", statusOutput)
 		}
 
 		// Stop daemon
@@ -135,16 +147,13 @@ func TestCLIIntegration(t *testing.T) {
 		// Wait for daemon to stop
 		time.Sleep(1 * time.Second)
 
-		// Verify PID file is removed
-		if _, err := os.Stat(pidFile); !os.IsNotExist(err) {
-			t.Error("PID file was not removed after stop")
+		// Verify control socket is removed
+		if _, err := os.Stat(socketPath); !os.IsNotExist(err) {
+			t.Error("Control socket was not removed after stop")
 		}
 	})
 
 	t.Run("StopWhenNotRunning", func(t *testing.T) {
-		// Ensure no PID file
-		os.Remove("/tmp/eco.pid")
-
 		cmd := exec.Command(binaryPath, "stop")
 		output, err := cmd.CombinedOutput()
 		// This should not error, just inform user
@@ -158,6 +167,14 @@ func TestCLIIntegration(t *testing.T) {
 		}
 	})
 
+	t.Run("MobileWhenNotRunning", func(t *testing.T) {
+		cmd := exec.Command(binaryPath, "mobile", "ring")
+		output, _ := cmd.CombinedOutput()
+		if code := cmd.ProcessState.ExitCode(); code != mobileExitNoDaemon {
+			t.Errorf("mobile ring exit code = %d, want %d\nOutput: %s", code, mobileExitNoDaemon, output)
+		}
+	})
+
 	t.Run("ConfigDelete", func(t *testing.T) {
 		cmd := exec.Command(binaryPath, "config", "delete")
 		output, err := cmd.CombinedOutput()
@@ -0,0 +1,119 @@
+package cmd
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"os"
+
+	"eco/internal/control"
+	"github.com/spf13/cobra"
+)
+
+// Exit codes of 'eco mobile', for scripts
+const (
+	mobileExitError       = 1
+	mobileExitNoDaemon    = 2
+	mobileExitUnavailable = 3
+	mobileExitRejected    = 4
+	mobileExitTimeout     = 5
+)
+
+func init() {
+	rootCmd.AddCommand(mobileCmd)
+	mobileCmd.AddCommand(mobileOpenCmd)
+	mobileCmd.AddCommand(mobileURLCmd)
+	mobileCmd.AddCommand(mobileRingCmd)
+	mobileCmd.AddCommand(mobileLockCmd)
+
+	mobileCmd.PersistentFlags().StringP("device", "d", "", "Device to ask (default: the only connected device)")
+}
+
+var mobileCmd = &cobra.Command{
+	Use:   "mobile",
+	Short: "Open apps and URLs, ring or lock the phone",
+	Long: `Ask a connected phone to open an app or URL, ring, or lock its screen.
+
+Each command waits until the phone has done it, or says why it couldn't.
+The exit code tells scripts how it went:
+  0  done
+  1  other error, like an invalid package name or URL
+  2  the daemon is not running
+  3  no device can take the request: none is connected, several are and
+     --device isn't given, the one chosen isn't connected, or its app
+     doesn't support it
+  4  the phone refused
+  5  the phone didn't answer in time`,
+}
+
+var mobileOpenCmd = &cobra.Command{
+	Use:     "open <package>",
+	Short:   "Launch an app by its package name",
+	Example: "  eco mobile open com.spotify.music",
+	Args:    cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		runMobile(cmd, control.MethodMobileOpen, &control.MobileParams{Package: args[0]}, "Opened "+args[0])
+	},
+}
+
+var mobileURLCmd = &cobra.Command{
+	Use:     "url <url>",
+	Short:   "Open a URL with the app the phone has for it",
+	Example: "  eco mobile url https://example.com\n  eco mobile url tel:+15551234567",
+	Args:    cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		runMobile(cmd, control.MethodMobileURL, &control.MobileParams{URL: args[0]}, "Opened "+args[0])
+	},
+}
+
+var mobileRingCmd = &cobra.Command{
+	Use:   "ring",
+	Short: "Ring the phone to find it",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		runMobile(cmd, control.MethodMobileRing, &control.MobileParams{}, "Ringing")
+	},
+}
+
+var mobileLockCmd = &cobra.Command{
+	Use:   "lock",
+	Short: "Lock the phone's screen",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		runMobile(cmd, control.MethodMobileLock, &control.MobileParams{}, "Locked")
+	},
+}
+
+// runMobile calls method for the device chosen with --device and exits with
+// the code for how it went
+func runMobile(cmd *cobra.Command, method string, params *control.MobileParams, done string) {
+	params.DeviceID, _ = cmd.Flags().GetString("device")
+
+	var result control.MobileResult
+	if err := control.Call(method, params, &result); err != nil {
+		if errors.Is(err, control.ErrDaemonNotRunning) {
+			fmt.Println("Daemon is not running. Run 'eco daemon start' first.")
+			os.Exit(mobileExitNoDaemon)
+		}
+		fmt.Printf("Error: %s\n", err)
+		os.Exit(mobileExitCode(err))
+	}
+	fmt.Printf("✓ %s (%s)\n", done, cmp.Or(result.DeviceName, result.DeviceID))
+}
+
+// mobileExitCode returns the exit code for an error of a mobile method
+func mobileExitCode(err error) int {
+	var ctlErr *control.Error
+	if !errors.As(err, &ctlErr) {
+		return mobileExitError
+	}
+	switch ctlErr.Code {
+	case control.CodeDeviceUnavailable:
+		return mobileExitUnavailable
+	case control.CodeDeviceRejected:
+		return mobileExitRejected
+	case control.CodeDeviceTimeout:
+		return mobileExitTimeout
+	}
+	return mobileExitError
+}
@@ -0,0 +1,37 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"eco/internal/device"
+	"eco/internal/protocol"
+	"eco/internal/server"
+)
+
+func TestMobileExitCode(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want int
+	}{
+		{"No device", fmt.Errorf("%w: none is connected", server.ErrNoDevice), mobileExitUnavailable},
+		{"Several devices", fmt.Errorf("%w: 2 are connected, choose one with --device", server.ErrNoDevice), mobileExitUnavailable},
+		{"Not connected", fmt.Errorf("device mobile-1 is %w", server.ErrNotConnected), mobileExitUnavailable},
+		{"Unsupported", fmt.Errorf("mobile is %w by device mobile-1", server.ErrUnsupported), mobileExitUnavailable},
+		{"Disconnected", device.ErrConnectionClosed, mobileExitUnavailable},
+		{"Rejected", &device.RejectedError{Type: protocol.MessageTypeMobileLock, Reason: "no permission"}, mobileExitRejected},
+		{"Timeout", device.ErrRequestTimeout, mobileExitTimeout},
+		{"Other", errors.New("invalid params"), mobileExitError},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// As the error reaches the CLI from the daemon
+			if got := mobileExitCode(deviceError(tt.err)); got != tt.want {
+				t.Errorf("mobileExitCode(%v) = %d, want %d", tt.err, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,96 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"time"
+
+	"eco/internal/control"
+	"eco/internal/pairing"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	rootCmd.AddCommand(pairCmd)
+	pairCmd.Flags().String("name", "", "Display name for the new device (defaults to the name it reports)")
+	pairCmd.Flags().Duration("timeout", pairing.DefaultTTL, "How long the pairing code stays valid")
+}
+
+var pairCmd = &cobra.Command{
+	Use:   "pair",
+	Short: "Pair a new device with a one-time code",
+	Long: `Pair a new mobile device using a short one-time code.
+
+The running daemon generates a 6-digit code. Type it into the Eco mobile app
+(or scan the QR link). The app and the daemon then run a SPAKE2 exchange that
+turns the code into a long random key for the device; the key itself is never
+sent over the network.
+
+Each code works for a single attempt and expires after --timeout.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		name, _ := cmd.Flags().GetString("name")
+		timeout, _ := cmd.Flags().GetDuration("timeout")
+
+		var session pairing.Session
+		err := control.Call(control.MethodPairBegin, &control.PairParams{
+			Name:       name,
+			TTLSeconds: int(timeout.Seconds()),
+		}, &session)
+		if errors.Is(err, control.ErrDaemonNotRunning) {
+			fmt.Println("Daemon is not running. Run 'eco daemon start' first.")
+			return
+		}
+		if err != nil {
+			fmt.Printf("Error starting pairing: %s\n", err)
+			return
+		}
+
+		fmt.Println("Pairing code")
+		fmt.Println("============")
+		fmt.Printf("    %s-%s\n", session.Code[:3], session.Code[3:])
+		fmt.Println("")
+		scheme := "http"
+		var status control.Status
+		if control.Call(control.MethodStatus, nil, &status) == nil && status.TLSFingerprint != "" {
+			scheme = "https"
+		}
+
+		fmt.Println("Enter this code in the Eco mobile app, or scan:")
+		fmt.Printf("    %s://localhost:4949/qr?code=%s\n", scheme, session.Code)
+		fmt.Printf("Expires at %s. Waiting for device...\n", session.ExpiresAt.Local().Format(time.TimeOnly))
+
+		interrupt := make(chan os.Signal, 1)
+		signal.Notify(interrupt, os.Interrupt)
+		ticker := time.NewTicker(500 * time.Millisecond)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-interrupt:
+				control.Call(control.MethodPairCancel, &control.PairParams{Code: session.Code}, nil)
+				fmt.Println("\nPairing cancelled")
+				return
+			case <-ticker.C:
+			}
+
+			var status pairing.Session
+			if err := control.Call(control.MethodPairStatus, &control.PairParams{Code: session.Code}, &status); err != nil {
+				fmt.Printf("Error checking pairing status: %s\n", err)
+				return
+			}
+
+			switch status.State {
+			case pairing.StatePaired:
+				fmt.Println("")
+				fmt.Println("✓ Device paired successfully!")
+				fmt.Printf("Device ID: %s\n", status.DeviceID)
+				return
+			case pairing.StateFailed, pairing.StateExpired, pairing.StateCancelled:
+				fmt.Printf("\nPairing %s: %s\n", status.State, status.Error)
+				return
+			}
+		}
+	},
+}
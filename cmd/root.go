@@ -5,9 +5,14 @@ import (
 	"os"
 )
 
+// Version is the eco release. Release builds set it with
+// -ldflags "-X eco/cmd.Version=<version>".
+var Version = "dev"
+
 var rootCmd = &cobra.Command{
-	Use:   "eco",
-	Short: "eco - LinuxXAndroid ecosystem CLI",
+	Use:     "eco",
+	Short:   "eco - LinuxXAndroid ecosystem CLI",
+	Version: Version,
 }
 
 func Execute() {
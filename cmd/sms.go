@@ -0,0 +1,172 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"eco/internal/control"
+	"eco/internal/server"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	rootCmd.AddCommand(smsCmd)
+	smsCmd.AddCommand(smsListCmd)
+	smsCmd.AddCommand(smsReadCmd)
+	smsCmd.AddCommand(smsSendCmd)
+
+	smsListCmd.Flags().IntP("limit", "l", 0, "Show only the most recent conversations")
+	smsReadCmd.Flags().IntP("limit", "l", 20, "Show only the newest messages (0 for all)")
+	smsReadCmd.Flags().StringP("device", "d", "", "Device the conversation is on, when several have one")
+	smsSendCmd.Flags().StringP("device", "d", "", "Device to send from (default: the only connected device)")
+}
+
+var smsCmd = &cobra.Command{
+	Use:   "sms",
+	Short: "Read and send text messages through a connected phone",
+	Long: `Read and send the text messages of your phones.
+
+The daemon keeps the conversations the phones report, and asks each phone
+for the messages it missed when it connects.`,
+}
+
+var smsListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List conversations, most recent first",
+	Run: func(cmd *cobra.Command, args []string) {
+		limit, _ := cmd.Flags().GetInt("limit")
+
+		var threads []control.SMSThread
+		if err := control.Call(control.MethodSMSList, nil, &threads); err != nil {
+			if errors.Is(err, control.ErrDaemonNotRunning) {
+				fmt.Println("Daemon is not running. Run 'eco daemon start' first.")
+				return
+			}
+			fmt.Printf("Error listing conversations: %s\n", err)
+			return
+		}
+
+		if len(threads) == 0 {
+			fmt.Println("No conversations yet.")
+			return
+		}
+		if limit > 0 && len(threads) > limit {
+			threads = threads[:limit]
+		}
+		for _, t := range threads {
+			unread := ""
+			if t.Unread > 0 {
+				unread = fmt.Sprintf("(%d new) ", t.Unread)
+			}
+			fmt.Printf("%s  %-24s  %s%s\n", t.Time.Local().Format(time.DateTime), threadName(&t), unread, t.Snippet)
+		}
+	},
+}
+
+var smsReadCmd = &cobra.Command{
+	Use:   "read <contact|number>",
+	Short: "Print a conversation",
+	Long: `Print the newest messages of the conversation with a contact, given by
+name or number:
+
+  eco sms read Ana
+  eco sms read +15551234`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		limit, _ := cmd.Flags().GetInt("limit")
+		deviceID, _ := cmd.Flags().GetString("device")
+
+		var thread control.SMSThread
+		params := &control.SMSThreadParams{Thread: args[0], DeviceID: deviceID, Limit: limit}
+		if err := control.Call(control.MethodSMSRead, params, &thread); err != nil {
+			if errors.Is(err, control.ErrDaemonNotRunning) {
+				fmt.Println("Daemon is not running. Run 'eco daemon start' first.")
+				return
+			}
+			fmt.Printf("Error reading the conversation: %s\n", err)
+			return
+		}
+
+		fmt.Printf("Conversation with %s\n\n", threadName(&thread))
+		for _, m := range thread.Messages {
+			from := thread.ContactName
+			if from == "" {
+				from = thread.Address
+			}
+			if m.Sent {
+				from = "Me"
+			}
+			fmt.Printf("%s  %s: %s\n", m.Time.Local().Format(time.DateTime), from, m.Body)
+		}
+	},
+}
+
+var smsSendCmd = &cobra.Command{
+	Use:   "send <contact|number> [text]",
+	Short: "Send a text message",
+	Long: `Send a text message from a connected phone. The recipient is a number,
+or the name of a contact you have a conversation with. Without text the
+message is read from standard input:
+
+  eco sms send +15551234 "On my way"
+  echo "On my way" | eco sms send Ana`,
+	Args: cobra.RangeArgs(1, 2),
+	Run: func(cmd *cobra.Command, args []string) {
+		deviceID, _ := cmd.Flags().GetString("device")
+
+		var body string
+		if len(args) == 2 {
+			body = args[1]
+		} else {
+			data, err := io.ReadAll(os.Stdin)
+			if err != nil {
+				fmt.Printf("Error reading standard input: %s\n", err)
+				return
+			}
+			body = strings.TrimRight(string(data), "\n")
+		}
+		if body == "" {
+			fmt.Println("Nothing to send.")
+			return
+		}
+
+		client, err := control.Dial()
+		if err != nil {
+			fmt.Println("Daemon is not running. Run 'eco daemon start' first.")
+			return
+		}
+		defer client.Close()
+		// Outwait the daemon, so a slow phone isn't taken for a failed send
+		// that the user then retries
+		client.Timeout = server.SMSSendTimeout + 5*time.Second
+
+		var sent control.SMSSent
+		params := &control.SMSSendParams{DeviceID: deviceID, To: args[0], Body: body}
+		if err := client.Call(control.MethodSMSSend, params, &sent); err != nil {
+			fmt.Printf("Error sending the message: %s\n", err)
+			return
+		}
+
+		to := sent.Address
+		if sent.ContactName != "" {
+			to = fmt.Sprintf("%s (%s)", sent.ContactName, sent.Address)
+		}
+		device := sent.DeviceName
+		if device == "" {
+			device = sent.DeviceID
+		}
+		fmt.Printf("✓ Sent to %s from %s\n", to, device)
+	},
+}
+
+// threadName names a conversation by its contact, with the number
+func threadName(t *control.SMSThread) string {
+	if t.ContactName == "" {
+		return t.Address
+	}
+	return fmt.Sprintf("%s (%s)", t.ContactName, t.Address)
+}
@@ -1,9 +1,14 @@
 package cmd
 
 import (
+	"errors"
 	"fmt"
+	"sort"
+	"time"
 
 	"eco/internal/config"
+	"eco/internal/control"
+	"eco/internal/tlscert"
 	"github.com/spf13/cobra"
 )
 
@@ -16,15 +21,11 @@ var statusCmd = &cobra.Command{
 	Short: "Show eco daemon status",
 	Long: `Display the current status of the eco system including:
   - Initialization status
+  - Daemon status and uptime
   - Device connection status
   - Active listeners (clipboard, notifications)
-  - Last sync time (optional, for future enhancement)`,
+  - Queue depths and last sync time`,
 	Run: func(cmd *cobra.Command, args []string) {
-		// TODO: Implement status command
-		// Steps:
-		//   1. Load configuration
-		//      - cfg, err := config.Load()
-
 		cfg, err := config.Load()
 		if err != nil {
 			fmt.Println(err)
@@ -39,7 +40,10 @@ var statusCmd = &cobra.Command{
 		fmt.Println("Eco Status")
 		fmt.Println("==========")
 		fmt.Println("Initialized: yes")
-		fmt.Println("Device ID:   " + cfg.DeviceID)
+		fmt.Printf("Devices:     %d paired\n", len(cfg.Devices))
+		for _, d := range cfg.Devices {
+			fmt.Printf("Device ID:   %s (%s)\n", d.ID, d.Name)
+		}
 
 		cfgPath, err := config.ConfigPath()
 		if err != nil {
@@ -49,28 +53,60 @@ var statusCmd = &cobra.Command{
 
 		fmt.Println("Config path", cfgPath)
 
-		//   3. Display configuration info
-		//      - Device ID
-		//      - Config file path
-		//   4. Check daemon status (optional for MVP)
-		//      - Check if daemon is running (could check PID file or just state)
-		//      - Print: "Daemon: running / not running"
-		//
-		//   5. Display device connection status (optional for MVP)
-		//      - This would require inter-process communication
-		//      - Could be implemented later with a status file or socket
-		//      - For now, just print: "Run 'eco daemon start' to begin"
-		//
-		//   6. Format output nicely
-		//      Example:
-		//      "Eco Status"
-		//      "=========="
-		//      "Initialized: yes"
-		//      "Device ID:   mobile-xxxxx"
-		//      "Daemon:      not running"
-		//      ""
-		//      "Run 'eco daemon start' to start the daemon."
+		var status control.Status
+		err = control.Call(control.MethodStatus, nil, &status)
+		if errors.Is(err, control.ErrDaemonNotRunning) {
+			fmt.Println("Daemon:      not running")
+			fmt.Println("")
+			fmt.Println("Run 'eco daemon start' to start the daemon.")
+			return
+		}
+		if err != nil {
+			fmt.Printf("Daemon:      unknown (%s)\n", err)
+			return
+		}
+
+		uptime := time.Duration(status.Uptime * float64(time.Second)).Round(time.Second)
+		fmt.Printf("Daemon:      running (PID %d, up %s)\n", status.PID, uptime)
+		fmt.Printf("Listeners:   %v\n", status.Listeners)
+		if status.Clipboard != "" {
+			fmt.Println("Clipboard:   " + status.Clipboard)
+		}
+		fmt.Println("Last sync:   " + formatTime(status.LastSync))
+		if status.TLSFingerprint != "" {
+			fmt.Println("TLS:         SHA256 " + tlscert.FormatFingerprint(status.TLSFingerprint))
+		} else {
+			fmt.Println("TLS:         disabled")
+		}
+
+		queues := make([]string, 0, len(status.QueueDepths))
+		for name := range status.QueueDepths {
+			queues = append(queues, name)
+		}
+		sort.Strings(queues)
+		for _, name := range queues {
+			fmt.Printf("Queue:       %s = %d\n", name, status.QueueDepths[name])
+		}
+
+		var handled, failed int64
+		for _, s := range status.Messages {
+			handled += s.Handled
+			failed += s.Failed
+		}
+		fmt.Printf("Messages:    %d received from devices (%d failed)\n", handled, failed)
 
-		// fmt.Println("eco status")
+		fmt.Println("")
+		for _, d := range status.Devices {
+			state := fmt.Sprintf("disconnected (%d pending)", d.Pending)
+			if d.Connected {
+				state = fmt.Sprintf("connected (%d queued)", d.QueueDepth)
+				if !d.Encrypted {
+					state = fmt.Sprintf("connected, unencrypted (%d queued)", d.QueueDepth)
+				}
+			} else if !d.Enabled {
+				state = "disabled"
+			}
+			fmt.Printf("%-20s %s\n", d.Name, state)
+		}
 	},
 }
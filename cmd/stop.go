@@ -1,12 +1,11 @@
 package cmd
 
 import (
+	"errors"
 	"fmt"
-	"os"
-	"strconv"
-	"syscall"
 	"time"
 
+	"eco/internal/control"
 	"github.com/spf13/cobra"
 )
 
@@ -18,67 +17,47 @@ var stopCmd = &cobra.Command{
 	Use:   "stop",
 	Short: "Stop eco daemon",
 	Long: `Stop the running eco daemon gracefully.
-	
-This command sends a termination signal to the daemon process
-causing it to shutdown cleanly and close all connections.`,
+
+This command asks the daemon over its control socket to shut down
+cleanly and close all connections.`,
 	Run: func(cmd *cobra.Command, args []string) {
-		// Read PID from file
-		pidBytes, err := os.ReadFile("/tmp/eco.pid")
+		client, err := control.Dial()
 		if err != nil {
-			if os.IsNotExist(err) {
-				fmt.Println("Daemon is not running (PID file not found)")
+			if errors.Is(err, control.ErrDaemonNotRunning) {
+				fmt.Println("Daemon is not running (control socket not found)")
 				return
 			}
-			fmt.Printf("Error reading daemon PID: %s\n", err)
-			return
-		}
-
-		// Parse PID
-		pidStr := string(pidBytes)
-		if pidStr == "" {
-			fmt.Println("Daemon is not running (PID file is empty)")
-			return
-		}
-
-		pid, err := strconv.Atoi(pidStr)
-		if err != nil {
-			fmt.Printf("Invalid PID in file: %s\n", err)
+			fmt.Printf("Error connecting to daemon: %s\n", err)
 			return
 		}
 
-		// Find process
-		process, err := os.FindProcess(pid)
-		if err != nil {
-			fmt.Printf("Error finding daemon process: %s\n", err)
+		var status control.Status
+		if err := client.Call(control.MethodStatus, nil, &status); err != nil {
+			client.Close()
+			fmt.Printf("Error querying daemon: %s\n", err)
 			return
 		}
 
-		// Send SIGTERM signal
-		fmt.Printf("Stopping eco daemon (PID: %d)...\n", pid)
-		err = process.Signal(syscall.SIGTERM)
+		fmt.Printf("Stopping eco daemon (PID: %d)...\n", status.PID)
+		err = client.Call(control.MethodDaemonStop, nil, nil)
+		client.Close()
 		if err != nil {
-			fmt.Printf("Error sending signal to daemon: %s\n", err)
+			fmt.Printf("Error stopping daemon: %s\n", err)
 			return
 		}
 
-		// Wait briefly for graceful shutdown
-		time.Sleep(2 * time.Second)
-
-		// Check if process still exists (it might have already exited)
-		err = process.Signal(syscall.Signal(0))
-		if err == nil {
-			// Process still running, try SIGKILL as last resort
-			fmt.Println("Process still running, sending SIGKILL...")
-			process.Signal(syscall.SIGKILL)
-			time.Sleep(1 * time.Second)
-		}
-
-		// Clean up PID file
-		err = os.Remove("/tmp/eco.pid")
-		if err != nil && !os.IsNotExist(err) {
-			fmt.Printf("Warning: Could not remove PID file: %s\n", err)
+		// Wait for the daemon to release its control socket
+		deadline := time.Now().Add(5 * time.Second)
+		for time.Now().Before(deadline) {
+			probe, err := control.Dial()
+			if err != nil {
+				fmt.Println("Eco daemon stopped")
+				return
+			}
+			probe.Close()
+			time.Sleep(200 * time.Millisecond)
 		}
 
-		fmt.Println("Eco daemon stopped")
+		fmt.Println("Daemon did not shut down within 5 seconds")
 	},
 }
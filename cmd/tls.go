@@ -0,0 +1,93 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+
+	"eco/internal/control"
+	"eco/internal/tlscert"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	rootCmd.AddCommand(tlsCmd)
+	tlsCmd.AddCommand(tlsShowCmd)
+	tlsCmd.AddCommand(tlsRotateCmd)
+}
+
+var tlsCmd = &cobra.Command{
+	Use:   "tls",
+	Short: "Manage the daemon's TLS certificate",
+	Long: `Manage the self-signed certificate the daemon serves https:// and wss:// with.
+
+The certificate lives in ~/.config/eco and is created the first time the
+daemon starts. Devices pin its SHA-256 fingerprint, which is included in the
+pairing QR code and shown by 'eco devices'.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		showCertificate()
+	},
+}
+
+var tlsShowCmd = &cobra.Command{
+	Use:   "show",
+	Short: "Show the certificate fingerprint",
+	Run: func(cmd *cobra.Command, args []string) {
+		showCertificate()
+	},
+}
+
+var tlsRotateCmd = &cobra.Command{
+	Use:   "rotate",
+	Short: "Replace the certificate with a new one",
+	Long: `Generate a new self-signed certificate and key.
+
+A running daemon picks up the new certificate for new connections right away.
+Devices that pinned the old fingerprint will refuse to connect until they are
+given the new one, e.g. by scanning the QR code again.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		certPath, keyPath, err := tlscert.Paths()
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+
+		cert, err := tlscert.Generate(certPath, keyPath)
+		if err != nil {
+			fmt.Printf("Error generating certificate: %s\n", err)
+			return
+		}
+
+		fmt.Println("✓ Certificate rotated")
+		fmt.Println("Fingerprint: SHA256 " + tlscert.FormatFingerprint(tlscert.Fingerprint(cert)))
+
+		var status control.TLSStatus
+		err = control.Call(control.MethodTLSReload, nil, &status)
+		switch {
+		case errors.Is(err, control.ErrDaemonNotRunning):
+			fmt.Println("The daemon will use it on its next start.")
+		case err != nil:
+			fmt.Printf("Error reloading the daemon's certificate: %s\n", err)
+			fmt.Println("Restart the daemon to use the new certificate.")
+		default:
+			fmt.Println("The running daemon now serves the new certificate.")
+		}
+		fmt.Println("Devices must pin the new fingerprint before they can reconnect.")
+	},
+}
+
+func showCertificate() {
+	certPath, _, err := tlscert.Paths()
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	fp, err := tlscert.ReadFingerprint(certPath)
+	if err != nil {
+		fmt.Println("No certificate yet. It is created when the daemon starts.")
+		return
+	}
+
+	fmt.Println("Certificate: " + certPath)
+	fmt.Println("Fingerprint: SHA256 " + tlscert.FormatFingerprint(fp))
+}
@@ -0,0 +1,275 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"time"
+
+	"eco/internal/control"
+	"eco/internal/transfer"
+	"github.com/spf13/cobra"
+)
+
+// transferPollInterval is how often the CLI asks the daemon for progress
+const transferPollInterval = 250 * time.Millisecond
+
+func init() {
+	rootCmd.AddCommand(sendCmd)
+	rootCmd.AddCommand(receiveCmd)
+
+	sendCmd.Flags().StringP("device", "d", "", "Device to send to (default: the only connected device)")
+	sendCmd.Flags().BoolP("zstd", "z", false, "Compress folders with zstd")
+	receiveCmd.Flags().StringP("output", "o", "", "Directory to save the files in (default: DownloadDir, or ~/Downloads)")
+	receiveCmd.Flags().StringP("conflict", "c", "", "What to do with files whose name is taken: rename, overwrite or skip (default: DownloadConflict, or rename)")
+	receiveCmd.Flags().BoolP("wait", "w", false, "Wait for a device to offer a file")
+	receiveCmd.Flags().BoolP("list", "l", false, "List the offered files without accepting them")
+}
+
+var sendCmd = &cobra.Command{
+	Use:   "send <file|folder>",
+	Short: "Send a file or folder to a connected device",
+	Long: `Offer a file or folder to a connected device and wait until it has been
+downloaded. The device downloads it from the daemon and checks its SHA-256
+digest. A folder is sent with everything in it as a tar stream, compressed
+with zstd when --zstd is given; symlinks in it are left out.
+
+Press Ctrl+C to cancel the transfer.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		deviceID, _ := cmd.Flags().GetString("device")
+		compress, _ := cmd.Flags().GetBool("zstd")
+		path, err := filepath.Abs(args[0])
+		if err != nil {
+			fmt.Printf("Error: %s\n", err)
+			return
+		}
+
+		client, err := control.Dial()
+		if err != nil {
+			fmt.Println("Daemon is not running. Run 'eco daemon start' first.")
+			return
+		}
+		defer client.Close()
+		// The daemon hashes the file or archives the folder before offering
+		// it, which takes a while for large ones
+		client.Timeout = 0
+
+		var t control.Transfer
+		if err := client.Call(control.MethodFileSend, &control.FileSendParams{DeviceID: deviceID, Path: path, Compress: compress}, &t); err != nil {
+			fmt.Printf("Error sending %s: %s\n", args[0], err)
+			return
+		}
+		fmt.Printf("Offered %s (%s) to %s, waiting for it to accept...\n", t.Name, transferSize(&t), transferDevice(&t))
+		waitForTransfers(client, []string{t.ID})
+	},
+}
+
+var receiveCmd = &cobra.Command{
+	Use:   "receive [id...]",
+	Short: "Accept files offered by devices",
+	Long: `Accept the files and folders devices offered and wait until they have
+arrived. Without IDs every offer is accepted. They are saved in the
+directory set as DownloadDir in the config, ~/Downloads by default, and
+one whose SHA-256 digest doesn't match what the device announced is
+discarded.
+
+A received file whose name is taken is saved as "name (1)" by default.
+--conflict, or DownloadConflict in the config, can overwrite the existing
+file or skip the received one instead. Folders are merged into an
+existing folder of the same name, file by file. Nothing in a folder is
+written outside of it.
+
+Press Ctrl+C to cancel the transfers.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		output, _ := cmd.Flags().GetString("output")
+		conflict, _ := cmd.Flags().GetString("conflict")
+		wait, _ := cmd.Flags().GetBool("wait")
+		list, _ := cmd.Flags().GetBool("list")
+
+		if output != "" {
+			var err error
+			if output, err = filepath.Abs(output); err != nil {
+				fmt.Printf("Error: %s\n", err)
+				return
+			}
+		}
+
+		client, err := control.Dial()
+		if err != nil {
+			fmt.Println("Daemon is not running. Run 'eco daemon start' first.")
+			return
+		}
+		defer client.Close()
+
+		if list {
+			listOffers(client)
+			return
+		}
+
+		interrupt := make(chan os.Signal, 1)
+		signal.Notify(interrupt, os.Interrupt)
+		defer signal.Stop(interrupt)
+
+		for {
+			var accepted []control.Transfer
+			if err := client.Call(control.MethodFileReceive, &control.FileReceiveParams{IDs: args, Dir: output, Conflict: conflict}, &accepted); err != nil {
+				fmt.Printf("Error accepting files: %s\n", err)
+				return
+			}
+			if len(accepted) > 0 {
+				ids := make([]string, len(accepted))
+				for i, t := range accepted {
+					ids[i] = t.ID
+					fmt.Printf("Receiving %s (%s) from %s\n", t.Name, transferSize(&t), transferDevice(&t))
+				}
+				signal.Stop(interrupt)
+				waitForTransfers(client, ids)
+				return
+			}
+
+			if !wait {
+				fmt.Println("No files waiting. Send one from your device, or use --wait.")
+				return
+			}
+			select {
+			case <-interrupt:
+				fmt.Println()
+				return
+			case <-time.After(time.Second):
+			}
+		}
+	},
+}
+
+// listOffers prints the files waiting for 'eco receive'
+func listOffers(client *control.Client) {
+	var transfers []control.Transfer
+	if err := client.Call(control.MethodFileList, nil, &transfers); err != nil {
+		fmt.Printf("Error listing transfers: %s\n", err)
+		return
+	}
+
+	found := false
+	for _, t := range transfers {
+		if t.Direction != transfer.Incoming || t.State != transfer.StateOffered {
+			continue
+		}
+		found = true
+		fmt.Printf("%s  %-32s  %18s  from %s\n", t.ID, t.Name, transferSize(&t), transferDevice(&t))
+	}
+	if !found {
+		fmt.Println("No files waiting.")
+	}
+}
+
+// waitForTransfers shows the progress of the transfers until they are
+// over, cancelling them on Ctrl+C
+func waitForTransfers(client *control.Client, ids []string) {
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	defer signal.Stop(interrupt)
+	ticker := time.NewTicker(transferPollInterval)
+	defer ticker.Stop()
+
+	pending := ids
+	reported := map[string]bool{}
+	for {
+		select {
+		case <-interrupt:
+			for _, id := range pending {
+				client.Call(control.MethodFileCancel, &control.FileParams{ID: id}, nil)
+			}
+			fmt.Println("\nTransfer cancelled")
+			return
+		case <-ticker.C:
+		}
+
+		var bytes, size int64
+		var active []control.Transfer
+		for _, id := range ids {
+			var t control.Transfer
+			if err := client.Call(control.MethodFileStatus, &control.FileParams{ID: id}, &t); err != nil {
+				fmt.Printf("\nError checking transfer: %s\n", err)
+				return
+			}
+			bytes += t.Bytes
+			size += t.Size
+			if !t.Finished() {
+				active = append(active, t)
+				continue
+			}
+			if !reported[id] {
+				reported[id] = true
+				fmt.Print("\r\033[K")
+				printTransferResult(&t)
+			}
+		}
+
+		pending = nil
+		for _, t := range active {
+			pending = append(pending, t.ID)
+		}
+		if len(active) == 0 {
+			return
+		}
+		if bytes > 0 {
+			fmt.Printf("\r\033[K%3d%%  %s of %s", bytes*100/max(size, 1), formatSize(bytes), formatSize(size))
+			// Name the file a lone folder transfer is at
+			if t := active[0]; len(active) == 1 && t.File != "" {
+				fmt.Printf("  %d/%d %s", t.FilesDone+1, t.Files, t.File)
+			}
+		}
+	}
+}
+
+// printTransferResult reports how a finished transfer ended
+func printTransferResult(t *control.Transfer) {
+	switch {
+	case t.State != transfer.StateComplete:
+		fmt.Printf("✗ %s %s: %s\n", t.Name, t.State, t.Error)
+	case t.Direction == transfer.Outgoing:
+		fmt.Printf("✓ Sent %s to %s\n", t.Name, transferDevice(t))
+	case t.Archive != "" && t.Skipped > 0:
+		fmt.Printf("✓ Received %s, saved to %s (%d files already there were skipped)\n", t.Name, t.Path, t.Skipped)
+	case t.Skipped > 0:
+		fmt.Printf("✓ Received %s, skipped as %s is already there\n", t.Name, t.Path)
+	default:
+		fmt.Printf("✓ Received %s, saved to %s\n", t.Name, t.Path)
+	}
+}
+
+// transferSize describes the size of a transfer, and the files of a folder
+func transferSize(t *control.Transfer) string {
+	if t.Archive == "" {
+		return formatSize(t.Size)
+	}
+	files := "files"
+	if t.Files == 1 {
+		files = "file"
+	}
+	return fmt.Sprintf("%d %s, %s", t.Files, files, formatSize(t.TotalSize))
+}
+
+// transferDevice names the device of a transfer
+func transferDevice(t *control.Transfer) string {
+	if t.DeviceName != "" {
+		return t.DeviceName
+	}
+	return t.DeviceID
+}
+
+// formatSize formats a number of bytes for people
+func formatSize(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -1,9 +1,22 @@
 package auth
 
 import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+
 	"eco/internal/config"
 )
 
+// NonceSize is the length in bytes of a handshake challenge
+const NonceSize = 32
+
+// proofContext binds proofs to this handshake so a MAC computed for another
+// purpose with the same secret can never be replayed as a login
+const proofContext = "eco-auth-v1"
+
 // Authenticator validates device connections
 type Authenticator struct {
 	config *config.Config
@@ -16,15 +29,59 @@ func NewAuthenticator(cfg *config.Config) *Authenticator {
 	}
 }
 
-// ValidateCredentials checks if device_id and secret match the config
+// ValidateCredentials checks if device_id and secret match a paired, enabled device
 func (a *Authenticator) ValidateCredentials(deviceID, secret string) bool {
-	return a.config.DeviceID == deviceID && a.config.SharedSecret == secret
+	d := a.lookup(deviceID)
+	if d == nil {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(d.Secret), []byte(secret)) == 1
+}
+
+// VerifyProof checks a handshake response from deviceID against the nonce
+// it was challenged with
+func (a *Authenticator) VerifyProof(deviceID string, nonce []byte, proof string) bool {
+	d := a.lookup(deviceID)
+	if d == nil {
+		return false
+	}
+
+	got, err := hex.DecodeString(proof)
+	if err != nil {
+		return false
+	}
+	want := computeMAC(d.Secret, nonce, deviceID)
+	return hmac.Equal(got, want)
 }
 
-// AuthenticateMessage validates a protocol.Message
-// TODO: Implement AuthenticateMessage method (optional)
-// This could validate the DeviceID and Secret fields of a Message
-// Return error if invalid, nil if valid
-// func (a *Authenticator) AuthenticateMessage(deviceID, secret string) error {
-// 	return nil
-// }
+// lookup returns the paired device if it exists and is enabled
+func (a *Authenticator) lookup(deviceID string) *config.Device {
+	d := a.config.FindDevice(deviceID)
+	if d == nil || !d.Enabled {
+		return nil
+	}
+	return d
+}
+
+// NewChallenge returns a fresh random nonce for the handshake
+func NewChallenge() ([]byte, error) {
+	nonce := make([]byte, NonceSize)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return nonce, nil
+}
+
+// ComputeProof returns the hex encoded response a device sends for nonce:
+// HMAC-SHA256(secret, "eco-auth-v1" || nonce || deviceID)
+func ComputeProof(secret string, nonce []byte, deviceID string) string {
+	return hex.EncodeToString(computeMAC(secret, nonce, deviceID))
+}
+
+func computeMAC(secret string, nonce []byte, deviceID string) []byte {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(proofContext))
+	mac.Write(nonce)
+	mac.Write([]byte(deviceID))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,101 @@
+package auth
+
+import (
+	"testing"
+
+	"eco/internal/config"
+)
+
+func testConfig() *config.Config {
+	return &config.Config{
+		Devices: []*config.Device{
+			{ID: "phone", Secret: "phone-secret", Enabled: true},
+			{ID: "old-tablet", Secret: "tablet-secret", Enabled: false},
+		},
+	}
+}
+
+func TestVerifyProof(t *testing.T) {
+	a := NewAuthenticator(testConfig())
+
+	nonce, err := NewChallenge()
+	if err != nil {
+		t.Fatalf("NewChallenge() error = %v", err)
+	}
+	if len(nonce) != NonceSize {
+		t.Fatalf("NewChallenge() length = %d, want %d", len(nonce), NonceSize)
+	}
+	otherNonce, _ := NewChallenge()
+
+	tests := []struct {
+		name     string
+		deviceID string
+		proof    string
+		want     bool
+	}{
+		{
+			name:     "Valid proof",
+			deviceID: "phone",
+			proof:    ComputeProof("phone-secret", nonce, "phone"),
+			want:     true,
+		},
+		{
+			name:     "Wrong secret",
+			deviceID: "phone",
+			proof:    ComputeProof("guess", nonce, "phone"),
+			want:     false,
+		},
+		{
+			name:     "Replayed proof for another challenge",
+			deviceID: "phone",
+			proof:    ComputeProof("phone-secret", otherNonce, "phone"),
+			want:     false,
+		},
+		{
+			name:     "Proof bound to another device ID",
+			deviceID: "phone",
+			proof:    ComputeProof("phone-secret", nonce, "tablet"),
+			want:     false,
+		},
+		{
+			name:     "Disabled device",
+			deviceID: "old-tablet",
+			proof:    ComputeProof("tablet-secret", nonce, "old-tablet"),
+			want:     false,
+		},
+		{
+			name:     "Unknown device",
+			deviceID: "stranger",
+			proof:    ComputeProof("phone-secret", nonce, "stranger"),
+			want:     false,
+		},
+		{
+			name:     "Malformed proof",
+			deviceID: "phone",
+			proof:    "not-hex",
+			want:     false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := a.VerifyProof(tt.deviceID, nonce, tt.proof); got != tt.want {
+				t.Errorf("VerifyProof() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateCredentials(t *testing.T) {
+	a := NewAuthenticator(testConfig())
+
+	if !a.ValidateCredentials("phone", "phone-secret") {
+		t.Error("ValidateCredentials() rejected valid credentials")
+	}
+	if a.ValidateCredentials("phone", "phone-secre") {
+		t.Error("ValidateCredentials() accepted a wrong secret")
+	}
+	if a.ValidateCredentials("old-tablet", "tablet-secret") {
+		t.Error("ValidateCredentials() accepted a disabled device")
+	}
+}
@@ -0,0 +1,139 @@
+package bus
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+
+	"eco/internal/protocol"
+)
+
+// Event is a system event published on the bus
+type Event struct {
+	Type    protocol.MessageType
+	Payload any
+	// Source names the subsystem that published the event (e.g. "clipboard")
+	Source string
+	// Origin is the device whose action caused the event, empty for local
+	// events. Events are never sent back to their origin.
+	Origin string
+}
+
+// Bus fans published events out to every interested subscriber
+type Bus struct {
+	mu     sync.RWMutex
+	subs   map[*Subscription]struct{}
+	closed bool
+}
+
+// Subscription receives events from a Bus
+type Subscription struct {
+	name    string
+	bus     *Bus
+	events  chan Event
+	types   map[protocol.MessageType]bool
+	dropped atomic.Int64
+	once    sync.Once
+}
+
+// New creates an empty event bus
+func New() *Bus {
+	return &Bus{
+		subs: make(map[*Subscription]struct{}),
+	}
+}
+
+// Subscribe registers a new subscriber with the given buffer size.
+// If types are given, only events of those types are delivered.
+func (b *Bus) Subscribe(name string, buffer int, types ...protocol.MessageType) *Subscription {
+	sub := &Subscription{
+		name:   name,
+		bus:    b,
+		events: make(chan Event, buffer),
+	}
+	if len(types) > 0 {
+		sub.types = make(map[protocol.MessageType]bool, len(types))
+		for _, t := range types {
+			sub.types[t] = true
+		}
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		close(sub.events)
+		return sub
+	}
+	b.subs[sub] = struct{}{}
+	return sub
+}
+
+// Publish delivers an event to every matching subscriber without blocking.
+// Subscribers whose buffer is full miss the event.
+func (b *Bus) Publish(event Event) error {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	if b.closed {
+		return fmt.Errorf("event bus closed")
+	}
+
+	for sub := range b.subs {
+		if sub.types != nil && !sub.types[event.Type] {
+			continue
+		}
+		select {
+		case sub.events <- event:
+		default:
+			sub.dropped.Add(1)
+			log.Printf("Bus: Subscriber %s is full, dropping event %s", sub.name, event.Type)
+		}
+	}
+	return nil
+}
+
+// Close unsubscribes everyone and rejects further events
+func (b *Bus) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		return
+	}
+	b.closed = true
+	for sub := range b.subs {
+		delete(b.subs, sub)
+		sub.once.Do(func() { close(sub.events) })
+	}
+}
+
+// Events returns the channel events are delivered on.
+// It is closed when the subscription or the bus is closed.
+func (s *Subscription) Events() <-chan Event {
+	return s.events
+}
+
+// Name returns the subscriber name
+func (s *Subscription) Name() string {
+	return s.name
+}
+
+// Pending returns the number of events waiting to be consumed
+func (s *Subscription) Pending() int {
+	return len(s.events)
+}
+
+// Dropped returns how many events were missed because the buffer was full
+func (s *Subscription) Dropped() int {
+	return int(s.dropped.Load())
+}
+
+// Unsubscribe stops delivery and closes the events channel
+func (s *Subscription) Unsubscribe() {
+	s.bus.mu.Lock()
+	defer s.bus.mu.Unlock()
+
+	delete(s.bus.subs, s)
+	s.once.Do(func() { close(s.events) })
+}
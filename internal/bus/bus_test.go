@@ -0,0 +1,98 @@
+package bus
+
+import (
+	"testing"
+	"time"
+
+	"eco/internal/protocol"
+)
+
+func receive(t *testing.T, sub *Subscription) Event {
+	t.Helper()
+	select {
+	case e, ok := <-sub.Events():
+		if !ok {
+			t.Fatal("subscription closed unexpectedly")
+		}
+		return e
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for event")
+	}
+	return Event{}
+}
+
+func TestPublishFanOut(t *testing.T) {
+	b := New()
+	first := b.Subscribe("first", 4)
+	second := b.Subscribe("second", 4)
+
+	event := Event{Type: protocol.MessageTypeClipboardChanged, Payload: "hello", Source: "test"}
+	if err := b.Publish(event); err != nil {
+		t.Fatalf("Publish() error = %v", err)
+	}
+
+	for _, sub := range []*Subscription{first, second} {
+		got := receive(t, sub)
+		if got.Type != event.Type || got.Payload != event.Payload || got.Source != event.Source {
+			t.Errorf("%s received %+v, want %+v", sub.Name(), got, event)
+		}
+	}
+}
+
+func TestSubscribeTypeFilter(t *testing.T) {
+	b := New()
+	sub := b.Subscribe("calls", 4, protocol.MessageTypeCallIncoming)
+
+	b.Publish(Event{Type: protocol.MessageTypeClipboardChanged})
+	b.Publish(Event{Type: protocol.MessageTypeCallIncoming})
+
+	if got := receive(t, sub); got.Type != protocol.MessageTypeCallIncoming {
+		t.Errorf("received %s, want %s", got.Type, protocol.MessageTypeCallIncoming)
+	}
+	if sub.Pending() != 0 {
+		t.Errorf("Pending() = %d, want 0", sub.Pending())
+	}
+}
+
+func TestSlowSubscriberDrops(t *testing.T) {
+	b := New()
+	slow := b.Subscribe("slow", 1)
+	fast := b.Subscribe("fast", 4)
+
+	for i := 0; i < 3; i++ {
+		if err := b.Publish(Event{Type: protocol.MessageTypeDevicePing}); err != nil {
+			t.Fatalf("Publish() error = %v", err)
+		}
+	}
+
+	if slow.Dropped() != 2 {
+		t.Errorf("slow Dropped() = %d, want 2", slow.Dropped())
+	}
+	if fast.Pending() != 3 {
+		t.Errorf("fast Pending() = %d, want 3", fast.Pending())
+	}
+}
+
+func TestUnsubscribeAndClose(t *testing.T) {
+	b := New()
+	sub := b.Subscribe("gone", 4)
+	sub.Unsubscribe()
+	sub.Unsubscribe()
+
+	b.Publish(Event{Type: protocol.MessageTypeDevicePing})
+	if _, ok := <-sub.Events(); ok {
+		t.Error("unsubscribed subscription received an event")
+	}
+
+	other := b.Subscribe("other", 4)
+	b.Close()
+	if _, ok := <-other.Events(); ok {
+		t.Error("subscription still open after Close()")
+	}
+	if err := b.Publish(Event{Type: protocol.MessageTypeDevicePing}); err == nil {
+		t.Error("Publish() after Close() should error")
+	}
+	if _, ok := <-b.Subscribe("late", 1).Events(); ok {
+		t.Error("Subscribe() after Close() should return a closed subscription")
+	}
+}
@@ -0,0 +1,131 @@
+package clipboard
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+)
+
+// BackendEnv overrides backend detection, e.g. ECO_CLIPBOARD_BACKEND=memory
+const BackendEnv = "ECO_CLIPBOARD_BACKEND"
+
+// Backend names accepted by New and BackendEnv
+const (
+	BackendAuto    = "auto"
+	BackendWayland = "wayland"
+	BackendX11     = "x11"
+	BackendMemory  = "memory"
+)
+
+// ErrNoBackend is returned by Detect when no clipboard is reachable
+var ErrNoBackend = errors.New("no clipboard backend available")
+
+// Backend reads, writes and watches a system clipboard
+type Backend interface {
+	// Name identifies the backend, e.g. "wayland (wl-clipboard)"
+	Name() string
+	// Read returns the current text on the clipboard
+	Read() (string, error)
+	// Write replaces the clipboard content with text
+	Write(text string) error
+	// Watch signals on the returned channel whenever the clipboard may have
+	// changed, until stop is closed. Signals may be coalesced or spurious, so
+	// callers compare the content themselves.
+	Watch(stop <-chan struct{}) (<-chan struct{}, error)
+}
+
+// RichBackend is a Backend that offers content as several MIME types, such
+// as images and file lists
+type RichBackend interface {
+	Backend
+	// Types lists the MIME types the current content is offered as. An
+	// empty clipboard has none.
+	Types() ([]string, error)
+	// ReadType returns the current content as mimeType
+	ReadType(mimeType string) ([]byte, error)
+	// WriteType replaces the clipboard content with data of mimeType
+	WriteType(mimeType string, data []byte) error
+}
+
+// New returns the backend with the given name. BackendAuto, or an empty
+// name, uses BackendEnv if set and otherwise detects the session type.
+func New(name string) (Backend, error) {
+	if name == "" || name == BackendAuto {
+		name = os.Getenv(BackendEnv)
+	}
+
+	switch name {
+	case "", BackendAuto:
+		return Detect()
+	case BackendWayland:
+		return NewWaylandBackend()
+	case BackendX11:
+		return NewX11Backend()
+	case BackendMemory:
+		return NewMemoryBackend(), nil
+	default:
+		return nil, fmt.Errorf("unknown clipboard backend %q (want %s, %s, %s or %s)", name, BackendAuto, BackendWayland, BackendX11, BackendMemory)
+	}
+}
+
+// Detect picks the backend for the current graphical session: Wayland when
+// XDG_SESSION_TYPE or WAYLAND_DISPLAY say so, X11 when DISPLAY is set
+func Detect() (Backend, error) {
+	session := os.Getenv("XDG_SESSION_TYPE")
+	wayland := session == "wayland" || os.Getenv("WAYLAND_DISPLAY") != ""
+	x11 := session == "x11" || os.Getenv("DISPLAY") != ""
+
+	var errs []error
+	if wayland {
+		b, err := NewWaylandBackend()
+		if err == nil {
+			return b, nil
+		}
+		errs = append(errs, err)
+	}
+	// XWayland sessions also set DISPLAY, so X11 tools are a fallback there
+	if x11 {
+		b, err := NewX11Backend()
+		if err == nil {
+			return b, nil
+		}
+		errs = append(errs, err)
+	}
+	if !wayland && !x11 {
+		errs = append(errs, errors.New("no graphical session (XDG_SESSION_TYPE, WAYLAND_DISPLAY and DISPLAY are unset)"))
+	}
+	return nil, fmt.Errorf("%w: %w", ErrNoBackend, errors.Join(errs...))
+}
+
+// lookPaths returns an error naming the first command that isn't installed
+func lookPaths(names ...string) error {
+	for _, name := range names {
+		if _, err := exec.LookPath(name); err != nil {
+			return fmt.Errorf("%s not found in PATH", name)
+		}
+	}
+	return nil
+}
+
+// writeCommand runs name with data on its standard input
+func writeCommand(data []byte, name string, args ...string) error {
+	cmd := exec.Command(name, args...)
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	if _, err := stdin.Write(data); err != nil {
+		return err
+	}
+
+	stdin.Close()
+
+	return cmd.Wait()
+}
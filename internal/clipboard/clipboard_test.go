@@ -0,0 +1,448 @@
+package clipboard
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"eco/internal/bus"
+	"eco/internal/protocol"
+)
+
+// fakeTools puts shell scripts named after clipboard tools on an otherwise
+// empty PATH. Each one keeps the clipboard in a file: reads print it, and
+// writes (any argument containing "in") replace it.
+func fakeTools(t *testing.T, names ...string) {
+	t.Helper()
+
+	cat, err := exec.LookPath("cat")
+	if err != nil {
+		t.Skip("cat not found")
+	}
+
+	dir := t.TempDir()
+	store := filepath.Join(dir, "clipboard")
+	script := "#!/bin/sh\ncase \"$*\" in\n*in*) " + cat + " > " + store + " ;;\n*) " + cat + " " + store + " 2>/dev/null || exit 1 ;;\nesac\n"
+	for _, name := range names {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(script), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Setenv("PATH", dir)
+}
+
+func setSession(t *testing.T, sessionType, waylandDisplay, display string) {
+	t.Helper()
+	t.Setenv("XDG_SESSION_TYPE", sessionType)
+	t.Setenv("WAYLAND_DISPLAY", waylandDisplay)
+	t.Setenv("DISPLAY", display)
+	t.Setenv(BackendEnv, "")
+}
+
+func waitSignal(t *testing.T, ch <-chan struct{}) {
+	t.Helper()
+	select {
+	case <-ch:
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for a change signal")
+	}
+}
+
+func TestMemoryBackend(t *testing.T) {
+	b := NewMemoryBackend()
+	stop := make(chan struct{})
+	changes, err := b.Watch(stop)
+	if err != nil {
+		t.Fatalf("Watch() error = %v", err)
+	}
+
+	if err := b.Write("hello"); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	waitSignal(t, changes)
+	if got, _ := b.Read(); got != "hello" {
+		t.Errorf("Read() = %q, want %q", got, "hello")
+	}
+
+	close(stop)
+	for range changes {
+	}
+}
+
+func TestListenerPublishesChanges(t *testing.T) {
+	eventBus := bus.New()
+	sub := eventBus.Subscribe("test", 8)
+	b := NewMemoryBackend()
+
+	l := NewListener(eventBus, b, nil)
+	if err := l.Start(); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	defer l.Stop()
+
+	for _, text := range []string{"first", "first", "", "second"} {
+		b.Write(text)
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	for _, want := range []string{"first", "second"} {
+		select {
+		case event := <-sub.Events():
+			payload := event.Payload.(*protocol.ClipboardPayload)
+			if event.Type != protocol.MessageTypeClipboardChanged || payload.Data != want {
+				t.Errorf("event = %s %q, want clipboard.changed %q", event.Type, payload.Data, want)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("timed out waiting for %q", want)
+		}
+	}
+	select {
+	case event := <-sub.Events():
+		t.Errorf("unexpected event %+v", event.Payload)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestTracker(t *testing.T) {
+	now := time.Now()
+	tracker := NewTracker()
+	tracker.now = func() time.Time { return now }
+
+	phone, tablet := Hash("from phone"), Hash("from tablet")
+	tracker.Remember(phone, "mobile-1")
+	tracker.Remember(tablet, "tablet-1")
+	if got := tracker.Origin(phone); got != "mobile-1" {
+		t.Errorf("Origin() = %q, want %q", got, "mobile-1")
+	}
+	if got := tracker.Origin(phone); got != OriginLocal {
+		t.Errorf("Origin() a second time = %q, want local", got)
+	}
+	if got := tracker.Origin(Hash("typed here")); got != OriginLocal {
+		t.Errorf("Origin() of unknown content = %q, want local", got)
+	}
+
+	now = now.Add(OriginTTL + time.Second)
+	if got := tracker.Origin(tablet); got != OriginLocal {
+		t.Errorf("Origin() after OriginTTL = %q, want local", got)
+	}
+
+	never := Hash("never written")
+	tracker.Remember(never, "mobile-1")
+	tracker.Forget(never)
+	if got := tracker.Origin(never); got != OriginLocal {
+		t.Errorf("Origin() after Forget() = %q, want local", got)
+	}
+}
+
+func TestHash(t *testing.T) {
+	if Hash("a") == Hash("b") {
+		t.Error("Hash() is the same for different content")
+	}
+	if Hash("a") != Hash("a") {
+		t.Error("Hash() differs for the same content")
+	}
+}
+
+func TestListenerOrigin(t *testing.T) {
+	eventBus := bus.New()
+	sub := eventBus.Subscribe("test", 8)
+	b := NewMemoryBackend()
+	tracker := NewTracker()
+	setter := NewSetter(b, tracker)
+
+	l := NewListener(eventBus, b, tracker)
+	if err := l.Start(); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	defer l.Stop()
+
+	steps := []struct {
+		write      func() error
+		wantData   string
+		wantOrigin string
+	}{
+		{func() error { return setter.SetTextFrom("mobile-1", "from phone") }, "from phone", "mobile-1"},
+		{func() error { return setter.SetText("typed here") }, "typed here", OriginLocal},
+		// The same text copied again on the desktop is a local change
+		{func() error { return b.Write("from phone") }, "from phone", OriginLocal},
+		{func() error { return setter.SetTextFrom("tablet-1", "from tablet") }, "from tablet", "tablet-1"},
+	}
+
+	for _, step := range steps {
+		if err := step.write(); err != nil {
+			t.Fatalf("write %q error = %v", step.wantData, err)
+		}
+		select {
+		case event := <-sub.Events():
+			payload := event.Payload.(*protocol.ClipboardPayload)
+			if payload.Data != step.wantData || event.Origin != step.wantOrigin {
+				t.Errorf("event = %q from %q, want %q from %q", payload.Data, event.Origin, step.wantData, step.wantOrigin)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("timed out waiting for %q", step.wantData)
+		}
+	}
+}
+
+func TestSetterWithoutBackend(t *testing.T) {
+	tracker := NewTracker()
+	setter := NewSetter(nil, tracker)
+	if err := setter.SetTextFrom("mobile-1", "text"); err == nil {
+		t.Error("SetTextFrom() error = nil, want an error")
+	}
+	if got := tracker.Origin(TextContent("text").Hash()); got != OriginLocal {
+		t.Errorf("Origin() after a failed write = %q, want local", got)
+	}
+}
+
+// textBackend hides the MIME type support of a memory clipboard
+type textBackend struct{ b *MemoryBackend }
+
+func (t textBackend) Name() string                                        { return "text" }
+func (t textBackend) Read() (string, error)                               { return t.b.Read() }
+func (t textBackend) Write(text string) error                             { return t.b.Write(text) }
+func (t textBackend) Watch(stop <-chan struct{}) (<-chan struct{}, error) { return t.b.Watch(stop) }
+
+func TestReadContent(t *testing.T) {
+	png := []byte("\x89PNG\r\n\x1a\n")
+	dir := t.TempDir()
+	file := filepath.Join(dir, "notes.txt")
+	os.WriteFile(file, []byte("12345"), 0644)
+
+	tests := []struct {
+		name  string
+		items map[string][]byte
+		want  Content
+	}{
+		{
+			name:  "Plain text",
+			items: map[string][]byte{protocol.MIMEText: []byte("hello")},
+			want:  TextContent("hello"),
+		},
+		{
+			name: "Text with HTML",
+			items: map[string][]byte{
+				protocol.MIMEText: []byte("hello"),
+				protocol.MIMEHTML: []byte("<b>hello</b>"),
+			},
+			want: Content{MIME: protocol.MIMEText, Data: []byte("hello"), HTML: "<b>hello</b>"},
+		},
+		{
+			name:  "HTML only",
+			items: map[string][]byte{protocol.MIMEHTML: []byte("<i>hi</i>")},
+			want:  Content{MIME: protocol.MIMEText, HTML: "<i>hi</i>"},
+		},
+		{
+			name:  "Image",
+			items: map[string][]byte{protocol.MIMEPNG: png, protocol.MIMEHTML: []byte("<img>")},
+			want:  Content{MIME: protocol.MIMEPNG, Data: png},
+		},
+		{
+			name:  "Other image type",
+			items: map[string][]byte{"image/jpeg": []byte("jpeg")},
+			want:  Content{MIME: "image/jpeg", Data: []byte("jpeg")},
+		},
+		{
+			name: "File list",
+			items: map[string][]byte{
+				protocol.MIMEURIList: []byte("file://" + file + "\r\n"),
+				protocol.MIMEText:    []byte(file),
+			},
+			want: Content{MIME: protocol.MIMEURIList, Data: []byte("file://" + file + "\r\n")},
+		},
+		{
+			name:  "Unknown type",
+			items: map[string][]byte{"application/x-private": []byte("?")},
+			want:  Content{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := NewMemoryBackend()
+			b.WriteTypes(tt.items)
+
+			got, err := ReadContent(b)
+			if err != nil {
+				t.Fatalf("ReadContent() error = %v", err)
+			}
+			if got.MIME != tt.want.MIME || string(got.Data) != string(tt.want.Data) || got.HTML != tt.want.HTML {
+				t.Errorf("ReadContent() = %s %q (html %q), want %s %q (html %q)", got.MIME, got.Data, got.HTML, tt.want.MIME, tt.want.Data, tt.want.HTML)
+			}
+		})
+	}
+}
+
+func TestContentPayload(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "notes.txt")
+	os.WriteFile(file, []byte("12345"), 0644)
+
+	image := Content{MIME: protocol.MIMEPNG, Data: []byte{0, 1, 2, 3}}
+	payload, err := image.Payload()
+	if err != nil {
+		t.Fatalf("Payload() error = %v", err)
+	}
+	if payload.MIME != protocol.MIMEPNG || payload.Encoding != protocol.EncodingBase64 || payload.Data != "AAECAw==" {
+		t.Errorf("Payload() = %+v, want base64 image/png", payload)
+	}
+	back, err := ContentFromPayload(payload)
+	if err != nil || back.Hash() != image.Hash() {
+		t.Errorf("ContentFromPayload() = %v, %v, want the original image", back, err)
+	}
+
+	html := Content{MIME: protocol.MIMEText, Data: []byte("hi"), HTML: "<b>hi</b>"}
+	if payload, _ := html.Payload(); payload.Data != "hi" || payload.HTML != "<b>hi</b>" || payload.MIME != "" {
+		t.Errorf("Payload() = %+v, want text with HTML", payload)
+	}
+
+	files := Content{MIME: protocol.MIMEURIList, Data: []byte("# comment\nfile://" + file + "\nfile:///does/not/exist\n")}
+	payload, _ = files.Payload()
+	if len(payload.Files) != 1 || payload.Files[0].Name != "notes.txt" || payload.Files[0].Size != 5 {
+		t.Errorf("Payload().Files = %+v, want notes.txt of 5 bytes", payload.Files)
+	}
+	if _, err := ContentFromPayload(payload); !errors.Is(err, ErrUnsupportedType) {
+		t.Errorf("ContentFromPayload() of a file list error = %v, want %v", err, ErrUnsupportedType)
+	}
+}
+
+func TestWriteContent(t *testing.T) {
+	b := NewMemoryBackend()
+	image := Content{MIME: protocol.MIMEPNG, Data: []byte("png")}
+	if err := WriteContent(b, image); err != nil {
+		t.Fatalf("WriteContent() error = %v", err)
+	}
+	if got, _ := ReadContent(b); got.Hash() != image.Hash() {
+		t.Errorf("ReadContent() = %s %q, want the image", got.MIME, got.Data)
+	}
+
+	if err := WriteContent(textBackend{NewMemoryBackend()}, image); !errors.Is(err, ErrUnsupportedType) {
+		t.Errorf("WriteContent() of an image to a text clipboard error = %v, want %v", err, ErrUnsupportedType)
+	}
+	if err := WriteContent(textBackend{b}, TextContent("plain")); err != nil {
+		t.Errorf("WriteContent() of text error = %v", err)
+	}
+}
+
+func TestListenerRemoteImage(t *testing.T) {
+	eventBus := bus.New()
+	sub := eventBus.Subscribe("test", 8)
+	b := NewMemoryBackend()
+	tracker := NewTracker()
+
+	l := NewListener(eventBus, b, tracker)
+	if err := l.Start(); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	defer l.Stop()
+
+	payload := &protocol.ClipboardPayload{Data: "AAECAw==", MIME: protocol.MIMEPNG, Encoding: protocol.EncodingBase64}
+	content, _ := ContentFromPayload(payload)
+	if err := NewSetter(b, tracker).SetContentFrom("mobile-1", content); err != nil {
+		t.Fatalf("SetContentFrom() error = %v", err)
+	}
+
+	select {
+	case event := <-sub.Events():
+		got := event.Payload.(*protocol.ClipboardPayload)
+		if event.Origin != "mobile-1" || got.MIME != protocol.MIMEPNG || got.Data != payload.Data {
+			t.Errorf("event = %s %q from %q, want the image from mobile-1", got.MIME, got.Data, event.Origin)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for the image")
+	}
+}
+
+func TestNew(t *testing.T) {
+	setSession(t, "", "", "")
+
+	if b, err := New(BackendMemory); err != nil || b.Name() != "memory" {
+		t.Errorf("New(memory) = %v, %v, want the memory backend", b, err)
+	}
+	if _, err := New("bogus"); err == nil {
+		t.Error("New(bogus) error = nil, want an error")
+	}
+
+	t.Setenv(BackendEnv, BackendMemory)
+	if b, err := New(BackendAuto); err != nil || b.Name() != "memory" {
+		t.Errorf("New(auto) with %s=memory = %v, %v, want the memory backend", BackendEnv, b, err)
+	}
+}
+
+func TestDetect(t *testing.T) {
+	tests := []struct {
+		name                             string
+		tools                            []string
+		session, waylandDisplay, display string
+		want                             string
+	}{
+		{"Wayland session", []string{"wl-paste", "wl-copy", "xclip"}, "wayland", "", ":0", "wayland (wl-clipboard)"},
+		{"Wayland display only", []string{"wl-paste", "wl-copy"}, "", "wayland-0", "", "wayland (wl-clipboard)"},
+		{"XWayland without wl-clipboard", []string{"xclip"}, "wayland", "wayland-0", ":0", "x11 (xclip)"},
+		{"X11 with xclip", []string{"xclip", "xsel"}, "x11", "", ":0", "x11 (xclip)"},
+		{"X11 with xsel", []string{"xsel"}, "", "", ":0", "x11 (xsel)"},
+		{"X11 without tools", nil, "x11", "", ":0", ""},
+		{"Headless", []string{"wl-paste", "wl-copy", "xclip"}, "tty", "", "", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fakeTools(t, tt.tools...)
+			setSession(t, tt.session, tt.waylandDisplay, tt.display)
+
+			b, err := Detect()
+			if tt.want == "" {
+				if !errors.Is(err, ErrNoBackend) {
+					t.Errorf("Detect() error = %v, want %v", err, ErrNoBackend)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Detect() error = %v", err)
+			}
+			if b.Name() != tt.want {
+				t.Errorf("Detect() = %s, want %s", b.Name(), tt.want)
+			}
+		})
+	}
+}
+
+func TestX11Backend(t *testing.T) {
+	for _, tool := range []string{"xclip", "xsel"} {
+		t.Run(tool, func(t *testing.T) {
+			fakeTools(t, tool)
+
+			b, err := NewX11Backend()
+			if err != nil {
+				t.Fatalf("NewX11Backend() error = %v", err)
+			}
+			b.interval = 10 * time.Millisecond
+
+			if got, err := b.Read(); err != nil || got != "" {
+				t.Errorf("Read() of an empty clipboard = %q, %v, want empty", got, err)
+			}
+
+			stop := make(chan struct{})
+			defer close(stop)
+			changes, err := b.Watch(stop)
+			if err != nil {
+				t.Fatalf("Watch() error = %v", err)
+			}
+
+			if err := b.Write("from x11\nline two"); err != nil {
+				t.Fatalf("Write() error = %v", err)
+			}
+			waitSignal(t, changes)
+			if got, _ := b.Read(); got != "from x11\nline two" {
+				t.Errorf("Read() = %q, want the written text", got)
+			}
+			if !strings.HasPrefix(b.Name(), "x11 ("+tool) {
+				t.Errorf("Name() = %s, want x11 (%s)", b.Name(), tool)
+			}
+		})
+	}
+}
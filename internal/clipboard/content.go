@@ -0,0 +1,245 @@
+package clipboard
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"eco/internal/protocol"
+)
+
+// MaxInlineSize is the largest binary content, such as an image, sent to
+// devices inside a clipboard message. Anything larger is offered as a file
+// transfer.
+const MaxInlineSize = 1 << 20
+
+// ErrUnsupportedType is returned when a backend can't hold a MIME type
+var ErrUnsupportedType = errors.New("clipboard type not supported")
+
+// Content is the clipboard in the richest form both ends understand
+type Content struct {
+	// MIME is the type of Data: protocol.MIMEText, protocol.MIMEURIList or
+	// an image type
+	MIME string
+	Data []byte
+	// HTML is the text/html version of text content, if one was offered
+	HTML string
+	// Concealed is set when the source marked the content as a secret with
+	// one of ConcealedTypes
+	Concealed bool
+}
+
+// TextContent returns plain text content
+func TextContent(text string) Content {
+	return Content{MIME: protocol.MIMEText, Data: []byte(text)}
+}
+
+// Text returns the content as text; empty for images
+func (c Content) Text() string {
+	if c.IsImage() {
+		return ""
+	}
+	return string(c.Data)
+}
+
+// IsImage reports whether the content is an image
+func (c Content) IsImage() bool {
+	return strings.HasPrefix(c.MIME, "image/")
+}
+
+// Empty reports whether there is nothing worth syncing
+func (c Content) Empty() bool {
+	return len(c.Data) == 0 && c.HTML == ""
+}
+
+// Hash identifies the content by type and data. HTML is left out because
+// backends that write one type at a time drop it.
+func (c Content) Hash() string {
+	return Hash(c.MIME + "\x00" + string(c.Data))
+}
+
+// URIs returns the entries of text/uri-list content, skipping comments
+func (c Content) URIs() []string {
+	if c.MIME != protocol.MIMEURIList {
+		return nil
+	}
+	var uris []string
+	for line := range strings.Lines(string(c.Data)) {
+		line = strings.TrimSpace(line)
+		if line != "" && !strings.HasPrefix(line, "#") {
+			uris = append(uris, line)
+		}
+	}
+	return uris
+}
+
+// Payload converts the content for sending to devices. Images are base64
+// encoded and file lists describe the local files they name. The router
+// replaces images over MaxInlineSize and the files with transfers.
+func (c Content) Payload() (*protocol.ClipboardPayload, error) {
+	switch {
+	case c.IsImage():
+		return &protocol.ClipboardPayload{
+			Data:     base64.StdEncoding.EncodeToString(c.Data),
+			MIME:     c.MIME,
+			Encoding: protocol.EncodingBase64,
+		}, nil
+	case c.MIME == protocol.MIMEURIList:
+		return &protocol.ClipboardPayload{
+			Data:  string(c.Data),
+			MIME:  c.MIME,
+			Files: localFiles(c.URIs()),
+		}, nil
+	default:
+		return &protocol.ClipboardPayload{Data: string(c.Data), HTML: c.HTML}, nil
+	}
+}
+
+// ContentFromPayload converts clipboard content received from a device
+func ContentFromPayload(p *protocol.ClipboardPayload) (Content, error) {
+	if p.TransferID != "" {
+		return Content{}, fmt.Errorf("%w: %s content sent as a file transfer", ErrUnsupportedType, p.Type())
+	}
+	// The URIs name files on the device, which are no use on the desktop
+	// until they're transferred
+	if p.Type() == protocol.MIMEURIList {
+		return Content{}, fmt.Errorf("%w: %s from a device, send the files instead", ErrUnsupportedType, p.Type())
+	}
+	data, err := p.Bytes()
+	if err != nil {
+		return Content{}, err
+	}
+	return Content{MIME: p.Type(), Data: data, HTML: p.HTML}, nil
+}
+
+// localFiles describes the file:// URIs that exist on this machine
+func localFiles(uris []string) []protocol.ClipboardFile {
+	var files []protocol.ClipboardFile
+	for _, path := range LocalPaths(uris) {
+		info, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		files = append(files, protocol.ClipboardFile{Name: filepath.Base(path), Size: info.Size()})
+	}
+	return files
+}
+
+// LocalPaths returns the paths of the file:// URIs among uris
+func LocalPaths(uris []string) []string {
+	var paths []string
+	for _, uri := range uris {
+		u, err := url.Parse(uri)
+		if err != nil || u.Scheme != "file" {
+			continue
+		}
+		paths = append(paths, u.Path)
+	}
+	return paths
+}
+
+// ReadContent reads the clipboard from b. Backends that list MIME types are
+// asked for file lists first, then text (with HTML when offered), then
+// images; others only give text.
+func ReadContent(b Backend) (Content, error) {
+	rich, ok := b.(RichBackend)
+	if !ok {
+		text, err := b.Read()
+		return TextContent(text), err
+	}
+
+	types, err := rich.Types()
+	if err != nil {
+		return Content{}, err
+	}
+	content, err := readType(rich, types)
+	content.Concealed = concealed(types)
+	return content, err
+}
+
+// readType reads the best of types from rich
+func readType(rich RichBackend, types []string) (Content, error) {
+	switch mimeType := pickType(types); {
+	case mimeType == "":
+		return Content{}, nil
+	case mimeType == protocol.MIMEHTML:
+		// Only HTML on offer; there is no text to go with it
+		html, err := rich.ReadType(protocol.MIMEHTML)
+		if err != nil {
+			return Content{}, err
+		}
+		return Content{MIME: protocol.MIMEText, HTML: string(html)}, nil
+	case mimeType == protocol.MIMEText:
+		text, err := rich.Read()
+		if err != nil {
+			return Content{}, err
+		}
+		content := TextContent(text)
+		if hasType(types, protocol.MIMEHTML) {
+			if html, err := rich.ReadType(protocol.MIMEHTML); err == nil {
+				content.HTML = string(html)
+			}
+		}
+		return content, nil
+	default:
+		data, err := rich.ReadType(mimeType)
+		if err != nil {
+			return Content{}, err
+		}
+		return Content{MIME: mimeType, Data: data}, nil
+	}
+}
+
+// WriteContent puts c on the clipboard of b. Only the main type is written:
+// command line clipboard tools offer a single type per copy.
+func WriteContent(b Backend, c Content) error {
+	if c.MIME == "" || c.MIME == protocol.MIMEText {
+		return b.Write(string(c.Data))
+	}
+	rich, ok := b.(RichBackend)
+	if !ok {
+		return fmt.Errorf("%w: %s can't hold %s", ErrUnsupportedType, b.Name(), c.MIME)
+	}
+	return rich.WriteType(c.MIME, c.Data)
+}
+
+// textTypes are the names plain text goes by on Wayland and X11
+var textTypes = []string{protocol.MIMEText, "text/plain;charset=utf-8", "UTF8_STRING", "STRING", "TEXT"}
+
+// pickType chooses the type to sync from those offered: a file list, then
+// text, then an image, then HTML on its own. Empty if none is usable.
+func pickType(types []string) string {
+	if hasType(types, protocol.MIMEURIList) {
+		return protocol.MIMEURIList
+	}
+	for _, t := range textTypes {
+		if hasType(types, t) {
+			return protocol.MIMEText
+		}
+	}
+	if hasType(types, protocol.MIMEPNG) {
+		return protocol.MIMEPNG
+	}
+	for _, t := range types {
+		if strings.HasPrefix(t, "image/") {
+			return t
+		}
+	}
+	if hasType(types, protocol.MIMEHTML) {
+		return protocol.MIMEHTML
+	}
+	return ""
+}
+
+func hasType(types []string, want string) bool {
+	for _, t := range types {
+		if strings.EqualFold(t, want) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,137 @@
+package clipboard
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// ConcealedTypes are the MIME types password managers add to mark the
+// clipboard content as a secret. KeePassXC and KDE use
+// x-kde-passwordManagerHint; the others come from macOS and Windows apps
+// and show up through XWayland and remote desktops.
+var ConcealedTypes = []string{
+	"x-kde-passwordManagerHint",
+	"org.nspasteboard.ConcealedType",
+	"application/x-nspasteboard-concealed-type",
+	"ExcludeClipboardContentFromMonitorProcessing",
+}
+
+// Pattern is a deny pattern for clipboard text
+type Pattern struct {
+	Name   string
+	Regexp *regexp.Regexp
+	// Valid, if set, confirms a match, e.g. with a checksum
+	Valid func(match string) bool
+}
+
+// DefaultPatterns catch the secrets most often copied by accident
+var DefaultPatterns = []Pattern{
+	{Name: "private key", Regexp: regexp.MustCompile(`-----BEGIN (?:[A-Z0-9]+ )*PRIVATE KEY-----`)},
+	{Name: "AWS access key", Regexp: regexp.MustCompile(`\b(?:AKIA|ASIA)[0-9A-Z]{16}\b`)},
+	{Name: "GitHub token", Regexp: regexp.MustCompile(`\b(?:gh[pousr]_[A-Za-z0-9]{36,}|github_pat_[A-Za-z0-9_]{22,})\b`)},
+	{Name: "Slack token", Regexp: regexp.MustCompile(`\bxox[abprs]-[A-Za-z0-9-]{10,}`)},
+	{Name: "Stripe secret key", Regexp: regexp.MustCompile(`\b[rs]k_live_[A-Za-z0-9]{16,}\b`)},
+	{Name: "Google API key", Regexp: regexp.MustCompile(`\bAIza[0-9A-Za-z_-]{35}\b`)},
+	{Name: "credit card number", Regexp: regexp.MustCompile(`\b\d(?:[ -]?\d){12,18}\b`), Valid: luhn},
+}
+
+// Filter decides which clipboard content is too sensitive to leave the
+// desktop
+type Filter struct {
+	patterns []Pattern
+}
+
+// NewFilter returns a filter that blocks content marked by a password
+// manager and text matching DefaultPatterns, when defaults is set, or one of
+// the regular expressions in deny
+func NewFilter(deny []string, defaults bool) (*Filter, error) {
+	f := &Filter{}
+	if defaults {
+		f.patterns = append(f.patterns, DefaultPatterns...)
+	}
+	for _, expr := range deny {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid deny pattern %q: %w", expr, err)
+		}
+		f.patterns = append(f.patterns, Pattern{Name: "deny pattern " + expr, Regexp: re})
+	}
+	return f, nil
+}
+
+// Check returns why content must not be synced, or "" if it may be
+func (f *Filter) Check(c Content) string {
+	if c.Concealed {
+		return "marked secret by a password manager"
+	}
+	if c.IsImage() {
+		return ""
+	}
+
+	for _, text := range []string{c.Text(), c.HTML} {
+		if text == "" {
+			continue
+		}
+		for _, p := range f.patterns {
+			if p.matches(text) {
+				return "matches " + p.Name
+			}
+		}
+	}
+	return ""
+}
+
+func (p *Pattern) matches(text string) bool {
+	if p.Valid == nil {
+		return p.Regexp.MatchString(text)
+	}
+	for _, match := range p.Regexp.FindAllString(text, -1) {
+		if p.Valid(match) {
+			return true
+		}
+	}
+	return false
+}
+
+// Redact describes content for logs without revealing it
+func Redact(c Content) string {
+	return fmt.Sprintf("%s, %d bytes, sha256 %.12s", c.MIME, len(c.Data), c.Hash())
+}
+
+// luhn reports whether the digits in number pass the Luhn checksum used by
+// payment card numbers
+func luhn(number string) bool {
+	digits := strings.Map(func(r rune) rune {
+		if r >= '0' && r <= '9' {
+			return r
+		}
+		return -1
+	}, number)
+	if len(digits) < 13 || len(digits) > 19 {
+		return false
+	}
+
+	sum := 0
+	for i := range len(digits) {
+		d := int(digits[len(digits)-1-i] - '0')
+		if i%2 == 1 {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+	}
+	return sum%10 == 0
+}
+
+// concealed reports whether types include a password manager hint
+func concealed(types []string) bool {
+	for _, t := range ConcealedTypes {
+		if hasType(types, t) {
+			return true
+		}
+	}
+	return false
+}
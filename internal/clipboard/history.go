@@ -0,0 +1,346 @@
+package clipboard
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	"eco/internal/bus"
+	"eco/internal/config"
+	"eco/internal/crypto"
+	"eco/internal/protocol"
+
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// History files inside the config directory
+const (
+	HistoryFile    = "clipboard_history.json"
+	HistoryKeyFile = "clipboard_history.key"
+)
+
+// DefaultHistorySize is how many entries are kept when the config doesn't say
+const DefaultHistorySize = 50
+
+// MaxHistoryImages is how many bytes of images the history keeps in all.
+// Older images are dropped to stay under it, so that saving stays cheap.
+const MaxHistoryImages = 8 << 20
+
+// ErrHistoryLocked is returned when the history file is encrypted and no
+// key was given
+var ErrHistoryLocked = errors.New("clipboard history is encrypted")
+
+// HistoryEntry is a clipboard change kept in the history
+type HistoryEntry struct {
+	MIME string `json:"mime"`
+	Data []byte `json:"data"`
+	HTML string `json:"html,omitempty"`
+	// Source is the device the content came from, OriginLocal for the desktop
+	Source string    `json:"source,omitempty"`
+	Time   time.Time `json:"time"`
+}
+
+// Content returns the entry's clipboard content
+func (e *HistoryEntry) Content() Content {
+	return Content{MIME: e.MIME, Data: e.Data, HTML: e.HTML}
+}
+
+// historyFile is the on-disk form of the history. With a key, the entries
+// are sealed with XChaCha20-Poly1305 instead of stored in Entries.
+type historyFile struct {
+	Entries []HistoryEntry `json:"entries,omitempty"`
+	Nonce   []byte         `json:"nonce,omitempty"`
+	Sealed  []byte         `json:"sealed,omitempty"`
+}
+
+// History keeps the most recent clipboard changes, newest first, in a file
+// that survives daemon restarts
+type History struct {
+	mu        sync.Mutex
+	saveMu    sync.Mutex // serializes writes of the file
+	path      string
+	key       []byte
+	max       int
+	maxImages int
+	entries   []HistoryEntry
+	events    *bus.Subscription
+	saves     chan struct{}
+	saved     chan struct{}
+	now       func() time.Time
+}
+
+// HistoryPaths returns the history and key files next to the config file
+func HistoryPaths() (historyPath, keyPath string, err error) {
+	cfgPath, err := config.ConfigPath()
+	if err != nil {
+		return "", "", err
+	}
+	dir := filepath.Dir(cfgPath)
+	return filepath.Join(dir, HistoryFile), filepath.Join(dir, HistoryKeyFile), nil
+}
+
+// LoadHistoryKey reads the key that encrypts the history, creating one the
+// first time. The key only keeps the history out of plain text files that
+// are backed up, synced or indexed on their own.
+func LoadHistoryKey(path string) ([]byte, error) {
+	key, err := os.ReadFile(path)
+	if err == nil {
+		if len(key) != chacha20poly1305.KeySize {
+			return nil, fmt.Errorf("history key %s has %d bytes, want %d", path, len(key), chacha20poly1305.KeySize)
+		}
+		return key, nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	key, err = crypto.GenerateRandomBytes(chacha20poly1305.KeySize)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(path, key, 0600); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// OpenHistory loads the history stored at path, keeping at most max
+// entries. A nil key stores it in plain text; otherwise it is encrypted.
+func OpenHistory(path string, max int, key []byte) (*History, error) {
+	if max <= 0 {
+		max = DefaultHistorySize
+	}
+	h := &History{path: path, key: key, max: max, maxImages: MaxHistoryImages, now: time.Now}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return h, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var file historyFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("reading %s: %w", path, err)
+	}
+	entries := file.Entries
+	if file.Sealed != nil {
+		if key == nil {
+			return nil, ErrHistoryLocked
+		}
+		if entries, err = openEntries(key, file.Nonce, file.Sealed); err != nil {
+			return nil, fmt.Errorf("reading %s: %w", path, err)
+		}
+	}
+	if len(entries) > max {
+		entries = entries[:max]
+	}
+	h.entries = entries
+	return h, nil
+}
+
+// Add records content as the newest entry and saves the history. Content
+// already in the history moves to the top instead of being kept twice.
+func (h *History) Add(content Content, source string) error {
+	if !h.insert(content, source) {
+		return nil
+	}
+	return h.save()
+}
+
+// insert records content as the newest entry, dropping the oldest entries
+// over the size limits. It reports whether the history changed.
+func (h *History) insert(content Content, source string) bool {
+	if content.Empty() {
+		return false
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	hash := content.Hash()
+	h.entries = slices.DeleteFunc(h.entries, func(e HistoryEntry) bool {
+		return e.Content().Hash() == hash
+	})
+	entry := HistoryEntry{
+		MIME:   content.MIME,
+		Data:   content.Data,
+		HTML:   content.HTML,
+		Source: source,
+		Time:   h.now(),
+	}
+	h.entries = slices.Insert(h.entries, 0, entry)
+	if len(h.entries) > h.max {
+		h.entries = h.entries[:h.max]
+	}
+
+	images := 0
+	h.entries = slices.DeleteFunc(h.entries, func(e HistoryEntry) bool {
+		if !e.Content().IsImage() {
+			return false
+		}
+		images += len(e.Data)
+		return images > h.maxImages
+	})
+	return true
+}
+
+// List returns the entries, newest first
+func (h *History) List() []HistoryEntry {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return slices.Clone(h.entries)
+}
+
+// Get returns entry n, counting from 1 for the newest
+func (h *History) Get(n int) (HistoryEntry, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if n < 1 || n > len(h.entries) {
+		return HistoryEntry{}, false
+	}
+	return h.entries[n-1], true
+}
+
+// Len returns the number of entries
+func (h *History) Len() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.entries)
+}
+
+// Clear forgets every entry
+func (h *History) Clear() error {
+	h.mu.Lock()
+	h.entries = nil
+	h.mu.Unlock()
+	return h.save()
+}
+
+// Start records every clipboard change published on eventBus. The file is
+// saved in the background so that a slow disk doesn't hold up the bus.
+func (h *History) Start(eventBus *bus.Bus) {
+	h.events = eventBus.Subscribe("clipboard-history", 32, protocol.MessageTypeClipboardChanged)
+	h.saves = make(chan struct{}, 1)
+	h.saved = make(chan struct{})
+
+	go func() {
+		defer close(h.saves)
+		for event := range h.events.Events() {
+			payload, ok := event.Payload.(*protocol.ClipboardPayload)
+			if !ok {
+				continue
+			}
+			data, err := payload.Bytes()
+			if err != nil {
+				continue
+			}
+			content := Content{MIME: payload.Type(), Data: data, HTML: payload.HTML}
+			if !h.insert(content, event.Origin) {
+				continue
+			}
+			// A save already waiting will write this entry too
+			select {
+			case h.saves <- struct{}{}:
+			default:
+			}
+		}
+	}()
+
+	go func() {
+		defer close(h.saved)
+		for range h.saves {
+			if err := h.save(); err != nil {
+				log.Printf("Clipboard: Failed to save history: %v", err)
+			}
+		}
+	}()
+}
+
+// Stop stops recording changes, waiting for the last ones to be saved
+func (h *History) Stop() {
+	if h.events != nil {
+		h.events.Unsubscribe()
+		<-h.saved
+	}
+}
+
+// save writes the history to disk, replacing the old file atomically. The
+// entries are copied first so that sealing and writing them doesn't hold
+// h.mu.
+func (h *History) save() error {
+	h.saveMu.Lock()
+	defer h.saveMu.Unlock()
+
+	h.mu.Lock()
+	entries := slices.Clone(h.entries)
+	h.mu.Unlock()
+
+	file := historyFile{Entries: entries}
+	if h.key != nil {
+		nonce, sealed, err := sealEntries(h.key, entries)
+		if err != nil {
+			return err
+		}
+		file = historyFile{Nonce: nonce, Sealed: sealed}
+	}
+
+	data, err := json.Marshal(&file)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(h.path), 0700); err != nil {
+		return err
+	}
+	tmp := h.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, h.path)
+}
+
+func sealEntries(key []byte, entries []HistoryEntry) (nonce, sealed []byte, err error) {
+	aead, err := chacha20poly1305.NewX(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	plaintext, err := json.Marshal(entries)
+	if err != nil {
+		return nil, nil, err
+	}
+	nonce, err = crypto.GenerateRandomBytes(aead.NonceSize())
+	if err != nil {
+		return nil, nil, err
+	}
+	return nonce, aead.Seal(nil, nonce, plaintext, []byte(HistoryFile)), nil
+}
+
+func openEntries(key, nonce, sealed []byte) ([]HistoryEntry, error) {
+	aead, err := chacha20poly1305.NewX(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(nonce) != aead.NonceSize() {
+		return nil, errors.New("invalid nonce")
+	}
+	plaintext, err := aead.Open(nil, nonce, sealed, []byte(HistoryFile))
+	if err != nil {
+		return nil, errors.New("wrong key or corrupted history")
+	}
+	var entries []HistoryEntry
+	if err := json.Unmarshal(plaintext, &entries); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
@@ -0,0 +1,172 @@
+package clipboard
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"eco/internal/bus"
+	"eco/internal/protocol"
+)
+
+func TestHistory(t *testing.T) {
+	path := filepath.Join(t.TempDir(), HistoryFile)
+	h, err := OpenHistory(path, 3, nil)
+	if err != nil {
+		t.Fatalf("OpenHistory() error = %v", err)
+	}
+
+	for _, text := range []string{"one", "two", "three", "two", "four"} {
+		if err := h.Add(TextContent(text), OriginLocal); err != nil {
+			t.Fatalf("Add() error = %v", err)
+		}
+	}
+	h.Add(TextContent(""), OriginLocal)
+
+	want := []string{"four", "two", "three"}
+	if got := historyTexts(h.List()); !slices.Equal(got, want) {
+		t.Errorf("List() = %v, want %v", got, want)
+	}
+	if e, ok := h.Get(2); !ok || string(e.Data) != "two" {
+		t.Errorf("Get(2) = %q, %v, want two", e.Data, ok)
+	}
+	for _, n := range []int{0, 4} {
+		if _, ok := h.Get(n); ok {
+			t.Errorf("Get(%d) ok = true, want false", n)
+		}
+	}
+
+	// Entries survive reopening
+	reopened, err := OpenHistory(path, 3, nil)
+	if err != nil {
+		t.Fatalf("OpenHistory() error = %v", err)
+	}
+	if got := historyTexts(reopened.List()); !slices.Equal(got, want) {
+		t.Errorf("List() after reopening = %v, want %v", got, want)
+	}
+
+	if err := reopened.Clear(); err != nil {
+		t.Fatalf("Clear() error = %v", err)
+	}
+	reopened, _ = OpenHistory(path, 3, nil)
+	if reopened.Len() != 0 {
+		t.Errorf("Len() after Clear() = %d, want 0", reopened.Len())
+	}
+}
+
+func TestHistoryEncrypted(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, HistoryFile)
+	key, err := LoadHistoryKey(filepath.Join(dir, HistoryKeyFile))
+	if err != nil {
+		t.Fatalf("LoadHistoryKey() error = %v", err)
+	}
+	if again, _ := LoadHistoryKey(filepath.Join(dir, HistoryKeyFile)); string(again) != string(key) {
+		t.Error("LoadHistoryKey() returned a different key the second time")
+	}
+
+	h, _ := OpenHistory(path, 0, key)
+	h.Add(TextContent("secret text"), "mobile-1")
+
+	data, _ := os.ReadFile(path)
+	if strings.Contains(string(data), "secret") {
+		t.Errorf("history file contains the plain text: %s", data)
+	}
+
+	if _, err := OpenHistory(path, 0, nil); !errors.Is(err, ErrHistoryLocked) {
+		t.Errorf("OpenHistory() without a key error = %v, want %v", err, ErrHistoryLocked)
+	}
+	wrongKey := make([]byte, len(key))
+	if _, err := OpenHistory(path, 0, wrongKey); err == nil {
+		t.Error("OpenHistory() with the wrong key error = nil, want an error")
+	}
+
+	reopened, err := OpenHistory(path, 0, key)
+	if err != nil {
+		t.Fatalf("OpenHistory() error = %v", err)
+	}
+	e, ok := reopened.Get(1)
+	if !ok || string(e.Data) != "secret text" || e.Source != "mobile-1" {
+		t.Errorf("Get(1) = %+v, want the secret text from mobile-1", e)
+	}
+}
+
+func TestHistoryLimitsImages(t *testing.T) {
+	h, _ := OpenHistory(filepath.Join(t.TempDir(), HistoryFile), 0, nil)
+	h.maxImages = 10
+
+	image := func(b byte) Content {
+		return Content{MIME: protocol.MIMEPNG, Data: []byte{b, b, b, b, b, b}}
+	}
+	h.Add(image('a'), OriginLocal)
+	h.Add(TextContent("text"), OriginLocal)
+	h.Add(image('b'), OriginLocal)
+
+	want := []string{"bbbbbb", "text"}
+	if got := historyTexts(h.List()); !slices.Equal(got, want) {
+		t.Errorf("List() = %v, want %v", got, want)
+	}
+}
+
+func TestHistoryRecordsBusEvents(t *testing.T) {
+	eventBus := bus.New()
+	defer eventBus.Close()
+
+	now := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	path := filepath.Join(t.TempDir(), HistoryFile)
+	h, _ := OpenHistory(path, 0, nil)
+	h.now = func() time.Time { return now }
+	h.Start(eventBus)
+
+	eventBus.Publish(bus.Event{
+		Type:    protocol.MessageTypeClipboardChanged,
+		Payload: &protocol.ClipboardPayload{Data: "AAEC", MIME: protocol.MIMEPNG, Encoding: protocol.EncodingBase64},
+		Origin:  "mobile-1",
+	})
+	eventBus.Publish(bus.Event{
+		Type:    protocol.MessageTypeNotificationPush,
+		Payload: &protocol.NotificationPayload{Title: "not clipboard"},
+	})
+	eventBus.Publish(bus.Event{
+		Type:    protocol.MessageTypeClipboardChanged,
+		Payload: &protocol.ClipboardPayload{Data: "copied here"},
+	})
+
+	deadline := time.Now().Add(2 * time.Second)
+	for h.Len() < 2 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	entries := h.List()
+	if len(entries) != 2 {
+		t.Fatalf("List() has %d entries, want 2", len(entries))
+	}
+	if e := entries[0]; string(e.Data) != "copied here" || e.Source != OriginLocal || !e.Time.Equal(now) {
+		t.Errorf("newest entry = %+v, want local text", e)
+	}
+	if e := entries[1]; e.MIME != protocol.MIMEPNG || string(e.Data) != "\x00\x01\x02" || e.Source != "mobile-1" {
+		t.Errorf("oldest entry = %+v, want the image from mobile-1", e)
+	}
+
+	// Stop waits for the changes to be saved
+	h.Stop()
+	reopened, err := OpenHistory(path, 0, nil)
+	if err != nil {
+		t.Fatalf("OpenHistory() error = %v", err)
+	}
+	if reopened.Len() != 2 {
+		t.Errorf("Len() after reopening = %d, want 2", reopened.Len())
+	}
+}
+
+func historyTexts(entries []HistoryEntry) []string {
+	var texts []string
+	for _, e := range entries {
+		texts = append(texts, string(e.Data))
+	}
+	return texts
+}
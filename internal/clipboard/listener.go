@@ -1,61 +1,67 @@
 package clipboard
 
 import (
-	"bufio"
-	"os/exec"
+	"log"
+
+	"eco/internal/bus"
+	"eco/internal/protocol"
 )
 
-// Listener monitors the system clipboard for changes
+// Listener monitors the system clipboard for changes and publishes them on the event bus
 type Listener struct {
-	lastContent string
-	onChange    func(string)
-	running     bool
-	cmd         *exec.Cmd
+	lastHash string
+	bus      *bus.Bus
+	backend  Backend
+	tracker  *Tracker
+	filter   *Filter
+	running  bool
+	stop     chan struct{}
 }
 
-// NewListener creates a new clipboard listener
-func NewListener(onChange func(content string)) *Listener {
+// NewListener creates a new clipboard listener watching backend and
+// publishing to eventBus. Changes written for a device, as recorded in
+// tracker, are published with that device as their origin. tracker may be
+// nil, in which case every change is local.
+func NewListener(eventBus *bus.Bus, backend Backend, tracker *Tracker) *Listener {
 	return &Listener{
-		lastContent: "",
-		onChange:    onChange,
-		running:     false,
-		cmd:         nil,
+		bus:     eventBus,
+		backend: backend,
+		tracker: tracker,
+		running: false,
 	}
 }
 
+// SetFilter keeps content that filter rejects from being published. It must
+// be called before Start.
+func (l *Listener) SetFilter(filter *Filter) {
+	l.filter = filter
+}
+
 // Start begins monitoring the clipboard
 func (l *Listener) Start() error {
-	_, err := exec.LookPath("wl-paste")
+	stop := make(chan struct{})
+	changes, err := l.backend.Watch(stop)
 	if err != nil {
 		return err
 	}
 
-	// We use wl-paste --watch to execute a command whenever the clipboard changes.
-	// We use 'printf "!\n"' as a lightweight trigger that we can scan for.
-	cmd := exec.Command("wl-paste", "--watch", "sh", "-c", "printf '!\n'")
-	l.cmd = cmd
-	stdout, err := cmd.StdoutPipe()
-	if err != nil {
-		return err
-	}
-
-	if err := cmd.Start(); err != nil {
-		return err
-	}
-
+	l.stop = stop
 	l.running = true
 	go func() {
-		scanner := bufio.NewScanner(stdout)
-		for scanner.Scan() {
+		for range changes {
 			// Trigger received! Fetch full content.
-			content, err := l.getContent()
+			content, err := ReadContent(l.backend)
 			if err != nil {
 				continue
 			}
 
-			if content != "" && content != l.lastContent {
-				l.onChange(content)
-				l.lastContent = content
+			// Look the origin up even for content we've already seen, so a
+			// device writing what's already there doesn't leave a stale entry
+			hash := content.Hash()
+			origin := l.origin(hash)
+			if !content.Empty() && hash != l.lastHash {
+				l.publish(content, origin)
+				l.lastHash = hash
 			}
 		}
 	}()
@@ -63,13 +69,38 @@ func (l *Listener) Start() error {
 	return nil
 }
 
-func (l *Listener) getContent() (string, error) {
-	cmd := exec.Command("wl-paste", "--no-newline")
-	out, err := cmd.Output()
+// origin returns who put the content with hash on the clipboard
+func (l *Listener) origin(hash string) string {
+	if l.tracker == nil {
+		return OriginLocal
+	}
+	return l.tracker.Origin(hash)
+}
+
+// publish announces a clipboard change on the bus, unless the filter
+// rejects it
+func (l *Listener) publish(content Content, origin string) {
+	if l.filter != nil {
+		if reason := l.filter.Check(content); reason != "" {
+			log.Printf("Clipboard: Filtered change (%s): %s", Redact(content), reason)
+			return
+		}
+	}
+
+	payload, err := content.Payload()
 	if err != nil {
-		return "", err
+		log.Printf("Clipboard: Not syncing change: %v", err)
+		return
+	}
+	err = l.bus.Publish(bus.Event{
+		Type:    protocol.MessageTypeClipboardChanged,
+		Payload: payload,
+		Source:  "clipboard",
+		Origin:  origin,
+	})
+	if err != nil {
+		log.Printf("Clipboard: Failed to publish change: %v", err)
 	}
-	return string(out), nil
 }
 
 // Stop halts the clipboard monitoring
@@ -78,10 +109,7 @@ func (l *Listener) Stop() error {
 		return nil
 	}
 
-	if l.cmd != nil && l.cmd.Process != nil {
-		_ = l.cmd.Process.Kill()
-	}
-
+	close(l.stop)
 	l.running = false
 	return nil
 }
@@ -90,3 +118,8 @@ func (l *Listener) Stop() error {
 func (l *Listener) IsRunning() bool {
 	return l.running
 }
+
+// Backend returns the clipboard the listener watches
+func (l *Listener) Backend() Backend {
+	return l.backend
+}
@@ -0,0 +1,94 @@
+package clipboard
+
+import (
+	"slices"
+	"sync"
+
+	"eco/internal/protocol"
+)
+
+// MemoryBackend is an in-process clipboard for headless daemons and tests.
+// Nothing outside eco can see or change it.
+type MemoryBackend struct {
+	mu       sync.Mutex
+	items    map[string][]byte
+	watchers map[chan struct{}]struct{}
+}
+
+// NewMemoryBackend returns an empty in-memory clipboard
+func NewMemoryBackend() *MemoryBackend {
+	return &MemoryBackend{
+		items:    make(map[string][]byte),
+		watchers: make(map[chan struct{}]struct{}),
+	}
+}
+
+func (b *MemoryBackend) Name() string {
+	return "memory"
+}
+
+func (b *MemoryBackend) Read() (string, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return string(b.items[protocol.MIMEText]), nil
+}
+
+// Write sets the clipboard and signals every watcher
+func (b *MemoryBackend) Write(text string) error {
+	return b.WriteTypes(map[string][]byte{protocol.MIMEText: []byte(text)})
+}
+
+func (b *MemoryBackend) Types() ([]string, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	types := make([]string, 0, len(b.items))
+	for t := range b.items {
+		types = append(types, t)
+	}
+	slices.Sort(types)
+	return types, nil
+}
+
+func (b *MemoryBackend) ReadType(mimeType string) ([]byte, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.items[mimeType], nil
+}
+
+func (b *MemoryBackend) WriteType(mimeType string, data []byte) error {
+	return b.WriteTypes(map[string][]byte{mimeType: data})
+}
+
+// WriteTypes offers the same content as several types at once, the way a
+// browser copies rich text as both text/html and text/plain
+func (b *MemoryBackend) WriteTypes(items map[string][]byte) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.items = make(map[string][]byte, len(items))
+	for t, data := range items {
+		b.items[t] = slices.Clone(data)
+	}
+	for ch := range b.watchers {
+		notify(ch)
+	}
+	return nil
+}
+
+func (b *MemoryBackend) Watch(stop <-chan struct{}) (<-chan struct{}, error) {
+	ch := make(chan struct{}, 1)
+
+	b.mu.Lock()
+	b.watchers[ch] = struct{}{}
+	b.mu.Unlock()
+
+	go func() {
+		<-stop
+		b.mu.Lock()
+		delete(b.watchers, ch)
+		close(ch)
+		b.mu.Unlock()
+	}()
+	return ch, nil
+}
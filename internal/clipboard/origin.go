@@ -0,0 +1,88 @@
+package clipboard
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"sync"
+	"time"
+)
+
+// OriginLocal is the origin of clipboard changes made on the desktop itself.
+// Changes written on behalf of a device have that device's ID as origin.
+const OriginLocal = ""
+
+// OriginTTL is how long a write from a device is remembered while waiting
+// for the backend to report it back as a change
+const OriginTTL = 10 * time.Second
+
+// Hash returns the hex SHA-256 of clipboard content, so content can be
+// compared and remembered without keeping it around
+func Hash(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(sum[:])
+}
+
+// Tracker remembers which device wrote content to the clipboard, so the
+// change the backend reports for that write isn't sent back to the device
+type Tracker struct {
+	mu     sync.Mutex
+	writes map[string]remoteWrite
+	now    func() time.Time
+}
+
+type remoteWrite struct {
+	origin string
+	at     time.Time
+}
+
+// NewTracker creates an empty origin tracker
+func NewTracker() *Tracker {
+	return &Tracker{
+		writes: make(map[string]remoteWrite),
+		now:    time.Now,
+	}
+}
+
+// Remember records that deviceID is about to write the content with the
+// given hash (see Content.Hash) to the clipboard
+func (t *Tracker) Remember(hash, deviceID string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.expire()
+	t.writes[hash] = remoteWrite{origin: deviceID, at: t.now()}
+}
+
+// Forget drops a remembered write, e.g. when it never reached the clipboard
+func (t *Tracker) Forget(hash string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.writes, hash)
+}
+
+// Origin returns the device that wrote the content with hash, or
+// OriginLocal if no device wrote it in the last OriginTTL. A remembered
+// write is only reported once: copying the same content again later is a
+// local change.
+func (t *Tracker) Origin(hash string) string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.expire()
+	write, ok := t.writes[hash]
+	if !ok {
+		return OriginLocal
+	}
+	delete(t.writes, hash)
+	return write.origin
+}
+
+// expire drops writes older than OriginTTL. Callers hold t.mu.
+func (t *Tracker) expire() {
+	now := t.now()
+	for hash, write := range t.writes {
+		if now.Sub(write.at) > OriginTTL {
+			delete(t.writes, hash)
+		}
+	}
+}
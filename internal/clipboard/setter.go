@@ -1,38 +1,50 @@
 package clipboard
 
-import (
-	// "fmt"
-	"os/exec"
-)
+import "errors"
 
 // Setter writes text to the system clipboard
-type Setter struct{}
+type Setter struct {
+	backend Backend
+	tracker *Tracker
+}
 
-// NewSetter creates a new clipboard setter
-func NewSetter() *Setter {
-	return &Setter{}
+// NewSetter creates a new clipboard setter writing to backend. Writes made
+// for a device are recorded in tracker, which may be nil.
+func NewSetter(backend Backend, tracker *Tracker) *Setter {
+	return &Setter{backend: backend, tracker: tracker}
 }
 
-// SetText writes text to the clipboard
+// SetText writes text to the clipboard as a local change
 func (s *Setter) SetText(text string) error {
-	cmd := exec.Command("wl-copy")
-
-	stdin, err := cmd.StdinPipe()
-	if err != nil {
-		return err
+	if s.backend == nil {
+		return errors.New("no clipboard backend")
 	}
+	return s.backend.Write(text)
+}
 
-	if err := cmd.Start(); err != nil {
-		return err
-	}
+// SetTextFrom writes text the device deviceID sent to the clipboard
+func (s *Setter) SetTextFrom(deviceID, text string) error {
+	return s.SetContentFrom(deviceID, TextContent(text))
+}
 
-	if _, err := stdin.Write([]byte(text)); err != nil {
+// SetContentFrom writes content the device deviceID sent to the clipboard.
+// The listener reports the resulting change with deviceID as its origin, so
+// it isn't echoed back to that device.
+func (s *Setter) SetContentFrom(deviceID string, content Content) error {
+	if s.backend == nil {
+		return errors.New("no clipboard backend")
+	}
+	hash := content.Hash()
+	if s.tracker != nil {
+		s.tracker.Remember(hash, deviceID)
+	}
+	if err := WriteContent(s.backend, content); err != nil {
+		if s.tracker != nil {
+			s.tracker.Forget(hash)
+		}
 		return err
 	}
-
-	stdin.Close()
-
-	return cmd.Wait()
+	return nil
 }
 
 // SetTextFromMessage extracts text from clipboard.set message and sets clipboard
@@ -46,11 +58,7 @@ func (s *Setter) SetTextFromMessage(payload []byte) error {
 	return nil
 }
 
-// IsAvailable checks if clipboard commands are available
+// IsAvailable checks if a clipboard backend is configured
 func (s *Setter) IsAvailable() bool {
-	_, err := exec.LookPath("wl-copy")
-	if err != nil {
-		return false
-	}
-	return true
+	return s.backend != nil
 }
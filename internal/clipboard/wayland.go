@@ -0,0 +1,92 @@
+package clipboard
+
+import (
+	"bufio"
+	"errors"
+	"os/exec"
+	"strings"
+)
+
+// WaylandBackend uses wl-clipboard (wl-paste and wl-copy)
+type WaylandBackend struct{}
+
+// NewWaylandBackend returns the Wayland backend if wl-clipboard is installed
+func NewWaylandBackend() (*WaylandBackend, error) {
+	if err := lookPaths("wl-paste", "wl-copy"); err != nil {
+		return nil, err
+	}
+	return &WaylandBackend{}, nil
+}
+
+func (b *WaylandBackend) Name() string {
+	return "wayland (wl-clipboard)"
+}
+
+func (b *WaylandBackend) Read() (string, error) {
+	out, err := exec.Command("wl-paste", "--no-newline").Output()
+	if err != nil {
+		return "", err
+	}
+	return string(out), nil
+}
+
+func (b *WaylandBackend) Write(text string) error {
+	return writeCommand([]byte(text), "wl-copy")
+}
+
+// Types runs wl-paste --list-types, which fails when nothing is copied
+func (b *WaylandBackend) Types() ([]string, error) {
+	out, err := exec.Command("wl-paste", "--list-types").Output()
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return strings.Fields(string(out)), nil
+}
+
+func (b *WaylandBackend) ReadType(mimeType string) ([]byte, error) {
+	return exec.Command("wl-paste", "--no-newline", "--type", mimeType).Output()
+}
+
+func (b *WaylandBackend) WriteType(mimeType string, data []byte) error {
+	return writeCommand(data, "wl-copy", "--type", mimeType)
+}
+
+// Watch uses wl-paste --watch, which runs a command on every change. A
+// printf of one line per change is the lightest trigger to scan for.
+func (b *WaylandBackend) Watch(stop <-chan struct{}) (<-chan struct{}, error) {
+	cmd := exec.Command("wl-paste", "--watch", "sh", "-c", "printf '!\n'")
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	changes := make(chan struct{}, 1)
+	go func() {
+		<-stop
+		_ = cmd.Process.Kill()
+	}()
+	go func() {
+		defer close(changes)
+		scanner := bufio.NewScanner(stdout)
+		for scanner.Scan() {
+			notify(changes)
+		}
+		_ = cmd.Wait()
+	}()
+	return changes, nil
+}
+
+// notify signals ch without blocking; a pending signal already covers it
+func notify(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
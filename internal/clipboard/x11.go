@@ -0,0 +1,156 @@
+package clipboard
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+
+	"eco/internal/protocol"
+)
+
+// PollInterval is how often the X11 backend checks the clipboard for changes.
+// X11 has no change notification that xclip or xsel expose.
+const PollInterval = 500 * time.Millisecond
+
+// X11Backend uses xclip, or xsel when xclip isn't installed, on the
+// CLIPBOARD selection. Only xclip can list and pick targets; with xsel the
+// clipboard is text only.
+type X11Backend struct {
+	tool      string
+	readArgs  []string
+	writeArgs []string
+	interval  time.Duration
+}
+
+// NewX11Backend returns the X11 backend if xclip or xsel is installed
+func NewX11Backend() (*X11Backend, error) {
+	if lookPaths("xclip") == nil {
+		return &X11Backend{
+			tool:      "xclip",
+			readArgs:  []string{"-selection", "clipboard", "-out"},
+			writeArgs: []string{"-selection", "clipboard", "-in"},
+			interval:  PollInterval,
+		}, nil
+	}
+	if lookPaths("xsel") == nil {
+		return &X11Backend{
+			tool:      "xsel",
+			readArgs:  []string{"--clipboard", "--output"},
+			writeArgs: []string{"--clipboard", "--input"},
+			interval:  PollInterval,
+		}, nil
+	}
+	return nil, errors.New("neither xclip nor xsel found in PATH")
+}
+
+func (b *X11Backend) Name() string {
+	return "x11 (" + b.tool + ")"
+}
+
+// Read returns the clipboard text. xclip fails when the selection is empty
+// or holds no text, which is reported as an empty clipboard.
+func (b *X11Backend) Read() (string, error) {
+	out, err := exec.Command(b.tool, b.readArgs...).Output()
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			return "", nil
+		}
+		return "", err
+	}
+	return string(out), nil
+}
+
+func (b *X11Backend) Write(text string) error {
+	return writeCommand([]byte(text), b.tool, b.writeArgs...)
+}
+
+// Types returns the TARGETS of the selection, or just text/plain with xsel
+func (b *X11Backend) Types() ([]string, error) {
+	if b.tool != "xclip" {
+		text, err := b.Read()
+		if err != nil || text == "" {
+			return nil, err
+		}
+		return []string{protocol.MIMEText}, nil
+	}
+
+	out, err := exec.Command("xclip", "-selection", "clipboard", "-target", "TARGETS", "-out").Output()
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return strings.Fields(string(out)), nil
+}
+
+func (b *X11Backend) ReadType(mimeType string) ([]byte, error) {
+	if b.tool != "xclip" {
+		if mimeType != protocol.MIMEText {
+			return nil, fmt.Errorf("%w: xsel can't read %s", ErrUnsupportedType, mimeType)
+		}
+		text, err := b.Read()
+		return []byte(text), err
+	}
+	return exec.Command("xclip", "-selection", "clipboard", "-target", mimeType, "-out").Output()
+}
+
+func (b *X11Backend) WriteType(mimeType string, data []byte) error {
+	if b.tool != "xclip" {
+		if mimeType != protocol.MIMEText {
+			return fmt.Errorf("%w: xsel can't write %s", ErrUnsupportedType, mimeType)
+		}
+		return b.Write(string(data))
+	}
+	return writeCommand(data, "xclip", "-selection", "clipboard", "-target", mimeType, "-in")
+}
+
+// snapshot is what Watch compares between polls: the text and the target
+// list, so copying an image is noticed too. Two images in a row with the
+// same targets and no text look the same and the second is missed.
+func (b *X11Backend) snapshot() (string, error) {
+	text, err := b.Read()
+	if err != nil || b.tool != "xclip" {
+		return text, err
+	}
+	types, err := b.Types()
+	if err != nil {
+		return "", err
+	}
+	return strings.Join(types, " ") + "\x00" + text, nil
+}
+
+// Watch polls the clipboard and signals when its content differs from the
+// last poll
+func (b *X11Backend) Watch(stop <-chan struct{}) (<-chan struct{}, error) {
+	last, err := b.snapshot()
+	if err != nil {
+		return nil, err
+	}
+
+	changes := make(chan struct{}, 1)
+	go func() {
+		defer close(changes)
+		ticker := time.NewTicker(b.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				content, err := b.snapshot()
+				if err != nil || content == last {
+					continue
+				}
+				last = content
+				notify(changes)
+			}
+		}
+	}()
+	return changes, nil
+}
@@ -7,6 +7,7 @@ import (
 	// "fmt"
 	"os"
 	"path/filepath"
+	"time"
 )
 
 const (
@@ -16,9 +17,67 @@ const (
 
 // Config holds all configuration for the eco daemon
 type Config struct {
+	// DeviceID and SharedSecret are the single-device credentials written by
+	// older versions of eco. They are migrated into Devices on Load.
 	DeviceID     string
 	SharedSecret string
 	Port         int
+	Devices      []*Device
+	// RequireEncryption refuses devices that don't negotiate payload
+	// encryption in device.hello instead of falling back to plain text
+	RequireEncryption bool
+	// DisableTLS serves plain ws:// and http:// instead of TLS with the
+	// daemon's self-signed certificate
+	DisableTLS bool
+	// ClipboardHistorySize is how many clipboard changes the daemon keeps;
+	// 0 uses the default and a negative size turns the history off
+	ClipboardHistorySize int
+	// EncryptClipboardHistory encrypts the history file with a key kept
+	// next to it
+	EncryptClipboardHistory bool
+	// ClipboardDenyPatterns are regular expressions for clipboard text that
+	// is never sent to devices
+	ClipboardDenyPatterns []string
+	// DisableDefaultClipboardPatterns stops filtering keys, tokens and card
+	// numbers out of the clipboard. Password manager hints are always
+	// honoured.
+	DisableDefaultClipboardPatterns bool
+	// NotificationAllowApps, if set, are the only applications whose desktop
+	// notifications are forwarded to devices
+	NotificationAllowApps []string
+	// NotificationDenyApps are applications whose desktop notifications are
+	// never forwarded
+	NotificationDenyApps []string
+	// DownloadDir is where files received from devices are saved; empty
+	// uses ~/Downloads
+	DownloadDir string
+	// DownloadConflict is what happens to a received file whose name is
+	// taken: "rename" (the default) saves it as "name (1)", "overwrite"
+	// replaces the existing file and "skip" keeps it
+	DownloadConflict string
+}
+
+// Device is a paired mobile device and its credentials
+type Device struct {
+	ID        string
+	Name      string
+	Secret    string
+	CreatedAt time.Time
+	LastSeen  time.Time
+	Enabled   bool
+	// Platform, AppVersion, ProtocolVersion and Capabilities are what the
+	// device advertised in its last device.hello. Capabilities is nil until
+	// the device has connected.
+	Platform        string
+	AppVersion      string
+	ProtocolVersion int
+	Capabilities    []string
+	// NeverSyncClipboard keeps desktop clipboard changes from this device
+	NeverSyncClipboard bool
+	// AllowInput lets the device type and move the pointer on the desktop.
+	// It is off until the user turns it on, as the device can then do
+	// anything the user can.
+	AllowInput bool
 }
 
 // ConfigPath returns the full path to the config file
@@ -52,9 +111,29 @@ func Load() (*Config, error) {
 		return nil, err
 	}
 
+	config.migrateLegacyDevice()
+
 	return &config, nil
 }
 
+// migrateLegacyDevice adds the single-device credentials to the registry
+func (c *Config) migrateLegacyDevice() {
+	if c.DeviceID == "" || c.SharedSecret == "" {
+		return
+	}
+	if c.FindDevice(c.DeviceID) != nil {
+		return
+	}
+
+	c.Devices = append(c.Devices, &Device{
+		ID:        c.DeviceID,
+		Name:      "default",
+		Secret:    c.SharedSecret,
+		CreatedAt: time.Now(),
+		Enabled:   true,
+	})
+}
+
 // Save writes the config to disk
 func (c *Config) Save() error {
 	cfgPath, err := ConfigPath()
@@ -79,7 +158,7 @@ func (c *Config) IsInitialized() bool {
 	if c.DeviceID != "" && c.SharedSecret != "" {
 		return true
 	}
-	return false
+	return len(c.Devices) > 0
 }
 
 // GetDeviceCredentials returns the device ID and secret for auth
@@ -87,6 +166,92 @@ func (c *Config) GetDeviceCredentials() (deviceID, secret string) {
 	return c.DeviceID, c.SharedSecret
 }
 
+// FindDevice returns the paired device with the given ID, or nil
+func (c *Config) FindDevice(id string) *Device {
+	for _, d := range c.Devices {
+		if d.ID == id {
+			return d
+		}
+	}
+	return nil
+}
+
+// AddDevice registers a new paired device
+func (c *Config) AddDevice(d *Device) error {
+	if d.ID == "" || d.Secret == "" {
+		return fmt.Errorf("device ID and secret are required")
+	}
+	if c.FindDevice(d.ID) != nil {
+		return fmt.Errorf("device %s is already paired", d.ID)
+	}
+	if d.CreatedAt.IsZero() {
+		d.CreatedAt = time.Now()
+	}
+
+	c.Devices = append(c.Devices, d)
+	return nil
+}
+
+// RemoveDevice unpairs the device with the given ID
+func (c *Config) RemoveDevice(id string) error {
+	for i, d := range c.Devices {
+		if d.ID == id {
+			c.Devices = append(c.Devices[:i], c.Devices[i+1:]...)
+			// Drop the legacy credentials too, otherwise Load would migrate them back
+			if c.DeviceID == id {
+				c.DeviceID = ""
+				c.SharedSecret = ""
+			}
+			return nil
+		}
+	}
+	return fmt.Errorf("device %s not found", id)
+}
+
+// RenameDevice changes the display name of a paired device
+func (c *Config) RenameDevice(id, name string) error {
+	d := c.FindDevice(id)
+	if d == nil {
+		return fmt.Errorf("device %s not found", id)
+	}
+	d.Name = name
+	return nil
+}
+
+// EnableDevice lets a paired device connect, or keeps it out without
+// unpairing it
+func (c *Config) EnableDevice(id string, enabled bool) error {
+	d := c.FindDevice(id)
+	if d == nil {
+		return fmt.Errorf("device %s not found", id)
+	}
+	d.Enabled = enabled
+	return nil
+}
+
+// SyncDeviceClipboard sends desktop clipboard changes to a paired device, or
+// stops sending them
+func (c *Config) SyncDeviceClipboard(id string, sync bool) error {
+	d := c.FindDevice(id)
+	if d == nil {
+		return fmt.Errorf("device %s not found", id)
+	}
+	d.NeverSyncClipboard = !sync
+	return nil
+}
+
+// AllowDeviceInput lets a paired device control the keyboard and mouse, or
+// stops it
+func (c *Config) AllowDeviceInput(id string, allow bool) error {
+	d := c.FindDevice(id)
+	if d == nil {
+		return fmt.Errorf("device %s not found", id)
+	}
+	d.AllowInput = allow
+	return nil
+}
+
+// DeleteConfig removes the config file from disk
 func (c *Config) DeleteConfig() error {
 	if !c.IsInitialized() {
 		return fmt.Errorf("Config does not exist to delete")
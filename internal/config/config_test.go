@@ -200,3 +200,122 @@ func containsHelper(s, substr string) bool {
 	}
 	return false
 }
+
+func TestDeviceRegistry(t *testing.T) {
+	cfg := &Config{}
+
+	if err := cfg.AddDevice(&Device{ID: "phone", Name: "Phone", Secret: "s1", Enabled: true}); err != nil {
+		t.Fatalf("AddDevice() error = %v", err)
+	}
+	if err := cfg.AddDevice(&Device{ID: "tablet", Name: "Tablet", Secret: "s2", Enabled: true}); err != nil {
+		t.Fatalf("AddDevice() error = %v", err)
+	}
+
+	if err := cfg.AddDevice(&Device{ID: "phone", Secret: "s3"}); err == nil {
+		t.Error("AddDevice() should error for duplicate device ID")
+	}
+	if err := cfg.AddDevice(&Device{ID: "no-secret"}); err == nil {
+		t.Error("AddDevice() should error for missing secret")
+	}
+
+	if !cfg.IsInitialized() {
+		t.Error("IsInitialized() = false with paired devices")
+	}
+
+	d := cfg.FindDevice("tablet")
+	if d == nil {
+		t.Fatal("FindDevice() returned nil for paired device")
+	}
+	if d.CreatedAt.IsZero() {
+		t.Error("AddDevice() did not set CreatedAt")
+	}
+
+	if err := cfg.RenameDevice("tablet", "Kitchen tablet"); err != nil {
+		t.Errorf("RenameDevice() error = %v", err)
+	}
+	if d.Name != "Kitchen tablet" {
+		t.Errorf("RenameDevice() Name = %v, want %v", d.Name, "Kitchen tablet")
+	}
+	if err := cfg.RenameDevice("missing", "x"); err == nil {
+		t.Error("RenameDevice() should error for unknown device")
+	}
+
+	if err := cfg.EnableDevice("tablet", false); err != nil || d.Enabled {
+		t.Errorf("EnableDevice() error = %v, Enabled = %v", err, d.Enabled)
+	}
+	if err := cfg.EnableDevice("missing", true); err == nil {
+		t.Error("EnableDevice() should error for unknown device")
+	}
+
+	if err := cfg.SyncDeviceClipboard("tablet", false); err != nil || !d.NeverSyncClipboard {
+		t.Errorf("SyncDeviceClipboard() error = %v, NeverSyncClipboard = %v", err, d.NeverSyncClipboard)
+	}
+	if err := cfg.SyncDeviceClipboard("missing", true); err == nil {
+		t.Error("SyncDeviceClipboard() should error for unknown device")
+	}
+
+	if d.AllowInput {
+		t.Error("AddDevice() allowed input")
+	}
+	if err := cfg.AllowDeviceInput("tablet", true); err != nil || !d.AllowInput {
+		t.Errorf("AllowDeviceInput() error = %v, AllowInput = %v", err, d.AllowInput)
+	}
+	if err := cfg.AllowDeviceInput("missing", true); err == nil {
+		t.Error("AllowDeviceInput() should error for unknown device")
+	}
+
+	if err := cfg.RemoveDevice("phone"); err != nil {
+		t.Errorf("RemoveDevice() error = %v", err)
+	}
+	if cfg.FindDevice("phone") != nil {
+		t.Error("RemoveDevice() did not remove device")
+	}
+	if err := cfg.RemoveDevice("phone"); err == nil {
+		t.Error("RemoveDevice() should error for unknown device")
+	}
+	if len(cfg.Devices) != 1 {
+		t.Errorf("len(Devices) = %d, want 1", len(cfg.Devices))
+	}
+}
+
+func TestLegacyDeviceMigration(t *testing.T) {
+	tempDir := t.TempDir()
+
+	originalHome := os.Getenv("HOME")
+	os.Setenv("HOME", tempDir)
+	defer os.Setenv("HOME", originalHome)
+
+	legacy := &Config{DeviceID: "mobile-legacy", SharedSecret: "legacy-secret"}
+	if err := legacy.Save(); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+
+	loaded, err := Load()
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+
+	d := loaded.FindDevice("mobile-legacy")
+	if d == nil {
+		t.Fatal("Load() did not migrate legacy device")
+	}
+	if d.Secret != "legacy-secret" || !d.Enabled {
+		t.Errorf("migrated device = %+v, want enabled with legacy secret", d)
+	}
+
+	// Removing the migrated device must not bring it back on the next load
+	if err := loaded.RemoveDevice("mobile-legacy"); err != nil {
+		t.Fatalf("RemoveDevice() error = %v", err)
+	}
+	if err := loaded.Save(); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+
+	reloaded, err := Load()
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if reloaded.FindDevice("mobile-legacy") != nil {
+		t.Error("removed legacy device was migrated again")
+	}
+}
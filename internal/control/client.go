@@ -0,0 +1,100 @@
+package control
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+)
+
+// ErrDaemonNotRunning is returned by Dial when no daemon is listening
+var ErrDaemonNotRunning = errors.New("daemon is not running")
+
+// Client sends commands to the daemon over its control socket
+type Client struct {
+	conn    net.Conn
+	reader  *bufio.Reader
+	nextID  int
+	Timeout time.Duration
+}
+
+// Dial connects to the daemon's control socket
+func Dial() (*Client, error) {
+	return DialPath(SocketPath())
+}
+
+// DialPath connects to the control socket at path
+func DialPath(path string) (*Client, error) {
+	conn, err := net.DialTimeout("unix", path, 2*time.Second)
+	if err != nil {
+		return nil, ErrDaemonNotRunning
+	}
+
+	return &Client{
+		conn:    conn,
+		reader:  bufio.NewReader(conn),
+		Timeout: 10 * time.Second,
+	}, nil
+}
+
+// Call invokes method with params and decodes the result into result (if non-nil)
+func (c *Client) Call(method string, params any, result any) error {
+	c.nextID++
+	req := Request{ID: c.nextID, Method: method}
+	if params != nil {
+		raw, err := json.Marshal(params)
+		if err != nil {
+			return err
+		}
+		req.Params = raw
+	}
+
+	data, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+
+	if c.Timeout > 0 {
+		c.conn.SetDeadline(time.Now().Add(c.Timeout))
+	}
+	if _, err := c.conn.Write(append(data, '\n')); err != nil {
+		return err
+	}
+
+	line, err := c.reader.ReadBytes('\n')
+	if err != nil {
+		return err
+	}
+
+	var resp Response
+	if err := json.Unmarshal(line, &resp); err != nil {
+		return err
+	}
+	if resp.ID != req.ID {
+		return fmt.Errorf("response id %d does not match request id %d", resp.ID, req.ID)
+	}
+	if resp.Error != nil {
+		return resp.Error
+	}
+	if result != nil && len(resp.Result) > 0 {
+		return json.Unmarshal(resp.Result, result)
+	}
+	return nil
+}
+
+// Close hangs up the connection
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
+// Call dials the daemon, invokes a single method and hangs up
+func Call(method string, params any, result any) error {
+	client, err := Dial()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	return client.Call(method, params, result)
+}
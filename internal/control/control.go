@@ -0,0 +1,337 @@
+package control
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"eco/internal/transfer"
+)
+
+const (
+	SocketDir  = "eco"
+	SocketFile = "eco.sock"
+)
+
+// Request is a single command sent to the daemon over the control socket
+type Request struct {
+	ID     int             `json:"id"`
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params,omitempty"`
+}
+
+// Response is the daemon's answer to a Request with the same ID
+type Response struct {
+	ID     int             `json:"id"`
+	Result json.RawMessage `json:"result,omitempty"`
+	Error  *Error          `json:"error,omitempty"`
+}
+
+// Error is a failed command
+type Error struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// Error codes, following JSON-RPC where one applies
+const (
+	CodeParseError     = -32700
+	CodeMethodNotFound = -32601
+	CodeInvalidParams  = -32602
+	CodeInternalError  = -32603
+
+	// Requests to devices, in the range JSON-RPC leaves to servers
+	CodeDeviceUnavailable = -32001
+	CodeDeviceRejected    = -32002
+	CodeDeviceTimeout     = -32003
+)
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// Errorf creates an Error with the given code
+func Errorf(code int, format string, args ...any) *Error {
+	return &Error{Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+// Control methods served by the daemon
+const (
+	MethodStatus           = "status"
+	MethodDevices          = "devices"
+	MethodDeviceDisconnect = "device.disconnect"
+	MethodDeviceAdd        = "device.add"
+	MethodDeviceRemove     = "device.remove"
+	MethodDeviceRename     = "device.rename"
+	MethodDeviceEnable     = "device.enable"
+	MethodDeviceClipboard  = "device.clipboard"
+	MethodClipboardPush    = "clipboard.push"
+	MethodClipboardPull    = "clipboard.pull"
+	MethodClipboardHistory = "clipboard.history"
+	MethodClipboardGet     = "clipboard.get"
+	MethodClipboardClear   = "clipboard.clear"
+	MethodCallAnswer       = "call.answer"
+	MethodCallHangup       = "call.hangup"
+	MethodSMSList          = "sms.list"
+	MethodSMSRead          = "sms.read"
+	MethodSMSSend          = "sms.send"
+	MethodFileSend         = "file.send"
+	MethodFileReceive      = "file.receive"
+	MethodFileList         = "file.list"
+	MethodFileStatus       = "file.status"
+	MethodFileCancel       = "file.cancel"
+	MethodInputAllow       = "input.allow"
+	MethodMobileOpen       = "mobile.open"
+	MethodMobileURL        = "mobile.url"
+	MethodMobileRing       = "mobile.ring"
+	MethodMobileLock       = "mobile.lock"
+	MethodDaemonStop       = "daemon.stop"
+	MethodPairBegin        = "pair.begin"
+	MethodPairStatus       = "pair.status"
+	MethodPairCancel       = "pair.cancel"
+	MethodTLSReload        = "tls.reload"
+)
+
+// Status is the result of MethodStatus
+type Status struct {
+	PID         int            `json:"pid"`
+	StartedAt   time.Time      `json:"started_at"`
+	Uptime      float64        `json:"uptime_seconds"`
+	Listeners   []string       `json:"listeners"`
+	Devices     []DeviceStatus `json:"devices"`
+	QueueDepths map[string]int `json:"queue_depths"`
+	LastSync    time.Time      `json:"last_sync"`
+	// TLSFingerprint is the SHA-256 of the served certificate, empty without TLS
+	TLSFingerprint string `json:"tls_fingerprint,omitempty"`
+	// Clipboard names the clipboard backend, e.g. "wayland (wl-clipboard)"
+	Clipboard string `json:"clipboard,omitempty"`
+	// Messages counts the messages received from devices, per message type
+	Messages map[string]MessageStats `json:"messages,omitempty"`
+}
+
+// MessageStats counts the messages of one type received from devices
+type MessageStats struct {
+	Handled int64 `json:"handled"`
+	Failed  int64 `json:"failed"`
+}
+
+// TLSStatus is the result of MethodTLSReload
+type TLSStatus struct {
+	Fingerprint string `json:"fingerprint"`
+}
+
+// DeviceStatus is the daemon's view of one paired device
+type DeviceStatus struct {
+	ID         string    `json:"id"`
+	Name       string    `json:"name"`
+	Enabled    bool      `json:"enabled"`
+	Connected  bool      `json:"connected"`
+	Encrypted  bool      `json:"encrypted"`
+	LastSeen   time.Time `json:"last_seen"`
+	QueueDepth int       `json:"queue_depth"`
+	// Pending is the number of messages kept until the device reconnects
+	Pending int `json:"pending"`
+}
+
+// DeviceParams selects a device for device.* methods
+type DeviceParams struct {
+	DeviceID string `json:"device_id"`
+}
+
+// DeviceAddParams registers a device with credentials made by the CLI
+type DeviceAddParams struct {
+	DeviceID string `json:"device_id"`
+	Name     string `json:"name,omitempty"`
+	Secret   string `json:"secret"`
+}
+
+// DeviceRenameParams gives DeviceID a new display name
+type DeviceRenameParams struct {
+	DeviceID string `json:"device_id"`
+	Name     string `json:"name"`
+}
+
+// DeviceEnableParams lets DeviceID connect, or keeps it out if Enabled is
+// unset
+type DeviceEnableParams struct {
+	DeviceID string `json:"device_id"`
+	Enabled  bool   `json:"enabled"`
+}
+
+// DeviceClipboardParams sends desktop clipboard changes to DeviceID, or
+// stops if Sync is unset
+type DeviceClipboardParams struct {
+	DeviceID string `json:"device_id"`
+	Sync     bool   `json:"sync"`
+}
+
+// ClipboardParams carries clipboard content for clipboard.* methods
+type ClipboardParams struct {
+	Data string `json:"data"`
+}
+
+// ClipboardPullParams selects the device to read the clipboard from.
+// An empty DeviceID means the only connected device.
+type ClipboardPullParams struct {
+	DeviceID string `json:"device_id,omitempty"`
+}
+
+// ClipboardContent is the result of MethodClipboardPull
+type ClipboardContent struct {
+	DeviceID string `json:"device_id"`
+	Data     string `json:"data"`
+	// MIME and Encoding are as in protocol.ClipboardPayload
+	MIME     string `json:"mime,omitempty"`
+	Encoding string `json:"encoding,omitempty"`
+}
+
+// ClipboardEntryParams selects a history entry, 1 being the newest
+type ClipboardEntryParams struct {
+	Index int `json:"index"`
+}
+
+// ClipboardEntry is a clipboard history entry. MethodClipboardHistory
+// returns entries with a Preview and no Data; MethodClipboardGet returns
+// the Data of one entry.
+type ClipboardEntry struct {
+	Index int `json:"index"`
+	// Source is the device the content came from, empty for the desktop
+	Source     string    `json:"source,omitempty"`
+	SourceName string    `json:"source_name,omitempty"`
+	Time       time.Time `json:"time"`
+	MIME       string    `json:"mime"`
+	Size       int       `json:"size"`
+	Preview    string    `json:"preview,omitempty"`
+	Data       string    `json:"data,omitempty"`
+	// Encoding is protocol.EncodingBase64 for binary Data
+	Encoding string `json:"encoding,omitempty"`
+}
+
+// CallParams selects the device whose call to answer or hang up. An empty
+// DeviceID means the only device with a call.
+type CallParams struct {
+	DeviceID string `json:"device_id,omitempty"`
+}
+
+// CallInfo is the result of MethodCallAnswer and MethodCallHangup
+type CallInfo struct {
+	DeviceID   string `json:"device_id"`
+	DeviceName string `json:"device_name,omitempty"`
+	Number     string `json:"number,omitempty"`
+}
+
+// SMSThreadParams selects a conversation by contact name, number or thread,
+// on DeviceID if several devices have one. Limit keeps only the newest
+// messages.
+type SMSThreadParams struct {
+	Thread   string `json:"thread"`
+	DeviceID string `json:"device_id,omitempty"`
+	Limit    int    `json:"limit,omitempty"`
+}
+
+// SMSThread is a conversation. MethodSMSList returns them without Messages.
+type SMSThread struct {
+	Key         string    `json:"key"`
+	DeviceID    string    `json:"device_id"`
+	DeviceName  string    `json:"device_name,omitempty"`
+	Address     string    `json:"address"`
+	ContactName string    `json:"contact_name,omitempty"`
+	Time        time.Time `json:"time"`
+	// Snippet previews the newest message
+	Snippet  string       `json:"snippet"`
+	Count    int          `json:"count"`
+	Unread   int          `json:"unread"`
+	Messages []SMSMessage `json:"messages,omitempty"`
+}
+
+// SMSMessage is a message in an SMSThread
+type SMSMessage struct {
+	Time time.Time `json:"time"`
+	Sent bool      `json:"sent,omitempty"`
+	Read bool      `json:"read,omitempty"`
+	Body string    `json:"body"`
+}
+
+// SMSSendParams sends Body to To, a number or the name of a contact with a
+// conversation, from DeviceID or the only connected device
+type SMSSendParams struct {
+	DeviceID string `json:"device_id,omitempty"`
+	To       string `json:"to"`
+	Body     string `json:"body"`
+}
+
+// SMSSent is the result of MethodSMSSend
+type SMSSent struct {
+	DeviceID    string `json:"device_id"`
+	DeviceName  string `json:"device_name,omitempty"`
+	Address     string `json:"address"`
+	ContactName string `json:"contact_name,omitempty"`
+}
+
+// FileSendParams offers the file or folder at Path, an absolute path, to
+// DeviceID or the only connected device. Compress sends a folder zstd
+// compressed.
+type FileSendParams struct {
+	DeviceID string `json:"device_id,omitempty"`
+	Path     string `json:"path"`
+	Compress bool   `json:"compress,omitempty"`
+}
+
+// FileReceiveParams accepts the files offered as IDs, or every offered file
+// if there are none, saving them in Dir or the configured directory.
+// Conflict overrides the configured policy for names that are taken.
+type FileReceiveParams struct {
+	IDs      []string `json:"ids,omitempty"`
+	Dir      string   `json:"dir,omitempty"`
+	Conflict string   `json:"conflict,omitempty"`
+}
+
+// FileParams selects a transfer for MethodFileStatus and MethodFileCancel
+type FileParams struct {
+	ID string `json:"id"`
+}
+
+// Transfer is a file transfer, as returned by the file methods
+type Transfer struct {
+	transfer.Transfer
+	DeviceName string `json:"device_name,omitempty"`
+}
+
+// InputAllowParams lets DeviceID control the keyboard and mouse, or stops
+// it if Allow is unset
+type InputAllowParams struct {
+	DeviceID string `json:"device_id"`
+	Allow    bool   `json:"allow"`
+}
+
+// MobileParams selects the device for mobile.* methods, DeviceID or the
+// only connected device, and the app or URL to open
+type MobileParams struct {
+	DeviceID string `json:"device_id,omitempty"`
+	Package  string `json:"package,omitempty"`
+	URL      string `json:"url,omitempty"`
+}
+
+// MobileResult is the device that carried out a mobile.* method
+type MobileResult struct {
+	DeviceID   string `json:"device_id"`
+	DeviceName string `json:"device_name,omitempty"`
+}
+
+// PairParams starts or selects a pairing session
+type PairParams struct {
+	Name       string `json:"name,omitempty"`
+	TTLSeconds int    `json:"ttl_seconds,omitempty"`
+	Code       string `json:"code,omitempty"`
+}
+
+// SocketPath returns the path of the daemon's control socket.
+// It lives in $XDG_RUNTIME_DIR when set, otherwise in a per-user temp directory.
+func SocketPath() string {
+	if dir := os.Getenv("XDG_RUNTIME_DIR"); dir != "" {
+		return filepath.Join(dir, SocketDir, SocketFile)
+	}
+	return filepath.Join(os.TempDir(), fmt.Sprintf("%s-%d", SocketDir, os.Getuid()), SocketFile)
+}
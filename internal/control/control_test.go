@@ -0,0 +1,129 @@
+package control
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func startTestServer(t *testing.T) *Server {
+	t.Helper()
+	srv := NewServer(filepath.Join(t.TempDir(), SocketDir, SocketFile))
+	srv.Handle("echo", func(params json.RawMessage) (any, error) {
+		var p ClipboardParams
+		if err := DecodeParams(params, &p); err != nil {
+			return nil, err
+		}
+		return p, nil
+	})
+	srv.Handle("fail", func(params json.RawMessage) (any, error) {
+		return nil, errors.New("boom")
+	})
+
+	if err := srv.Start(); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	t.Cleanup(func() { srv.Stop() })
+	return srv
+}
+
+func TestCallRoundTrip(t *testing.T) {
+	srv := startTestServer(t)
+
+	client, err := DialPath(srv.Path())
+	if err != nil {
+		t.Fatalf("DialPath() error = %v", err)
+	}
+	defer client.Close()
+
+	// Several calls share one connection
+	for _, data := range []string{"first", "second"} {
+		var result ClipboardParams
+		if err := client.Call("echo", &ClipboardParams{Data: data}, &result); err != nil {
+			t.Fatalf("Call() error = %v", err)
+		}
+		if result.Data != data {
+			t.Errorf("Call() result = %q, want %q", result.Data, data)
+		}
+	}
+}
+
+func TestCallErrors(t *testing.T) {
+	srv := startTestServer(t)
+
+	client, err := DialPath(srv.Path())
+	if err != nil {
+		t.Fatalf("DialPath() error = %v", err)
+	}
+	defer client.Close()
+
+	tests := []struct {
+		name   string
+		method string
+		params any
+		code   int
+	}{
+		{name: "Unknown method", method: "missing", code: CodeMethodNotFound},
+		{name: "Missing params", method: "echo", code: CodeInvalidParams},
+		{name: "Handler error", method: "fail", code: CodeInternalError},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := client.Call(tt.method, tt.params, nil)
+			var ctlErr *Error
+			if !errors.As(err, &ctlErr) {
+				t.Fatalf("Call() error = %v, want *Error", err)
+			}
+			if ctlErr.Code != tt.code {
+				t.Errorf("Call() error code = %d, want %d", ctlErr.Code, tt.code)
+			}
+		})
+	}
+}
+
+func TestDialNotRunning(t *testing.T) {
+	_, err := DialPath(filepath.Join(t.TempDir(), SocketFile))
+	if !errors.Is(err, ErrDaemonNotRunning) {
+		t.Errorf("DialPath() error = %v, want ErrDaemonNotRunning", err)
+	}
+}
+
+func TestStartRefusesLiveSocket(t *testing.T) {
+	srv := startTestServer(t)
+
+	second := NewServer(srv.Path())
+	if err := second.Start(); err == nil {
+		second.Stop()
+		t.Error("Start() should fail while another server owns the socket")
+	}
+}
+
+func TestStartRefusesSharedDir(t *testing.T) {
+	open := filepath.Join(t.TempDir(), "open")
+	os.Mkdir(open, 0755)
+	os.Chmod(open, 0755)
+	private := filepath.Join(t.TempDir(), "private")
+	os.Mkdir(private, 0700)
+	link := filepath.Join(t.TempDir(), "link")
+	os.Symlink(private, link)
+
+	for _, dir := range []string{open, link} {
+		srv := NewServer(filepath.Join(dir, SocketFile))
+		if err := srv.Start(); !errors.Is(err, ErrUnsafeDir) {
+			srv.Stop()
+			t.Errorf("Start() in %s error = %v, want %v", dir, err, ErrUnsafeDir)
+		}
+	}
+
+	srv := NewServer(filepath.Join(private, SocketFile))
+	if err := srv.Start(); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	defer srv.Stop()
+	if info, _ := os.Stat(srv.Path()); info.Mode().Perm() != 0600 {
+		t.Errorf("socket mode = %o, want 600", info.Mode().Perm())
+	}
+}
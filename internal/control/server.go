@@ -0,0 +1,237 @@
+package control
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+)
+
+// ErrUnsafeDir is returned when the socket directory isn't private to the
+// user, so someone else could replace the socket
+var ErrUnsafeDir = errors.New("control socket directory is not private")
+
+// HandlerFunc serves one control method. The returned value is sent back as
+// the result; an error is sent back as the response error.
+type HandlerFunc func(params json.RawMessage) (any, error)
+
+// Server accepts commands from the CLI on a unix socket
+type Server struct {
+	path     string
+	listener net.Listener
+	mu       sync.RWMutex
+	handlers map[string]HandlerFunc
+	conns    map[net.Conn]struct{}
+	wg       sync.WaitGroup
+}
+
+// NewServer creates a control server listening on path
+func NewServer(path string) *Server {
+	return &Server{
+		path:     path,
+		handlers: make(map[string]HandlerFunc),
+		conns:    make(map[net.Conn]struct{}),
+	}
+}
+
+// Handle registers the handler for method
+func (s *Server) Handle(method string, handler HandlerFunc) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.handlers[method] = handler
+}
+
+// Path returns the socket path
+func (s *Server) Path() string {
+	return s.path
+}
+
+// Start creates the socket and begins accepting connections
+func (s *Server) Start() error {
+	dir := filepath.Dir(s.path)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	// MkdirAll accepts a directory another user created first
+	if err := checkDir(dir); err != nil {
+		return err
+	}
+
+	// A socket file left behind by a crashed daemon is removed; a live one is not
+	if _, err := os.Stat(s.path); err == nil {
+		if conn, err := net.Dial("unix", s.path); err == nil {
+			conn.Close()
+			return fmt.Errorf("daemon already running (socket %s)", s.path)
+		}
+		if err := os.Remove(s.path); err != nil {
+			return err
+		}
+	}
+
+	// Create the socket without access for others rather than restrict it
+	// once it is already listening
+	umask := syscall.Umask(0177)
+	listener, err := net.Listen("unix", s.path)
+	syscall.Umask(umask)
+	if err != nil {
+		return err
+	}
+	s.listener = listener
+
+	s.wg.Add(1)
+	go s.acceptLoop()
+	return nil
+}
+
+// Stop closes the socket and all open connections
+func (s *Server) Stop() error {
+	if s.listener == nil {
+		return nil
+	}
+	err := s.listener.Close()
+
+	s.mu.Lock()
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mu.Unlock()
+
+	s.wg.Wait()
+	return err
+}
+
+func (s *Server) acceptLoop() {
+	defer s.wg.Done()
+
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		if err := checkPeer(conn); err != nil {
+			log.Printf("Control: Refusing connection: %v", err)
+			conn.Close()
+			continue
+		}
+
+		s.mu.Lock()
+		s.conns[conn] = struct{}{}
+		s.mu.Unlock()
+
+		s.wg.Add(1)
+		go s.serveConn(conn)
+	}
+}
+
+// checkDir refuses dir unless it is a real directory of the current user
+// that nobody else can use
+func checkDir(dir string) error {
+	info, err := os.Lstat(dir)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%w: %s is not a directory", ErrUnsafeDir, dir)
+	}
+	if st, ok := info.Sys().(*syscall.Stat_t); !ok || int(st.Uid) != os.Getuid() {
+		return fmt.Errorf("%w: %s belongs to another user", ErrUnsafeDir, dir)
+	}
+	if perm := info.Mode().Perm(); perm != 0700 {
+		return fmt.Errorf("%w: %s has mode %o, want 700", ErrUnsafeDir, dir, perm)
+	}
+	return nil
+}
+
+// checkPeer refuses connections from processes of other users
+func checkPeer(conn net.Conn) error {
+	unixConn, ok := conn.(*net.UnixConn)
+	if !ok {
+		return fmt.Errorf("not a unix socket connection")
+	}
+	raw, err := unixConn.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var cred *syscall.Ucred
+	var credErr error
+	if err := raw.Control(func(fd uintptr) {
+		cred, credErr = syscall.GetsockoptUcred(int(fd), syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+	}); err != nil {
+		return err
+	}
+	if credErr != nil {
+		return credErr
+	}
+	if int(cred.Uid) != os.Getuid() {
+		return fmt.Errorf("peer uid %d is not %d", cred.Uid, os.Getuid())
+	}
+	return nil
+}
+
+// serveConn answers newline-delimited requests until the client hangs up
+func (s *Server) serveConn(conn net.Conn) {
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+		conn.Close()
+		s.wg.Done()
+	}()
+
+	scanner := bufio.NewScanner(conn)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	encoder := json.NewEncoder(conn)
+
+	for scanner.Scan() {
+		resp := s.dispatch(scanner.Bytes())
+		if err := encoder.Encode(resp); err != nil {
+			log.Printf("Control: Failed to write response: %v", err)
+			return
+		}
+	}
+}
+
+func (s *Server) dispatch(data []byte) *Response {
+	var req Request
+	if err := json.Unmarshal(data, &req); err != nil {
+		return &Response{Error: Errorf(CodeParseError, "invalid request: %v", err)}
+	}
+
+	s.mu.RLock()
+	handler, ok := s.handlers[req.Method]
+	s.mu.RUnlock()
+	if !ok {
+		return &Response{ID: req.ID, Error: Errorf(CodeMethodNotFound, "unknown method: %s", req.Method)}
+	}
+
+	result, err := handler(req.Params)
+	if err != nil {
+		if ctlErr, ok := err.(*Error); ok {
+			return &Response{ID: req.ID, Error: ctlErr}
+		}
+		return &Response{ID: req.ID, Error: Errorf(CodeInternalError, "%v", err)}
+	}
+
+	raw, err := json.Marshal(result)
+	if err != nil {
+		return &Response{ID: req.ID, Error: Errorf(CodeInternalError, "failed to encode result: %v", err)}
+	}
+	return &Response{ID: req.ID, Result: raw}
+}
+
+// DecodeParams unmarshals params into target, reporting failures as invalid params
+func DecodeParams(params json.RawMessage, target any) error {
+	if len(params) == 0 {
+		return Errorf(CodeInvalidParams, "missing params")
+	}
+	if err := json.Unmarshal(params, target); err != nil {
+		return Errorf(CodeInvalidParams, "invalid params: %v", err)
+	}
+	return nil
+}
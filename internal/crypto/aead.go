@@ -0,0 +1,115 @@
+package crypto
+
+import (
+	"crypto/cipher"
+	"crypto/hkdf"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// CipherXChaCha20Poly1305 is the name of the payload cipher in device.hello
+const CipherXChaCha20Poly1305 = "xchacha20poly1305"
+
+// SessionNonceSize is the length in bytes of the nonces each side contributes
+// to the session key derivation
+const SessionNonceSize = 32
+
+// sessionKeyContext labels the HKDF expansion of the payload keys
+const sessionKeyContext = "eco-payload-v1"
+
+// ErrReplay is returned when a sealed message reuses or rewinds a counter
+var ErrReplay = errors.New("replayed or out of order message")
+
+// PayloadCipher seals message payloads for one connection with
+// XChaCha20-Poly1305.
+//
+// Each direction has its own key, derived with HKDF from the device's shared
+// secret and fresh nonces from both sides, so keys are never reused across
+// connections and a message can't be reflected back at its sender. Every
+// message carries a random 24-byte nonce and a counter that is bound into the
+// additional data; the receiver only accepts counters that strictly increase.
+type PayloadCipher struct {
+	mu          sync.Mutex
+	send        cipher.AEAD
+	recv        cipher.AEAD
+	sendCounter uint64
+	recvCounter uint64
+}
+
+// NewPayloadCipher derives the session keys for deviceID from its shared
+// secret and the nonces sent in device.hello. daemon selects which key is used
+// for sending, so both ends must agree on the nonces but pass opposite roles.
+func NewPayloadCipher(secret, deviceID string, deviceNonce, daemonNonce []byte, daemon bool) (*PayloadCipher, error) {
+	if len(deviceNonce) != SessionNonceSize || len(daemonNonce) != SessionNonceSize {
+		return nil, fmt.Errorf("session nonces must be %d bytes", SessionNonceSize)
+	}
+
+	salt := append(append([]byte{}, deviceNonce...), daemonNonce...)
+	keys, err := hkdf.Key(sha256.New, []byte(secret), salt, sessionKeyContext+deviceID, 2*chacha20poly1305.KeySize)
+	if err != nil {
+		return nil, err
+	}
+
+	toDaemon, err := chacha20poly1305.NewX(keys[:chacha20poly1305.KeySize])
+	if err != nil {
+		return nil, err
+	}
+	toDevice, err := chacha20poly1305.NewX(keys[chacha20poly1305.KeySize:])
+	if err != nil {
+		return nil, err
+	}
+
+	if daemon {
+		return &PayloadCipher{send: toDevice, recv: toDaemon}, nil
+	}
+	return &PayloadCipher{send: toDaemon, recv: toDevice}, nil
+}
+
+// Seal encrypts plaintext and authenticates it together with header.
+// It returns the counter and nonce the receiver needs to open it.
+func (c *PayloadCipher) Seal(header, plaintext []byte) (counter uint64, nonce, ciphertext []byte, err error) {
+	nonce, err = GenerateRandomBytes(chacha20poly1305.NonceSizeX)
+	if err != nil {
+		return 0, nil, nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.sendCounter++
+	counter = c.sendCounter
+	ciphertext = c.send.Seal(nil, nonce, plaintext, additionalData(header, counter))
+	return counter, nonce, ciphertext, nil
+}
+
+// Open authenticates and decrypts a sealed payload. Counters must strictly
+// increase; the counter only advances once the message has been verified.
+func (c *PayloadCipher) Open(header []byte, counter uint64, nonce, ciphertext []byte) ([]byte, error) {
+	if len(nonce) != chacha20poly1305.NonceSizeX {
+		return nil, fmt.Errorf("invalid nonce length: %d", len(nonce))
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if counter <= c.recvCounter {
+		return nil, ErrReplay
+	}
+	plaintext, err := c.recv.Open(nil, nonce, ciphertext, additionalData(header, counter))
+	if err != nil {
+		return nil, err
+	}
+	c.recvCounter = counter
+	return plaintext, nil
+}
+
+// additionalData is header || big-endian counter
+func additionalData(header []byte, counter uint64) []byte {
+	ad := append([]byte{}, header...)
+	return binary.BigEndian.AppendUint64(ad, counter)
+}
@@ -0,0 +1,155 @@
+package crypto
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+// cipherPair returns the daemon and device ends of one session
+func cipherPair(t *testing.T, secret string) (*PayloadCipher, *PayloadCipher) {
+	t.Helper()
+
+	deviceNonce, _ := GenerateRandomBytes(SessionNonceSize)
+	daemonNonce, _ := GenerateRandomBytes(SessionNonceSize)
+
+	daemon, err := NewPayloadCipher(secret, "mobile-1", deviceNonce, daemonNonce, true)
+	if err != nil {
+		t.Fatalf("NewPayloadCipher(daemon) error = %v", err)
+	}
+	device, err := NewPayloadCipher(secret, "mobile-1", deviceNonce, daemonNonce, false)
+	if err != nil {
+		t.Fatalf("NewPayloadCipher(device) error = %v", err)
+	}
+	return daemon, device
+}
+
+func TestPayloadCipherRoundTrip(t *testing.T) {
+	daemon, device := cipherPair(t, "secret")
+	header := []byte("clipboard.changed")
+
+	tests := []struct {
+		name string
+		from *PayloadCipher
+		to   *PayloadCipher
+	}{
+		{"daemon to device", daemon, device},
+		{"device to daemon", device, daemon},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plaintext := []byte(`{"data":"hello"}`)
+			counter, nonce, ciphertext, err := tt.from.Seal(header, plaintext)
+			if err != nil {
+				t.Fatalf("Seal() error = %v", err)
+			}
+			if bytes.Contains(ciphertext, []byte("hello")) {
+				t.Error("ciphertext contains the plaintext")
+			}
+
+			got, err := tt.to.Open(header, counter, nonce, ciphertext)
+			if err != nil {
+				t.Fatalf("Open() error = %v", err)
+			}
+			if !bytes.Equal(got, plaintext) {
+				t.Errorf("Open() = %s, want %s", got, plaintext)
+			}
+		})
+	}
+}
+
+func TestPayloadCipherRejects(t *testing.T) {
+	header := []byte("clipboard.changed")
+
+	tests := []struct {
+		name   string
+		tamper func(header []byte, counter uint64, nonce, ciphertext []byte) ([]byte, uint64, []byte, []byte)
+	}{
+		{"modified ciphertext", func(h []byte, c uint64, n, ct []byte) ([]byte, uint64, []byte, []byte) {
+			ct[0] ^= 1
+			return h, c, n, ct
+		}},
+		{"modified header", func(h []byte, c uint64, n, ct []byte) ([]byte, uint64, []byte, []byte) {
+			return []byte("clipboard.set"), c, n, ct
+		}},
+		{"modified counter", func(h []byte, c uint64, n, ct []byte) ([]byte, uint64, []byte, []byte) {
+			return h, c + 1, n, ct
+		}},
+		{"modified nonce", func(h []byte, c uint64, n, ct []byte) ([]byte, uint64, []byte, []byte) {
+			n[0] ^= 1
+			return h, c, n, ct
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			daemon, device := cipherPair(t, "secret")
+			counter, nonce, ciphertext, err := daemon.Seal(header, []byte("payload"))
+			if err != nil {
+				t.Fatalf("Seal() error = %v", err)
+			}
+
+			h, c, n, ct := tt.tamper(header, counter, bytes.Clone(nonce), bytes.Clone(ciphertext))
+			if _, err := device.Open(h, c, n, ct); err == nil {
+				t.Error("Open() accepted a tampered message")
+			}
+
+			// A rejected message must not advance the replay window
+			if _, err := device.Open(header, counter, nonce, ciphertext); err != nil {
+				t.Errorf("Open() of the original message error = %v", err)
+			}
+		})
+	}
+}
+
+func TestPayloadCipherReplay(t *testing.T) {
+	daemon, device := cipherPair(t, "secret")
+	header := []byte("notification.push")
+
+	c1, n1, ct1, _ := daemon.Seal(header, []byte("first"))
+	c2, n2, ct2, _ := daemon.Seal(header, []byte("second"))
+
+	if _, err := device.Open(header, c2, n2, ct2); err != nil {
+		t.Fatalf("Open(second) error = %v", err)
+	}
+	if _, err := device.Open(header, c2, n2, ct2); !errors.Is(err, ErrReplay) {
+		t.Errorf("Open(second) again error = %v, want %v", err, ErrReplay)
+	}
+	if _, err := device.Open(header, c1, n1, ct1); !errors.Is(err, ErrReplay) {
+		t.Errorf("Open(first) after second error = %v, want %v", err, ErrReplay)
+	}
+}
+
+func TestPayloadCipherKeys(t *testing.T) {
+	daemon, _ := cipherPair(t, "secret")
+	_, otherDevice := cipherPair(t, "secret")
+	_, wrongSecret := cipherPair(t, "other")
+	header := []byte("clipboard.changed")
+
+	counter, nonce, ciphertext, _ := daemon.Seal(header, []byte("payload"))
+
+	// Fresh nonces give every connection its own keys
+	if _, err := otherDevice.Open(header, counter, nonce, ciphertext); err == nil {
+		t.Error("Open() succeeded with keys from another session")
+	}
+	if _, err := wrongSecret.Open(header, counter, nonce, ciphertext); err == nil {
+		t.Error("Open() succeeded with a different secret")
+	}
+
+	// Each direction has its own key, so a message can't be reflected
+	counter, nonce, ciphertext, _ = daemon.Seal(header, []byte("payload"))
+	if _, err := daemon.Open(header, counter, nonce, ciphertext); err == nil {
+		t.Error("daemon opened its own message")
+	}
+}
+
+func TestNewPayloadCipherNonceSize(t *testing.T) {
+	good := make([]byte, SessionNonceSize)
+	if _, err := NewPayloadCipher("secret", "mobile-1", good[:8], good, true); err == nil {
+		t.Error("NewPayloadCipher() accepted a short device nonce")
+	}
+	if _, err := NewPayloadCipher("secret", "mobile-1", good, nil, true); err == nil {
+		t.Error("NewPayloadCipher() accepted a missing daemon nonce")
+	}
+}
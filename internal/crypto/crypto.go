@@ -4,39 +4,83 @@ import (
 	"crypto/rand"
 	"encoding/hex"
 	"fmt"
+	"math/big"
 )
 
-func GenerateRandomString(length int) (string, error) {
-	b := make([]byte, length-2)
+// SecretSize is the length in bytes of a device's shared secret
+const SecretSize = 32
+
+// PairingCodeDigits is the number of digits in a one-time pairing code
+const PairingCodeDigits = 6
+
+// GenerateRandomBytes returns n bytes from the system CSPRNG
+func GenerateRandomBytes(n int) ([]byte, error) {
+	if n <= 0 {
+		return nil, fmt.Errorf("invalid random length: %d", n)
+	}
 
+	b := make([]byte, n)
 	_, err := rand.Read(b)
+	if err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// GenerateRandomString returns a random hex string of exactly length characters
+func GenerateRandomString(length int) (string, error) {
+	if length <= 0 {
+		return "", fmt.Errorf("invalid random string length: %d", length)
+	}
+
+	b, err := GenerateRandomBytes((length + 1) / 2)
 	if err != nil {
 		return "", err
 	}
 
-	return hex.EncodeToString(b), nil
+	return hex.EncodeToString(b)[:length], nil
 }
 
 // GenerateDeviceID creates a unique device identifier
-// Example: "mobile-a1b2c3d4"
+// Example: "mobile-a1b2c3d4e5f6"
 func GenerateDeviceID() string {
-	str, err := GenerateRandomString(8)
+	str, err := GenerateRandomString(12)
 	if err != nil {
 		fmt.Println(err)
 	}
 	return "mobile-" + str
 }
 
-// GenerateSecret creates a 6-character random secret
+// GenerateSecret creates a random SecretSize-byte secret, hex encoded
 func GenerateSecret() (string, error) {
-	return GenerateRandomString(5)
+	b, err := GenerateRandomBytes(SecretSize)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
 }
 
-// ValidateSecret checks if a secret string is valid hex and 3 bytes when decoded
+// ValidateSecret checks if a secret string is valid hex and SecretSize bytes when decoded
 func ValidateSecret(secret string) bool {
 	decoded, err := hex.DecodeString(secret)
 	if err != nil {
 		return false
 	}
-	return len(decoded) == 3
+	return len(decoded) == SecretSize
+}
+
+// GeneratePairingCode creates a short one-time numeric code for pairing.
+// The code only has to resist online guessing: a pairing session accepts a
+// single attempt, and the long-term key is derived from the exchange, not the code.
+func GeneratePairingCode() (string, error) {
+	max := big.NewInt(1)
+	for i := 0; i < PairingCodeDigits; i++ {
+		max.Mul(max, big.NewInt(10))
+	}
+
+	n, err := rand.Int(rand.Reader, max)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%0*d", PairingCodeDigits, n), nil
 }
@@ -0,0 +1,85 @@
+package crypto
+
+import (
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+func TestGenerateRandomString(t *testing.T) {
+	for _, length := range []int{1, 2, 7, 8, 64} {
+		s, err := GenerateRandomString(length)
+		if err != nil {
+			t.Fatalf("GenerateRandomString(%d) error = %v", length, err)
+		}
+		if len(s) != length {
+			t.Errorf("GenerateRandomString(%d) length = %d", length, len(s))
+		}
+	}
+
+	for _, length := range []int{0, -1} {
+		if _, err := GenerateRandomString(length); err == nil {
+			t.Errorf("GenerateRandomString(%d) should error", length)
+		}
+	}
+}
+
+func TestGenerateDeviceID(t *testing.T) {
+	id := GenerateDeviceID()
+	if !strings.HasPrefix(id, "mobile-") || len(id) != len("mobile-")+12 {
+		t.Errorf("GenerateDeviceID() = %q, want mobile- followed by 12 hex characters", id)
+	}
+	if id == GenerateDeviceID() {
+		t.Error("GenerateDeviceID() returned the same ID twice")
+	}
+}
+
+func TestGenerateSecret(t *testing.T) {
+	secret, err := GenerateSecret()
+	if err != nil {
+		t.Fatalf("GenerateSecret() error = %v", err)
+	}
+	if !ValidateSecret(secret) {
+		t.Errorf("ValidateSecret(%q) = false for generated secret", secret)
+	}
+	decoded, _ := hex.DecodeString(secret)
+	if len(decoded) != SecretSize {
+		t.Errorf("GenerateSecret() decoded length = %d, want %d", len(decoded), SecretSize)
+	}
+}
+
+func TestValidateSecret(t *testing.T) {
+	tests := []struct {
+		name   string
+		secret string
+		want   bool
+	}{
+		{name: "Valid", secret: strings.Repeat("ab", SecretSize), want: true},
+		{name: "Too short", secret: "abcdef", want: false},
+		{name: "Not hex", secret: strings.Repeat("zz", SecretSize), want: false},
+		{name: "Empty", secret: "", want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ValidateSecret(tt.secret); got != tt.want {
+				t.Errorf("ValidateSecret() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGeneratePairingCode(t *testing.T) {
+	code, err := GeneratePairingCode()
+	if err != nil {
+		t.Fatalf("GeneratePairingCode() error = %v", err)
+	}
+	if len(code) != PairingCodeDigits {
+		t.Errorf("GeneratePairingCode() = %q, want %d digits", code, PairingCodeDigits)
+	}
+	for _, c := range code {
+		if c < '0' || c > '9' {
+			t.Errorf("GeneratePairingCode() = %q contains non-digit", code)
+		}
+	}
+}
@@ -0,0 +1,194 @@
+package crypto
+
+import (
+	"crypto/hkdf"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"filippo.io/edwards25519"
+)
+
+// SPAKE2 implements the SPAKE2 password-authenticated key exchange
+// (RFC 9382) over edwards25519 with SHA-256, HKDF and HMAC.
+//
+// Both sides start from the same short pairing code. An eavesdropper learns
+// nothing that lets them test code guesses offline, and an active attacker
+// gets exactly one guess per exchange.
+type SPAKE2 struct {
+	initiator bool
+	idA, idB  []byte
+	w         *edwards25519.Scalar
+	x         *edwards25519.Scalar
+	msg       []byte
+}
+
+// SPAKE2Result holds the keys agreed by a finished exchange
+type SPAKE2Result struct {
+	// SharedKey is Ke from RFC 9382; use DeriveKey to expand it
+	SharedKey []byte
+	// Confirmation is the MAC this side sends to prove it derived the same key
+	Confirmation []byte
+
+	peerConfirmation []byte
+}
+
+// ErrSPAKE2Confirmation is returned when the peer used a different code
+var ErrSPAKE2Confirmation = errors.New("spake2: key confirmation failed")
+
+// M and N are the SPAKE2 blinding points. They are derived by hashing fixed
+// strings to the curve so nobody knows their discrete logarithm.
+var (
+	spake2M = hashToPoint("eco SPAKE2 M")
+	spake2N = hashToPoint("eco SPAKE2 N")
+)
+
+// NewSPAKE2A starts the exchange as the initiator (the device)
+func NewSPAKE2A(password, idA, idB []byte) (*SPAKE2, error) {
+	return newSPAKE2(true, password, idA, idB)
+}
+
+// NewSPAKE2B starts the exchange as the responder (the daemon)
+func NewSPAKE2B(password, idA, idB []byte) (*SPAKE2, error) {
+	return newSPAKE2(false, password, idA, idB)
+}
+
+func newSPAKE2(initiator bool, password, idA, idB []byte) (*SPAKE2, error) {
+	w, err := passwordScalar(password)
+	if err != nil {
+		return nil, err
+	}
+
+	seed, err := GenerateRandomBytes(64)
+	if err != nil {
+		return nil, err
+	}
+	x, err := edwards25519.NewScalar().SetUniformBytes(seed)
+	if err != nil {
+		return nil, err
+	}
+
+	// pA = x*G + w*M, pB = y*G + w*N
+	blind := spake2N
+	if initiator {
+		blind = spake2M
+	}
+	public := new(edwards25519.Point).ScalarBaseMult(x)
+	public.Add(public, new(edwards25519.Point).ScalarMult(w, blind))
+
+	return &SPAKE2{
+		initiator: initiator,
+		idA:       idA,
+		idB:       idB,
+		w:         w,
+		x:         x,
+		msg:       public.Bytes(),
+	}, nil
+}
+
+// Message returns the public share to send to the peer
+func (s *SPAKE2) Message() []byte {
+	return s.msg
+}
+
+// Finish combines the peer's public share with ours
+func (s *SPAKE2) Finish(peerMsg []byte) (*SPAKE2Result, error) {
+	peer, err := new(edwards25519.Point).SetBytes(peerMsg)
+	if err != nil {
+		return nil, fmt.Errorf("spake2: invalid peer message: %w", err)
+	}
+
+	// Remove the peer's blinding and multiply by our secret scalar and the cofactor:
+	// K = h*x*(pB - w*N) for A, K = h*y*(pA - w*M) for B
+	blind := spake2M
+	if s.initiator {
+		blind = spake2N
+	}
+	unblinded := new(edwards25519.Point).Subtract(peer, new(edwards25519.Point).ScalarMult(s.w, blind))
+	k := new(edwards25519.Point).ScalarMult(s.x, unblinded)
+	k.MultByCofactor(k)
+	if k.Equal(edwards25519.NewIdentityPoint()) == 1 {
+		return nil, errors.New("spake2: degenerate shared point")
+	}
+
+	pA, pB := s.msg, peerMsg
+	if !s.initiator {
+		pA, pB = peerMsg, s.msg
+	}
+	tt := transcript(s.idA, s.idB, pA, pB, k.Bytes(), s.w.Bytes())
+
+	hash := sha256.Sum256(tt)
+	ke, ka := hash[:16], hash[16:]
+
+	confirmKeys, err := hkdf.Key(sha256.New, ka, nil, "ConfirmationKeys", 64)
+	if err != nil {
+		return nil, err
+	}
+	kcA, kcB := confirmKeys[:32], confirmKeys[32:]
+
+	cA, cB := mac(kcA, tt), mac(kcB, tt)
+	result := &SPAKE2Result{SharedKey: ke}
+	if s.initiator {
+		result.Confirmation, result.peerConfirmation = cA, cB
+	} else {
+		result.Confirmation, result.peerConfirmation = cB, cA
+	}
+	return result, nil
+}
+
+// VerifyPeer checks the peer's confirmation MAC in constant time
+func (r *SPAKE2Result) VerifyPeer(confirmation []byte) error {
+	if !hmac.Equal(confirmation, r.peerConfirmation) {
+		return ErrSPAKE2Confirmation
+	}
+	return nil
+}
+
+// DeriveKey expands the shared key into a SecretSize-byte key for purpose
+func (r *SPAKE2Result) DeriveKey(purpose string) ([]byte, error) {
+	return hkdf.Key(sha256.New, r.SharedKey, nil, purpose, SecretSize)
+}
+
+// passwordScalar maps the pairing code to a scalar
+func passwordScalar(password []byte) (*edwards25519.Scalar, error) {
+	digest := sha512.Sum512(append([]byte("eco SPAKE2 password"), password...))
+	return edwards25519.NewScalar().SetUniformBytes(digest[:])
+}
+
+// hashToPoint derives a prime-order point from seed by try-and-increment
+func hashToPoint(seed string) *edwards25519.Point {
+	for counter := uint32(0); ; counter++ {
+		var buf [4]byte
+		binary.BigEndian.PutUint32(buf[:], counter)
+		digest := sha256.Sum256(append([]byte(seed), buf[:]...))
+
+		p, err := new(edwards25519.Point).SetBytes(digest[:])
+		if err != nil {
+			continue
+		}
+		p.MultByCofactor(p)
+		if p.Equal(edwards25519.NewIdentityPoint()) == 1 {
+			continue
+		}
+		return p
+	}
+}
+
+// transcript builds TT: every field prefixed with its 8-byte little-endian length
+func transcript(fields ...[]byte) []byte {
+	var tt []byte
+	for _, f := range fields {
+		tt = binary.LittleEndian.AppendUint64(tt, uint64(len(f)))
+		tt = append(tt, f...)
+	}
+	return tt
+}
+
+func mac(key, data []byte) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write(data)
+	return h.Sum(nil)
+}
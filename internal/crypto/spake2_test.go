@@ -0,0 +1,100 @@
+package crypto
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+var (
+	testIDA = []byte("eco-device")
+	testIDB = []byte("eco-daemon")
+)
+
+// exchange runs both sides of SPAKE2 and returns their results
+func exchange(t *testing.T, codeA, codeB string) (*SPAKE2Result, *SPAKE2Result) {
+	t.Helper()
+
+	a, err := NewSPAKE2A([]byte(codeA), testIDA, testIDB)
+	if err != nil {
+		t.Fatalf("NewSPAKE2A() error = %v", err)
+	}
+	b, err := NewSPAKE2B([]byte(codeB), testIDA, testIDB)
+	if err != nil {
+		t.Fatalf("NewSPAKE2B() error = %v", err)
+	}
+
+	resultA, err := a.Finish(b.Message())
+	if err != nil {
+		t.Fatalf("A Finish() error = %v", err)
+	}
+	resultB, err := b.Finish(a.Message())
+	if err != nil {
+		t.Fatalf("B Finish() error = %v", err)
+	}
+	return resultA, resultB
+}
+
+func TestSPAKE2SameCode(t *testing.T) {
+	resultA, resultB := exchange(t, "482913", "482913")
+
+	if !bytes.Equal(resultA.SharedKey, resultB.SharedKey) {
+		t.Fatal("shared keys differ for the same code")
+	}
+	if err := resultA.VerifyPeer(resultB.Confirmation); err != nil {
+		t.Errorf("A VerifyPeer() error = %v", err)
+	}
+	if err := resultB.VerifyPeer(resultA.Confirmation); err != nil {
+		t.Errorf("B VerifyPeer() error = %v", err)
+	}
+
+	keyA, err := resultA.DeriveKey("eco device key")
+	if err != nil {
+		t.Fatalf("DeriveKey() error = %v", err)
+	}
+	keyB, _ := resultB.DeriveKey("eco device key")
+	if !bytes.Equal(keyA, keyB) {
+		t.Error("derived device keys differ")
+	}
+	if len(keyA) != SecretSize {
+		t.Errorf("derived key length = %d, want %d", len(keyA), SecretSize)
+	}
+
+	other, _ := resultA.DeriveKey("another purpose")
+	if bytes.Equal(keyA, other) {
+		t.Error("keys for different purposes must differ")
+	}
+}
+
+func TestSPAKE2DifferentCode(t *testing.T) {
+	resultA, resultB := exchange(t, "482913", "482914")
+
+	if bytes.Equal(resultA.SharedKey, resultB.SharedKey) {
+		t.Fatal("shared keys match for different codes")
+	}
+	if err := resultB.VerifyPeer(resultA.Confirmation); !errors.Is(err, ErrSPAKE2Confirmation) {
+		t.Errorf("B VerifyPeer() error = %v, want ErrSPAKE2Confirmation", err)
+	}
+	if err := resultA.VerifyPeer(resultB.Confirmation); !errors.Is(err, ErrSPAKE2Confirmation) {
+		t.Errorf("A VerifyPeer() error = %v, want ErrSPAKE2Confirmation", err)
+	}
+}
+
+func TestSPAKE2FreshKeysPerExchange(t *testing.T) {
+	first, _ := exchange(t, "000000", "000000")
+	second, _ := exchange(t, "000000", "000000")
+
+	if bytes.Equal(first.SharedKey, second.SharedKey) {
+		t.Error("two exchanges with the same code produced the same key")
+	}
+}
+
+func TestSPAKE2InvalidMessage(t *testing.T) {
+	a, err := NewSPAKE2A([]byte("123456"), testIDA, testIDB)
+	if err != nil {
+		t.Fatalf("NewSPAKE2A() error = %v", err)
+	}
+	if _, err := a.Finish([]byte("short")); err == nil {
+		t.Error("Finish() should reject a malformed peer message")
+	}
+}
@@ -2,14 +2,39 @@ package device
 
 import (
 	"eco/internal/protocol"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"slices"
 	"sync"
 	"time"
 
 	"github.com/gorilla/websocket"
 )
 
+// maxMessageSize limits messages read from a device. It fits a clipboard
+// image of the inline limit after base64, sealing and base64 again.
+const maxMessageSize = 2 << 20
+
+// ErrRequestTimeout is returned by Request when the device doesn't answer in time
+var ErrRequestTimeout = errors.New("device did not respond in time")
+
+// ErrConnectionClosed is returned by Request when the connection goes away
+// before the device answers
+var ErrConnectionClosed = errors.New("connection closed")
+
+// RejectedError is returned by Request when the device answers with a
+// negative ack
+type RejectedError struct {
+	Type   protocol.MessageType
+	Reason string
+}
+
+func (e *RejectedError) Error() string {
+	return fmt.Sprintf("device rejected %s: %s", e.Type, e.Reason)
+}
+
 // Connection represents a single WebSocket connection to the mobile device
 type Connection struct {
 	deviceID  string
@@ -19,6 +44,15 @@ type Connection struct {
 	stopOnce  sync.Once
 	connected bool
 	handler   func(*protocol.Message)
+	cipher    protocol.Sealer
+	caps      []string
+
+	// sendSeq is only touched by writePump and recvSeq only by readPump
+	sendSeq uint64
+	recvSeq uint64
+
+	mu      sync.Mutex
+	pending map[string]chan *protocol.Message
 }
 
 // NewConnection creates a new device connection
@@ -28,6 +62,7 @@ func NewConnection(deviceID string, conn *websocket.Conn) *Connection {
 		conn:     conn,
 		send:     make(chan *protocol.Message, 256),
 		stop:     make(chan struct{}),
+		pending:  make(map[string]chan *protocol.Message),
 	}
 }
 
@@ -35,16 +70,40 @@ func (c *Connection) IsConnected() bool {
 	return c.connected
 }
 
+// SetCipher enables payload encryption for every message after the
+// device.hello exchange. It must be called before Start.
+func (c *Connection) SetCipher(cipher protocol.Sealer) {
+	c.cipher = cipher
+}
+
+// IsEncrypted returns true if payloads are sealed on this connection
+func (c *Connection) IsEncrypted() bool {
+	return c.cipher != nil
+}
+
+// SetCapabilities sets the capabilities negotiated in device.hello, i.e. the
+// ones both the device and the daemon support. It must be called before Start.
+func (c *Connection) SetCapabilities(caps []string) {
+	c.caps = caps
+}
+
+// Capabilities returns the capabilities negotiated for this connection
+func (c *Connection) Capabilities() []string {
+	return c.caps
+}
+
+// Supports reports whether capability was negotiated. Messages that belong
+// to no capability ("") are always supported.
+func (c *Connection) Supports(capability string) bool {
+	return capability == "" || slices.Contains(c.caps, capability)
+}
+
 // Start begins the read and write pumps
 func (c *Connection) Start() {
 	c.connected = true
 
 	go c.readPump()
 	go c.writePump()
-
-	c.Send(&protocol.Message{
-		Type: protocol.MessageTypeDeviceHello,
-	})
 }
 
 // Stop gracefully closes the connection
@@ -71,6 +130,82 @@ func (c *Connection) Send(msg *protocol.Message) error {
 	}
 }
 
+// Request sends msg and waits for the device's response, i.e. the first
+// message whose reply_to is msg.ID. A negative ack is returned as an error.
+func (c *Connection) Request(msg *protocol.Message, timeout time.Duration) (*protocol.Message, error) {
+	if msg.ID == "" {
+		return nil, fmt.Errorf("request %s has no id", msg.Type)
+	}
+
+	reply := make(chan *protocol.Message, 1)
+	c.mu.Lock()
+	c.pending[msg.ID] = reply
+	c.mu.Unlock()
+	defer func() {
+		c.mu.Lock()
+		delete(c.pending, msg.ID)
+		c.mu.Unlock()
+	}()
+
+	if err := c.Send(msg); err != nil {
+		return nil, err
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case resp := <-reply:
+		if resp.Type == protocol.MessageTypeAck {
+			var ack protocol.AckPayload
+			if err := json.Unmarshal(resp.Payload, &ack); err != nil {
+				return nil, fmt.Errorf("invalid ack: %w", err)
+			}
+			if !ack.OK {
+				return nil, &RejectedError{Type: msg.Type, Reason: ack.Error}
+			}
+		}
+		return resp, nil
+	case <-timer.C:
+		return nil, ErrRequestTimeout
+	case <-c.stop:
+		return nil, ErrConnectionClosed
+	}
+}
+
+// resolve hands a reply to the Request waiting for it. It returns false if
+// nobody is waiting, e.g. because the request already timed out.
+func (c *Connection) resolve(msg *protocol.Message) bool {
+	c.mu.Lock()
+	reply, ok := c.pending[msg.ReplyTo]
+	delete(c.pending, msg.ReplyTo)
+	c.mu.Unlock()
+
+	if ok {
+		reply <- msg
+	}
+	return ok
+}
+
+// checkSeq drops messages that were already received on this connection.
+// Messages without a seq come from older clients and are always accepted.
+func (c *Connection) checkSeq(msg *protocol.Message) bool {
+	if msg.Seq == 0 {
+		return true
+	}
+	if msg.Seq <= c.recvSeq {
+		log.Printf("Connection: Dropping duplicate %s (seq %d) from %s", msg.Type, msg.Seq, c.deviceID)
+		return false
+	}
+	// The handshake messages are read before the pumps start, so the first
+	// seq seen here is usually above 1
+	if c.recvSeq != 0 && msg.Seq != c.recvSeq+1 {
+		log.Printf("Connection: Missed %d messages from %s before seq %d", msg.Seq-c.recvSeq-1, c.deviceID, msg.Seq)
+	}
+	c.recvSeq = msg.Seq
+	return true
+}
+
 // readPump reads messages from the WebSocket connection
 func (c *Connection) readPump() {
 	defer func() {
@@ -80,7 +215,7 @@ func (c *Connection) readPump() {
 		c.conn.Close()
 	}()
 
-	c.conn.SetReadLimit(5120)
+	c.conn.SetReadLimit(maxMessageSize)
 	c.conn.SetReadDeadline(time.Now().Add(60 * time.Second))
 	c.conn.SetPongHandler(func(string) error {
 		c.conn.SetReadDeadline(time.Now().Add(60 * time.Second))
@@ -99,6 +234,35 @@ func (c *Connection) readPump() {
 			continue
 		}
 
+		// The session belongs to the device that completed the handshake
+		if msg.DeviceID != "" && msg.DeviceID != c.deviceID {
+			log.Printf("Connection: Dropping message claiming device %s on session of %s", msg.DeviceID, c.deviceID)
+			continue
+		}
+
+		if c.cipher != nil {
+			opened, err := msg.Open(c.cipher)
+			if err != nil {
+				log.Printf("Connection: Dropping %s from %s: %v", msg.Type, c.deviceID, err)
+				continue
+			}
+			msg = opened
+		} else if msg.IsSealed() {
+			log.Printf("Connection: Dropping sealed %s from %s on a plain text session", msg.Type, c.deviceID)
+			continue
+		}
+
+		if !c.checkSeq(msg) {
+			continue
+		}
+
+		if msg.IsReply() {
+			if !c.resolve(msg) && msg.Type != protocol.MessageTypeAck {
+				log.Printf("Connection: Dropping late %s from %s", msg.Type, c.deviceID)
+			}
+			continue
+		}
+
 		if c.handler != nil {
 			c.handler(msg)
 		}
@@ -116,6 +280,19 @@ func (c *Connection) writePump() {
 
 		case msg := <-c.send:
 			//outgoing msg
+			// Seq and sealing happen here so they go out in the order they were assigned
+			c.sendSeq++
+			stamped := *msg
+			stamped.Seq = c.sendSeq
+			msg = &stamped
+			if c.cipher != nil {
+				sealed, err := msg.Seal(c.cipher)
+				if err != nil {
+					log.Printf("Connection: Failed to seal %s for %s: %v", msg.Type, c.deviceID, err)
+					continue
+				}
+				msg = sealed
+			}
 			if err := c.conn.WriteJSON(msg); err != nil {
 				c.conn.Close()
 				return
@@ -136,6 +313,16 @@ func (c *Connection) writePump() {
 	}
 }
 
+// QueueLen returns the number of messages waiting to be written
+func (c *Connection) QueueLen() int {
+	return len(c.send)
+}
+
+// Done returns a channel that is closed when the connection shuts down
+func (c *Connection) Done() <-chan struct{} {
+	return c.stop
+}
+
 // GetDeviceID returns the connected device's ID
 func (c *Connection) GetDeviceID() string {
 	return c.deviceID
@@ -0,0 +1,149 @@
+package device
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"eco/internal/protocol"
+
+	"github.com/gorilla/websocket"
+)
+
+// connect starts a Connection on the server end of a WebSocket and returns it
+// with the client end, which plays the device
+func connect(t *testing.T) (*Connection, *websocket.Conn) {
+	t.Helper()
+
+	conns := make(chan *Connection, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+		if err != nil {
+			t.Errorf("Upgrade() error = %v", err)
+			return
+		}
+		conns <- NewConnection("mobile-1", ws)
+	}))
+	t.Cleanup(srv.Close)
+
+	device, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	t.Cleanup(func() { device.Close() })
+
+	conn := <-conns
+	t.Cleanup(conn.Stop)
+	return conn, device
+}
+
+func readMessage(t *testing.T, ws *websocket.Conn) *protocol.Message {
+	t.Helper()
+
+	ws.SetReadDeadline(time.Now().Add(2 * time.Second))
+	var msg protocol.Message
+	if err := ws.ReadJSON(&msg); err != nil {
+		t.Fatalf("ReadJSON() error = %v", err)
+	}
+	return &msg
+}
+
+func TestConnectionAssignsSeq(t *testing.T) {
+	conn, device := connect(t)
+	conn.Start()
+
+	for i := 0; i < 3; i++ {
+		msg, _ := protocol.NewMessage(protocol.MessageTypeDevicePing, "mobile-1", nil)
+		if err := conn.Send(msg); err != nil {
+			t.Fatalf("Send() error = %v", err)
+		}
+	}
+	for want := uint64(1); want <= 3; want++ {
+		if got := readMessage(t, device).Seq; got != want {
+			t.Errorf("Seq = %d, want %d", got, want)
+		}
+	}
+}
+
+func TestConnectionRequest(t *testing.T) {
+	conn, device := connect(t)
+	conn.Start()
+
+	go func() {
+		req := readMessage(t, device)
+		reply, _ := protocol.NewReply(req, protocol.MessageTypeClipboardContent, &protocol.ClipboardPayload{Data: "phone"})
+		device.WriteJSON(reply)
+	}()
+
+	req, _ := protocol.NewMessage(protocol.MessageTypeClipboardGet, "mobile-1", nil)
+	resp, err := conn.Request(req, 2*time.Second)
+	if err != nil {
+		t.Fatalf("Request() error = %v", err)
+	}
+	if resp.Type != protocol.MessageTypeClipboardContent || resp.ReplyTo != req.ID {
+		t.Errorf("Request() = %s reply_to %s, want %s reply_to %s", resp.Type, resp.ReplyTo, protocol.MessageTypeClipboardContent, req.ID)
+	}
+}
+
+func TestConnectionRequestRejected(t *testing.T) {
+	conn, device := connect(t)
+	conn.Start()
+
+	go func() {
+		req := readMessage(t, device)
+		ack, _ := protocol.NewAck(req, errors.New("screen locked"))
+		device.WriteJSON(ack)
+	}()
+
+	req, _ := protocol.NewMessage(protocol.MessageTypeClipboardGet, "mobile-1", nil)
+	_, err := conn.Request(req, 2*time.Second)
+	var rejected *RejectedError
+	if !errors.As(err, &rejected) || rejected.Reason != "screen locked" {
+		t.Errorf("Request() error = %v, want the device's rejection", err)
+	}
+}
+
+func TestConnectionRequestTimeout(t *testing.T) {
+	conn, _ := connect(t)
+	conn.Start()
+
+	req, _ := protocol.NewMessage(protocol.MessageTypeClipboardGet, "mobile-1", nil)
+	if _, err := conn.Request(req, 50*time.Millisecond); !errors.Is(err, ErrRequestTimeout) {
+		t.Errorf("Request() error = %v, want %v", err, ErrRequestTimeout)
+	}
+}
+
+func TestConnectionDropsDuplicateSeq(t *testing.T) {
+	conn, device := connect(t)
+
+	received := make(chan *protocol.Message, 4)
+	conn.SetHandler(func(msg *protocol.Message) { received <- msg })
+	conn.Start()
+
+	for _, seq := range []uint64{1, 2, 2, 1, 3} {
+		msg, _ := protocol.NewMessage(protocol.MessageTypeDevicePing, "mobile-1", nil)
+		msg.Seq = seq
+		if err := device.WriteJSON(msg); err != nil {
+			t.Fatalf("WriteJSON() error = %v", err)
+		}
+	}
+
+	for _, want := range []uint64{1, 2, 3} {
+		select {
+		case msg := <-received:
+			if msg.Seq != want {
+				t.Errorf("handled seq %d, want %d", msg.Seq, want)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("timed out waiting for seq %d", want)
+		}
+	}
+	select {
+	case msg := <-received:
+		t.Errorf("handled unexpected seq %d", msg.Seq)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
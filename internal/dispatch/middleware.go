@@ -0,0 +1,183 @@
+package dispatch
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"sync"
+	"time"
+
+	"eco/internal/protocol"
+)
+
+// ErrRateLimited is returned when a device sends messages faster than allowed
+var ErrRateLimited = errors.New("rate limit exceeded")
+
+// ErrPermissionDenied is returned when a device may not send a message type
+var ErrPermissionDenied = errors.New("permission denied")
+
+// Logging logs every request and its outcome
+func Logging() Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(req *Request) error {
+			start := time.Now()
+			err := next(req)
+			if err != nil {
+				log.Printf("Dispatch: %s from %s failed after %s: %v", req.Message.Type, req.DeviceID, time.Since(start), err)
+			} else {
+				log.Printf("Dispatch: %s from %s handled in %s", req.Message.Type, req.DeviceID, time.Since(start))
+			}
+			return err
+		}
+	}
+}
+
+// Recover turns a panicking handler into an error so one bad message can't
+// take the daemon down
+func Recover() Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(req *Request) (err error) {
+			defer func() {
+				if p := recover(); p != nil {
+					err = fmt.Errorf("handler for %s panicked: %v", req.Message.Type, p)
+				}
+			}()
+			return next(req)
+		}
+	}
+}
+
+// Stats counts the requests of one message type
+type Stats struct {
+	Handled  int64         `json:"handled"`
+	Failed   int64         `json:"failed"`
+	Duration time.Duration `json:"duration_ns"`
+}
+
+// Metrics counts requests and time spent per message type
+type Metrics struct {
+	mu    sync.Mutex
+	stats map[protocol.MessageType]Stats
+}
+
+// NewMetrics creates an empty Metrics
+func NewMetrics() *Metrics {
+	return &Metrics{stats: make(map[protocol.MessageType]Stats)}
+}
+
+// Middleware records every request in m
+func (m *Metrics) Middleware() Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(req *Request) error {
+			start := time.Now()
+			err := next(req)
+
+			m.mu.Lock()
+			defer m.mu.Unlock()
+			s := m.stats[req.Message.Type]
+			s.Handled++
+			if err != nil {
+				s.Failed++
+			}
+			s.Duration += time.Since(start)
+			m.stats[req.Message.Type] = s
+			return err
+		}
+	}
+}
+
+// Snapshot returns a copy of the counters
+func (m *Metrics) Snapshot() map[protocol.MessageType]Stats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	snapshot := make(map[protocol.MessageType]Stats, len(m.stats))
+	for t, s := range m.stats {
+		snapshot[t] = s
+	}
+	return snapshot
+}
+
+// RateLimit allows each device rate messages per second on average, with
+// bursts of up to burst messages
+func RateLimit(rate float64, burst int) Middleware {
+	return rateLimit(rate, burst, time.Now)
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func rateLimit(rate float64, burst int, now func() time.Time) Middleware {
+	var mu sync.Mutex
+	buckets := make(map[string]*bucket)
+
+	allow := func(deviceID string) bool {
+		mu.Lock()
+		defer mu.Unlock()
+
+		t := now()
+		b, ok := buckets[deviceID]
+		if !ok {
+			b = &bucket{tokens: float64(burst), last: t}
+			buckets[deviceID] = b
+		}
+		b.tokens = min(float64(burst), b.tokens+t.Sub(b.last).Seconds()*rate)
+		b.last = t
+		if b.tokens < 1 {
+			return false
+		}
+		b.tokens--
+		return true
+	}
+
+	return func(next HandlerFunc) HandlerFunc {
+		return func(req *Request) error {
+			if !allow(req.DeviceID) {
+				return fmt.Errorf("%w for %s", ErrRateLimited, req.DeviceID)
+			}
+			return next(req)
+		}
+	}
+}
+
+// Permission rejects requests for which allowed returns false
+func Permission(allowed func(req *Request) bool) Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(req *Request) error {
+			if !allowed(req) {
+				return fmt.Errorf("%w: %s may not send %s", ErrPermissionDenied, req.DeviceID, req.Message.Type)
+			}
+			return next(req)
+		}
+	}
+}
+
+// When applies mw only to the requests match returns true for
+func When(match func(req *Request) bool, mw Middleware) Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		wrapped := mw(next)
+		return func(req *Request) error {
+			if match(req) {
+				return wrapped(req)
+			}
+			return next(req)
+		}
+	}
+}
+
+// RequireCapability rejects messages of a capability that wasn't negotiated
+// for the device's session
+func RequireCapability() Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(req *Request) error {
+			capability := req.Message.Type.Capability()
+			if capability != "" && !slices.Contains(req.Capabilities, capability) {
+				return fmt.Errorf("%w: %s was not negotiated for this session", ErrPermissionDenied, capability)
+			}
+			return next(req)
+		}
+	}
+}
@@ -0,0 +1,162 @@
+package dispatch
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+
+	"eco/internal/protocol"
+)
+
+// ErrUnknownType is returned by Dispatch for message types without a handler
+var ErrUnknownType = errors.New("unsupported message type")
+
+// ErrInvalidPayload wraps payload decoding and validation errors
+var ErrInvalidPayload = errors.New("invalid payload")
+
+// Request is a message from a device being handled
+type Request struct {
+	DeviceID string
+	Message  *protocol.Message
+	// Capabilities are the ones negotiated for the device's session
+	Capabilities []string
+
+	send    func(*protocol.Message) error
+	replied bool
+}
+
+// NewRequest creates a Request for msg from deviceID. send delivers replies
+// to the device and may be nil when the request can't be answered.
+func NewRequest(deviceID string, msg *protocol.Message, capabilities []string, send func(*protocol.Message) error) *Request {
+	return &Request{
+		DeviceID:     deviceID,
+		Message:      msg,
+		Capabilities: capabilities,
+		send:         send,
+	}
+}
+
+// Reply answers the request with a message of the given type. The router
+// doesn't ack requests that were replied to.
+func (r *Request) Reply(msgType protocol.MessageType, payload any) error {
+	if r.send == nil {
+		return fmt.Errorf("cannot reply to %s", r.Message.Type)
+	}
+	reply, err := protocol.NewReply(r.Message, msgType, payload)
+	if err != nil {
+		return err
+	}
+	if err := r.send(reply); err != nil {
+		return err
+	}
+	r.replied = true
+	return nil
+}
+
+// Replied reports whether a handler answered the request with Reply
+func (r *Request) Replied() bool {
+	return r.replied
+}
+
+// HandlerFunc handles one request
+type HandlerFunc func(req *Request) error
+
+// Middleware wraps a handler, e.g. to log, measure or reject requests
+type Middleware func(next HandlerFunc) HandlerFunc
+
+// Validator is implemented by payloads that can check their own fields
+type Validator interface {
+	Validate() error
+}
+
+// Registry maps message types to handlers. Every handler runs inside the
+// middleware added with Use, the first one outermost.
+type Registry struct {
+	mu         sync.RWMutex
+	handlers   map[protocol.MessageType]HandlerFunc
+	middleware []Middleware
+}
+
+// NewRegistry creates an empty registry
+func NewRegistry() *Registry {
+	return &Registry{
+		handlers: make(map[protocol.MessageType]HandlerFunc),
+	}
+}
+
+// Use appends middleware that wraps every handler
+func (r *Registry) Use(mw ...Middleware) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.middleware = append(r.middleware, mw...)
+}
+
+// HandleFunc registers h for msgType, replacing any earlier handler.
+// h receives the raw message; see Handle for decoded payloads.
+func (r *Registry) HandleFunc(msgType protocol.MessageType, h HandlerFunc) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.handlers[msgType] = h
+}
+
+// Handle registers h for msgType with its payload decoded into a T. When
+// *T implements Validator the payload is validated before h runs.
+func Handle[T any](r *Registry, msgType protocol.MessageType, h func(req *Request, payload *T) error) {
+	r.HandleFunc(msgType, func(req *Request) error {
+		payload, err := Decode[T](req.Message)
+		if err != nil {
+			return err
+		}
+		return h(req, payload)
+	})
+}
+
+// Decode unmarshals and validates the payload of msg. A missing payload
+// decodes to the zero value.
+func Decode[T any](msg *protocol.Message) (*T, error) {
+	payload := new(T)
+	if len(msg.Payload) > 0 {
+		if err := json.Unmarshal(msg.Payload, payload); err != nil {
+			return nil, fmt.Errorf("%w for %s: %v", ErrInvalidPayload, msg.Type, err)
+		}
+	}
+	if v, ok := any(payload).(Validator); ok {
+		if err := v.Validate(); err != nil {
+			return nil, fmt.Errorf("%w for %s: %v", ErrInvalidPayload, msg.Type, err)
+		}
+	}
+	return payload, nil
+}
+
+// Dispatch runs the handler for the request's message type
+func (r *Registry) Dispatch(req *Request) error {
+	r.mu.RLock()
+	h, ok := r.handlers[req.Message.Type]
+	middleware := r.middleware
+	r.mu.RUnlock()
+
+	if !ok {
+		h = func(req *Request) error {
+			return fmt.Errorf("%w: %s", ErrUnknownType, req.Message.Type)
+		}
+	}
+	for i := len(middleware) - 1; i >= 0; i-- {
+		h = middleware[i](h)
+	}
+	return h(req)
+}
+
+// Types returns the registered message types in sorted order
+func (r *Registry) Types() []protocol.MessageType {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	types := make([]protocol.MessageType, 0, len(r.handlers))
+	for t := range r.handlers {
+		types = append(types, t)
+	}
+	sort.Slice(types, func(i, j int) bool { return types[i] < types[j] })
+	return types
+}
@@ -0,0 +1,211 @@
+package dispatch
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"eco/internal/protocol"
+)
+
+func request(t *testing.T, msgType protocol.MessageType, payload any, caps ...string) *Request {
+	t.Helper()
+
+	msg, err := protocol.NewMessage(msgType, "mobile-1", payload)
+	if err != nil {
+		t.Fatalf("NewMessage() error = %v", err)
+	}
+	return NewRequest("mobile-1", msg, caps, nil)
+}
+
+func TestHandleDecodesPayload(t *testing.T) {
+	r := NewRegistry()
+	var got string
+	Handle(r, protocol.MessageTypeClipboardSet, func(req *Request, p *protocol.ClipboardPayload) error {
+		got = p.Data
+		return nil
+	})
+
+	if err := r.Dispatch(request(t, protocol.MessageTypeClipboardSet, &protocol.ClipboardPayload{Data: "hello"})); err != nil {
+		t.Fatalf("Dispatch() error = %v", err)
+	}
+	if got != "hello" {
+		t.Errorf("handler got %q, want %q", got, "hello")
+	}
+}
+
+func TestHandleRejectsInvalidPayload(t *testing.T) {
+	tests := []struct {
+		name    string
+		payload string
+	}{
+		{"Malformed JSON", `{"title":`},
+		{"Wrong type", `{"title":42}`},
+		{"Fails validation", `{"app":"x","title":"","body":""}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := NewRegistry()
+			called := false
+			Handle(r, protocol.MessageTypeNotificationPush, func(req *Request, p *protocol.NotificationPayload) error {
+				called = true
+				return nil
+			})
+
+			req := request(t, protocol.MessageTypeNotificationPush, nil)
+			req.Message.Payload = []byte(tt.payload)
+			if err := r.Dispatch(req); !errors.Is(err, ErrInvalidPayload) {
+				t.Errorf("Dispatch() error = %v, want %v", err, ErrInvalidPayload)
+			}
+			if called {
+				t.Error("handler was called with an invalid payload")
+			}
+		})
+	}
+}
+
+func TestDispatchUnknownType(t *testing.T) {
+	r := NewRegistry()
+	if err := r.Dispatch(request(t, "bogus.type", nil)); !errors.Is(err, ErrUnknownType) {
+		t.Errorf("Dispatch() error = %v, want %v", err, ErrUnknownType)
+	}
+}
+
+func TestMiddlewareOrder(t *testing.T) {
+	r := NewRegistry()
+	var order []string
+	trace := func(name string) Middleware {
+		return func(next HandlerFunc) HandlerFunc {
+			return func(req *Request) error {
+				order = append(order, name)
+				return next(req)
+			}
+		}
+	}
+	r.Use(trace("outer"), trace("inner"))
+	r.HandleFunc(protocol.MessageTypeDevicePing, func(*Request) error {
+		order = append(order, "handler")
+		return nil
+	})
+
+	r.Dispatch(request(t, protocol.MessageTypeDevicePing, nil))
+	if got := strings.Join(order, ","); got != "outer,inner,handler" {
+		t.Errorf("call order = %s, want outer,inner,handler", got)
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	r := NewRegistry()
+	m := NewMetrics()
+	r.Use(m.Middleware())
+	r.HandleFunc(protocol.MessageTypeDevicePing, func(*Request) error { return nil })
+
+	r.Dispatch(request(t, protocol.MessageTypeDevicePing, nil))
+	r.Dispatch(request(t, protocol.MessageTypeDevicePing, nil))
+	r.Dispatch(request(t, "bogus.type", nil))
+
+	stats := m.Snapshot()
+	if s := stats[protocol.MessageTypeDevicePing]; s.Handled != 2 || s.Failed != 0 {
+		t.Errorf("ping stats = %+v, want 2 handled, 0 failed", s)
+	}
+	if s := stats["bogus.type"]; s.Handled != 1 || s.Failed != 1 {
+		t.Errorf("unknown type stats = %+v, want 1 handled, 1 failed", s)
+	}
+}
+
+func TestRecover(t *testing.T) {
+	r := NewRegistry()
+	r.Use(Recover())
+	r.HandleFunc(protocol.MessageTypeDevicePing, func(*Request) error { panic("boom") })
+
+	if err := r.Dispatch(request(t, protocol.MessageTypeDevicePing, nil)); err == nil {
+		t.Error("Dispatch() error = nil, want the recovered panic")
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	now := time.Unix(0, 0)
+	r := NewRegistry()
+	r.Use(rateLimit(1, 2, func() time.Time { return now }))
+	r.HandleFunc(protocol.MessageTypeDevicePing, func(*Request) error { return nil })
+
+	ping := func() error { return r.Dispatch(request(t, protocol.MessageTypeDevicePing, nil)) }
+
+	for i := 0; i < 2; i++ {
+		if err := ping(); err != nil {
+			t.Fatalf("burst message %d: error = %v", i, err)
+		}
+	}
+	if err := ping(); !errors.Is(err, ErrRateLimited) {
+		t.Errorf("over the burst: error = %v, want %v", err, ErrRateLimited)
+	}
+
+	now = now.Add(time.Second)
+	if err := ping(); err != nil {
+		t.Errorf("after refill: error = %v", err)
+	}
+}
+
+func TestRequireCapability(t *testing.T) {
+	r := NewRegistry()
+	r.Use(RequireCapability())
+	r.HandleFunc(protocol.MessageTypeClipboardSet, func(*Request) error { return nil })
+	r.HandleFunc(protocol.MessageTypeDevicePing, func(*Request) error { return nil })
+
+	if err := r.Dispatch(request(t, protocol.MessageTypeClipboardSet, nil, protocol.CapabilityCalls)); !errors.Is(err, ErrPermissionDenied) {
+		t.Errorf("clipboard without capability: error = %v, want %v", err, ErrPermissionDenied)
+	}
+	if err := r.Dispatch(request(t, protocol.MessageTypeClipboardSet, nil, protocol.CapabilityClipboard)); err != nil {
+		t.Errorf("clipboard with capability: error = %v", err)
+	}
+	if err := r.Dispatch(request(t, protocol.MessageTypeDevicePing, nil)); err != nil {
+		t.Errorf("ping: error = %v", err)
+	}
+}
+
+func TestPermission(t *testing.T) {
+	r := NewRegistry()
+	r.Use(Permission(func(req *Request) bool { return req.DeviceID != "mobile-1" }))
+	r.HandleFunc(protocol.MessageTypeDevicePing, func(*Request) error { return nil })
+
+	if err := r.Dispatch(request(t, protocol.MessageTypeDevicePing, nil)); !errors.Is(err, ErrPermissionDenied) {
+		t.Errorf("Dispatch() error = %v, want %v", err, ErrPermissionDenied)
+	}
+}
+
+func TestWhen(t *testing.T) {
+	r := NewRegistry()
+	r.Use(When(func(req *Request) bool { return req.Message.Type == protocol.MessageTypeClipboardSet }, RateLimit(0, 1)))
+	r.HandleFunc(protocol.MessageTypeClipboardSet, func(*Request) error { return nil })
+	r.HandleFunc(protocol.MessageTypeDevicePing, func(*Request) error { return nil })
+
+	for i := range 3 {
+		if err := r.Dispatch(request(t, protocol.MessageTypeDevicePing, nil)); err != nil {
+			t.Errorf("ping %d: error = %v", i, err)
+		}
+	}
+	if err := r.Dispatch(request(t, protocol.MessageTypeClipboardSet, nil)); err != nil {
+		t.Errorf("first clipboard.set: error = %v", err)
+	}
+	if err := r.Dispatch(request(t, protocol.MessageTypeClipboardSet, nil)); !errors.Is(err, ErrRateLimited) {
+		t.Errorf("second clipboard.set: error = %v, want %v", err, ErrRateLimited)
+	}
+}
+
+func TestReply(t *testing.T) {
+	var sent *protocol.Message
+	msg, _ := protocol.NewMessage(protocol.MessageTypeClipboardGet, "mobile-1", nil)
+	req := NewRequest("mobile-1", msg, nil, func(m *protocol.Message) error {
+		sent = m
+		return nil
+	})
+
+	if err := req.Reply(protocol.MessageTypeClipboardContent, &protocol.ClipboardPayload{Data: "x"}); err != nil {
+		t.Fatalf("Reply() error = %v", err)
+	}
+	if !req.Replied() || sent == nil || sent.ReplyTo != msg.ID {
+		t.Errorf("Reply() sent %+v, want a reply to %s", sent, msg.ID)
+	}
+}
@@ -0,0 +1,206 @@
+package events
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"eco/internal/dispatch"
+	"eco/internal/notifications"
+	"eco/internal/protocol"
+)
+
+// callNotificationID is the ID of the notification for a device's call. It
+// can't clash with Android notification keys, which look like
+// "0|com.app|1|tag|10123".
+const callNotificationID = "eco:call"
+
+// Actions on the incoming call notification
+const (
+	actionAnswer  = "answer"
+	actionDecline = "decline"
+)
+
+// callTimeout bounds how long answering or hanging up waits for the phone
+const callTimeout = 5 * time.Second
+
+// ErrNoCall is returned when there is no call to answer or hang up
+var ErrNoCall = errors.New("no call in progress")
+
+// Call is a call ringing or in progress on a device
+type Call struct {
+	DeviceID string
+	Number   string
+}
+
+// handleCallIncoming shows a call ringing on the device until it is answered
+// or ends, with actions to answer or decline it on the phone
+func (r *Router) handleCallIncoming(req *dispatch.Request, payload *protocol.CallPayload) error {
+	r.mu.Lock()
+	r.calls[req.DeviceID] = payload.Number
+	r.mu.Unlock()
+
+	if r.notifier == nil {
+		return nil
+	}
+	caller := payload.Number
+	if caller == "" {
+		caller = "Unknown number"
+	}
+	_, err := r.notifier.Show(&notifications.Notification{
+		App:        notifications.AppName,
+		Summary:    "Incoming call",
+		Body:       caller,
+		Icon:       "call-start",
+		Urgency:    notifications.UrgencyCritical,
+		Persistent: true,
+		Actions: []notifications.Action{
+			{ID: actionAnswer, Label: "Answer"},
+			{ID: actionDecline, Label: "Decline"},
+		},
+		Device: req.DeviceID,
+		ID:     callNotificationID,
+	})
+	if err != nil {
+		log.Printf("Router: Failed to show incoming call: %v", err)
+	}
+	return nil
+}
+
+// handleCallAnswer withdraws the incoming call once it is answered on the
+// phone. The call stays in progress for 'eco call hangup'.
+func (r *Router) handleCallAnswer(req *dispatch.Request, payload *protocol.CallPayload) error {
+	r.mu.Lock()
+	if _, ok := r.calls[req.DeviceID]; !ok {
+		r.calls[req.DeviceID] = payload.Number
+	}
+	r.mu.Unlock()
+
+	r.withdrawCall(req.DeviceID)
+	return nil
+}
+
+// handleCallHangup forgets the device's call once it ends
+func (r *Router) handleCallHangup(req *dispatch.Request) error {
+	r.mu.Lock()
+	delete(r.calls, req.DeviceID)
+	r.mu.Unlock()
+
+	r.withdrawCall(req.DeviceID)
+	return nil
+}
+
+// withdrawCall closes the incoming call notification for deviceID
+func (r *Router) withdrawCall(deviceID string) {
+	if r.notifier == nil {
+		return
+	}
+	err := r.notifier.Close(notifications.Key(deviceID, callNotificationID))
+	if err != nil && !errors.Is(err, notifications.ErrUnavailable) {
+		log.Printf("Router: Failed to withdraw call notification: %v", err)
+	}
+}
+
+// callAction answers or declines a call from its notification
+func (r *Router) callAction(deviceID, action string) {
+	var err error
+	switch action {
+	case actionAnswer:
+		_, err = r.AnswerCall(deviceID)
+	case actionDecline:
+		_, err = r.HangupCall(deviceID)
+	default:
+		return
+	}
+	if err != nil {
+		log.Printf("Router: Failed to %s call on %s: %v", action, deviceID, err)
+	}
+}
+
+// AnswerCall asks the phone to answer its ringing call. An empty deviceID
+// picks the only device with a call.
+func (r *Router) AnswerCall(deviceID string) (Call, error) {
+	call, err := r.activeCall(deviceID)
+	if err != nil {
+		return call, err
+	}
+	if err := r.requestCall(call, protocol.MessageTypeCallAnswer); err != nil {
+		return call, err
+	}
+	r.withdrawCall(call.DeviceID)
+	return call, nil
+}
+
+// HangupCall asks the phone to decline its ringing call or end the call in
+// progress. An empty deviceID picks the only device with a call.
+func (r *Router) HangupCall(deviceID string) (Call, error) {
+	call, err := r.activeCall(deviceID)
+	if err != nil {
+		return call, err
+	}
+	if err := r.requestCall(call, protocol.MessageTypeCallHangup); err != nil {
+		return call, err
+	}
+
+	r.mu.Lock()
+	delete(r.calls, call.DeviceID)
+	r.mu.Unlock()
+	r.withdrawCall(call.DeviceID)
+	return call, nil
+}
+
+// Calls returns the calls ringing or in progress
+func (r *Router) Calls() []Call {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	calls := make([]Call, 0, len(r.calls))
+	for deviceID, number := range r.calls {
+		calls = append(calls, Call{DeviceID: deviceID, Number: number})
+	}
+	return calls
+}
+
+// activeCall returns the call on deviceID, or the only call if it is empty
+func (r *Router) activeCall(deviceID string) (Call, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if deviceID != "" {
+		number, ok := r.calls[deviceID]
+		if !ok {
+			return Call{DeviceID: deviceID}, fmt.Errorf("%w on device %s", ErrNoCall, deviceID)
+		}
+		return Call{DeviceID: deviceID, Number: number}, nil
+	}
+
+	switch len(r.calls) {
+	case 0:
+		return Call{}, ErrNoCall
+	case 1:
+		for id, number := range r.calls {
+			return Call{DeviceID: id, Number: number}, nil
+		}
+	}
+	return Call{}, fmt.Errorf("calls on %d devices, choose one with --device", len(r.calls))
+}
+
+// requestCall sends a call message to the phone and waits for it to accept.
+// Calls don't wait in the offline queue: they are over by the time the
+// device is back.
+func (r *Router) requestCall(call Call, msgType protocol.MessageType) error {
+	r.mu.RLock()
+	conn := r.deviceConns[call.DeviceID]
+	r.mu.RUnlock()
+
+	if conn == nil || !conn.IsConnected() {
+		return fmt.Errorf("device %s is not connected", call.DeviceID)
+	}
+	msg, err := protocol.NewMessage(msgType, call.DeviceID, &protocol.CallPayload{Number: call.Number})
+	if err != nil {
+		return err
+	}
+	_, err = conn.Request(msg, callTimeout)
+	return err
+}
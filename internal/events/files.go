@@ -0,0 +1,321 @@
+package events
+
+import (
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"eco/internal/clipboard"
+	"eco/internal/device"
+	"eco/internal/dispatch"
+	"eco/internal/protocol"
+	"eco/internal/transfer"
+)
+
+// fileOfferTimeout bounds how long the device may take to acknowledge an offer
+const fileOfferTimeout = 10 * time.Second
+
+// maxClipboardFiles is how many bytes of copied files are offered with a
+// file list. The files are hashed on every copy, so larger ones are only
+// listed and can be sent with 'eco send'.
+const maxClipboardFiles = 64 << 20
+
+// ErrNoTransfers is returned for file messages when the daemon has no
+// transfer manager
+var ErrNoTransfers = errors.New("file transfers are not available")
+
+// SetTransfers sets the manager for file transfers and reports their
+// progress to the devices
+func (r *Router) SetTransfers(m *transfer.Manager) {
+	r.transfers = m
+	m.OnProgress(r.transferProgress)
+}
+
+// handleFileOffer records a file or folder the device wants to send until
+// it is accepted with 'eco receive'. A device repeating the offer of a file
+// accepted earlier gets the accept again, with the offset to resume from.
+func (r *Router) handleFileOffer(req *dispatch.Request, payload *protocol.FileOfferPayload) error {
+	if r.transfers == nil {
+		return ErrNoTransfers
+	}
+	t, err := r.transfers.Incoming(req.DeviceID, payload)
+	if err != nil {
+		return err
+	}
+	if t.State == transfer.StateOffered {
+		log.Printf("Router: %s offers %s (%d bytes)", req.DeviceID, t.Name, t.Size)
+		return nil
+	}
+	r.sendTo(req.DeviceID, protocol.MessageTypeFileAccept, &protocol.FileAcceptPayload{
+		ID:     t.ID,
+		Token:  t.Token,
+		Offset: t.Bytes,
+	})
+	return nil
+}
+
+// handleFileAccept records that the device took a file offered to it
+func (r *Router) handleFileAccept(req *dispatch.Request, payload *protocol.FileAcceptPayload) error {
+	if r.transfers == nil {
+		return ErrNoTransfers
+	}
+	return r.transfers.Accepted(req.DeviceID, payload.ID)
+}
+
+// handleFileCancel aborts a transfer the device declined or gave up on
+func (r *Router) handleFileCancel(req *dispatch.Request, payload *protocol.FileCancelPayload) error {
+	if r.transfers == nil {
+		return ErrNoTransfers
+	}
+	_, err := r.transfers.Cancel(req.DeviceID, payload.ID, payload.Reason)
+	return err
+}
+
+// OfferFile offers the file or folder at path to the device on conn and
+// waits for the device to take note of the offer. Folders are zstd
+// compressed if compress is set.
+func (r *Router) OfferFile(conn *device.Connection, path string, compress bool) (transfer.Transfer, error) {
+	if r.transfers == nil {
+		return transfer.Transfer{}, ErrNoTransfers
+	}
+	deviceID := conn.GetDeviceID()
+	t, err := r.transfers.Offer(deviceID, path, compress)
+	if err != nil {
+		return transfer.Transfer{}, err
+	}
+
+	msg, err := protocol.NewMessage(protocol.MessageTypeFileOffer, deviceID, offerPayload(t))
+	if err == nil {
+		_, err = conn.Request(msg, fileOfferTimeout)
+	}
+	if err != nil {
+		r.transfers.Cancel("", t.ID, err.Error())
+		return transfer.Transfer{}, err
+	}
+	return t, nil
+}
+
+// clipboardOffer is what of a clipboard change goes to devices as
+// transfers, hashed once for all of them
+type clipboardOffer struct {
+	// large is set for an image too large to send inline, and image is
+	// that image if any device can take it as a transfer
+	large bool
+	image *transfer.Prepared
+	// files are the entries of a file list
+	files []clipboardFile
+}
+
+// clipboardFile is an entry of a copied file list, with the file prepared
+// for transfer if it is offered
+type clipboardFile struct {
+	protocol.ClipboardFile
+	prepared *transfer.Prepared
+}
+
+// prepareClipboard withdraws the transfers offered for the previous
+// clipboard and hashes what of payload goes to devices as transfers. It
+// runs before fan-out takes r.deliverMu, so that hashing doesn't hold up
+// other deliveries. It returns nil if the payload goes as it is.
+func (r *Router) prepareClipboard(payload *protocol.ClipboardPayload) *clipboardOffer {
+	r.cancelClipboardOffers()
+	canOffer := slices.ContainsFunc(r.connectedDevices(), func(conn *device.Connection) bool {
+		return r.canOffer(conn.GetDeviceID())
+	})
+
+	switch {
+	case payload.IsImage():
+		data, err := payload.Bytes()
+		if err != nil || len(data) <= clipboard.MaxInlineSize {
+			return nil
+		}
+		offer := &clipboardOffer{large: true}
+		if canOffer {
+			image := transfer.PrepareData(clipboardImageName(payload.Type()), data)
+			offer.image = &image
+		}
+		return offer
+
+	case payload.Type() == protocol.MIMEURIList && canOffer:
+		uris := clipboard.Content{MIME: protocol.MIMEURIList, Data: []byte(payload.Data)}.URIs()
+		offer := &clipboardOffer{}
+		budget := int64(maxClipboardFiles)
+		for _, path := range clipboard.LocalPaths(uris) {
+			info, err := os.Stat(path)
+			if err != nil {
+				continue
+			}
+			file := clipboardFile{ClipboardFile: protocol.ClipboardFile{Name: filepath.Base(path), Size: info.Size()}}
+			if info.Mode().IsRegular() && info.Size() <= budget {
+				if prepared, err := transfer.PrepareFile(path); err != nil {
+					log.Printf("Router: Failed to read copied file %s: %v", file.Name, err)
+				} else {
+					budget -= prepared.Size
+					file.prepared = &prepared
+				}
+			}
+			offer.files = append(offer.files, file)
+		}
+		return offer
+	}
+	return nil
+}
+
+// offerClipboard returns the clipboard payload as it goes to deviceID,
+// offering the device the transfers of offer, which the payload refers to.
+// It returns nil if the payload can't go to the device. Callers hold
+// r.deliverMu.
+func (r *Router) offerClipboard(deviceID string, payload *protocol.ClipboardPayload, offer *clipboardOffer) *protocol.ClipboardPayload {
+	if offer == nil {
+		return payload
+	}
+	canOffer := r.canOffer(deviceID)
+
+	switch {
+	case offer.large:
+		if offer.image == nil || !canOffer {
+			log.Printf("Router: Not sending %s to %s: too large to send inline and it can't take file transfers", payload.Type(), deviceID)
+			return nil
+		}
+		t, err := r.transfers.OfferPrepared(deviceID, *offer.image)
+		if err != nil {
+			log.Printf("Router: Failed to offer clipboard image to %s: %v", deviceID, err)
+			return nil
+		}
+		r.deliverClipboardOffer(t)
+		offered := *payload
+		offered.Data, offered.Encoding, offered.TransferID = "", "", t.ID
+		return &offered
+
+	case canOffer:
+		offered := *payload
+		offered.Files = nil
+		for _, f := range offer.files {
+			file := f.ClipboardFile
+			if f.prepared != nil {
+				if t, err := r.transfers.OfferPrepared(deviceID, *f.prepared); err != nil {
+					log.Printf("Router: Failed to offer copied file %s to %s: %v", file.Name, deviceID, err)
+				} else {
+					file.TransferID = t.ID
+					r.deliverClipboardOffer(t)
+				}
+			}
+			offered.Files = append(offered.Files, file)
+		}
+		return &offered
+	}
+	return payload
+}
+
+// canOffer reports whether deviceID is connected and takes file transfers.
+// Offers to a device that is away would expire before it is back.
+func (r *Router) canOffer(deviceID string) bool {
+	r.mu.RLock()
+	conn := r.deviceConns[deviceID]
+	r.mu.RUnlock()
+	return r.transfers != nil && conn != nil && conn.IsConnected() && conn.Supports(protocol.CapabilityFiles)
+}
+
+// deliverClipboardOffer tells the device of transfer t of the clipboard,
+// remembering it to be withdrawn when the clipboard changes. Callers hold
+// r.deliverMu.
+func (r *Router) deliverClipboardOffer(t transfer.Transfer) {
+	r.clipboardOffers = append(r.clipboardOffers, t.ID)
+	r.deliverOffer(t)
+}
+
+// cancelClipboardOffers withdraws the transfers of the previous clipboard,
+// which the devices won't paste anymore. Those already over are left be.
+func (r *Router) cancelClipboardOffers() {
+	for _, id := range r.clipboardOffers {
+		r.transfers.Cancel("", id, "clipboard changed")
+	}
+	r.clipboardOffers = nil
+}
+
+// deliverOffer tells the device of outgoing transfer t without waiting for
+// it to take note. Callers hold r.deliverMu.
+func (r *Router) deliverOffer(t transfer.Transfer) {
+	msg, err := protocol.NewMessage(protocol.MessageTypeFileOffer, t.DeviceID, offerPayload(t))
+	if err != nil {
+		log.Printf("Router: Failed to create message: %v", err)
+		return
+	}
+	r.deliver(t.DeviceID, msg)
+}
+
+// offerPayload returns the file.offer for outgoing transfer t
+func offerPayload(t transfer.Transfer) *protocol.FileOfferPayload {
+	return &protocol.FileOfferPayload{
+		ID:        t.ID,
+		Name:      t.Name,
+		Size:      t.Size,
+		SHA256:    t.SHA256,
+		Token:     t.Token,
+		Archive:   t.Archive,
+		Files:     t.Files,
+		TotalSize: t.TotalSize,
+	}
+}
+
+// clipboardImageName names a clipboard image of mimeType sent as a file,
+// e.g. clipboard.png for image/png and clipboard.svg for image/svg+xml
+func clipboardImageName(mimeType string) string {
+	ext, _, _ := strings.Cut(strings.TrimPrefix(mimeType, "image/"), "+")
+	return "clipboard." + ext
+}
+
+// AcceptFile accepts the file offered as id, to be saved in dir with the
+// conflict policy, and sends the device the token to upload it with
+func (r *Router) AcceptFile(id, dir string, conflict transfer.Conflict) (transfer.Transfer, error) {
+	if r.transfers == nil {
+		return transfer.Transfer{}, ErrNoTransfers
+	}
+	t, err := r.transfers.Accept(id, dir, conflict)
+	if err != nil {
+		return transfer.Transfer{}, err
+	}
+	r.sendTo(t.DeviceID, protocol.MessageTypeFileAccept, &protocol.FileAcceptPayload{
+		ID:    t.ID,
+		Token: t.Token,
+	})
+	return t, nil
+}
+
+// transferProgress tells the device how far a transfer got. Only the final
+// report waits in the offline queue: progress is stale by the time the
+// device is back.
+func (r *Router) transferProgress(t transfer.Transfer) {
+	payload := &protocol.FileProgressPayload{
+		ID:        t.ID,
+		Bytes:     t.Bytes,
+		Size:      t.Size,
+		State:     string(t.State),
+		Error:     t.Error,
+		File:      t.File,
+		FilesDone: t.FilesDone,
+	}
+	if t.Finished() {
+		r.sendTo(t.DeviceID, protocol.MessageTypeFileProgress, payload)
+		return
+	}
+
+	r.mu.RLock()
+	conn := r.deviceConns[t.DeviceID]
+	r.mu.RUnlock()
+	if conn == nil || !conn.IsConnected() {
+		return
+	}
+	msg, err := protocol.NewMessage(protocol.MessageTypeFileProgress, t.DeviceID, payload)
+	if err != nil {
+		log.Printf("Router: Failed to create message: %v", err)
+		return
+	}
+	if err := conn.Send(msg); err != nil {
+		log.Printf("Router: Failed to send progress of %s to %s: %v", t.ID, t.DeviceID, err)
+	}
+}
@@ -0,0 +1,128 @@
+package events
+
+import (
+	"fmt"
+
+	"eco/internal/clipboard"
+	"eco/internal/dispatch"
+	"eco/internal/notifications"
+	"eco/internal/protocol"
+)
+
+// registerDefaultHandlers registers the handlers for the features the daemon
+// supports out of the box
+func (r *Router) registerDefaultHandlers() {
+	dispatch.Handle(r.handlers, protocol.MessageTypeClipboardSet, r.handleClipboardSet)
+	// Clients announce their own clipboard changes as clipboard.changed
+	dispatch.Handle(r.handlers, protocol.MessageTypeClipboardChanged, r.handleClipboardSet)
+	dispatch.Handle(r.handlers, protocol.MessageTypeNotificationPush, r.handleNotificationPush)
+	dispatch.Handle(r.handlers, protocol.MessageTypeNotificationDismiss, r.handleNotificationDismiss)
+	dispatch.Handle(r.handlers, protocol.MessageTypeCallIncoming, r.handleCallIncoming)
+	dispatch.Handle(r.handlers, protocol.MessageTypeCallAnswer, r.handleCallAnswer)
+	r.handlers.HandleFunc(protocol.MessageTypeCallHangup, r.handleCallHangup)
+	dispatch.Handle(r.handlers, protocol.MessageTypeSMSReceived, r.handleSMSReceived)
+	dispatch.Handle(r.handlers, protocol.MessageTypeSMSSync, r.handleSMSSync)
+	dispatch.Handle(r.handlers, protocol.MessageTypeFileOffer, r.handleFileOffer)
+	dispatch.Handle(r.handlers, protocol.MessageTypeFileAccept, r.handleFileAccept)
+	dispatch.Handle(r.handlers, protocol.MessageTypeFileCancel, r.handleFileCancel)
+	dispatch.Handle(r.handlers, protocol.MessageTypeInputKey, r.handleInputKey)
+	dispatch.Handle(r.handlers, protocol.MessageTypeInputText, r.handleInputText)
+	dispatch.Handle(r.handlers, protocol.MessageTypeInputMouse, r.handleInputMouse)
+	dispatch.Handle(r.handlers, protocol.MessageTypeInputButton, r.handleInputButton)
+	dispatch.Handle(r.handlers, protocol.MessageTypeInputScroll, r.handleInputScroll)
+	r.handlers.HandleFunc(protocol.MessageTypeDevicePing, func(*dispatch.Request) error { return nil })
+}
+
+// handleClipboardSet copies the device's clipboard to the desktop
+func (r *Router) handleClipboardSet(req *dispatch.Request, payload *protocol.ClipboardPayload) error {
+	content, err := clipboard.ContentFromPayload(payload)
+	if err != nil {
+		return err
+	}
+	if err := r.clipboardSetter.SetContentFrom(req.DeviceID, content); err != nil {
+		return fmt.Errorf("setting clipboard: %w", err)
+	}
+	return nil
+}
+
+// handleNotificationPush shows a notification from the device on the desktop
+func (r *Router) handleNotificationPush(req *dispatch.Request, payload *protocol.NotificationPayload) error {
+	if r.notifier == nil {
+		return notifications.ErrUnavailable
+	}
+
+	n := &notifications.Notification{
+		App:       payload.App,
+		Summary:   payload.Title,
+		Body:      payload.Body,
+		Icon:      payload.Icon,
+		Urgency:   payload.Urgency,
+		Category:  payload.Category,
+		Replyable: payload.Replyable,
+		Device:    req.DeviceID,
+		ID:        payload.ID,
+	}
+	for _, a := range payload.Actions {
+		n.Actions = append(n.Actions, notifications.Action{ID: a.ID, Label: a.Label})
+	}
+	if _, err := r.notifier.Show(n); err != nil {
+		return fmt.Errorf("sending notification: %w", err)
+	}
+	return nil
+}
+
+// handleNotificationDismiss withdraws a notification the user dismissed on
+// the device
+func (r *Router) handleNotificationDismiss(req *dispatch.Request, payload *protocol.NotificationDismissPayload) error {
+	if r.notifier == nil {
+		return notifications.ErrUnavailable
+	}
+	if err := r.notifier.Close(notifications.Key(req.DeviceID, payload.ID)); err != nil {
+		return fmt.Errorf("closing notification: %w", err)
+	}
+	return nil
+}
+
+// notificationAction tells the device a notification came from that the
+// user invoked one of its actions
+func (r *Router) notificationAction(n *notifications.Notification, action string) {
+	if n.Device == "" || n.ID == "" {
+		return
+	}
+	if n.ID == callNotificationID {
+		go r.callAction(n.Device, action)
+		return
+	}
+	r.sendTo(n.Device, protocol.MessageTypeNotificationAction, &protocol.NotificationEventPayload{
+		ID:     n.ID,
+		Action: action,
+	})
+}
+
+// notificationClosed tells the device a notification came from that it is
+// no longer shown. One the user dismissed is dismissed on the device too.
+func (r *Router) notificationClosed(n *notifications.Notification, reason notifications.CloseReason) {
+	if n.Device == "" || n.ID == "" || n.ID == callNotificationID {
+		return
+	}
+	if reason == notifications.ClosedDismissed {
+		r.sendTo(n.Device, protocol.MessageTypeNotificationDismiss, &protocol.NotificationDismissPayload{ID: n.ID})
+		return
+	}
+	r.sendTo(n.Device, protocol.MessageTypeNotificationClosed, &protocol.NotificationEventPayload{
+		ID:     n.ID,
+		Reason: reason.String(),
+	})
+}
+
+// notificationReply sends the reply the user typed into a notification to
+// the device, to answer the message with
+func (r *Router) notificationReply(n *notifications.Notification, text string) {
+	if n.Device == "" || n.ID == "" {
+		return
+	}
+	r.sendTo(n.Device, protocol.MessageTypeNotificationReply, &protocol.NotificationReplyPayload{
+		ID:   n.ID,
+		Text: text,
+	})
+}
@@ -0,0 +1,84 @@
+package events
+
+import (
+	"errors"
+
+	"eco/internal/dispatch"
+	"eco/internal/input"
+	"eco/internal/protocol"
+)
+
+// inputRate and inputBurst limit input messages, which a touchpad sends far
+// more often than anything else
+const (
+	inputRate  = 200
+	inputBurst = 400
+)
+
+// ErrNoInput is returned for input messages when the daemon has no virtual
+// input device
+var ErrNoInput = errors.New("input is not available")
+
+// SetInput sets the injector for input from devices. allowed tells whether
+// the user lets a device use it; input from other devices is refused.
+func (r *Router) SetInput(in *input.Injector, allowed func(deviceID string) bool) {
+	r.input = in
+	r.inputAllowed = allowed
+}
+
+// isInput reports whether req is input for the virtual keyboard and mouse
+func isInput(req *dispatch.Request) bool {
+	return req.Message.Type.Capability() == protocol.CapabilityInput
+}
+
+// permitted keeps input from devices the user didn't allow it for
+func (r *Router) permitted(req *dispatch.Request) bool {
+	return !isInput(req) || (r.inputAllowed != nil && r.inputAllowed(req.DeviceID))
+}
+
+// releaseInput lets go of the keys and buttons deviceID held when it goes away
+func (r *Router) releaseInput(deviceID string) {
+	if r.input != nil {
+		r.input.Release(deviceID)
+	}
+}
+
+// handleInputKey presses a key on the virtual keyboard
+func (r *Router) handleInputKey(req *dispatch.Request, payload *protocol.InputKeyPayload) error {
+	if r.input == nil {
+		return ErrNoInput
+	}
+	return r.input.Key(req.DeviceID, payload.Key, payload.Modifiers, payload.Action)
+}
+
+// handleInputText types text on the virtual keyboard
+func (r *Router) handleInputText(req *dispatch.Request, payload *protocol.InputTextPayload) error {
+	if r.input == nil {
+		return ErrNoInput
+	}
+	return r.input.Type(req.DeviceID, payload.Text)
+}
+
+// handleInputMouse moves the pointer
+func (r *Router) handleInputMouse(req *dispatch.Request, payload *protocol.InputMousePayload) error {
+	if r.input == nil {
+		return ErrNoInput
+	}
+	return r.input.Move(payload.DX, payload.DY)
+}
+
+// handleInputButton clicks a mouse button
+func (r *Router) handleInputButton(req *dispatch.Request, payload *protocol.InputButtonPayload) error {
+	if r.input == nil {
+		return ErrNoInput
+	}
+	return r.input.Button(req.DeviceID, payload.Button, payload.Action)
+}
+
+// handleInputScroll turns the scroll wheel
+func (r *Router) handleInputScroll(req *dispatch.Request, payload *protocol.InputScrollPayload) error {
+	if r.input == nil {
+		return ErrNoInput
+	}
+	return r.input.Scroll(payload.DX, payload.DY)
+}
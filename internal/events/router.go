@@ -1,81 +1,421 @@
 package events
 
 import (
+	"eco/internal/bus"
 	"eco/internal/clipboard"
 	"eco/internal/device"
+	"eco/internal/dispatch"
+	"eco/internal/input"
 	"eco/internal/notifications"
 	"eco/internal/protocol"
-	"encoding/json"
-	"fmt"
+	"eco/internal/queue"
+	"eco/internal/sms"
+	"eco/internal/transfer"
 	"log"
+	"slices"
+	"sync"
+	"time"
 )
 
-// Router handles routing system events to the connected device
+// handlerRate and handlerBurst limit how fast one device can send messages
+const (
+	handlerRate  = 20
+	handlerBurst = 50
+)
+
+// Router handles routing system events to the connected devices
 type Router struct {
-	deviceConn      *device.Connection
-	eventChan       chan Event
-	stop            chan struct{}
+	mu              sync.RWMutex
+	deviceConns     map[string]*device.Connection
+	bus             *bus.Bus
+	events          *bus.Subscription
 	running         bool
+	lastSync        time.Time
 	clipboardSetter *clipboard.Setter
-}
+	notifier        *notifications.Notifier
+	handlers        *dispatch.Registry
+	metrics         *dispatch.Metrics
+
+	// deliverMu orders fan-out against flushing the offline queue, so a
+	// reconnecting device gets its queued messages before anything newer
+	deliverMu     sync.Mutex
+	offline       *queue.Queue
+	pairedDevices func() []string
+
+	// capabilities looks up what an offline device advertised when it last
+	// connected; connected devices use the capabilities of their session
+	capabilities func(deviceID string) []string
 
-// Event represents a system event to be sent to the device
-type Event struct {
-	Type    protocol.MessageType
-	Payload interface{}
+	// filter can keep an event from a device, e.g. by user policy
+	filter func(deviceID string, event bus.Event) error
+
+	// calls are the numbers of calls ringing or in progress by device
+	calls map[string]string
+
+	smsStore  *sms.Store
+	transfers *transfer.Manager
+	// clipboardOffers are the transfers offered for the current clipboard,
+	// used only by the fan-out goroutine
+	clipboardOffers []string
+
+	input        *input.Injector
+	inputAllowed func(deviceID string) bool
 }
 
-// NewRouter creates a new event router
-func NewRouter() *Router {
-	return &Router{
-		deviceConn:      nil,
-		eventChan:       make(chan Event, 256),
-		stop:            make(chan struct{}),
+// NewRouter creates a new event router that forwards events from eventBus
+// to the connected devices
+func NewRouter(eventBus *bus.Bus) *Router {
+	r := &Router{
+		deviceConns:     make(map[string]*device.Connection),
+		bus:             eventBus,
 		running:         false,
-		clipboardSetter: clipboard.NewSetter(),
+		clipboardSetter: clipboard.NewSetter(nil, nil),
+		handlers:        dispatch.NewRegistry(),
+		metrics:         dispatch.NewMetrics(),
+		calls:           make(map[string]string),
 	}
+	notInput := func(req *dispatch.Request) bool { return !isInput(req) }
+	r.handlers.Use(
+		// Input comes too often to log
+		dispatch.When(notInput, dispatch.Logging()),
+		r.metrics.Middleware(),
+		dispatch.Recover(),
+		dispatch.When(notInput, dispatch.RateLimit(handlerRate, handlerBurst)),
+		dispatch.When(isInput, dispatch.RateLimit(inputRate, inputBurst)),
+		dispatch.RequireCapability(),
+		dispatch.Permission(r.permitted),
+	)
+	r.registerDefaultHandlers()
+	return r
 }
 
-// SetDeviceConnection sets the current device connection
-// This should be called when a device connects/disconnects
-func (r *Router) SetDeviceConnection(conn *device.Connection) {
-	r.deviceConn = conn
+// SetClipboard sets the clipboard that clipboard.set from devices writes to.
+// Writes are recorded in tracker so they aren't echoed back to the device.
+func (r *Router) SetClipboard(backend clipboard.Backend, tracker *clipboard.Tracker) {
+	r.clipboardSetter = clipboard.NewSetter(backend, tracker)
+}
+
+// SetNotifier sets the notifier that shows notifications pushed by devices.
+// What the user does with them is reported back to the device they came
+// from. It must be called before the notifier connects.
+func (r *Router) SetNotifier(n *notifications.Notifier) {
+	n.OnAction(r.notificationAction)
+	n.OnClosed(r.notificationClosed)
+	n.OnReply(r.notificationReply)
+	r.notifier = n
+}
+
+// Handlers returns the registry of handlers for messages from devices, for
+// subsystems to register their own
+func (r *Router) Handlers() *dispatch.Registry {
+	return r.handlers
+}
+
+// HandlerStats returns per message type counters of handled device messages
+func (r *Router) HandlerStats() map[protocol.MessageType]dispatch.Stats {
+	return r.metrics.Snapshot()
+}
+
+// SetOfflineQueue keeps events for paired devices that are not connected in
+// q until they reconnect. pairedDevices lists the devices to queue for.
+func (r *Router) SetOfflineQueue(q *queue.Queue, pairedDevices func() []string) {
+	r.deliverMu.Lock()
+	defer r.deliverMu.Unlock()
+	r.offline = q
+	r.pairedDevices = pairedDevices
+}
+
+// SetCapabilityLookup tells the router what offline devices support, so it
+// doesn't queue events they can't handle. A nil result means unknown, and
+// everything is queued.
+func (r *Router) SetCapabilityLookup(lookup func(deviceID string) []string) {
+	r.deliverMu.Lock()
+	defer r.deliverMu.Unlock()
+	r.capabilities = lookup
+}
+
+// SetEventFilter sets a check run before an event goes to a device. Events
+// it returns an error for are not sent or queued for that device.
+func (r *Router) SetEventFilter(filter func(deviceID string, event bus.Event) error) {
+	r.deliverMu.Lock()
+	defer r.deliverMu.Unlock()
+	r.filter = filter
+}
+
+// AddDeviceConnection registers a connected device for event fan-out
+// This should be called when a device connects, after the connection has
+// started. Messages queued while the device was away are sent first.
+func (r *Router) AddDeviceConnection(conn *device.Connection) {
+	r.deliverMu.Lock()
+	defer r.deliverMu.Unlock()
+
+	r.flushQueue(conn)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.deviceConns[conn.GetDeviceID()] = conn
+}
+
+// queueSender is the part of a device connection the offline queue is
+// flushed to
+type queueSender interface {
+	GetDeviceID() string
+	Supports(capability string) bool
+	Send(msg *protocol.Message) error
+}
+
+// flushQueue sends conn everything queued for its device, in order
+func (r *Router) flushQueue(conn queueSender) {
+	if r.offline == nil {
+		return
+	}
+
+	deviceID := conn.GetDeviceID()
+	entries, err := r.offline.Drain(deviceID)
+	if err != nil {
+		log.Printf("Router: Failed to update queue for %s: %v", deviceID, err)
+	}
+	if len(entries) == 0 {
+		return
+	}
+
+	log.Printf("Router: Flushing %d queued messages to device %s", len(entries), deviceID)
+	for i, e := range entries {
+		if !conn.Supports(e.Message.Type.Capability()) {
+			continue
+		}
+		if err := conn.Send(e.Message); err != nil {
+			log.Printf("Router: Failed to flush queue to %s: %v", deviceID, err)
+			// Keep what didn't make it for the next connection, expiring
+			// when it would have
+			if err := r.offline.Restore(deviceID, entries[i:]); err != nil {
+				log.Printf("Router: Failed to keep %d unsent messages for %s: %v", len(entries)-i, deviceID, err)
+			}
+			return
+		}
+	}
+	r.markSynced()
+}
+
+// RemoveDeviceConnection unregisters a device connection
+// This should be called when a device disconnects. A newer connection for the
+// same device is left in place. Keys and buttons the device held are
+// released, and its call is forgotten, as the phone can't report its end.
+func (r *Router) RemoveDeviceConnection(conn *device.Connection) {
+	deviceID := conn.GetDeviceID()
+	r.mu.Lock()
+	if r.deviceConns[deviceID] != conn {
+		r.mu.Unlock()
+		return
+	}
+	delete(r.deviceConns, deviceID)
+	_, ringing := r.calls[deviceID]
+	delete(r.calls, deviceID)
+	r.releaseInput(deviceID)
+	r.mu.Unlock()
+
+	if ringing {
+		r.withdrawCall(deviceID)
+	}
+}
+
+// connectedDevices returns a snapshot of the currently connected devices
+func (r *Router) connectedDevices() []*device.Connection {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	conns := make([]*device.Connection, 0, len(r.deviceConns))
+	for _, conn := range r.deviceConns {
+		if conn.IsConnected() {
+			conns = append(conns, conn)
+		}
+	}
+	return conns
 }
 
 // Start begins processing events
 func (r *Router) Start() error {
+	r.events = r.bus.Subscribe("router", 256)
 	r.running = true
 
 	go func() {
-		for {
-			select {
-			case event := <-r.eventChan:
-				if r.deviceConn != nil && r.deviceConn.IsConnected() {
-					log.Printf("Router: Routing event %s to device", event.Type)
-					newMsg, err := protocol.NewMessage(event.Type, r.deviceConn.GetDeviceID(), "", event.Payload)
-					if err == nil {
-						if err := r.deviceConn.Send(newMsg); err != nil {
-							log.Printf("Router: Failed to send message: %v", err)
-						}
-					} else {
-						log.Printf("Router: Failed to create message: %v", err)
-					}
-
-				} else {
-					log.Printf("Router: Dropping event %s (no device connected)", event.Type)
-				}
-			case <-r.stop:
-				return
-			}
+		for event := range r.events.Events() {
+			r.fanOut(event)
 		}
 	}()
 
 	return nil
 }
 
+// fanOut sends event to every connected device and queues it for paired
+// devices that are offline. The device the event originated from is skipped.
+func (r *Router) fanOut(event bus.Event) {
+	clip, isClipboard := event.Payload.(*protocol.ClipboardPayload)
+	var offer *clipboardOffer
+	if isClipboard {
+		offer = r.prepareClipboard(clip)
+	}
+
+	r.deliverMu.Lock()
+	defer r.deliverMu.Unlock()
+
+	targets := r.targetDevices()
+	if len(targets) == 0 {
+		log.Printf("Router: Dropping event %s (no device connected)", event.Type)
+		return
+	}
+
+	for _, deviceID := range targets {
+		if deviceID == event.Origin {
+			continue
+		}
+		if r.filter != nil {
+			if err := r.filter(deviceID, event); err != nil {
+				log.Printf("Router: Not sending %s to device %s: %v", event.Type, deviceID, err)
+				continue
+			}
+		}
+		payload := event.Payload
+		if isClipboard {
+			if payload = r.offerClipboard(deviceID, clip, offer); payload == nil {
+				continue
+			}
+		}
+		newMsg, err := protocol.NewMessage(event.Type, deviceID, payload)
+		if err != nil {
+			log.Printf("Router: Failed to create message: %v", err)
+			return
+		}
+		r.deliver(deviceID, newMsg)
+	}
+}
+
+// targetDevices returns the devices an event goes to: every paired device
+// when there is an offline queue, otherwise only the connected ones
+func (r *Router) targetDevices() []string {
+	if r.offline != nil && r.pairedDevices != nil {
+		return r.pairedDevices()
+	}
+
+	conns := r.connectedDevices()
+	ids := make([]string, 0, len(conns))
+	for _, conn := range conns {
+		ids = append(ids, conn.GetDeviceID())
+	}
+	return ids
+}
+
+// deliver sends msg to deviceID, or queues it if the device is unreachable.
+// Messages for a capability the device doesn't support are dropped.
+func (r *Router) deliver(deviceID string, msg *protocol.Message) {
+	r.mu.RLock()
+	conn := r.deviceConns[deviceID]
+	r.mu.RUnlock()
+
+	if !r.supports(conn, deviceID, msg.Type.Capability()) {
+		log.Printf("Router: Skipping event %s for device %s (%s not supported)", msg.Type, deviceID, msg.Type.Capability())
+		return
+	}
+
+	if conn != nil && conn.IsConnected() {
+		log.Printf("Router: Routing event %s to device %s", msg.Type, deviceID)
+		err := conn.Send(msg)
+		if err == nil {
+			r.markSynced()
+			return
+		}
+		log.Printf("Router: Failed to send message to %s: %v", deviceID, err)
+	}
+
+	if r.offline == nil {
+		return
+	}
+	if err := r.offline.Enqueue(deviceID, msg); err != nil {
+		log.Printf("Router: Failed to queue %s for %s: %v", msg.Type, deviceID, err)
+		return
+	}
+	log.Printf("Router: Queued event %s for offline device %s", msg.Type, deviceID)
+}
+
+// sendTo sends a message to one device, queueing it if the device is offline
+func (r *Router) sendTo(deviceID string, msgType protocol.MessageType, payload any) {
+	msg, err := protocol.NewMessage(msgType, deviceID, payload)
+	if err != nil {
+		log.Printf("Router: Failed to create message: %v", err)
+		return
+	}
+
+	r.deliverMu.Lock()
+	defer r.deliverMu.Unlock()
+	r.deliver(deviceID, msg)
+}
+
+// supports reports whether deviceID can handle messages of capability,
+// using the live session when there is one
+func (r *Router) supports(conn *device.Connection, deviceID, capability string) bool {
+	if conn != nil && conn.IsConnected() {
+		return conn.Supports(capability)
+	}
+	if capability == "" || r.capabilities == nil {
+		return true
+	}
+	caps := r.capabilities(deviceID)
+	return caps == nil || slices.Contains(caps, capability)
+}
+
+// markSynced records that a message was just exchanged with a device
+func (r *Router) markSynced() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.lastSync = time.Now()
+}
+
+// LastSync returns when a message was last exchanged with a device
+func (r *Router) LastSync() time.Time {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.lastSync
+}
+
+// QueueDepth returns the number of events waiting to be routed
+func (r *Router) QueueDepth() int {
+	if r.events == nil {
+		return 0
+	}
+	return r.events.Pending()
+}
+
+// PendingMessages returns how many messages are queued for deviceID while it is offline
+func (r *Router) PendingMessages(deviceID string) int {
+	if r.offline == nil {
+		return 0
+	}
+	return r.offline.Pending(deviceID)
+}
+
+// ClearQueue drops the messages queued for deviceID, e.g. when it is unpaired
+func (r *Router) ClearQueue(deviceID string) error {
+	r.deliverMu.Lock()
+	defer r.deliverMu.Unlock()
+	if r.offline == nil {
+		return nil
+	}
+	return r.offline.Clear(deviceID)
+}
+
+// PendingTotal returns how many messages are queued for offline devices
+func (r *Router) PendingTotal() int {
+	if r.offline == nil {
+		return 0
+	}
+	return r.offline.PendingTotal()
+}
+
 // Stop halts the event router
 func (r *Router) Stop() error {
-	close(r.stop)
+	if r.events != nil {
+		r.events.Unsubscribe()
+	}
 	r.running = false
 
 	return nil
@@ -86,25 +426,20 @@ func (r *Router) IsRunning() bool {
 	return r.running
 }
 
-// RouteEvent queues an event to be sent to the device
+// RouteEvent publishes an event on the bus to be sent to the devices
 func (r *Router) RouteEvent(eventType protocol.MessageType, payload any) error {
-	newEvent := Event{
+	return r.bus.Publish(bus.Event{
 		Type:    eventType,
 		Payload: payload,
-	}
-
-	select {
-	case r.eventChan <- newEvent:
-		return nil
-	default:
-		return fmt.Errorf("event queue full")
-	}
-
+		Source:  "router",
+	})
 }
 
 // RouteClipboardChange sends clipboard change event to device
 func (r *Router) RouteClipboardChange(content string) error {
-	err := r.RouteEvent(protocol.MessageTypeClipboardChanged, content)
+	err := r.RouteEvent(protocol.MessageTypeClipboardChanged, &protocol.ClipboardPayload{
+		Data: content,
+	})
 	if err != nil {
 		return err
 	}
@@ -136,56 +471,35 @@ func (r *Router) RouteCallIncoming(number string) error {
 	return nil
 }
 
-// CreateMessageHandler creates a handler function for incoming messages
-func (r *Router) CreateMessageHandler() func(*protocol.Message) {
+// CreateMessageHandler creates a handler function for incoming messages on conn
+func (r *Router) CreateMessageHandler(conn *device.Connection) func(*protocol.Message) {
 	return func(msg *protocol.Message) {
-		r.handleIncomingMessage(msg)
+		r.handleIncomingMessage(conn, msg)
 	}
 }
 
-// handleIncomingMessage processes incoming messages from the device
-func (r *Router) handleIncomingMessage(msg *protocol.Message) {
-	log.Printf("Router: Handling incoming message of type: %s", msg.Type)
-	switch msg.Type {
-	case protocol.MessageTypeClipboardSet:
-		var payload protocol.ClipboardPayload
-		if err := json.Unmarshal(msg.Payload, &payload); err != nil {
-			fmt.Printf("Error parsing clipboard payload: %v\n", err)
-			return
-		}
-		if err := r.clipboardSetter.SetText(payload.Data); err != nil {
-			fmt.Printf("Error setting clipboard: %v\n", err)
-		}
-
-	case protocol.MessageTypeNotificationPush:
-		var payload protocol.NotificationPayload
-		if err := json.Unmarshal(msg.Payload, &payload); err != nil {
-			fmt.Printf("Error parsing notification payload: %v\n", err)
-			return
-		}
-		if err := notifications.Send(payload.Title, payload.Body); err != nil {
-			fmt.Printf("Error sending notification: %v\n", err)
-		}
-
-	case protocol.MessageTypeCallAnswer:
-		var payload protocol.CallPayload
-		if err := json.Unmarshal(msg.Payload, &payload); err != nil {
-			fmt.Printf("Error parsing call answer payload: %v\n", err)
-			return
-		}
-		fmt.Printf("Call answered: %s\n", payload.Number)
-
-	case protocol.MessageTypeCallHangup:
-		fmt.Println("Call hung up")
+// handleIncomingMessage processes incoming messages from the device and
+// acknowledges the ones that ask for it
+func (r *Router) handleIncomingMessage(conn *device.Connection, msg *protocol.Message) {
+	r.markSynced()
 
-	case protocol.MessageTypeDevicePing:
+	if msg.Type == protocol.MessageTypeDeviceDisconnect {
+		conn.Stop()
+		return
+	}
 
-	case protocol.MessageTypeDeviceDisconnect:
-		if r.deviceConn != nil {
-			r.deviceConn.Stop()
-		}
+	req := dispatch.NewRequest(conn.GetDeviceID(), msg, conn.Capabilities(), conn.Send)
+	err := r.handlers.Dispatch(req)
 
-	default:
-		fmt.Printf("Unknown message type: %s\n", msg.Type)
+	if !msg.NeedsAck() || req.Replied() {
+		return
+	}
+	ack, ackErr := protocol.NewAck(msg, err)
+	if ackErr != nil {
+		log.Printf("Router: Failed to create ack: %v", ackErr)
+		return
+	}
+	if sendErr := conn.Send(ack); sendErr != nil {
+		log.Printf("Router: Failed to ack %s from %s: %v", msg.Type, conn.GetDeviceID(), sendErr)
 	}
 }
@@ -0,0 +1,625 @@
+package events
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"eco/internal/bus"
+	"eco/internal/clipboard"
+	"eco/internal/device"
+	"eco/internal/input"
+	"eco/internal/notifications"
+	"eco/internal/protocol"
+	"eco/internal/queue"
+	"eco/internal/sms"
+	"eco/internal/transfer"
+
+	"github.com/gorilla/websocket"
+)
+
+// connectDevice adds a connection for deviceID to r and returns the client
+// end of its WebSocket, which plays the device
+func connectDevice(t *testing.T, r *Router, deviceID string) *websocket.Conn {
+	t.Helper()
+
+	conns := make(chan *device.Connection, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		ws, err := (&websocket.Upgrader{}).Upgrade(w, req, nil)
+		if err != nil {
+			t.Errorf("Upgrade() error = %v", err)
+			return
+		}
+		conns <- device.NewConnection(deviceID, ws)
+	}))
+	t.Cleanup(srv.Close)
+
+	ws, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	t.Cleanup(func() { ws.Close() })
+
+	conn := <-conns
+	conn.SetCapabilities([]string{protocol.CapabilityClipboard, protocol.CapabilityNotifications, protocol.CapabilityCalls, protocol.CapabilitySMS, protocol.CapabilityFiles, protocol.CapabilityInput})
+	conn.SetHandler(r.CreateMessageHandler(conn))
+	conn.Start()
+	t.Cleanup(conn.Stop)
+	r.AddDeviceConnection(conn)
+	return ws
+}
+
+// expectMessage reads from ws until a message of type want arrives
+func expectMessage(t *testing.T, ws *websocket.Conn, want protocol.MessageType) *protocol.Message {
+	t.Helper()
+
+	ws.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for {
+		var msg protocol.Message
+		if err := ws.ReadJSON(&msg); err != nil {
+			t.Fatalf("waiting for %s: %v", want, err)
+		}
+		if msg.Type == want {
+			return &msg
+		}
+	}
+}
+
+// TestClipboardRoundTrip sends a clipboard change from one device through
+// the desktop clipboard and checks that only the other device hears of it
+func TestClipboardRoundTrip(t *testing.T) {
+	for _, msgType := range []protocol.MessageType{protocol.MessageTypeClipboardSet, protocol.MessageTypeClipboardChanged} {
+		t.Run(string(msgType), func(t *testing.T) {
+			eventBus := bus.New()
+			defer eventBus.Close()
+
+			backend := clipboard.NewMemoryBackend()
+			tracker := clipboard.NewTracker()
+			r := NewRouter(eventBus)
+			r.SetClipboard(backend, tracker)
+			r.Start()
+			defer r.Stop()
+
+			listener := clipboard.NewListener(eventBus, backend, tracker)
+			if err := listener.Start(); err != nil {
+				t.Fatalf("Start() error = %v", err)
+			}
+			defer listener.Stop()
+
+			phone := connectDevice(t, r, "mobile-1")
+			tablet := connectDevice(t, r, "tablet-1")
+
+			msg, _ := protocol.NewMessage(msgType, "mobile-1", &protocol.ClipboardPayload{Data: "from phone"})
+			if err := phone.WriteJSON(msg); err != nil {
+				t.Fatalf("WriteJSON() error = %v", err)
+			}
+
+			ack := expectMessage(t, phone, protocol.MessageTypeAck)
+			if ack.ReplyTo != msg.ID {
+				t.Errorf("ack reply_to = %q, want %q", ack.ReplyTo, msg.ID)
+			}
+			if got, _ := backend.Read(); got != "from phone" {
+				t.Errorf("desktop clipboard = %q, want %q", got, "from phone")
+			}
+
+			changed := expectMessage(t, tablet, protocol.MessageTypeClipboardChanged)
+			var payload protocol.ClipboardPayload
+			changed.GetPayload(&payload)
+			if payload.Data != "from phone" {
+				t.Errorf("tablet got %q, want %q", payload.Data, "from phone")
+			}
+			// A later copy on the desktop goes to both devices. It must be the
+			// first change the phone hears of, or its own was echoed back.
+			backend.Write("typed here")
+			for _, ws := range []*websocket.Conn{phone, tablet} {
+				changed := expectMessage(t, ws, protocol.MessageTypeClipboardChanged)
+				changed.GetPayload(&payload)
+				if payload.Data != "typed here" {
+					t.Errorf("device got %q, want %q", payload.Data, "typed here")
+				}
+			}
+		})
+	}
+}
+
+// failingSender takes n messages and then fails
+type failingSender struct {
+	n    int
+	sent []*protocol.Message
+}
+
+func (f *failingSender) GetDeviceID() string             { return "mobile-1" }
+func (f *failingSender) Supports(capability string) bool { return true }
+
+func (f *failingSender) Send(msg *protocol.Message) error {
+	if len(f.sent) == f.n {
+		return errors.New("connection lost")
+	}
+	f.sent = append(f.sent, msg)
+	return nil
+}
+
+func TestFlushQueueFailure(t *testing.T) {
+	offline, err := queue.New(t.TempDir(), 0)
+	if err != nil {
+		t.Fatalf("queue.New() error = %v", err)
+	}
+	eventBus := bus.New()
+	defer eventBus.Close()
+	r := NewRouter(eventBus)
+	r.SetOfflineQueue(offline, func() []string { return []string{"mobile-1"} })
+
+	for _, title := range []string{"one", "two", "three"} {
+		msg, _ := protocol.NewMessage(protocol.MessageTypeNotificationPush, "mobile-1", &protocol.NotificationPayload{Title: title})
+		offline.Enqueue("mobile-1", msg)
+	}
+	// Look at the entries as queued, to compare with later
+	queued, _ := offline.Drain("mobile-1")
+	offline.Restore("mobile-1", queued)
+
+	// The connection drops after the first message: the rest stay queued
+	// in order, expiring when they would have
+	r.flushQueue(&failingSender{n: 1})
+	left, _ := offline.Drain("mobile-1")
+	if len(left) != 2 || left[0].Message.ID != queued[1].Message.ID || left[1].Message.ID != queued[2].Message.ID {
+		t.Fatalf("queue after a failed flush = %+v, want the last two messages", left)
+	}
+	if !left[0].ExpiresAt.Equal(queued[1].ExpiresAt) {
+		t.Errorf("ExpiresAt = %v, want %v", left[0].ExpiresAt, queued[1].ExpiresAt)
+	}
+	offline.Restore("mobile-1", left)
+
+	sender := &failingSender{n: 2}
+	r.flushQueue(sender)
+	if len(sender.sent) != 2 || offline.Pending("mobile-1") != 0 {
+		t.Errorf("flush sent %d messages and left %d, want 2 and 0", len(sender.sent), offline.Pending("mobile-1"))
+	}
+}
+
+func TestEventFilter(t *testing.T) {
+	eventBus := bus.New()
+	defer eventBus.Close()
+
+	r := NewRouter(eventBus)
+	r.SetEventFilter(func(deviceID string, event bus.Event) error {
+		payload := event.Payload.(*protocol.ClipboardPayload)
+		if deviceID == "tablet-1" && payload.Data == "private" {
+			return errors.New("not for the tablet")
+		}
+		return nil
+	})
+	r.Start()
+	defer r.Stop()
+
+	phone := connectDevice(t, r, "mobile-1")
+	tablet := connectDevice(t, r, "tablet-1")
+
+	r.RouteClipboardChange("private")
+	r.RouteClipboardChange("public")
+
+	var payload protocol.ClipboardPayload
+	for _, want := range []string{"private", "public"} {
+		expectMessage(t, phone, protocol.MessageTypeClipboardChanged).GetPayload(&payload)
+		if payload.Data != want {
+			t.Errorf("phone got %q, want %q", payload.Data, want)
+		}
+	}
+	expectMessage(t, tablet, protocol.MessageTypeClipboardChanged).GetPayload(&payload)
+	if payload.Data != "public" {
+		t.Errorf("tablet got %q, want only %q", payload.Data, "public")
+	}
+}
+
+// TestNotificationResponses checks that what happens to a mirrored
+// notification is reported to the device it came from only
+func TestNotificationResponses(t *testing.T) {
+	eventBus := bus.New()
+	defer eventBus.Close()
+
+	r := NewRouter(eventBus)
+	r.SetNotifier(notifications.NewNotifier(""))
+	r.Start()
+	defer r.Stop()
+
+	phone := connectDevice(t, r, "mobile-1")
+	tablet := connectDevice(t, r, "tablet-1")
+
+	// Without a notification server the device hears why nothing was shown
+	msg, _ := protocol.NewMessage(protocol.MessageTypeNotificationPush, "mobile-1", &protocol.NotificationPayload{ID: "7", Title: "Hi"})
+	if err := phone.WriteJSON(msg); err != nil {
+		t.Fatalf("WriteJSON() error = %v", err)
+	}
+	var ack protocol.AckPayload
+	expectMessage(t, phone, protocol.MessageTypeAck).GetPayload(&ack)
+	if ack.OK || ack.Error == "" {
+		t.Errorf("ack = %+v, want an error", ack)
+	}
+
+	// A dismissal needs the notification's id
+	msg, _ = protocol.NewMessage(protocol.MessageTypeNotificationDismiss, "mobile-1", &protocol.NotificationDismissPayload{})
+	phone.WriteJSON(msg)
+	ack = protocol.AckPayload{}
+	expectMessage(t, phone, protocol.MessageTypeAck).GetPayload(&ack)
+	if ack.OK || !strings.Contains(ack.Error, "id is required") {
+		t.Errorf("ack = %+v, want an error about the id", ack)
+	}
+
+	mirrored := &notifications.Notification{Summary: "Hi", Device: "tablet-1", ID: "7"}
+	r.notificationAction(mirrored, "mark-read")
+	r.notificationClosed(mirrored, notifications.ClosedExpired)
+	r.notificationReply(mirrored, "on my way")
+	r.notificationClosed(mirrored, notifications.ClosedDismissed)
+
+	var payload protocol.NotificationEventPayload
+	expectMessage(t, tablet, protocol.MessageTypeNotificationAction).GetPayload(&payload)
+	if payload.ID != "7" || payload.Action != "mark-read" {
+		t.Errorf("notification.action = %+v, want id 7 and action mark-read", payload)
+	}
+	payload = protocol.NotificationEventPayload{}
+	expectMessage(t, tablet, protocol.MessageTypeNotificationClosed).GetPayload(&payload)
+	if payload.ID != "7" || payload.Reason != "expired" {
+		t.Errorf("notification.closed = %+v, want id 7 and reason expired", payload)
+	}
+	var reply protocol.NotificationReplyPayload
+	expectMessage(t, tablet, protocol.MessageTypeNotificationReply).GetPayload(&reply)
+	if reply.ID != "7" || reply.Text != "on my way" {
+		t.Errorf("notification.reply = %+v, want id 7 and text %q", reply, "on my way")
+	}
+	var dismiss protocol.NotificationDismissPayload
+	expectMessage(t, tablet, protocol.MessageTypeNotificationDismiss).GetPayload(&dismiss)
+	if dismiss.ID != "7" {
+		t.Errorf("notification.dismiss = %+v, want id 7", dismiss)
+	}
+
+	// The phone hears nothing: its next message is the reply to a ping
+	ping, _ := protocol.NewMessage(protocol.MessageTypeDevicePing, "mobile-1", struct{}{})
+	phone.WriteJSON(ping)
+	var got protocol.Message
+	phone.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if err := phone.ReadJSON(&got); err != nil || got.ReplyTo != ping.ID {
+		t.Errorf("phone got %s replying to %q (%v), want the ack of the ping", got.Type, got.ReplyTo, err)
+	}
+}
+
+// answerRequest reads the next message of type want from ws and acks it
+func answerRequest(t *testing.T, ws *websocket.Conn, want protocol.MessageType) *protocol.Message {
+	t.Helper()
+	msg := expectMessage(t, ws, want)
+	ack, _ := protocol.NewAck(msg, nil)
+	if err := ws.WriteJSON(ack); err != nil {
+		t.Fatalf("WriteJSON() error = %v", err)
+	}
+	return msg
+}
+
+func TestCalls(t *testing.T) {
+	eventBus := bus.New()
+	defer eventBus.Close()
+
+	r := NewRouter(eventBus)
+	r.Start()
+	defer r.Stop()
+
+	phone := connectDevice(t, r, "mobile-1")
+
+	if _, err := r.AnswerCall(""); !errors.Is(err, ErrNoCall) {
+		t.Errorf("AnswerCall() without a call error = %v, want %v", err, ErrNoCall)
+	}
+
+	msg, _ := protocol.NewMessage(protocol.MessageTypeCallIncoming, "mobile-1", &protocol.CallPayload{Number: "+15551234"})
+	phone.WriteJSON(msg)
+	expectMessage(t, phone, protocol.MessageTypeAck)
+	if calls := r.Calls(); len(calls) != 1 || calls[0] != (Call{DeviceID: "mobile-1", Number: "+15551234"}) {
+		t.Fatalf("Calls() = %v, want the call on mobile-1", calls)
+	}
+
+	// Answering asks the phone and waits for it to accept
+	answered := make(chan error, 1)
+	go func() {
+		_, err := r.AnswerCall("")
+		answered <- err
+	}()
+	var payload protocol.CallPayload
+	answerRequest(t, phone, protocol.MessageTypeCallAnswer).GetPayload(&payload)
+	if payload.Number != "+15551234" {
+		t.Errorf("call.answer number = %q, want %q", payload.Number, "+15551234")
+	}
+	if err := <-answered; err != nil {
+		t.Errorf("AnswerCall() error = %v", err)
+	}
+
+	// Declining from the notification hangs up and forgets the call
+	r.notificationAction(&notifications.Notification{Device: "mobile-1", ID: callNotificationID}, actionDecline)
+	answerRequest(t, phone, protocol.MessageTypeCallHangup)
+	deadline := time.Now().Add(2 * time.Second)
+	for len(r.Calls()) > 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if calls := r.Calls(); len(calls) != 0 {
+		t.Errorf("Calls() after hangup = %v, want none", calls)
+	}
+
+	// A call the phone reports as over is forgotten too
+	msg, _ = protocol.NewMessage(protocol.MessageTypeCallIncoming, "mobile-1", &protocol.CallPayload{Number: "+15559876"})
+	phone.WriteJSON(msg)
+	expectMessage(t, phone, protocol.MessageTypeAck)
+	msg, _ = protocol.NewMessage(protocol.MessageTypeCallHangup, "mobile-1", struct{}{})
+	phone.WriteJSON(msg)
+	expectMessage(t, phone, protocol.MessageTypeAck)
+	if _, err := r.HangupCall("mobile-1"); !errors.Is(err, ErrNoCall) {
+		t.Errorf("HangupCall() after the call ended error = %v, want %v", err, ErrNoCall)
+	}
+}
+
+// TestCallDisconnect checks that a call is forgotten when its phone goes away
+// while it rings, as the phone can't tell when it ends
+func TestCallDisconnect(t *testing.T) {
+	eventBus := bus.New()
+	defer eventBus.Close()
+
+	r := NewRouter(eventBus)
+	r.SetNotifier(notifications.NewNotifier(""))
+	r.Start()
+	defer r.Stop()
+
+	phone := connectDevice(t, r, "mobile-1")
+	msg, _ := protocol.NewMessage(protocol.MessageTypeCallIncoming, "mobile-1", &protocol.CallPayload{Number: "+15551234"})
+	phone.WriteJSON(msg)
+	expectMessage(t, phone, protocol.MessageTypeAck)
+
+	r.RemoveDeviceConnection(r.connectedDevices()[0])
+	if calls := r.Calls(); len(calls) != 0 {
+		t.Errorf("Calls() after disconnecting = %v, want none", calls)
+	}
+	if _, err := r.AnswerCall(""); !errors.Is(err, ErrNoCall) {
+		t.Errorf("AnswerCall() after disconnecting error = %v, want %v", err, ErrNoCall)
+	}
+}
+
+func TestSMS(t *testing.T) {
+	eventBus := bus.New()
+	defer eventBus.Close()
+
+	r := NewRouter(eventBus)
+	r.Start()
+	defer r.Stop()
+
+	store, err := sms.Open(filepath.Join(t.TempDir(), sms.File), 0)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	r.SetSMSStore(store)
+
+	phone := connectDevice(t, r, "mobile-1")
+
+	msg, _ := protocol.NewMessage(protocol.MessageTypeSMSReceived, "mobile-1", &protocol.SMSMessage{
+		ID: "1", ThreadID: "t1", Address: "+15551234", Body: "hello", Time: 100,
+	})
+	phone.WriteJSON(msg)
+	expectMessage(t, phone, protocol.MessageTypeAck)
+
+	// Syncing asks for what came after the newest stored message
+	synced := make(chan error, 1)
+	go func() { synced <- r.SyncSMS(r.connectedDevices()[0]) }()
+
+	req := expectMessage(t, phone, protocol.MessageTypeSMSSync)
+	var since protocol.SMSSyncPayload
+	req.GetPayload(&since)
+	if since.Since != 100 {
+		t.Errorf("sms.sync since = %d, want 100", since.Since)
+	}
+	resp, _ := protocol.NewReply(req, protocol.MessageTypeSMSSync, &protocol.SMSSyncPayload{
+		Messages: []protocol.SMSMessage{
+			{ID: "2", ThreadID: "t1", Address: "+15551234", Body: "are you there?", Time: 200},
+			{ID: "3", ThreadID: "t2", Address: "+15559876", Body: "lunch?", Time: 300},
+		},
+	})
+	phone.WriteJSON(resp)
+	if err := <-synced; err != nil {
+		t.Fatalf("SyncSMS() error = %v", err)
+	}
+
+	threads := store.Threads()
+	if len(threads) != 2 || len(threads[1].Messages) != 2 || threads[0].Last().Body != "lunch?" {
+		t.Errorf("Threads() = %+v, want t2 then t1 with two messages", threads)
+	}
+}
+
+func TestFileOffers(t *testing.T) {
+	eventBus := bus.New()
+	defer eventBus.Close()
+
+	r := NewRouter(eventBus)
+	r.Start()
+	defer r.Stop()
+
+	dir := t.TempDir()
+	transfers := transfer.NewManager(dir)
+	r.SetTransfers(transfers)
+
+	phone := connectDevice(t, r, "mobile-1")
+
+	// The phone's offer waits for 'eco receive', which sends it the token
+	offer := &protocol.FileOfferPayload{ID: "file-1", Name: "photo.jpg", Size: 3, SHA256: strings.Repeat("0", 64)}
+	msg, _ := protocol.NewMessage(protocol.MessageTypeFileOffer, "mobile-1", offer)
+	phone.WriteJSON(msg)
+	expectMessage(t, phone, protocol.MessageTypeAck)
+
+	accepted, err := r.AcceptFile("file-1", "", "")
+	if err != nil {
+		t.Fatalf("AcceptFile() error = %v", err)
+	}
+	var accept protocol.FileAcceptPayload
+	expectMessage(t, phone, protocol.MessageTypeFileAccept).GetPayload(&accept)
+	if accept.ID != "file-1" || accept.Token != accepted.Token || accept.Token == "" {
+		t.Errorf("file.accept = %+v, want the token for file-1", accept)
+	}
+
+	// Offering it again, e.g. after reconnecting, gets the accept again
+	msg, _ = protocol.NewMessage(protocol.MessageTypeFileOffer, "mobile-1", offer)
+	phone.WriteJSON(msg)
+	expectMessage(t, phone, protocol.MessageTypeFileAccept).GetPayload(&accept)
+	if accept.Token != accepted.Token {
+		t.Errorf("file.accept after a repeated offer has token %q, want %q", accept.Token, accepted.Token)
+	}
+
+	// Files from the desktop are offered with the token for the download
+	path := filepath.Join(t.TempDir(), "notes.txt")
+	os.WriteFile(path, []byte("hello"), 0644)
+	offered := make(chan transfer.Transfer, 1)
+	go func() {
+		sent, err := r.OfferFile(r.connectedDevices()[0], path, false)
+		if err != nil {
+			t.Errorf("OfferFile() error = %v", err)
+		}
+		offered <- sent
+	}()
+	var payload protocol.FileOfferPayload
+	answerRequest(t, phone, protocol.MessageTypeFileOffer).GetPayload(&payload)
+	sent := <-offered
+	if payload.ID != sent.ID || payload.Name != "notes.txt" || payload.Size != 5 || payload.Token != sent.Token {
+		t.Errorf("file.offer = %+v, want notes.txt with the token of %s", payload, sent.ID)
+	}
+
+	// Cancelling on the desktop tells the phone
+	transfers.Cancel("", sent.ID, "")
+	var progress protocol.FileProgressPayload
+	expectMessage(t, phone, protocol.MessageTypeFileProgress).GetPayload(&progress)
+	if progress.ID != sent.ID || progress.State != string(transfer.StateCancelled) {
+		t.Errorf("file.progress = %+v, want %s cancelled", progress, sent.ID)
+	}
+}
+
+func TestClipboardOffers(t *testing.T) {
+	eventBus := bus.New()
+	defer eventBus.Close()
+
+	transfers := transfer.NewManager(t.TempDir())
+	r := NewRouter(eventBus)
+	r.SetTransfers(transfers)
+	r.Start()
+	defer r.Stop()
+
+	phone := connectDevice(t, r, "mobile-1")
+	tablet := connectDevice(t, r, "tablet-1")
+
+	// A large image is offered to each device instead of sent inline
+	image := make([]byte, clipboard.MaxInlineSize+1)
+	payload, _ := clipboard.Content{MIME: protocol.MIMEPNG, Data: image}.Payload()
+	eventBus.Publish(bus.Event{Type: protocol.MessageTypeClipboardChanged, Payload: payload})
+
+	offers := make(map[*websocket.Conn]protocol.FileOfferPayload)
+	for _, ws := range []*websocket.Conn{phone, tablet} {
+		var offer protocol.FileOfferPayload
+		expectMessage(t, ws, protocol.MessageTypeFileOffer).GetPayload(&offer)
+		var changed protocol.ClipboardPayload
+		expectMessage(t, ws, protocol.MessageTypeClipboardChanged).GetPayload(&changed)
+		if offer.Name != "clipboard.png" || offer.Size != int64(len(image)) {
+			t.Errorf("file.offer = %+v, want clipboard.png of %d bytes", offer, len(image))
+		}
+		if changed.TransferID != offer.ID || changed.Data != "" || changed.MIME != protocol.MIMEPNG {
+			t.Errorf("clipboard.changed = %+v, want %s without data", changed, offer.ID)
+		}
+		offers[ws] = offer
+	}
+	if offers[phone].ID == offers[tablet].ID || offers[phone].SHA256 != offers[tablet].SHA256 {
+		t.Errorf("offers = %+v, want separate transfers of the same image", offers)
+	}
+
+	// So are the files of a file list, and the image is withdrawn
+	path := filepath.Join(t.TempDir(), "notes.txt")
+	os.WriteFile(path, []byte("hello"), 0644)
+	payload, _ = clipboard.Content{MIME: protocol.MIMEURIList, Data: []byte("file://" + path + "\n")}.Payload()
+	eventBus.Publish(bus.Event{Type: protocol.MessageTypeClipboardChanged, Payload: payload})
+
+	var progress protocol.FileProgressPayload
+	expectMessage(t, phone, protocol.MessageTypeFileProgress).GetPayload(&progress)
+	if progress.ID != offers[phone].ID || progress.State != string(transfer.StateCancelled) {
+		t.Errorf("file.progress = %+v, want the image cancelled", progress)
+	}
+	var offer protocol.FileOfferPayload
+	expectMessage(t, phone, protocol.MessageTypeFileOffer).GetPayload(&offer)
+	var changed protocol.ClipboardPayload
+	expectMessage(t, phone, protocol.MessageTypeClipboardChanged).GetPayload(&changed)
+	if len(changed.Files) != 1 || changed.Files[0].Name != "notes.txt" || changed.Files[0].TransferID != offer.ID {
+		t.Errorf("clipboard.changed files = %+v, want notes.txt sent as %s", changed.Files, offer.ID)
+	}
+	if got, _ := transfers.Get(offers[tablet].ID); got.State != transfer.StateCancelled {
+		t.Errorf("tablet's image transfer = %s, want %s", got.State, transfer.StateCancelled)
+	}
+}
+
+func TestInput(t *testing.T) {
+	eventBus := bus.New()
+	defer eventBus.Close()
+
+	r := NewRouter(eventBus)
+	r.Start()
+	defer r.Stop()
+
+	rec := &input.Recorder{}
+	var allowed atomic.Bool
+	r.SetInput(input.NewInjector(rec), func(deviceID string) bool { return allowed.Load() })
+	phone := connectDevice(t, r, "mobile-1")
+
+	send := func(msgType protocol.MessageType, payload any) protocol.AckPayload {
+		t.Helper()
+		msg, _ := protocol.NewMessage(msgType, "mobile-1", payload)
+		phone.WriteJSON(msg)
+		var ack protocol.AckPayload
+		expectMessage(t, phone, protocol.MessageTypeAck).GetPayload(&ack)
+		return ack
+	}
+
+	// Nothing is typed until the user allows the device
+	if ack := send(protocol.MessageTypeInputKey, &protocol.InputKeyPayload{Key: "a"}); ack.OK || !strings.Contains(ack.Error, "permission denied") {
+		t.Errorf("input.key before allowing = %+v, want permission denied", ack)
+	}
+	if events := rec.Events(); len(events) != 0 {
+		t.Errorf("input before allowing emitted %v", events)
+	}
+
+	allowed.Store(true)
+	for _, tt := range []struct {
+		msgType protocol.MessageType
+		payload any
+	}{
+		{protocol.MessageTypeInputKey, &protocol.InputKeyPayload{Key: "a"}},
+		{protocol.MessageTypeInputText, &protocol.InputTextPayload{Text: "hi"}},
+		{protocol.MessageTypeInputMouse, &protocol.InputMousePayload{DX: 3, DY: 4}},
+		{protocol.MessageTypeInputScroll, &protocol.InputScrollPayload{DY: 1}},
+		{protocol.MessageTypeInputButton, &protocol.InputButtonPayload{Button: "left", Action: protocol.InputActionDown}},
+	} {
+		if ack := send(tt.msgType, tt.payload); !ack.OK {
+			t.Errorf("%s = %+v, want ok", tt.msgType, ack)
+		}
+	}
+	if ack := send(protocol.MessageTypeInputKey, &protocol.InputKeyPayload{Key: "hyper"}); ack.OK {
+		t.Error("input.key of an unknown key succeeded")
+	}
+	if events := rec.Events(); len(events) != 19 {
+		t.Errorf("input emitted %d events, want 19: %v", len(events), events)
+	}
+
+	// The button held down is released when the device goes away, but not
+	// the key another device holds
+	tablet := connectDevice(t, r, "tablet-1")
+	msg, _ := protocol.NewMessage(protocol.MessageTypeInputKey, "tablet-1", &protocol.InputKeyPayload{Key: "shift", Action: protocol.InputActionDown})
+	tablet.WriteJSON(msg)
+	expectMessage(t, tablet, protocol.MessageTypeAck)
+	rec.Events()
+	for _, conn := range r.connectedDevices() {
+		if conn.GetDeviceID() == "mobile-1" {
+			r.RemoveDeviceConnection(conn)
+		}
+	}
+	if events := rec.Events(); len(events) != 2 || events[0].Value != 0 {
+		t.Errorf("disconnecting emitted %v, want the button released", events)
+	}
+}
@@ -0,0 +1,86 @@
+package events
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"eco/internal/device"
+	"eco/internal/dispatch"
+	"eco/internal/protocol"
+	"eco/internal/sms"
+)
+
+// smsSyncTimeout bounds how long the phone may take to answer sms.sync
+const smsSyncTimeout = 30 * time.Second
+
+// ErrNoSMSStore is returned for text messages when the daemon keeps no
+// conversation store
+var ErrNoSMSStore = errors.New("text messages are not available")
+
+// SetSMSStore sets the store text messages from devices are kept in
+func (r *Router) SetSMSStore(store *sms.Store) {
+	r.smsStore = store
+}
+
+// handleSMSReceived stores a message the phone received or sent
+func (r *Router) handleSMSReceived(req *dispatch.Request, payload *protocol.SMSMessage) error {
+	if r.smsStore == nil {
+		return ErrNoSMSStore
+	}
+	if _, err := r.smsStore.Add(req.DeviceID, *payload); err != nil {
+		return fmt.Errorf("storing text message: %w", err)
+	}
+	return nil
+}
+
+// handleSMSSync stores messages the phone pushed without being asked, e.g.
+// after it was offline
+func (r *Router) handleSMSSync(req *dispatch.Request, payload *protocol.SMSSyncPayload) error {
+	if r.smsStore == nil {
+		return ErrNoSMSStore
+	}
+	if _, err := r.smsStore.Add(req.DeviceID, payload.Messages...); err != nil {
+		return fmt.Errorf("storing text messages: %w", err)
+	}
+	return nil
+}
+
+// SyncSMS asks the device on conn for the messages newer than the ones in
+// the store and stores them
+func (r *Router) SyncSMS(conn *device.Connection) error {
+	if r.smsStore == nil {
+		return ErrNoSMSStore
+	}
+
+	deviceID := conn.GetDeviceID()
+	msg, err := protocol.NewMessage(protocol.MessageTypeSMSSync, deviceID, &protocol.SMSSyncPayload{
+		Since: r.smsStore.Since(deviceID),
+	})
+	if err != nil {
+		return err
+	}
+	resp, err := conn.Request(msg, smsSyncTimeout)
+	if err != nil {
+		return err
+	}
+	if resp.Type != protocol.MessageTypeSMSSync {
+		return fmt.Errorf("device answered with %s", resp.Type)
+	}
+
+	var payload protocol.SMSSyncPayload
+	if err := json.Unmarshal(resp.Payload, &payload); err != nil {
+		return fmt.Errorf("invalid sms.sync answer: %w", err)
+	}
+	if err := payload.Validate(); err != nil {
+		return fmt.Errorf("invalid sms.sync answer: %w", err)
+	}
+	added, err := r.smsStore.Add(deviceID, payload.Messages...)
+	if err != nil {
+		return err
+	}
+	log.Printf("Router: Synced %d new text messages from %s", added, deviceID)
+	return nil
+}
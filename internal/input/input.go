@@ -0,0 +1,252 @@
+package input
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+
+	"eco/internal/protocol"
+)
+
+// Event is one input event, a struct input_event without the time
+type Event struct {
+	Type  uint16
+	Code  uint16
+	Value int32
+}
+
+// Device is a virtual keyboard and mouse the desktop takes input from
+type Device interface {
+	// Emit writes events to the device followed by a sync report, so they
+	// take effect together
+	Emit(events ...Event) error
+	Close() error
+}
+
+// Injector turns the input devices send into events of a Device. It keeps
+// track of the keys and buttons each device holds, so they can be released
+// when that device goes away.
+type Injector struct {
+	mu  sync.Mutex
+	dev Device
+	// held maps held keys and buttons to the devices holding them
+	held map[uint16]map[string]bool
+}
+
+// NewInjector creates an injector writing to dev
+func NewInjector(dev Device) *Injector {
+	return &Injector{
+		dev:  dev,
+		held: make(map[uint16]map[string]bool),
+	}
+}
+
+// Key presses the named key for deviceID with modifiers held around it, or
+// with action "down" or "up" only presses or releases them
+func (in *Injector) Key(deviceID, name string, mods []string, action string) error {
+	code, err := LookupKey(name)
+	if err != nil {
+		return err
+	}
+	codes := make([]uint16, 0, len(mods)+1)
+	for _, mod := range mods {
+		c, ok := modifiers[strings.ToLower(mod)]
+		if !ok {
+			return fmt.Errorf("unknown modifier %q", mod)
+		}
+		codes = append(codes, c)
+	}
+	codes = append(codes, code)
+
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	return in.press(deviceID, codes, action)
+}
+
+// Type types text as if on a US keyboard. Text with characters that
+// keyboard doesn't have is refused before anything is typed.
+func (in *Injector) Type(deviceID, text string) error {
+	var strokes [][]uint16
+	for _, r := range text {
+		code, shift, ok := charKey(r)
+		if !ok {
+			return fmt.Errorf("can't type %q", r)
+		}
+		if shift {
+			strokes = append(strokes, []uint16{keyLeftShift, code})
+		} else {
+			strokes = append(strokes, []uint16{code})
+		}
+	}
+
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	for _, codes := range strokes {
+		if err := in.press(deviceID, codes, protocol.InputActionPress); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Move moves the pointer by dx and dy
+func (in *Injector) Move(dx, dy int32) error {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	return in.dev.Emit(Event{evRel, relX, dx}, Event{evRel, relY, dy})
+}
+
+// Scroll turns the wheel by dx and dy notches, positive dy scrolling up
+func (in *Injector) Scroll(dx, dy int32) error {
+	var events []Event
+	if dy != 0 {
+		events = append(events, Event{evRel, relWheel, dy})
+	}
+	if dx != 0 {
+		events = append(events, Event{evRel, relHWheel, dx})
+	}
+	if len(events) == 0 {
+		return nil
+	}
+
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	return in.dev.Emit(events...)
+}
+
+// Button clicks the named mouse button for deviceID, or with action "down"
+// or "up" only presses or releases it
+func (in *Injector) Button(deviceID, name, action string) error {
+	code, ok := buttons[strings.ToLower(name)]
+	if !ok {
+		return fmt.Errorf("unknown mouse button %q", name)
+	}
+
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	return in.press(deviceID, []uint16{code}, action)
+}
+
+// Release releases the keys and buttons deviceID holds down. Those another
+// device holds as well stay down.
+func (in *Injector) Release(deviceID string) error {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+
+	var codes []uint16
+	for code, holders := range in.held {
+		if !holders[deviceID] {
+			continue
+		}
+		delete(holders, deviceID)
+		if len(holders) == 0 {
+			codes = append(codes, code)
+		}
+	}
+	return in.release(codes)
+}
+
+// ReleaseAll releases every key and button held down
+func (in *Injector) ReleaseAll() error {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+
+	codes := make([]uint16, 0, len(in.held))
+	for code := range in.held {
+		codes = append(codes, code)
+	}
+	return in.release(codes)
+}
+
+// Close releases what is held and closes the device
+func (in *Injector) Close() error {
+	in.ReleaseAll()
+	return in.dev.Close()
+}
+
+// press presses codes for deviceID in order and releases them in reverse, in
+// separate reports so the press isn't lost. A code another device holds
+// down as well stays down. Callers hold in.mu.
+func (in *Injector) press(deviceID string, codes []uint16, action string) error {
+	if action != protocol.InputActionUp {
+		events := make([]Event, 0, len(codes))
+		for _, code := range codes {
+			events = append(events, Event{evKey, code, 1})
+			if in.held[code] == nil {
+				in.held[code] = make(map[string]bool)
+			}
+			in.held[code][deviceID] = true
+		}
+		if err := in.dev.Emit(events...); err != nil {
+			return err
+		}
+	}
+	if action != protocol.InputActionDown {
+		events := make([]Event, 0, len(codes))
+		for _, code := range slices.Backward(codes) {
+			holders := in.held[code]
+			delete(holders, deviceID)
+			if len(holders) > 0 {
+				continue
+			}
+			events = append(events, Event{evKey, code, 0})
+			delete(in.held, code)
+		}
+		if len(events) > 0 {
+			return in.dev.Emit(events...)
+		}
+	}
+	return nil
+}
+
+// release releases codes, in order. Callers hold in.mu.
+func (in *Injector) release(codes []uint16) error {
+	if len(codes) == 0 {
+		return nil
+	}
+	slices.Sort(codes)
+	events := make([]Event, 0, len(codes))
+	for _, code := range codes {
+		events = append(events, Event{evKey, code, 0})
+		delete(in.held, code)
+	}
+	return in.dev.Emit(events...)
+}
+
+// Recorder is a Device that records the events emitted to it, each call
+// ending in a sync report as on a real device, for tests
+type Recorder struct {
+	mu     sync.Mutex
+	events []Event
+	closed bool
+}
+
+// Emit records events
+func (r *Recorder) Emit(events ...Event) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return fmt.Errorf("device closed")
+	}
+	r.events = append(r.events, events...)
+	r.events = append(r.events, Event{evSyn, synReport, 0})
+	return nil
+}
+
+// Close makes later Emit calls fail
+func (r *Recorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.closed = true
+	return nil
+}
+
+// Events returns the events recorded since the last call
+func (r *Recorder) Events() []Event {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	events := r.events
+	r.events = nil
+	return events
+}
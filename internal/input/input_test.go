@@ -0,0 +1,199 @@
+package input
+
+import (
+	"encoding/binary"
+	"reflect"
+	"testing"
+	"unsafe"
+)
+
+var syn = Event{evSyn, synReport, 0}
+
+func down(code uint16) Event { return Event{evKey, code, 1} }
+func up(code uint16) Event   { return Event{evKey, code, 0} }
+
+func TestKey(t *testing.T) {
+	tests := []struct {
+		name    string
+		key     string
+		mods    []string
+		action  string
+		want    []Event
+		wantErr bool
+	}{
+		{"Press", "A", nil, "", []Event{down(keyA), up(keyA)}, false},
+		{"Shortcut", "t", []string{"ctrl", "Shift"}, "press", []Event{down(keyLeftCtrl), down(keyLeftShift), down(keyT), up(keyT), up(keyLeftShift), up(keyLeftCtrl)}, false},
+		{"Down", "enter", nil, "down", []Event{down(keyEnter)}, false},
+		{"Up", "volumeup", nil, "up", []Event{up(keyVolumeUp)}, false},
+		{"Unknown key", "hyper", nil, "", nil, true},
+		{"Unknown modifier", "a", []string{"fn"}, "", nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := &Recorder{}
+			err := NewInjector(rec).Key("phone", tt.key, tt.mods, tt.action)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Key() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got := rec.Events(); !reflect.DeepEqual(keyEvents(got), tt.want) {
+				t.Errorf("Key() emitted %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestType(t *testing.T) {
+	rec := &Recorder{}
+	in := NewInjector(rec)
+
+	if err := in.Type("phone", "Hi!\n"); err != nil {
+		t.Fatalf("Type() error = %v", err)
+	}
+	want := []Event{
+		down(keyLeftShift), down(keyH), up(keyH), up(keyLeftShift),
+		down(keyI), up(keyI),
+		down(keyLeftShift), down(key1), up(key1), up(keyLeftShift),
+		down(keyEnter), up(keyEnter),
+	}
+	if got := keyEvents(rec.Events()); !reflect.DeepEqual(got, want) {
+		t.Errorf("Type() emitted %v, want %v", got, want)
+	}
+
+	if err := in.Type("phone", "ok ✓"); err == nil {
+		t.Error("Type() of a character missing from the keyboard succeeded")
+	}
+	if got := rec.Events(); len(got) != 0 {
+		t.Errorf("Type() of untypeable text emitted %v", got)
+	}
+}
+
+func TestPointer(t *testing.T) {
+	rec := &Recorder{}
+	in := NewInjector(rec)
+
+	in.Move(12, -4)
+	in.Scroll(0, -3)
+	in.Scroll(0, 0)
+	in.Button("phone", "left", "")
+	want := []Event{
+		{evRel, relX, 12}, {evRel, relY, -4}, syn,
+		{evRel, relWheel, -3}, syn,
+		down(btnLeft), syn, up(btnLeft), syn,
+	}
+	if got := rec.Events(); !reflect.DeepEqual(got, want) {
+		t.Errorf("emitted %v, want %v", got, want)
+	}
+	if err := in.Button("phone", "back", ""); err == nil {
+		t.Error("Button() of an unknown button succeeded")
+	}
+}
+
+func TestRelease(t *testing.T) {
+	rec := &Recorder{}
+	in := NewInjector(rec)
+
+	// A drag and a held shift from a phone that goes away, while a tablet
+	// holds ctrl and shift too
+	in.Button("phone", "left", "down")
+	in.Key("phone", "shift", nil, "down")
+	in.Key("phone", "a", nil, "")
+	in.Key("tablet", "ctrl", []string{"shift"}, "down")
+	rec.Events()
+
+	if err := in.Release("phone"); err != nil {
+		t.Fatalf("Release() error = %v", err)
+	}
+	want := []Event{up(btnLeft)}
+	if got := rec.Events(); !reflect.DeepEqual(keyEvents(got), want) {
+		t.Errorf("Release() emitted %v, want %v", got, want)
+	}
+	in.Release("phone")
+	if got := rec.Events(); len(got) != 0 {
+		t.Errorf("second Release() emitted %v", got)
+	}
+
+	if err := in.ReleaseAll(); err != nil {
+		t.Fatalf("ReleaseAll() error = %v", err)
+	}
+	want = []Event{up(keyLeftCtrl), up(keyLeftShift)}
+	if got := rec.Events(); !reflect.DeepEqual(keyEvents(got), want) {
+		t.Errorf("ReleaseAll() emitted %v, want %v", got, want)
+	}
+	in.Release("tablet")
+	if got := rec.Events(); len(got) != 0 {
+		t.Errorf("Release() after ReleaseAll() emitted %v", got)
+	}
+}
+
+func TestSharedKeys(t *testing.T) {
+	rec := &Recorder{}
+	in := NewInjector(rec)
+
+	// The phone lets go of shift and types a capital while the tablet
+	// still holds shift down
+	in.Key("tablet", "shift", nil, "down")
+	in.Key("phone", "shift", nil, "down")
+	rec.Events()
+	in.Key("phone", "shift", nil, "up")
+	in.Type("phone", "A")
+	want := []Event{down(keyLeftShift), down(keyA), up(keyA)}
+	if got := rec.Events(); !reflect.DeepEqual(keyEvents(got), want) {
+		t.Errorf("phone's keys emitted %v, want %v", got, want)
+	}
+
+	// Shift goes up once the tablet lets go too
+	in.Key("tablet", "shift", nil, "up")
+	want = []Event{up(keyLeftShift)}
+	if got := rec.Events(); !reflect.DeepEqual(keyEvents(got), want) {
+		t.Errorf("tablet's key up emitted %v, want %v", got, want)
+	}
+	in.ReleaseAll()
+	if got := rec.Events(); len(got) != 0 {
+		t.Errorf("ReleaseAll() with nothing held emitted %v", got)
+	}
+}
+
+func TestLookupKey(t *testing.T) {
+	tests := []struct {
+		name    string
+		want    uint16
+		wantErr bool
+	}{
+		{"a", keyA, false},
+		{"Z", keyZ, false},
+		{"Escape", keyEsc, false},
+		{"F12", keyF12, false},
+		{"/", keySlash, false},
+		{"", 0, true},
+		{"shift+a", 0, true},
+	}
+
+	for _, tt := range tests {
+		got, err := LookupKey(tt.name)
+		if got != tt.want || (err != nil) != tt.wantErr {
+			t.Errorf("LookupKey(%q) = %d, %v, want %d", tt.name, got, err, tt.want)
+		}
+	}
+}
+
+// The ioctl numbers and writes depend on the layout of the kernel structs
+func TestStructSizes(t *testing.T) {
+	if got := binary.Size(uinputSetup{}); got != 92 {
+		t.Errorf("uinput_setup is %d bytes, want 92", got)
+	}
+	if got, want := binary.Size(inputEvent{}), int(unsafe.Sizeof(inputEvent{})); got != want {
+		t.Errorf("input_event encodes as %d bytes, want %d", got, want)
+	}
+}
+
+// keyEvents drops the sync reports between key events
+func keyEvents(events []Event) []Event {
+	var keys []Event
+	for _, e := range events {
+		if e.Type == evKey {
+			keys = append(keys, e)
+		}
+	}
+	return keys
+}
@@ -0,0 +1,240 @@
+package input
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Event types and codes of linux/input-event-codes.h
+const (
+	evSyn = 0x00
+	evKey = 0x01
+	evRel = 0x02
+
+	synReport = 0
+
+	relX      = 0x00
+	relY      = 0x01
+	relHWheel = 0x06
+	relWheel  = 0x08
+
+	btnLeft   = 0x110
+	btnRight  = 0x111
+	btnMiddle = 0x112
+)
+
+// Key codes of linux/input-event-codes.h
+const (
+	keyEsc            = 1
+	key1              = 2
+	key2              = 3
+	key3              = 4
+	key4              = 5
+	key5              = 6
+	key6              = 7
+	key7              = 8
+	key8              = 9
+	key9              = 10
+	key0              = 11
+	keyMinus          = 12
+	keyEqual          = 13
+	keyBackspace      = 14
+	keyTab            = 15
+	keyQ              = 16
+	keyW              = 17
+	keyE              = 18
+	keyR              = 19
+	keyT              = 20
+	keyY              = 21
+	keyU              = 22
+	keyI              = 23
+	keyO              = 24
+	keyP              = 25
+	keyLeftBrace      = 26
+	keyRightBrace     = 27
+	keyEnter          = 28
+	keyLeftCtrl       = 29
+	keyA              = 30
+	keyS              = 31
+	keyD              = 32
+	keyF              = 33
+	keyG              = 34
+	keyH              = 35
+	keyJ              = 36
+	keyK              = 37
+	keyL              = 38
+	keySemicolon      = 39
+	keyApostrophe     = 40
+	keyGrave          = 41
+	keyLeftShift      = 42
+	keyBackslash      = 43
+	keyZ              = 44
+	keyX              = 45
+	keyC              = 46
+	keyV              = 47
+	keyB              = 48
+	keyN              = 49
+	keyM              = 50
+	keyComma          = 51
+	keyDot            = 52
+	keySlash          = 53
+	keyRightShift     = 54
+	keyLeftAlt        = 56
+	keySpace          = 57
+	keyCapsLock       = 58
+	keyF1             = 59
+	keyF2             = 60
+	keyF3             = 61
+	keyF4             = 62
+	keyF5             = 63
+	keyF6             = 64
+	keyF7             = 65
+	keyF8             = 66
+	keyF9             = 67
+	keyF10            = 68
+	keyNumLock        = 69
+	keyScrollLock     = 70
+	keyF11            = 87
+	keyF12            = 88
+	keyRightCtrl      = 97
+	keySysRq          = 99
+	keyRightAlt       = 100
+	keyHome           = 102
+	keyUp             = 103
+	keyPageUp         = 104
+	keyLeft           = 105
+	keyRight          = 106
+	keyEnd            = 107
+	keyDown           = 108
+	keyPageDown       = 109
+	keyInsert         = 110
+	keyDelete         = 111
+	keyMute           = 113
+	keyVolumeDown     = 114
+	keyVolumeUp       = 115
+	keyPause          = 119
+	keyLeftMeta       = 125
+	keyRightMeta      = 126
+	keyCompose        = 127
+	keyNextSong       = 163
+	keyPlayPause      = 164
+	keyPreviousSong   = 165
+	keyStopCD         = 166
+	keyBrightnessDown = 224
+	keyBrightnessUp   = 225
+)
+
+// keys maps the key names devices send to key codes
+var keys = map[string]uint16{
+	"a": keyA, "b": keyB, "c": keyC, "d": keyD, "e": keyE, "f": keyF, "g": keyG,
+	"h": keyH, "i": keyI, "j": keyJ, "k": keyK, "l": keyL, "m": keyM, "n": keyN,
+	"o": keyO, "p": keyP, "q": keyQ, "r": keyR, "s": keyS, "t": keyT, "u": keyU,
+	"v": keyV, "w": keyW, "x": keyX, "y": keyY, "z": keyZ,
+
+	"0": key0, "1": key1, "2": key2, "3": key3, "4": key4,
+	"5": key5, "6": key6, "7": key7, "8": key8, "9": key9,
+
+	"-": keyMinus, "=": keyEqual, "[": keyLeftBrace, "]": keyRightBrace,
+	";": keySemicolon, "'": keyApostrophe, "`": keyGrave, "\\": keyBackslash,
+	",": keyComma, ".": keyDot, "/": keySlash,
+
+	"f1": keyF1, "f2": keyF2, "f3": keyF3, "f4": keyF4, "f5": keyF5, "f6": keyF6,
+	"f7": keyF7, "f8": keyF8, "f9": keyF9, "f10": keyF10, "f11": keyF11, "f12": keyF12,
+
+	"esc": keyEsc, "escape": keyEsc,
+	"enter": keyEnter, "return": keyEnter,
+	"backspace":  keyBackspace,
+	"tab":        keyTab,
+	"space":      keySpace,
+	"capslock":   keyCapsLock,
+	"numlock":    keyNumLock,
+	"scrolllock": keyScrollLock,
+	"print":      keySysRq,
+	"pause":      keyPause,
+	"menu":       keyCompose,
+
+	"up": keyUp, "down": keyDown, "left": keyLeft, "right": keyRight,
+	"home": keyHome, "end": keyEnd, "pageup": keyPageUp, "pagedown": keyPageDown,
+	"insert": keyInsert, "delete": keyDelete,
+
+	"ctrl": keyLeftCtrl, "shift": keyLeftShift, "alt": keyLeftAlt, "meta": keyLeftMeta,
+	"super":     keyLeftMeta,
+	"rightctrl": keyRightCtrl, "rightshift": keyRightShift, "rightalt": keyRightAlt,
+	"rightmeta": keyRightMeta,
+
+	"mute": keyMute, "volumedown": keyVolumeDown, "volumeup": keyVolumeUp,
+	"playpause": keyPlayPause, "next": keyNextSong, "previous": keyPreviousSong,
+	"stop":           keyStopCD,
+	"brightnessdown": keyBrightnessDown, "brightnessup": keyBrightnessUp,
+}
+
+// modifiers are the keys that may be held around another key
+var modifiers = map[string]uint16{
+	"ctrl":  keyLeftCtrl,
+	"shift": keyLeftShift,
+	"alt":   keyLeftAlt,
+	"meta":  keyLeftMeta,
+	"super": keyLeftMeta,
+}
+
+// buttons maps the mouse button names devices send to button codes
+var buttons = map[string]uint16{
+	"left":   btnLeft,
+	"right":  btnRight,
+	"middle": btnMiddle,
+}
+
+// shifted are the characters typed with shift on a US keyboard, by the
+// character on the same key
+var shifted = map[rune]rune{
+	'!': '1', '@': '2', '#': '3', '$': '4', '%': '5',
+	'^': '6', '&': '7', '*': '8', '(': '9', ')': '0',
+	'_': '-', '+': '=', '{': '[', '}': ']', ':': ';',
+	'"': '\'', '~': '`', '|': '\\', '<': ',', '>': '.', '?': '/',
+}
+
+// LookupKey returns the code of a key name like "a", "enter" or "f5". Names
+// are case insensitive.
+func LookupKey(name string) (uint16, error) {
+	code, ok := keys[strings.ToLower(name)]
+	if !ok {
+		return 0, fmt.Errorf("unknown key %q", name)
+	}
+	return code, nil
+}
+
+// charKey returns the key that types r on a US keyboard and whether shift
+// is held for it
+func charKey(r rune) (code uint16, shift bool, ok bool) {
+	switch {
+	case r == '\n':
+		return keyEnter, false, true
+	case r == '\t':
+		return keyTab, false, true
+	case r == ' ':
+		return keySpace, false, true
+	case r >= 'A' && r <= 'Z':
+		return keys[string(r-'A'+'a')], true, true
+	}
+	if base, ok := shifted[r]; ok {
+		return keys[string(base)], true, true
+	}
+	if r < 0x80 {
+		code, ok := keys[string(r)]
+		return code, false, ok
+	}
+	return 0, false, false
+}
+
+// keyCodes lists every key the virtual keyboard can press
+func keyCodes() []uint16 {
+	codes := make([]uint16, 0, len(keys))
+	seen := make(map[uint16]bool)
+	for _, code := range keys {
+		if !seen[code] {
+			seen[code] = true
+			codes = append(codes, code)
+		}
+	}
+	return codes
+}
@@ -0,0 +1,124 @@
+package input
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// UinputPath is the device node virtual input devices are created through
+const UinputPath = "/dev/uinput"
+
+// DeviceName is what the virtual device is called on the desktop
+const DeviceName = "eco virtual input"
+
+// ioctls of linux/uinput.h
+const (
+	uiDevCreate  = 0x5501
+	uiDevDestroy = 0x5502
+	uiDevSetup   = 0x405c5503
+	uiSetEvBit   = 0x40045564
+	uiSetKeyBit  = 0x40045565
+	uiSetRelBit  = 0x40045566
+
+	busVirtual = 0x06
+)
+
+// uinputSetup is struct uinput_setup
+type uinputSetup struct {
+	BusType      uint16
+	Vendor       uint16
+	Product      uint16
+	Version      uint16
+	Name         [80]byte
+	FFEffectsMax uint32
+}
+
+// inputEvent is struct input_event
+type inputEvent struct {
+	Time  syscall.Timeval
+	Type  uint16
+	Code  uint16
+	Value int32
+}
+
+// Uinput is a virtual keyboard and mouse created through /dev/uinput
+type Uinput struct {
+	f *os.File
+}
+
+// OpenUinput creates the virtual device. Writing to /dev/uinput usually
+// takes a udev rule giving the user access, which the error suggests when
+// it is missing.
+func OpenUinput() (*Uinput, error) {
+	f, err := os.OpenFile(UinputPath, os.O_WRONLY, 0)
+	switch {
+	case errors.Is(err, fs.ErrPermission):
+		return nil, fmt.Errorf("%w; allow your user to write to it, e.g. with the udev rule KERNEL==\"uinput\", GROUP=\"input\", MODE=\"0660\" and membership of the input group", err)
+	case errors.Is(err, fs.ErrNotExist):
+		return nil, fmt.Errorf("%w; load the uinput kernel module with 'modprobe uinput'", err)
+	case err != nil:
+		return nil, err
+	}
+
+	u := &Uinput{f: f}
+	if err := u.setup(); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("creating virtual input device: %w", err)
+	}
+	return u, nil
+}
+
+// setup registers the keys, buttons and axes of the device and creates it
+func (u *Uinput) setup() error {
+	for _, bit := range []uintptr{evKey, evRel} {
+		if err := u.ioctl(uiSetEvBit, bit); err != nil {
+			return err
+		}
+	}
+	for _, code := range append(keyCodes(), btnLeft, btnRight, btnMiddle) {
+		if err := u.ioctl(uiSetKeyBit, uintptr(code)); err != nil {
+			return err
+		}
+	}
+	for _, axis := range []uintptr{relX, relY, relWheel, relHWheel} {
+		if err := u.ioctl(uiSetRelBit, axis); err != nil {
+			return err
+		}
+	}
+
+	setup := uinputSetup{BusType: busVirtual, Version: 1}
+	copy(setup.Name[:], DeviceName)
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, u.f.Fd(), uiDevSetup, uintptr(unsafe.Pointer(&setup))); errno != 0 {
+		return errno
+	}
+	return u.ioctl(uiDevCreate, 0)
+}
+
+// Emit writes events and a sync report in one write
+func (u *Uinput) Emit(events ...Event) error {
+	var buf bytes.Buffer
+	for _, e := range append(events, Event{evSyn, synReport, 0}) {
+		binary.Write(&buf, binary.NativeEndian, &inputEvent{Type: e.Type, Code: e.Code, Value: e.Value})
+	}
+	_, err := u.f.Write(buf.Bytes())
+	return err
+}
+
+// Close removes the virtual device
+func (u *Uinput) Close() error {
+	u.ioctl(uiDevDestroy, 0)
+	return u.f.Close()
+}
+
+func (u *Uinput) ioctl(req, arg uintptr) error {
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, u.f.Fd(), req, arg); errno != 0 {
+		return errno
+	}
+	return nil
+}
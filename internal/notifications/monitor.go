@@ -0,0 +1,309 @@
+package notifications
+
+import (
+	"errors"
+	"fmt"
+	"html"
+	"log"
+	"regexp"
+	"strings"
+	"sync"
+
+	"eco/internal/bus"
+	"eco/internal/protocol"
+
+	"github.com/godbus/dbus/v5"
+)
+
+// D-Bus names of the freedesktop notification service
+const (
+	Interface  = "org.freedesktop.Notifications"
+	ObjectPath = "/org/freedesktop/Notifications"
+)
+
+// AppName is the application name eco shows its own notifications under.
+// The monitor never forwards them, nor notifications mirrored from a device
+// (see HintMirrored), so they don't go back to the phone.
+const AppName = "Eco"
+
+// Urgency levels of the notification spec, as sent to devices
+const (
+	UrgencyLow      = "low"
+	UrgencyNormal   = "normal"
+	UrgencyCritical = "critical"
+)
+
+// notifyRule matches Notify calls to the notification service
+const notifyRule = "type='method_call',interface='" + Interface + "',member='Notify'"
+
+// Notification is a desktop notification, seen on the session bus or shown
+// by the Notifier
+type Notification struct {
+	App      string
+	Summary  string
+	Body     string
+	Icon     string
+	Urgency  string
+	Category string
+	Actions  []Action
+	// Replyable offers an inline reply, where the notification server
+	// supports it
+	Replyable bool
+	// Persistent notifications stay until they are closed instead of
+	// expiring
+	Persistent bool
+	// Device is the device a mirrored notification came from and ID the
+	// device's identifier for it; both are empty for desktop notifications
+	Device string
+	ID     string
+}
+
+// Action is a button on a notification. The action with ID "default" is
+// invoked by clicking the notification itself.
+type Action struct {
+	ID    string
+	Label string
+}
+
+// Key identifies a mirrored notification across updates, "" if it has no ID
+func (n *Notification) Key() string {
+	return Key(n.Device, n.ID)
+}
+
+// Key returns the key of the notification device calls id
+func Key(device, id string) string {
+	if id == "" {
+		return ""
+	}
+	return device + "/" + id
+}
+
+// Payload converts the notification for sending to devices
+func (n *Notification) Payload() *protocol.NotificationPayload {
+	return &protocol.NotificationPayload{
+		App:      n.App,
+		Title:    n.Summary,
+		Body:     n.Body,
+		Icon:     n.Icon,
+		Urgency:  n.Urgency,
+		Category: n.Category,
+	}
+}
+
+// Monitor watches the session bus for notifications shown on the desktop and
+// publishes them on the event bus as notification.push
+type Monitor struct {
+	mu      sync.Mutex
+	address string
+	bus     *bus.Bus
+	filter  *AppFilter
+	conn    *dbus.Conn
+	running bool
+}
+
+// NewMonitor creates a monitor for the D-Bus at address, or the session bus
+// if address is empty, publishing to eventBus
+func NewMonitor(eventBus *bus.Bus, address string) *Monitor {
+	return &Monitor{address: address, bus: eventBus}
+}
+
+// SetAppFilter limits which applications' notifications are forwarded. It
+// must be called before Start.
+func (m *Monitor) SetAppFilter(filter *AppFilter) {
+	m.filter = filter
+}
+
+// Start connects to the bus and begins monitoring. The connection is only
+// used for monitoring: a monitor can't send messages of its own.
+func (m *Monitor) Start() error {
+	conn, err := m.connect()
+	if err != nil {
+		return fmt.Errorf("connecting to D-Bus: %w", err)
+	}
+
+	if err := becomeMonitor(conn); err != nil {
+		conn.Close()
+		return err
+	}
+
+	messages := make(chan *dbus.Message, 32)
+	conn.Eavesdrop(messages)
+
+	m.mu.Lock()
+	m.conn = conn
+	m.running = true
+	m.mu.Unlock()
+
+	go func() {
+		for msg := range messages {
+			m.handle(msg)
+		}
+	}()
+	return nil
+}
+
+func (m *Monitor) connect() (*dbus.Conn, error) {
+	if m.address == "" {
+		return dbus.ConnectSessionBus()
+	}
+	return dbus.Connect(m.address)
+}
+
+// becomeMonitor turns conn into a monitor for Notify calls. Buses older than
+// dbus 1.9.10 lack BecomeMonitor and get an eavesdropping match rule instead.
+func becomeMonitor(conn *dbus.Conn) error {
+	call := conn.BusObject().Call("org.freedesktop.DBus.Monitoring.BecomeMonitor", 0, []string{notifyRule}, uint32(0))
+	if call.Err == nil {
+		return nil
+	}
+
+	err := conn.BusObject().Call("org.freedesktop.DBus.AddMatch", 0, notifyRule+",eavesdrop='true'").Err
+	if err != nil {
+		return fmt.Errorf("monitoring notifications: %w", errors.Join(call.Err, err))
+	}
+	return nil
+}
+
+// handle publishes msg if it is a notification worth forwarding
+func (m *Monitor) handle(msg *dbus.Message) {
+	n, err := ParseNotify(msg)
+	if err != nil {
+		return
+	}
+	if n.App == AppName || n.Device != "" {
+		return
+	}
+	if !m.filter.Allows(n.App) {
+		log.Printf("Notifications: Not forwarding notification from %q (filtered)", n.App)
+		return
+	}
+
+	err = m.bus.Publish(bus.Event{
+		Type:    protocol.MessageTypeNotificationPush,
+		Payload: n.Payload(),
+		Source:  "notifications",
+	})
+	if err != nil {
+		log.Printf("Notifications: Failed to publish notification: %v", err)
+	}
+}
+
+// Stop closes the monitoring connection
+func (m *Monitor) Stop() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if !m.running {
+		return nil
+	}
+	m.running = false
+	return m.conn.Close()
+}
+
+// IsRunning returns whether the monitor is active
+func (m *Monitor) IsRunning() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.running
+}
+
+// ParseNotify reads a notification from a Notify call, whose arguments are
+// app_name, replaces_id, app_icon, summary, body, actions, hints and
+// expire_timeout
+func ParseNotify(msg *dbus.Message) (*Notification, error) {
+	if msg.Type != dbus.TypeMethodCall {
+		return nil, errors.New("not a method call")
+	}
+	iface, _ := msg.Headers[dbus.FieldInterface].Value().(string)
+	member, _ := msg.Headers[dbus.FieldMember].Value().(string)
+	if iface != Interface || member != "Notify" {
+		return nil, fmt.Errorf("not a notification: %s.%s", iface, member)
+	}
+
+	var (
+		app, icon, summary, body string
+		replacesID               uint32
+		actions                  []string
+		hints                    map[string]dbus.Variant
+		timeout                  int32
+	)
+	if err := dbus.Store(msg.Body, &app, &replacesID, &icon, &summary, &body, &actions, &hints, &timeout); err != nil {
+		return nil, fmt.Errorf("invalid Notify arguments: %w", err)
+	}
+
+	n := &Notification{
+		App:      app,
+		Summary:  summary,
+		Body:     stripMarkup(body),
+		Icon:     icon,
+		Urgency:  UrgencyNormal,
+		Category: stringHint(hints, "category"),
+		Device:   stringHint(hints, HintMirrored),
+	}
+	for i := 0; i+1 < len(actions); i += 2 {
+		n.Actions = append(n.Actions, Action{ID: actions[i], Label: actions[i+1]})
+	}
+	if n.Icon == "" {
+		n.Icon = stringHint(hints, "image-path", "image_path")
+	}
+	if u, ok := hints["urgency"].Value().(byte); ok {
+		switch u {
+		case 0:
+			n.Urgency = UrgencyLow
+		case 2:
+			n.Urgency = UrgencyCritical
+		}
+	}
+	return n, nil
+}
+
+// stringHint returns the first of names that is a string hint
+func stringHint(hints map[string]dbus.Variant, names ...string) string {
+	for _, name := range names {
+		if s, ok := hints[name].Value().(string); ok && s != "" {
+			return s
+		}
+	}
+	return ""
+}
+
+var markupTag = regexp.MustCompile(`<[^>]*>`)
+
+// stripMarkup removes the simple HTML-like markup notification bodies may
+// contain, which devices would show literally
+func stripMarkup(body string) string {
+	return strings.TrimSpace(html.UnescapeString(markupTag.ReplaceAllString(body, "")))
+}
+
+// AppFilter decides which applications' notifications are forwarded
+type AppFilter struct {
+	allow map[string]bool
+	deny  map[string]bool
+}
+
+// NewAppFilter forwards only the apps in allow, if it isn't empty, and never
+// the apps in deny. Names are matched without regard to case.
+func NewAppFilter(allow, deny []string) *AppFilter {
+	return &AppFilter{allow: appSet(allow), deny: appSet(deny)}
+}
+
+// Allows reports whether notifications from app are forwarded. A nil filter
+// allows everything.
+func (f *AppFilter) Allows(app string) bool {
+	if f == nil {
+		return true
+	}
+	app = strings.ToLower(app)
+	if f.deny[app] {
+		return false
+	}
+	return len(f.allow) == 0 || f.allow[app]
+}
+
+func appSet(apps []string) map[string]bool {
+	set := make(map[string]bool, len(apps))
+	for _, app := range apps {
+		set[strings.ToLower(strings.TrimSpace(app))] = true
+	}
+	return set
+}
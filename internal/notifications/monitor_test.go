@@ -0,0 +1,274 @@
+package notifications
+
+import (
+	"bufio"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"eco/internal/bus"
+	"eco/internal/protocol"
+
+	"github.com/godbus/dbus/v5"
+)
+
+// busConfig is a session bus that lets the test user do anything
+const busConfig = `<!DOCTYPE busconfig PUBLIC "-//freedesktop//DTD D-Bus Bus Configuration 1.0//EN"
+ "http://www.freedesktop.org/standards/dbus/1.0/busconfig.dtd">
+<busconfig>
+  <type>session</type>
+  <listen>unix:dir=%s</listen>
+  <policy context="default">
+    <allow send_destination="*" eavesdrop="true"/>
+    <allow eavesdrop="true"/>
+    <allow own="*"/>
+  </policy>
+</busconfig>
+`
+
+// privateBus starts a dbus-daemon for the test and returns its address
+func privateBus(t *testing.T) string {
+	t.Helper()
+
+	daemon, err := exec.LookPath("dbus-daemon")
+	if err != nil {
+		t.Skip("dbus-daemon not found")
+	}
+
+	dir := t.TempDir()
+	configPath := filepath.Join(dir, "bus.conf")
+	if err := os.WriteFile(configPath, []byte(strings.Replace(busConfig, "%s", dir, 1)), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	cmd := exec.Command(daemon, "--config-file="+configPath, "--nofork", "--print-address")
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("starting dbus-daemon: %v", err)
+	}
+	t.Cleanup(func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	})
+
+	address, err := bufio.NewReader(stdout).ReadString('\n')
+	if err != nil {
+		t.Fatalf("reading the bus address: %v", err)
+	}
+	return strings.TrimSpace(address)
+}
+
+// notifyCall is a Notify call received by notificationServer
+type notifyCall struct {
+	app, icon, summary, body string
+	replacesID               uint32
+	actions                  []string
+	hints                    map[string]dbus.Variant
+	timeout                  int32
+}
+
+// notificationServer answers Notify like a desktop notification daemon
+type notificationServer struct {
+	conn         *dbus.Conn
+	capabilities []string
+	mu           sync.Mutex
+	next         uint32
+	calls        []notifyCall
+	closed       []uint32
+}
+
+func (s *notificationServer) GetCapabilities() ([]string, *dbus.Error) {
+	return s.capabilities, nil
+}
+
+func (s *notificationServer) CloseNotification(id uint32) *dbus.Error {
+	s.mu.Lock()
+	s.closed = append(s.closed, id)
+	s.mu.Unlock()
+	s.conn.Emit(ObjectPath, Interface+".NotificationClosed", id, uint32(ClosedByCall))
+	return nil
+}
+
+func (s *notificationServer) Notify(app string, replacesID uint32, icon, summary, body string, actions []string, hints map[string]dbus.Variant, timeout int32) (uint32, *dbus.Error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.calls = append(s.calls, notifyCall{app, icon, summary, body, replacesID, actions, hints, timeout})
+	if replacesID != 0 {
+		return replacesID, nil
+	}
+	s.next++
+	return s.next, nil
+}
+
+// lastCall returns the last Notify call received
+func (s *notificationServer) lastCall(t *testing.T) notifyCall {
+	t.Helper()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.calls) == 0 {
+		t.Fatal("no Notify call received")
+	}
+	return s.calls[len(s.calls)-1]
+}
+
+// emit sends a signal of the notification server
+func (s *notificationServer) emit(t *testing.T, name string, args ...any) {
+	t.Helper()
+	if err := s.conn.Emit(ObjectPath, Interface+"."+name, args...); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// connect opens a connection to address, closed when the test ends
+func connect(t *testing.T, address string) *dbus.Conn {
+	t.Helper()
+	conn, err := dbus.Connect(address)
+	if err != nil {
+		t.Fatalf("Connect() error = %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// serveNotifications registers a notification server with capabilities on
+// the bus at address
+func serveNotifications(t *testing.T, address string, capabilities ...string) *notificationServer {
+	t.Helper()
+
+	server := &notificationServer{conn: connect(t, address), capabilities: capabilities}
+	if err := server.conn.Export(server, ObjectPath, Interface); err != nil {
+		t.Fatal(err)
+	}
+	reply, err := server.conn.RequestName(Interface, dbus.NameFlagDoNotQueue)
+	if err != nil || reply != dbus.RequestNameReplyPrimaryOwner {
+		t.Fatalf("RequestName() = %v, %v", reply, err)
+	}
+	return server
+}
+
+// notify shows a notification over the bus at address, like notify-send
+func notify(t *testing.T, conn *dbus.Conn, app, icon, summary, body string, hints map[string]dbus.Variant) {
+	t.Helper()
+
+	obj := conn.Object(Interface, ObjectPath)
+	call := obj.Call(Interface+".Notify", 0, app, uint32(0), icon, summary, body, []string{}, hints, int32(-1))
+	if call.Err != nil {
+		t.Fatalf("Notify() error = %v", call.Err)
+	}
+}
+
+func TestMonitor(t *testing.T) {
+	address := privateBus(t)
+	serveNotifications(t, address)
+
+	eventBus := bus.New()
+	defer eventBus.Close()
+	sub := eventBus.Subscribe("test", 8)
+
+	m := NewMonitor(eventBus, address)
+	m.SetAppFilter(NewAppFilter(nil, []string{"Spotify"}))
+	if err := m.Start(); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	defer m.Stop()
+
+	client := connect(t, address)
+	notify(t, client, "Spotify", "", "Now playing", "Song", nil)
+	notify(t, client, AppName, "", "Eco daemon started", "", nil)
+	notify(t, client, "Signal", "", "From the phone", "mirrored", map[string]dbus.Variant{
+		HintMirrored: dbus.MakeVariant("phone-1"),
+	})
+	notify(t, client, "Slack", "slack", "New message", "<b>Ana</b>: lunch &amp; coffee?", map[string]dbus.Variant{
+		"urgency": dbus.MakeVariant(byte(2)),
+	})
+
+	select {
+	case event := <-sub.Events():
+		got := event.Payload.(*protocol.NotificationPayload)
+		want := protocol.NotificationPayload{App: "Slack", Title: "New message", Body: "Ana: lunch & coffee?", Icon: "slack", Urgency: UrgencyCritical}
+		if event.Type != protocol.MessageTypeNotificationPush || !reflect.DeepEqual(*got, want) {
+			t.Errorf("event = %s %+v, want notification.push %+v", event.Type, *got, want)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("timed out waiting for the notification")
+	}
+
+	select {
+	case event := <-sub.Events():
+		t.Errorf("unexpected event %+v", event.Payload)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	if err := m.Stop(); err != nil || m.IsRunning() {
+		t.Errorf("Stop() error = %v, IsRunning() = %v", err, m.IsRunning())
+	}
+}
+
+func TestParseNotify(t *testing.T) {
+	msg := &dbus.Message{
+		Type: dbus.TypeMethodCall,
+		Headers: map[dbus.HeaderField]dbus.Variant{
+			dbus.FieldInterface: dbus.MakeVariant(Interface),
+			dbus.FieldMember:    dbus.MakeVariant("Notify"),
+		},
+		Body: []any{"Mail", uint32(0), "", "Inbox", "1 new message", []string{}, map[string]dbus.Variant{
+			"urgency":    dbus.MakeVariant(byte(0)),
+			"image-path": dbus.MakeVariant("/usr/share/icons/mail.png"),
+		}, int32(5000)},
+	}
+
+	n, err := ParseNotify(msg)
+	if err != nil {
+		t.Fatalf("ParseNotify() error = %v", err)
+	}
+	if n.App != "Mail" || n.Summary != "Inbox" || n.Urgency != UrgencyLow || n.Icon != "/usr/share/icons/mail.png" {
+		t.Errorf("ParseNotify() = %+v", n)
+	}
+
+	msg.Headers[dbus.FieldMember] = dbus.MakeVariant("CloseNotification")
+	if _, err := ParseNotify(msg); err == nil {
+		t.Error("ParseNotify() of CloseNotification error = nil, want an error")
+	}
+	msg.Headers[dbus.FieldMember] = dbus.MakeVariant("Notify")
+	msg.Body = []any{"too", "few"}
+	if _, err := ParseNotify(msg); err == nil {
+		t.Error("ParseNotify() with bad arguments error = nil, want an error")
+	}
+}
+
+func TestAppFilter(t *testing.T) {
+	tests := []struct {
+		name  string
+		allow []string
+		deny  []string
+		app   string
+		want  bool
+	}{
+		{"No lists", nil, nil, "Slack", true},
+		{"Denied", nil, []string{"slack"}, "Slack", false},
+		{"Allowed", []string{"Slack", "Signal"}, nil, "signal", true},
+		{"Not allowed", []string{"Slack"}, nil, "Spotify", false},
+		{"Denied wins", []string{"Slack"}, []string{"Slack"}, "Slack", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := NewAppFilter(tt.allow, tt.deny).Allows(tt.app); got != tt.want {
+				t.Errorf("Allows(%q) = %v, want %v", tt.app, got, tt.want)
+			}
+		})
+	}
+
+	var none *AppFilter
+	if !none.Allows("anything") {
+		t.Error("nil AppFilter Allows() = false, want true")
+	}
+}
@@ -1,33 +1,326 @@
 package notifications
 
 import (
-	// "fmt"
-	"os/exec"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+
+	"github.com/godbus/dbus/v5"
+)
+
+// HintMirrored marks notifications eco shows for a device, so the monitor
+// doesn't send them back
+const HintMirrored = "x-eco-mirrored"
+
+// ActionInlineReply is the action of a notification the user can reply to
+// inline, as the notification server reports with NotificationReplied. It is
+// supported by KDE Plasma.
+const ActionInlineReply = "inline-reply"
+
+// ErrUnavailable is returned when there is no notification server to show
+// notifications with
+var ErrUnavailable = errors.New("no desktop notification server")
+
+// CloseReason is why the notification server closed a notification
+type CloseReason uint32
+
+// Close reasons of the notification spec
+const (
+	ClosedExpired   CloseReason = 1
+	ClosedDismissed CloseReason = 2
+	ClosedByCall    CloseReason = 3
+	ClosedUndefined CloseReason = 4
 )
 
-// Send displays a desktop notification using notify-send
-func Send(title, body string) error {
-	if IsAvailable() {
-		cmd := exec.Command("notify-send", title, body)
-		return cmd.Run()
+// String returns the reason as sent to devices
+func (r CloseReason) String() string {
+	switch r {
+	case ClosedExpired:
+		return "expired"
+	case ClosedDismissed:
+		return "dismissed"
+	case ClosedByCall:
+		return "closed"
 	}
-	return nil
+	return "undefined"
 }
 
-// SendWithIcon displays a notification with an icon
-func SendWithIcon(title, body, iconPath string) error {
-	if IsAvailable() {
-		cmd := exec.Command("notify-send", "--icon", iconPath, title, body)
-		return cmd.Run()
+// Notifier shows notifications through the desktop notification server and
+// reports what the user does with them
+type Notifier struct {
+	mu      sync.Mutex
+	address string
+	conn    *dbus.Conn
+	// shown are the notifications on screen by server ID, keys their IDs by
+	// Notification.Key
+	shown map[uint32]*Notification
+	keys  map[string]uint32
+	// inlineReply is set when the server can take a reply in the notification
+	inlineReply bool
+
+	onAction func(n *Notification, action string)
+	onClosed func(n *Notification, reason CloseReason)
+	onReply  func(n *Notification, text string)
+}
+
+// NewNotifier creates a notifier for the D-Bus at address, or the session
+// bus if address is empty
+func NewNotifier(address string) *Notifier {
+	return &Notifier{
+		address: address,
+		shown:   make(map[uint32]*Notification),
+		keys:    make(map[string]uint32),
 	}
+}
+
+// OnAction sets the function called when the user invokes one of a
+// notification's actions. It must be called before Connect.
+func (n *Notifier) OnAction(fn func(notification *Notification, action string)) {
+	n.onAction = fn
+}
+
+// OnClosed sets the function called when a notification goes away. It must
+// be called before Connect.
+func (n *Notifier) OnClosed(fn func(notification *Notification, reason CloseReason)) {
+	n.onClosed = fn
+}
+
+// OnReply sets the function called when the user replies inline to a
+// Replyable notification. It must be called before Connect.
+func (n *Notifier) OnReply(fn func(notification *Notification, text string)) {
+	n.onReply = fn
+}
+
+// Connect connects to the bus and starts listening for the notification
+// server's signals
+func (n *Notifier) Connect() error {
+	var conn *dbus.Conn
+	var err error
+	if n.address == "" {
+		conn, err = dbus.ConnectSessionBus()
+	} else {
+		conn, err = dbus.Connect(n.address)
+	}
+	if err != nil {
+		return fmt.Errorf("connecting to D-Bus: %w", err)
+	}
+
+	err = conn.AddMatchSignal(dbus.WithMatchInterface(Interface), dbus.WithMatchObjectPath(ObjectPath))
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("watching notification signals: %w", err)
+	}
+
+	signals := make(chan *dbus.Signal, 32)
+	conn.Signal(signals)
+
+	// Servers that aren't running yet can't answer; they get no inline replies
+	var capabilities []string
+	conn.Object(Interface, ObjectPath).Call(Interface+".GetCapabilities", 0).Store(&capabilities)
+
+	n.mu.Lock()
+	n.conn = conn
+	n.inlineReply = slices.Contains(capabilities, ActionInlineReply)
+	n.mu.Unlock()
+
+	go func() {
+		for signal := range signals {
+			n.handleSignal(signal)
+		}
+	}()
 	return nil
 }
 
-// IsAvailable checks if notify-send command exists
-func IsAvailable() bool {
-	_, err := exec.LookPath("notify-send")
+// Show displays notification, replacing the one shown earlier with the same
+// key. It returns the ID the notification server gave it.
+func (n *Notifier) Show(notification *Notification) (uint32, error) {
+	n.mu.Lock()
+	conn := n.conn
+	inlineReply := n.inlineReply && notification.Replyable
+	replaces := uint32(0)
+	key := notification.Key()
+	if key != "" {
+		replaces = n.keys[key]
+	}
+	n.mu.Unlock()
+
+	if conn == nil {
+		return 0, ErrUnavailable
+	}
+
+	app := notification.App
+	if app == "" {
+		app = AppName
+	}
+	actions := make([]string, 0, 2*len(notification.Actions)+2)
+	for _, a := range notification.Actions {
+		actions = append(actions, a.ID, a.Label)
+	}
+	hints := notification.hints()
+	if inlineReply {
+		actions = append(actions, ActionInlineReply, "Reply")
+		hints["x-kde-reply-placeholder-text"] = dbus.MakeVariant("Reply to " + app)
+	}
+
+	timeout := int32(-1)
+	if notification.Persistent {
+		timeout = 0
+	}
+
+	var id uint32
+	obj := conn.Object(Interface, ObjectPath)
+	err := obj.Call(Interface+".Notify", 0,
+		app, replaces, desktopIcon(notification.Icon), notification.Summary, notification.Body,
+		actions, hints, timeout).Store(&id)
 	if err != nil {
-		return false
+		return 0, fmt.Errorf("showing notification: %w", err)
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if replaces != 0 && replaces != id {
+		delete(n.shown, replaces)
+	}
+	n.shown[id] = notification
+	if key != "" {
+		n.keys[key] = id
+	}
+	return id, nil
+}
+
+// Close withdraws the notification shown with key, e.g. because it was
+// dismissed on the device. Nothing is reported for it afterwards.
+func (n *Notifier) Close(key string) error {
+	n.mu.Lock()
+	conn := n.conn
+	id, ok := n.keys[key]
+	if ok {
+		delete(n.keys, key)
+		delete(n.shown, id)
+	}
+	n.mu.Unlock()
+
+	if conn == nil {
+		return ErrUnavailable
+	}
+	if !ok {
+		return nil
+	}
+	return conn.Object(Interface, ObjectPath).Call(Interface+".CloseNotification", 0, id).Err
+}
+
+// hints returns the Notify hints for the notification
+func (n *Notification) hints() map[string]dbus.Variant {
+	hints := map[string]dbus.Variant{
+		"urgency": dbus.MakeVariant(urgencyLevel(n.Urgency)),
+	}
+	if n.Category != "" {
+		hints["category"] = dbus.MakeVariant(n.Category)
+	}
+	if n.Device != "" {
+		hints[HintMirrored] = dbus.MakeVariant(n.Device)
+	}
+	return hints
+}
+
+// urgencyLevel converts an urgency name to the byte in the urgency hint
+func urgencyLevel(urgency string) byte {
+	switch urgency {
+	case UrgencyLow:
+		return 0
+	case UrgencyCritical:
+		return 2
+	}
+	return 1
+}
+
+// desktopIcon returns icon unless it is a path to a file that isn't here,
+// like one on the phone a notification came from
+func desktopIcon(icon string) string {
+	if filepath.IsAbs(icon) {
+		if _, err := os.Stat(icon); err != nil {
+			return ""
+		}
+	}
+	return icon
+}
+
+// handleSignal reports ActionInvoked, NotificationReplied and
+// NotificationClosed for the notifications eco showed
+func (n *Notifier) handleSignal(signal *dbus.Signal) {
+	var id uint32
+	switch signal.Name {
+	case Interface + ".NotificationReplied":
+		var text string
+		if err := dbus.Store(signal.Body, &id, &text); err != nil {
+			return
+		}
+		n.mu.Lock()
+		notification := n.shown[id]
+		n.mu.Unlock()
+		if notification != nil && n.onReply != nil {
+			n.onReply(notification, text)
+		}
+
+	case Interface + ".ActionInvoked":
+		var action string
+		if err := dbus.Store(signal.Body, &id, &action); err != nil {
+			return
+		}
+		n.mu.Lock()
+		notification := n.shown[id]
+		n.mu.Unlock()
+		if notification != nil && n.onAction != nil {
+			n.onAction(notification, action)
+		}
+
+	case Interface + ".NotificationClosed":
+		var reason uint32
+		if err := dbus.Store(signal.Body, &id, &reason); err != nil {
+			return
+		}
+		n.mu.Lock()
+		notification := n.shown[id]
+		delete(n.shown, id)
+		if notification != nil && n.keys[notification.Key()] == id {
+			delete(n.keys, notification.Key())
+		}
+		n.mu.Unlock()
+		if notification != nil && n.onClosed != nil {
+			n.onClosed(notification, CloseReason(reason))
+		}
+	}
+}
+
+// IsAvailable reports whether the notifier is connected to a bus
+func (n *Notifier) IsAvailable() bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.conn != nil
+}
+
+// Disconnect disconnects from the bus
+func (n *Notifier) Disconnect() error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.conn == nil {
+		return nil
+	}
+	err := n.conn.Close()
+	n.conn = nil
+	return err
+}
+
+// ShowStatus shows a notification from eco itself, e.g. that the daemon
+// started. Failures are only logged.
+func (n *Notifier) ShowStatus(summary, body string) {
+	_, err := n.Show(&Notification{App: AppName, Summary: summary, Body: body})
+	if err != nil && !errors.Is(err, ErrUnavailable) {
+		log.Printf("Notifications: %v", err)
 	}
-	return true
 }
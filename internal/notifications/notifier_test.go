@@ -0,0 +1,187 @@
+package notifications
+
+import (
+	"errors"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestNotifier(t *testing.T) {
+	address := privateBus(t)
+	server := serveNotifications(t, address)
+
+	actions := make(chan string, 1)
+	closed := make(chan CloseReason, 1)
+	n := NewNotifier(address)
+	n.OnAction(func(notification *Notification, action string) {
+		actions <- notification.Key() + " " + action
+	})
+	n.OnClosed(func(notification *Notification, reason CloseReason) {
+		closed <- reason
+	})
+	if err := n.Connect(); err != nil {
+		t.Fatalf("Connect() error = %v", err)
+	}
+	defer n.Disconnect()
+
+	message := &Notification{
+		App:      "Signal",
+		Summary:  "Ana",
+		Body:     "lunch?",
+		Urgency:  UrgencyCritical,
+		Category: "im.received",
+		Actions:  []Action{{ID: "default", Label: "Open"}, {ID: "mark-read", Label: "Mark as read"}},
+		Device:   "phone-1",
+		ID:       "42",
+	}
+	id, err := n.Show(message)
+	if err != nil {
+		t.Fatalf("Show() error = %v", err)
+	}
+
+	call := server.lastCall(t)
+	if call.app != "Signal" || call.summary != "Ana" || call.replacesID != 0 {
+		t.Errorf("Notify(%q, %d, %q), want Notify(\"Signal\", 0, \"Ana\")", call.app, call.replacesID, call.summary)
+	}
+	if call.timeout != -1 {
+		t.Errorf("timeout = %d, want -1", call.timeout)
+	}
+	if want := []string{"default", "Open", "mark-read", "Mark as read"}; !slices.Equal(call.actions, want) {
+		t.Errorf("actions = %v, want %v", call.actions, want)
+	}
+	if call.hints["urgency"].Value() != byte(2) || call.hints["category"].Value() != "im.received" || call.hints[HintMirrored].Value() != "phone-1" {
+		t.Errorf("hints = %v", call.hints)
+	}
+
+	// An update with the same key replaces the notification on screen
+	update := *message
+	update.Body = "lunch at 1?"
+	if _, err := n.Show(&update); err != nil {
+		t.Fatalf("Show() error = %v", err)
+	}
+	if call := server.lastCall(t); call.replacesID != id {
+		t.Errorf("update replaces %d, want %d", call.replacesID, id)
+	}
+
+	server.emit(t, "ActionInvoked", id, "mark-read")
+	select {
+	case got := <-actions:
+		if got != "phone-1/42 mark-read" {
+			t.Errorf("OnAction got %q, want %q", got, "phone-1/42 mark-read")
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("timed out waiting for ActionInvoked")
+	}
+
+	server.emit(t, "NotificationClosed", id, uint32(ClosedDismissed))
+	select {
+	case reason := <-closed:
+		if reason != ClosedDismissed {
+			t.Errorf("OnClosed got %v, want %v", reason, ClosedDismissed)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("timed out waiting for NotificationClosed")
+	}
+
+	// Once closed, the key shows a new notification
+	if _, err := n.Show(message); err != nil {
+		t.Fatalf("Show() error = %v", err)
+	}
+	if call := server.lastCall(t); call.replacesID != 0 {
+		t.Errorf("Show() after close replaces %d, want 0", call.replacesID)
+	}
+}
+
+func TestNotifierReplyAndClose(t *testing.T) {
+	address := privateBus(t)
+	server := serveNotifications(t, address, "actions", "body", ActionInlineReply)
+
+	replies := make(chan string, 1)
+	closed := make(chan CloseReason, 1)
+	n := NewNotifier(address)
+	n.OnReply(func(notification *Notification, text string) {
+		replies <- notification.ID + " " + text
+	})
+	n.OnClosed(func(notification *Notification, reason CloseReason) {
+		closed <- reason
+	})
+	if err := n.Connect(); err != nil {
+		t.Fatalf("Connect() error = %v", err)
+	}
+	defer n.Disconnect()
+
+	id, err := n.Show(&Notification{App: "Signal", Summary: "Ana", Body: "lunch?", Replyable: true, Device: "phone-1", ID: "42"})
+	if err != nil {
+		t.Fatalf("Show() error = %v", err)
+	}
+	call := server.lastCall(t)
+	if !slices.Contains(call.actions, ActionInlineReply) || call.hints["x-kde-reply-placeholder-text"].Value() != "Reply to Signal" {
+		t.Errorf("Notify() actions = %v, hints = %v, want an inline reply", call.actions, call.hints)
+	}
+
+	server.emit(t, "NotificationReplied", id, "at 1")
+	select {
+	case got := <-replies:
+		if got != "42 at 1" {
+			t.Errorf("OnReply got %q, want %q", got, "42 at 1")
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("timed out waiting for NotificationReplied")
+	}
+
+	// Closing for the device withdraws the notification without reporting
+	// it back
+	if err := n.Close(Key("phone-1", "42")); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	server.mu.Lock()
+	withdrawn := slices.Contains(server.closed, id)
+	server.mu.Unlock()
+	if !withdrawn {
+		t.Errorf("CloseNotification(%d) not called", id)
+	}
+	select {
+	case reason := <-closed:
+		t.Errorf("OnClosed called with %v after Close()", reason)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	// Messages that can't be answered get no reply action
+	if _, err := n.Show(&Notification{App: "Mail", Summary: "Inbox", Persistent: true, Device: "phone-1", ID: "43"}); err != nil {
+		t.Fatalf("Show() error = %v", err)
+	}
+	call = server.lastCall(t)
+	if slices.Contains(call.actions, ActionInlineReply) {
+		t.Errorf("Notify() actions = %v, want no inline reply", call.actions)
+	}
+	if call.timeout != 0 {
+		t.Errorf("persistent notification timeout = %d, want 0", call.timeout)
+	}
+}
+
+func TestNotifierUnavailable(t *testing.T) {
+	n := NewNotifier("")
+	if _, err := n.Show(&Notification{Summary: "Hello"}); !errors.Is(err, ErrUnavailable) {
+		t.Errorf("Show() before Connect error = %v, want %v", err, ErrUnavailable)
+	}
+}
+
+func TestCloseReasonString(t *testing.T) {
+	tests := []struct {
+		reason CloseReason
+		want   string
+	}{
+		{ClosedExpired, "expired"},
+		{ClosedDismissed, "dismissed"},
+		{ClosedByCall, "closed"},
+		{ClosedUndefined, "undefined"},
+		{CloseReason(9), "undefined"},
+	}
+
+	for _, tt := range tests {
+		if got := tt.reason.String(); got != tt.want {
+			t.Errorf("CloseReason(%d).String() = %q, want %q", tt.reason, got, tt.want)
+		}
+	}
+}
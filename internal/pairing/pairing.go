@@ -0,0 +1,278 @@
+package pairing
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"eco/internal/config"
+	"eco/internal/crypto"
+)
+
+// Identities bound into every SPAKE2 exchange
+var (
+	DeviceIdentity = []byte("eco-device")
+	DaemonIdentity = []byte("eco-daemon")
+)
+
+// DeviceKeyPurpose is the HKDF label for the long-term device key
+const DeviceKeyPurpose = "eco device key v1"
+
+// DefaultTTL is how long a pairing code stays valid
+const DefaultTTL = 5 * time.Minute
+
+// ExchangeTimeout is how long a device has to finish the exchange it
+// started, whatever is left of the code's TTL
+const ExchangeTimeout = time.Minute
+
+// State is the progress of a pairing session
+type State string
+
+const (
+	StatePending    State = "pending"
+	StateExchanging State = "exchanging"
+	StatePaired     State = "paired"
+	StateFailed     State = "failed"
+	StateExpired    State = "expired"
+	StateCancelled  State = "cancelled"
+)
+
+// ErrNoSession is returned when no pairing code is active
+var ErrNoSession = errors.New("no pairing session in progress")
+
+// Session is one pairing attempt started by 'eco pair'
+type Session struct {
+	Code      string    `json:"code"`
+	Name      string    `json:"name"`
+	State     State     `json:"state"`
+	ExpiresAt time.Time `json:"expires_at"`
+	DeviceID  string    `json:"device_id,omitempty"`
+	Error     string    `json:"error,omitempty"`
+
+	deviceName string
+	result     *crypto.SPAKE2Result
+	// exchangeBy is when an exchanging session fails if the device hasn't
+	// confirmed
+	exchangeBy time.Time
+}
+
+// Manager runs pairing sessions. Only one code is active at a time and
+// each code allows a single exchange, so a wrong guess burns the code.
+type Manager struct {
+	mu       sync.Mutex
+	session  *Session
+	onPaired func(*config.Device) error
+}
+
+// NewManager creates a manager that calls onPaired to register new devices
+func NewManager(onPaired func(*config.Device) error) *Manager {
+	return &Manager{
+		onPaired: onPaired,
+	}
+}
+
+// Begin starts a new session, replacing any unfinished one
+func (m *Manager) Begin(name string, ttl time.Duration) (Session, error) {
+	if ttl <= 0 {
+		ttl = DefaultTTL
+	}
+
+	code, err := crypto.GeneratePairingCode()
+	if err != nil {
+		return Session{}, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.session != nil && !m.session.finished() {
+		m.session.fail(StateCancelled, "replaced by a new pairing code")
+	}
+	m.session = &Session{
+		Code:      code,
+		Name:      name,
+		State:     StatePending,
+		ExpiresAt: time.Now().Add(ttl),
+	}
+	return *m.session, nil
+}
+
+// Status returns the session for code
+func (m *Manager) Status(code string) (Session, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s, err := m.lookup(code)
+	if err != nil {
+		return Session{}, err
+	}
+	return *s, nil
+}
+
+// Cancel aborts the session for code
+func (m *Manager) Cancel(code string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s, err := m.lookup(code)
+	if err != nil {
+		return err
+	}
+	if !s.finished() {
+		s.fail(StateCancelled, "cancelled")
+	}
+	return nil
+}
+
+// ActiveCode returns the code of the session waiting for a device, if any
+func (m *Manager) ActiveCode() string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.session == nil {
+		return ""
+	}
+	m.session.expire()
+	if m.session.State != StatePending {
+		return ""
+	}
+	return m.session.Code
+}
+
+// Start handles pair.start: it answers the device's SPAKE2 share with ours
+// and our key confirmation. Both are hex encoded.
+func (m *Manager) Start(deviceName, deviceMessage string) (message, confirm string, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s := m.session
+	if s == nil {
+		return "", "", ErrNoSession
+	}
+	s.expire()
+	if s.State != StatePending {
+		return "", "", fmt.Errorf("pairing session is %s", s.State)
+	}
+	// From here on the code is spent, whatever the outcome
+	s.State = StateExchanging
+	s.deviceName = deviceName
+	s.exchangeBy = time.Now().Add(ExchangeTimeout)
+
+	peer, err := hex.DecodeString(deviceMessage)
+	if err != nil {
+		s.fail(StateFailed, "invalid device message")
+		return "", "", err
+	}
+
+	exchange, err := crypto.NewSPAKE2B([]byte(s.Code), DeviceIdentity, DaemonIdentity)
+	if err != nil {
+		s.fail(StateFailed, err.Error())
+		return "", "", err
+	}
+	result, err := exchange.Finish(peer)
+	if err != nil {
+		s.fail(StateFailed, err.Error())
+		return "", "", err
+	}
+	s.result = result
+
+	return hex.EncodeToString(exchange.Message()), hex.EncodeToString(result.Confirmation), nil
+}
+
+// Abort fails the session whose exchange is under way, for a device that
+// went away or sent something unexpected before confirming
+func (m *Manager) Abort(reason string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.session != nil && m.session.State == StateExchanging {
+		m.session.fail(StateFailed, reason)
+	}
+}
+
+// Confirm handles pair.confirm: it checks the device derived the same key,
+// then registers the device with a key derived from the exchange.
+func (m *Manager) Confirm(confirm string) (*config.Device, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s := m.session
+	if s == nil || s.State != StateExchanging || s.result == nil {
+		return nil, ErrNoSession
+	}
+
+	confirmation, err := hex.DecodeString(confirm)
+	if err != nil {
+		s.fail(StateFailed, "invalid confirmation")
+		return nil, err
+	}
+	if err := s.result.VerifyPeer(confirmation); err != nil {
+		s.fail(StateFailed, "pairing code did not match")
+		return nil, err
+	}
+
+	key, err := s.result.DeriveKey(DeviceKeyPurpose)
+	if err != nil {
+		s.fail(StateFailed, err.Error())
+		return nil, err
+	}
+
+	name := s.Name
+	if name == "" {
+		name = s.deviceName
+	}
+	if name == "" {
+		name = "mobile"
+	}
+
+	dev := &config.Device{
+		ID:      crypto.GenerateDeviceID(),
+		Name:    name,
+		Secret:  hex.EncodeToString(key),
+		Enabled: true,
+	}
+	if m.onPaired != nil {
+		if err := m.onPaired(dev); err != nil {
+			s.fail(StateFailed, err.Error())
+			return nil, err
+		}
+	}
+
+	s.State = StatePaired
+	s.DeviceID = dev.ID
+	s.result = nil
+	return dev, nil
+}
+
+// lookup returns the current session if it matches code
+func (m *Manager) lookup(code string) (*Session, error) {
+	if m.session == nil || m.session.Code != code {
+		return nil, fmt.Errorf("unknown pairing code %s", code)
+	}
+	m.session.expire()
+	return m.session, nil
+}
+
+func (s *Session) finished() bool {
+	return s.State == StatePaired || s.State == StateFailed ||
+		s.State == StateExpired || s.State == StateCancelled
+}
+
+// expire marks a waiting session expired once its code times out, and
+// fails an exchange the device didn't finish in time
+func (s *Session) expire() {
+	switch {
+	case s.State == StatePending && time.Now().After(s.ExpiresAt):
+		s.fail(StateExpired, "pairing code expired")
+	case s.State == StateExchanging && time.Now().After(s.exchangeBy):
+		s.fail(StateFailed, "device did not finish pairing")
+	}
+}
+
+func (s *Session) fail(state State, reason string) {
+	s.State = state
+	s.Error = reason
+	s.result = nil
+}
@@ -0,0 +1,206 @@
+package pairing
+
+import (
+	"encoding/hex"
+	"testing"
+	"time"
+
+	"eco/internal/config"
+	"eco/internal/crypto"
+)
+
+// device plays the phone's side of the exchange
+type device struct {
+	t        *testing.T
+	exchange *crypto.SPAKE2
+}
+
+func newDevice(t *testing.T, code string) *device {
+	t.Helper()
+	exchange, err := crypto.NewSPAKE2A([]byte(code), DeviceIdentity, DaemonIdentity)
+	if err != nil {
+		t.Fatalf("NewSPAKE2A() error = %v", err)
+	}
+	return &device{t: t, exchange: exchange}
+}
+
+func (d *device) message() string {
+	return hex.EncodeToString(d.exchange.Message())
+}
+
+// finish verifies the daemon's reply and returns the device's confirmation and key
+func (d *device) finish(message, confirm string) (string, []byte, error) {
+	peer, _ := hex.DecodeString(message)
+	result, err := d.exchange.Finish(peer)
+	if err != nil {
+		return "", nil, err
+	}
+	daemonConfirm, _ := hex.DecodeString(confirm)
+	if err := result.VerifyPeer(daemonConfirm); err != nil {
+		return hex.EncodeToString(result.Confirmation), nil, err
+	}
+	key, err := result.DeriveKey(DeviceKeyPurpose)
+	return hex.EncodeToString(result.Confirmation), key, err
+}
+
+func TestPairingSuccess(t *testing.T) {
+	var registered *config.Device
+	m := NewManager(func(d *config.Device) error {
+		registered = d
+		return nil
+	})
+
+	session, err := m.Begin("Pixel", time.Minute)
+	if err != nil {
+		t.Fatalf("Begin() error = %v", err)
+	}
+	if m.ActiveCode() != session.Code {
+		t.Errorf("ActiveCode() = %q, want %q", m.ActiveCode(), session.Code)
+	}
+
+	phone := newDevice(t, session.Code)
+	message, confirm, err := m.Start("android", phone.message())
+	if err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+
+	deviceConfirm, key, err := phone.finish(message, confirm)
+	if err != nil {
+		t.Fatalf("device finish error = %v", err)
+	}
+
+	dev, err := m.Confirm(deviceConfirm)
+	if err != nil {
+		t.Fatalf("Confirm() error = %v", err)
+	}
+	if dev != registered {
+		t.Error("Confirm() did not register the device")
+	}
+	if dev.Secret != hex.EncodeToString(key) {
+		t.Error("daemon and device derived different keys")
+	}
+	if !crypto.ValidateSecret(dev.Secret) {
+		t.Errorf("derived secret %q is not a valid %d-byte key", dev.Secret, crypto.SecretSize)
+	}
+	if dev.Name != "Pixel" || !dev.Enabled {
+		t.Errorf("registered device = %+v, want enabled device named Pixel", dev)
+	}
+
+	status, err := m.Status(session.Code)
+	if err != nil {
+		t.Fatalf("Status() error = %v", err)
+	}
+	if status.State != StatePaired || status.DeviceID != dev.ID {
+		t.Errorf("Status() = %+v, want paired with %s", status, dev.ID)
+	}
+	if m.ActiveCode() != "" {
+		t.Error("code is still active after pairing")
+	}
+}
+
+func TestPairingWrongCodeBurnsSession(t *testing.T) {
+	m := NewManager(func(d *config.Device) error {
+		t.Error("device registered with the wrong code")
+		return nil
+	})
+
+	session, _ := m.Begin("", time.Minute)
+	wrong := "000000"
+	if session.Code == wrong {
+		wrong = "111111"
+	}
+
+	attacker := newDevice(t, wrong)
+	message, confirm, err := m.Start("attacker", attacker.message())
+	if err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+
+	attackerConfirm, _, err := attacker.finish(message, confirm)
+	if err == nil {
+		t.Fatal("device verified the daemon's confirmation with the wrong code")
+	}
+	if _, err := m.Confirm(attackerConfirm); err == nil {
+		t.Fatal("Confirm() accepted a confirmation made with the wrong code")
+	}
+
+	status, _ := m.Status(session.Code)
+	if status.State != StateFailed {
+		t.Errorf("State = %s, want %s", status.State, StateFailed)
+	}
+
+	// The real device cannot reuse the burned code
+	phone := newDevice(t, session.Code)
+	if _, _, err := m.Start("android", phone.message()); err == nil {
+		t.Error("Start() accepted a second attempt on the same code")
+	}
+}
+
+func TestPairingExpiryAndCancel(t *testing.T) {
+	m := NewManager(nil)
+
+	session, _ := m.Begin("", time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+	if m.ActiveCode() != "" {
+		t.Error("expired code is still active")
+	}
+	status, _ := m.Status(session.Code)
+	if status.State != StateExpired {
+		t.Errorf("State = %s, want %s", status.State, StateExpired)
+	}
+
+	session, _ = m.Begin("", time.Minute)
+	if err := m.Cancel(session.Code); err != nil {
+		t.Fatalf("Cancel() error = %v", err)
+	}
+	if _, _, err := m.Start("android", newDevice(t, session.Code).message()); err == nil {
+		t.Error("Start() accepted a cancelled session")
+	}
+
+	if _, err := m.Status("nope"); err == nil {
+		t.Error("Status() should error for an unknown code")
+	}
+}
+
+func TestPairingAbort(t *testing.T) {
+	m := NewManager(nil)
+
+	session, _ := m.Begin("", time.Minute)
+	d := newDevice(t, session.Code)
+	message, confirm, err := m.Start("android", d.message())
+	if err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	m.Abort("device disconnected")
+
+	status, _ := m.Status(session.Code)
+	if status.State != StateFailed || status.Error != "device disconnected" {
+		t.Errorf("Status() = %s (%s), want %s", status.State, status.Error, StateFailed)
+	}
+	deviceConfirm, _, _ := d.finish(message, confirm)
+	if _, err := m.Confirm(deviceConfirm); err == nil {
+		t.Error("Confirm() accepted an aborted session")
+	}
+
+	// A session waiting for a device isn't affected
+	session, _ = m.Begin("", time.Minute)
+	m.Abort("device disconnected")
+	if m.ActiveCode() != session.Code {
+		t.Error("Abort() ended a session no device had started")
+	}
+}
+
+func TestPairingExchangeTimeout(t *testing.T) {
+	m := NewManager(nil)
+
+	session, _ := m.Begin("", time.Minute)
+	if _, _, err := m.Start("android", newDevice(t, session.Code).message()); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	m.session.exchangeBy = time.Now().Add(-time.Second)
+
+	status, _ := m.Status(session.Code)
+	if status.State != StateFailed {
+		t.Errorf("State = %s, want %s", status.State, StateFailed)
+	}
+}
@@ -0,0 +1,68 @@
+package protocol
+
+import (
+	"slices"
+	"strings"
+)
+
+// ProtocolVersion is the version of the message protocol spoken by this build.
+// Devices from before version negotiation don't send one.
+const ProtocolVersion = 1
+
+// Capabilities advertised in device.hello. A feature is only used when both
+// sides list it.
+const (
+	CapabilityClipboard     = "clipboard"
+	CapabilityNotifications = "notifications"
+	CapabilityCalls         = "calls"
+	CapabilityFiles         = "files"
+	CapabilityInput         = "input"
+	CapabilityMobile        = "mobile"
+	CapabilitySMS           = "sms"
+)
+
+// LegacyCapabilities are assumed for devices that predate negotiation: the
+// features every client supported at the time
+var LegacyCapabilities = []string{CapabilityClipboard, CapabilityNotifications, CapabilityCalls}
+
+// capabilityPrefixes maps the namespace of a message type to its capability
+var capabilityPrefixes = map[string]string{
+	"clipboard":    CapabilityClipboard,
+	"notification": CapabilityNotifications,
+	"call":         CapabilityCalls,
+	"file":         CapabilityFiles,
+	"input":        CapabilityInput,
+	"mobile":       CapabilityMobile,
+	"sms":          CapabilitySMS,
+}
+
+// Capability returns the capability a message type belongs to, or "" for
+// messages every peer understands (handshake, ping, ack)
+func (t MessageType) Capability() string {
+	prefix, _, _ := strings.Cut(string(t), ".")
+	return capabilityPrefixes[prefix]
+}
+
+// AdvertisedCapabilities returns the capabilities the sender of a hello
+// supports, filling in LegacyCapabilities for peers without a version
+func (p *DevicePayload) AdvertisedCapabilities() []string {
+	switch {
+	case p.ProtocolVersion == 0 && len(p.Capabilities) == 0:
+		return LegacyCapabilities
+	case p.Capabilities == nil:
+		return []string{}
+	}
+	return p.Capabilities
+}
+
+// IntersectCapabilities returns the capabilities listed in both a and b, in
+// the order of a
+func IntersectCapabilities(a, b []string) []string {
+	both := []string{}
+	for _, c := range a {
+		if slices.Contains(b, c) && !slices.Contains(both, c) {
+			both = append(both, c)
+		}
+	}
+	return both
+}
@@ -0,0 +1,86 @@
+package protocol
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMessageTypeCapability(t *testing.T) {
+	tests := []struct {
+		msgType MessageType
+		want    string
+	}{
+		{MessageTypeClipboardChanged, CapabilityClipboard},
+		{MessageTypeClipboardGet, CapabilityClipboard},
+		{MessageTypeNotificationPush, CapabilityNotifications},
+		{MessageTypeCallIncoming, CapabilityCalls},
+		{MessageTypeFileOffer, CapabilityFiles},
+		{MessageTypeInputKey, CapabilityInput},
+		{MessageTypeMobileRing, CapabilityMobile},
+		{MessageTypeSMSSync, CapabilitySMS},
+		{MessageTypeDeviceHello, ""},
+		{MessageTypeDevicePing, ""},
+		{MessageTypeAck, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.msgType), func(t *testing.T) {
+			if got := tt.msgType.Capability(); got != tt.want {
+				t.Errorf("Capability() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAdvertisedCapabilities(t *testing.T) {
+	tests := []struct {
+		name  string
+		hello DevicePayload
+		want  []string
+	}{
+		{
+			name:  "Legacy device",
+			hello: DevicePayload{DeviceName: "old"},
+			want:  LegacyCapabilities,
+		},
+		{
+			name:  "Versioned device",
+			hello: DevicePayload{ProtocolVersion: 1, Capabilities: []string{CapabilityClipboard}},
+			want:  []string{CapabilityClipboard},
+		},
+		{
+			name:  "Versioned device without capabilities",
+			hello: DevicePayload{ProtocolVersion: 1},
+			want:  []string{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.hello.AdvertisedCapabilities(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("AdvertisedCapabilities() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIntersectCapabilities(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b []string
+		want []string
+	}{
+		{"Overlap", []string{"clipboard", "calls", "input"}, []string{"input", "clipboard"}, []string{"clipboard", "input"}},
+		{"Disjoint", []string{"clipboard"}, []string{"files"}, []string{}},
+		{"Duplicates", []string{"calls", "calls"}, []string{"calls"}, []string{"calls"}},
+		{"Empty", nil, []string{"calls"}, []string{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IntersectCapabilities(tt.a, tt.b); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("IntersectCapabilities() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,69 @@
+package protocol
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strings"
+)
+
+// MIME types with special handling in clipboard payloads
+const (
+	MIMEText    = "text/plain"
+	MIMEHTML    = "text/html"
+	MIMEURIList = "text/uri-list"
+	MIMEPNG     = "image/png"
+)
+
+// EncodingBase64 marks clipboard Data that is standard base64
+const EncodingBase64 = "base64"
+
+// ClipboardFile is a file on the sender's clipboard
+type ClipboardFile struct {
+	Name string `json:"name"`
+	Size int64  `json:"size"`
+	// TransferID refers to the file transfer, offered with file.offer,
+	// carrying the file. Files that weren't offered have none.
+	TransferID string `json:"transfer_id,omitempty"`
+}
+
+// Type returns the MIME type of the content, MIMEText if unset
+func (p *ClipboardPayload) Type() string {
+	if p.MIME == "" {
+		return MIMEText
+	}
+	return p.MIME
+}
+
+// IsImage reports whether the content is an image
+func (p *ClipboardPayload) IsImage() bool {
+	return strings.HasPrefix(p.Type(), "image/")
+}
+
+// Bytes returns the content, decoding Data if it is base64
+func (p *ClipboardPayload) Bytes() ([]byte, error) {
+	if p.Encoding == EncodingBase64 {
+		return base64.StdEncoding.DecodeString(p.Data)
+	}
+	return []byte(p.Data), nil
+}
+
+// Validate rejects unknown encodings, malformed base64 and binary content
+// sent as plain text
+func (p *ClipboardPayload) Validate() error {
+	switch p.Encoding {
+	case "":
+		if p.IsImage() && p.TransferID == "" {
+			return fmt.Errorf("%s content must be %s encoded", p.Type(), EncodingBase64)
+		}
+	case EncodingBase64:
+		if _, err := base64.StdEncoding.DecodeString(p.Data); err != nil {
+			return fmt.Errorf("invalid %s data: %w", EncodingBase64, err)
+		}
+	default:
+		return fmt.Errorf("unknown encoding %q", p.Encoding)
+	}
+	if p.TransferID != "" && p.Data != "" {
+		return fmt.Errorf("content has both data and transfer_id")
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+package protocol
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestClipboardPayloadValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		payload ClipboardPayload
+		wantErr bool
+	}{
+		{"Plain text", ClipboardPayload{Data: "hello"}, false},
+		{"Text with HTML", ClipboardPayload{Data: "hello", HTML: "<b>hello</b>"}, false},
+		{"Base64 image", ClipboardPayload{Data: "iVBORw0KGgo=", MIME: MIMEPNG, Encoding: EncodingBase64}, false},
+		{"Image by transfer", ClipboardPayload{MIME: MIMEPNG, TransferID: "t1"}, false},
+		{"Unencoded image", ClipboardPayload{Data: "\x89PNG", MIME: MIMEPNG}, true},
+		{"Bad base64", ClipboardPayload{Data: "not base64!", MIME: MIMEPNG, Encoding: EncodingBase64}, true},
+		{"Unknown encoding", ClipboardPayload{Data: "x", Encoding: "gzip"}, true},
+		{"Data and transfer", ClipboardPayload{Data: "aGk=", Encoding: EncodingBase64, TransferID: "t1"}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.payload.Validate()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestClipboardPayloadBytes(t *testing.T) {
+	image := &ClipboardPayload{Data: "AAECAw==", MIME: MIMEPNG, Encoding: EncodingBase64}
+	data, err := image.Bytes()
+	if err != nil || string(data) != "\x00\x01\x02\x03" {
+		t.Errorf("Bytes() = %q, %v, want the decoded image", data, err)
+	}
+	if !image.IsImage() {
+		t.Error("IsImage() = false for image/png")
+	}
+
+	text := &ClipboardPayload{Data: "hello"}
+	if text.Type() != MIMEText || text.IsImage() {
+		t.Errorf("Type() = %s, want %s", text.Type(), MIMEText)
+	}
+}
+
+func TestClipboardPayloadTextIsCompatible(t *testing.T) {
+	// Plain text must still look like {"data": ...} to older clients
+	data, _ := json.Marshal(&ClipboardPayload{Data: "hello"})
+	if string(data) != `{"data":"hello"}` {
+		t.Errorf("Marshal() = %s, want only the data field", data)
+	}
+}
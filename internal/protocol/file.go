@@ -0,0 +1,128 @@
+package protocol
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// File transfers. The sender offers a file with file.offer and the receiver
+// takes it with file.accept or turns it down with file.cancel. The data goes
+// over HTTP: the device downloads offers from the desktop with
+// GET /download/{id} and uploads its own with POST /upload/{id}, presenting
+// the one-time token from the offer or the accept. The desktop reports how
+// far a transfer got with file.progress.
+//
+// A folder is offered with Archive set and moves as a tar stream of its
+// contents, named relative to the folder, which may be zstd compressed.
+// Size and SHA256 then describe the stream.
+const (
+	MessageTypeFileOffer    MessageType = "file.offer"
+	MessageTypeFileAccept   MessageType = "file.accept"
+	MessageTypeFileProgress MessageType = "file.progress"
+	MessageTypeFileCancel   MessageType = "file.cancel"
+)
+
+// Archive formats of folder offers
+const (
+	FileArchiveTar     = "tar"
+	FileArchiveTarZstd = "tar+zstd"
+)
+
+// FileOfferPayload offers a file to the other side
+type FileOfferPayload struct {
+	// ID names the transfer in the other file messages and the HTTP paths
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	Size int64  `json:"size"`
+	// SHA256 is the hex encoded digest the receiver checks the file against
+	SHA256 string `json:"sha256"`
+	// Token authorizes the download of a file offered by the desktop
+	Token string `json:"token,omitempty"`
+	// Archive is the format of a folder, empty for a single file
+	Archive string `json:"archive,omitempty"`
+	// Files and TotalSize count the files of a folder and their size before
+	// archiving
+	Files     int   `json:"files,omitempty"`
+	TotalSize int64 `json:"total_size,omitempty"`
+}
+
+// Validate checks the offer describes a file that can be transferred
+func (p *FileOfferPayload) Validate() error {
+	if err := validateTransferID(p.ID); err != nil {
+		return err
+	}
+	switch {
+	case p.Name == "":
+		return errors.New("file name is required")
+	case p.Size < 0:
+		return fmt.Errorf("invalid file size %d", p.Size)
+	}
+	if digest, err := hex.DecodeString(p.SHA256); err != nil || len(digest) != 32 {
+		return errors.New("file sha256 must be a hex encoded SHA-256 digest")
+	}
+	switch p.Archive {
+	case "", FileArchiveTar, FileArchiveTarZstd:
+	default:
+		return fmt.Errorf("unknown archive format %q", p.Archive)
+	}
+	if p.Files < 0 || p.TotalSize < 0 {
+		return errors.New("invalid folder size")
+	}
+	return nil
+}
+
+// FileAcceptPayload accepts an offered file. When the desktop accepts, it
+// carries the token for the upload and the offset to upload from, which is
+// past the start when an earlier upload was interrupted.
+type FileAcceptPayload struct {
+	ID     string `json:"id"`
+	Token  string `json:"token,omitempty"`
+	Offset int64  `json:"offset,omitempty"`
+}
+
+// Validate requires a valid transfer ID
+func (p *FileAcceptPayload) Validate() error {
+	return validateTransferID(p.ID)
+}
+
+// FileProgressPayload reports how many bytes of a transfer arrived, and its
+// state once it is over
+type FileProgressPayload struct {
+	ID    string `json:"id"`
+	Bytes int64  `json:"bytes"`
+	Size  int64  `json:"size"`
+	// State is "transferring", "complete", "failed" or "cancelled"
+	State string `json:"state"`
+	Error string `json:"error,omitempty"`
+	// File is the file of a folder being transferred or unpacked, and
+	// FilesDone how many of the folder's files came before it
+	File      string `json:"file,omitempty"`
+	FilesDone int    `json:"files_done,omitempty"`
+}
+
+// FileCancelPayload declines an offer or aborts a transfer
+type FileCancelPayload struct {
+	ID     string `json:"id"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// Validate requires a valid transfer ID
+func (p *FileCancelPayload) Validate() error {
+	return validateTransferID(p.ID)
+}
+
+// validateTransferID accepts IDs that are safe in URLs and file names
+func validateTransferID(id string) error {
+	if id == "" {
+		return errors.New("transfer id is required")
+	}
+	if len(id) > MaxIDLength {
+		return fmt.Errorf("transfer id longer than %d characters", MaxIDLength)
+	}
+	if strings.Trim(id, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789-_") != "" {
+		return fmt.Errorf("invalid transfer id %q", id)
+	}
+	return nil
+}
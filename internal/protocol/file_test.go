@@ -0,0 +1,38 @@
+package protocol
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestFileOfferPayloadValidate(t *testing.T) {
+	digest := strings.Repeat("ab", 32)
+
+	tests := []struct {
+		name    string
+		payload FileOfferPayload
+		wantErr bool
+	}{
+		{"Valid", FileOfferPayload{ID: "file-1", Name: "photo.jpg", Size: 213123, SHA256: digest}, false},
+		{"Empty file", FileOfferPayload{ID: "file_2", Name: "empty", SHA256: digest}, false},
+		{"No ID", FileOfferPayload{Name: "photo.jpg", SHA256: digest}, true},
+		{"Path in ID", FileOfferPayload{ID: "../file", Name: "photo.jpg", SHA256: digest}, true},
+		{"Long ID", FileOfferPayload{ID: strings.Repeat("a", MaxIDLength+1), Name: "photo.jpg", SHA256: digest}, true},
+		{"No name", FileOfferPayload{ID: "file-1", SHA256: digest}, true},
+		{"Negative size", FileOfferPayload{ID: "file-1", Name: "photo.jpg", Size: -1, SHA256: digest}, true},
+		{"No digest", FileOfferPayload{ID: "file-1", Name: "photo.jpg"}, true},
+		{"Short digest", FileOfferPayload{ID: "file-1", Name: "photo.jpg", SHA256: "abcd"}, true},
+		{"Folder", FileOfferPayload{ID: "file-1", Name: "Photos", Size: 4096, SHA256: digest, Archive: FileArchiveTarZstd, Files: 3, TotalSize: 12000}, false},
+		{"Unknown archive", FileOfferPayload{ID: "file-1", Name: "Photos", SHA256: digest, Archive: "zip"}, true},
+		{"Negative file count", FileOfferPayload{ID: "file-1", Name: "Photos", SHA256: digest, Archive: FileArchiveTar, Files: -1}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.payload.Validate()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,120 @@
+package protocol
+
+import (
+	"errors"
+	"fmt"
+	"unicode/utf8"
+)
+
+// Input from the phone, acting as the desktop's keyboard and mouse. Keys
+// and buttons are pressed and released in one message unless the action
+// says "down" or "up", so they can also be held. The desktop only takes
+// input from devices the user allowed with 'eco input allow'.
+const (
+	MessageTypeInputKey    MessageType = "input.key"
+	MessageTypeInputText   MessageType = "input.text"
+	MessageTypeInputMouse  MessageType = "input.mouse"
+	MessageTypeInputButton MessageType = "input.button"
+	MessageTypeInputScroll MessageType = "input.scroll"
+)
+
+// Actions of keys and buttons
+const (
+	InputActionPress = "press"
+	InputActionDown  = "down"
+	InputActionUp    = "up"
+)
+
+// MaxInputText is the most characters one input.text may type
+const MaxInputText = 4096
+
+// MaxInputDelta bounds the distance of one movement or scroll
+const MaxInputDelta = 10000
+
+// InputKeyPayload presses a key, named like "a", "enter", "f5" or
+// "volumeup", with the modifiers held around it
+type InputKeyPayload struct {
+	Key string `json:"key"`
+	// Modifiers are "ctrl", "shift", "alt" or "meta"
+	Modifiers []string `json:"modifiers,omitempty"`
+	// Action is "press", the default, "down" or "up"
+	Action string `json:"action,omitempty"`
+}
+
+// Validate requires a key and a known action
+func (p *InputKeyPayload) Validate() error {
+	if p.Key == "" {
+		return errors.New("input key is required")
+	}
+	return validateInputAction(p.Action)
+}
+
+// InputTextPayload types text as if on a US keyboard
+type InputTextPayload struct {
+	Text string `json:"text"`
+}
+
+// Validate requires text of at most MaxInputText characters
+func (p *InputTextPayload) Validate() error {
+	switch n := utf8.RuneCountInString(p.Text); {
+	case n == 0:
+		return errors.New("input text is required")
+	case n > MaxInputText:
+		return fmt.Errorf("input text longer than %d characters", MaxInputText)
+	}
+	return nil
+}
+
+// InputMousePayload moves the pointer by DX and DY pixels
+type InputMousePayload struct {
+	DX int32 `json:"dx"`
+	DY int32 `json:"dy"`
+}
+
+// Validate bounds the movement by MaxInputDelta
+func (p *InputMousePayload) Validate() error {
+	return validateInputDelta(p.DX, p.DY)
+}
+
+// InputButtonPayload clicks a mouse button
+type InputButtonPayload struct {
+	// Button is "left", "right" or "middle"
+	Button string `json:"button"`
+	// Action is "press", the default, "down" or "up"
+	Action string `json:"action,omitempty"`
+}
+
+// Validate requires a button and a known action
+func (p *InputButtonPayload) Validate() error {
+	if p.Button == "" {
+		return errors.New("input button is required")
+	}
+	return validateInputAction(p.Action)
+}
+
+// InputScrollPayload turns the scroll wheel by DX and DY notches. Positive
+// DY scrolls up and positive DX scrolls right.
+type InputScrollPayload struct {
+	DX int32 `json:"dx"`
+	DY int32 `json:"dy"`
+}
+
+// Validate bounds the scroll by MaxInputDelta
+func (p *InputScrollPayload) Validate() error {
+	return validateInputDelta(p.DX, p.DY)
+}
+
+func validateInputAction(action string) error {
+	switch action {
+	case "", InputActionPress, InputActionDown, InputActionUp:
+		return nil
+	}
+	return fmt.Errorf("unknown input action %q", action)
+}
+
+func validateInputDelta(dx, dy int32) error {
+	if max(int64(dx), -int64(dx), int64(dy), -int64(dy)) > MaxInputDelta {
+		return fmt.Errorf("input movement beyond %d", MaxInputDelta)
+	}
+	return nil
+}
@@ -0,0 +1,39 @@
+package protocol
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestInputPayloadValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		payload interface{ Validate() error }
+		wantErr bool
+	}{
+		{"Key", &InputKeyPayload{Key: "a", Modifiers: []string{"ctrl"}}, false},
+		{"Key down", &InputKeyPayload{Key: "shift", Action: InputActionDown}, false},
+		{"No key", &InputKeyPayload{}, true},
+		{"Unknown action", &InputKeyPayload{Key: "a", Action: "tap"}, true},
+		{"Text", &InputTextPayload{Text: "héllo"}, false},
+		{"No text", &InputTextPayload{}, true},
+		{"Long text", &InputTextPayload{Text: strings.Repeat("é", MaxInputText+1)}, true},
+		{"Mouse", &InputMousePayload{DX: 12, DY: -4}, false},
+		{"Mouse too far", &InputMousePayload{DX: MaxInputDelta + 1}, true},
+		{"Mouse overflow", &InputMousePayload{DY: math.MinInt32}, true},
+		{"Button", &InputButtonPayload{Button: "left", Action: InputActionUp}, false},
+		{"No button", &InputButtonPayload{}, true},
+		{"Scroll", &InputScrollPayload{DY: -3}, false},
+		{"Scroll too far", &InputScrollPayload{DX: -MaxInputDelta - 1}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.payload.Validate()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -2,6 +2,11 @@ package protocol
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"eco/internal/crypto"
 )
 
 // MessageType represents the type of message being sent
@@ -18,19 +23,77 @@ const (
 	MessageTypeDeviceHello      MessageType = "device.hello"
 	MessageTypeDevicePing       MessageType = "device.ping"
 	MessageTypeDeviceDisconnect MessageType = "device.disconnect"
+	MessageTypeAuthChallenge    MessageType = "auth.challenge"
+	MessageTypeAuthResponse     MessageType = "auth.response"
+	MessageTypeAuthResult       MessageType = "auth.result"
+	MessageTypePairStart        MessageType = "pair.start"
+	MessageTypePairReply        MessageType = "pair.reply"
+	MessageTypePairConfirm      MessageType = "pair.confirm"
+	MessageTypePairComplete     MessageType = "pair.complete"
+	MessageTypeAck              MessageType = "ack"
+	MessageTypeClipboardGet     MessageType = "clipboard.get"
+	MessageTypeClipboardContent MessageType = "clipboard.content"
 )
 
-// Message is the base structure for all WebSocket messages
+// Notification responses tell a device what happened on the desktop to a
+// notification it pushed. notification.dismiss goes both ways: whichever
+// side the user dismissed a notification on tells the other to withdraw it.
+const (
+	MessageTypeNotificationAction  MessageType = "notification.action"
+	MessageTypeNotificationClosed  MessageType = "notification.closed"
+	MessageTypeNotificationDismiss MessageType = "notification.dismiss"
+	MessageTypeNotificationReply   MessageType = "notification.reply"
+)
+
+// IDLength is the length of the random message IDs made by NewMessage
+const IDLength = 16
+
+// MaxIDLength bounds the id and reply_to fields accepted by ParseMessage
+const MaxIDLength = 64
+
+// ErrInvalidMessage is wrapped by every validation error from ParseMessage
+var ErrInvalidMessage = errors.New("invalid message")
+
+// Message is the base structure for all WebSocket messages.
+// Messages carry no credentials: the connection is authenticated once by the
+// auth.challenge / auth.response handshake and every later message is
+// attributed to the device that completed it.
+//
+// When the connection negotiated encryption, Payload is empty and the
+// encrypted payload travels in Sealed instead (see Seal and Open).
+//
+// ID, Seq, Timestamp and ReplyTo are optional so older peers keep working:
+//   - ID identifies the message; the receiver answers messages that have one
+//     with an ack, or with a response, both carrying ReplyTo = ID
+//   - Seq numbers the messages sent on one connection, starting at 1, so the
+//     receiver can spot duplicates and gaps
+//   - Timestamp is when the message was created, in Unix milliseconds
 type Message struct {
-	Type     MessageType     `json:"type"`
-	DeviceID string          `json:"device_id"`
-	Secret   string          `json:"secret"`
-	Payload  json.RawMessage `json:"payload"`
+	Type      MessageType     `json:"type"`
+	DeviceID  string          `json:"device_id"`
+	ID        string          `json:"id,omitempty"`
+	Seq       uint64          `json:"seq,omitempty"`
+	Timestamp int64           `json:"ts,omitempty"`
+	ReplyTo   string          `json:"reply_to,omitempty"`
+	Payload   json.RawMessage `json:"payload"`
+	Sealed    *SealedPayload  `json:"sealed,omitempty"`
 }
 
 // ClipboardPayload represents clipboard content
 type ClipboardPayload struct {
 	Data string `json:"data"`
+	// MIME is the type of Data, MIMEText when empty
+	MIME string `json:"mime,omitempty"`
+	// Encoding is EncodingBase64 when Data holds binary content, e.g. an image
+	Encoding string `json:"encoding,omitempty"`
+	// HTML is the text/html version of text content, when the source had one
+	HTML string `json:"html,omitempty"`
+	// Files describes the files listed by text/uri-list content
+	Files []ClipboardFile `json:"files,omitempty"`
+	// TransferID refers to the file transfer, offered with file.offer,
+	// carrying an image too large to send inline. Data is empty when it is
+	// set.
+	TransferID string `json:"transfer_id,omitempty"`
 }
 
 // NotificationPayload represents a system notification
@@ -38,6 +101,71 @@ type NotificationPayload struct {
 	App   string `json:"app"`
 	Title string `json:"title"`
 	Body  string `json:"body"`
+	// Icon is an icon name or file path on the sender
+	Icon string `json:"icon,omitempty"`
+	// Urgency is "low", "normal" or "critical"
+	Urgency string `json:"urgency,omitempty"`
+	// ID is the sender's key for the notification, stable across updates,
+	// e.g. the key of an Android StatusBarNotification. A push with the same
+	// ID replaces it, and the other notification messages refer to it.
+	ID string `json:"id,omitempty"`
+	// Category is a freedesktop notification category, e.g. "im.received"
+	Category string `json:"category,omitempty"`
+	// Actions are buttons to show on the notification
+	Actions []NotificationAction `json:"actions,omitempty"`
+	// Replyable is set for messages that can be answered, which the desktop
+	// then offers an inline reply for and sends as notification.reply
+	Replyable bool `json:"replyable,omitempty"`
+}
+
+// NotificationAction is a button on a notification
+type NotificationAction struct {
+	ID    string `json:"id"`
+	Label string `json:"label"`
+}
+
+// NotificationEventPayload reports the user invoking an action on a
+// notification (notification.action) or the notification going away other
+// than by the user dismissing it (notification.closed)
+type NotificationEventPayload struct {
+	ID     string `json:"id"`
+	Action string `json:"action,omitempty"`
+	// Reason is why it closed: "expired", "closed" or "undefined"
+	Reason string `json:"reason,omitempty"`
+}
+
+// NotificationDismissPayload withdraws the notification with ID, which is
+// the key of the device that pushed it
+type NotificationDismissPayload struct {
+	ID string `json:"id"`
+}
+
+// Validate requires the ID
+func (p *NotificationDismissPayload) Validate() error {
+	if p.ID == "" {
+		return errors.New("notification id is required")
+	}
+	return nil
+}
+
+// NotificationReplyPayload answers the notification with ID on the device
+// that pushed it
+type NotificationReplyPayload struct {
+	ID   string `json:"id"`
+	Text string `json:"text"`
+}
+
+// Validate requires a title or a body, since there is nothing to show otherwise
+func (p *NotificationPayload) Validate() error {
+	if p.Title == "" && p.Body == "" {
+		return errors.New("notification has neither title nor body")
+	}
+	for _, action := range p.Actions {
+		if action.ID == "" || action.Label == "" {
+			return errors.New("notification action needs an id and a label")
+		}
+	}
+	return nil
 }
 
 // CallPayload represents call-related events
@@ -45,13 +173,84 @@ type CallPayload struct {
 	Number string `json:"number"`
 }
 
-// DevicePayload represents device handshake info
-type DevicePayload struct {
+// AuthChallengePayload is sent by the server when a device connects.
+// Nonce is hex encoded.
+type AuthChallengePayload struct {
+	Nonce string `json:"nonce"`
+}
+
+// AuthResponsePayload proves the device holds its shared secret.
+// Proof is the hex encoded HMAC-SHA256 of the challenge (see auth.ComputeProof).
+type AuthResponsePayload struct {
+	Proof string `json:"proof"`
+}
+
+// AuthResultPayload tells the device whether the handshake succeeded
+type AuthResultPayload struct {
+	OK    bool   `json:"ok"`
+	Error string `json:"error,omitempty"`
+}
+
+// PairStartPayload opens a pairing exchange on /pair.
+// Message is the device's hex encoded SPAKE2 share.
+type PairStartPayload struct {
 	DeviceName string `json:"device_name"`
+	Message    string `json:"message"`
+}
+
+// PairReplyPayload carries the daemon's SPAKE2 share and key confirmation (hex)
+type PairReplyPayload struct {
+	Message string `json:"message"`
+	Confirm string `json:"confirm"`
+}
+
+// PairConfirmPayload carries the device's key confirmation (hex)
+type PairConfirmPayload struct {
+	Confirm string `json:"confirm"`
+}
+
+// PairCompletePayload ends the exchange. On success the device stores DeviceID
+// and the key both sides derived from the exchange.
+type PairCompletePayload struct {
+	OK       bool   `json:"ok"`
+	DeviceID string `json:"device_id,omitempty"`
+	Error    string `json:"error,omitempty"`
 }
 
-// NewMessage creates a new Message with the given type
-func NewMessage(msgType MessageType, deviceID, secret string, payload any) (*Message, error) {
+// AckPayload confirms that the message named in ReplyTo was handled.
+// When OK is false, Error says why it was rejected.
+type AckPayload struct {
+	OK    bool   `json:"ok"`
+	Error string `json:"error,omitempty"`
+}
+
+// DevicePayload represents device handshake info.
+//
+// The device lists the payload ciphers it supports in Encryption along with a
+// fresh hex nonce. The daemon answers with the single cipher it picked and its
+// own nonce, or with no Encryption when the session stays in plain text.
+//
+// Both sides also describe themselves: the protocol version they speak, their
+// app version and platform, and the capabilities they support. The session
+// uses only the capabilities both sides list.
+type DevicePayload struct {
+	DeviceName      string   `json:"device_name"`
+	Encryption      []string `json:"encryption,omitempty"`
+	Nonce           string   `json:"nonce,omitempty"`
+	ProtocolVersion int      `json:"protocol_version,omitempty"`
+	AppVersion      string   `json:"app_version,omitempty"`
+	Platform        string   `json:"platform,omitempty"`
+	Capabilities    []string `json:"capabilities,omitempty"`
+}
+
+// DisconnectPayload tells the other side why the connection is being closed
+type DisconnectPayload struct {
+	Reason string `json:"reason,omitempty"`
+}
+
+// NewMessage creates a new Message with the given type, a fresh ID and the
+// current time. Seq is assigned by the connection that sends it.
+func NewMessage(msgType MessageType, deviceID string, payload any) (*Message, error) {
 	var raw json.RawMessage
 
 	if payload != nil {
@@ -62,15 +261,40 @@ func NewMessage(msgType MessageType, deviceID, secret string, payload any) (*Mes
 		raw = b
 	}
 
+	id, err := crypto.GenerateRandomString(IDLength)
+	if err != nil {
+		return nil, err
+	}
+
 	return &Message{
-		Type:     msgType,
-		DeviceID: deviceID,
-		Secret:   secret,
-		Payload:  raw,
+		Type:      msgType,
+		DeviceID:  deviceID,
+		ID:        id,
+		Timestamp: time.Now().UnixMilli(),
+		Payload:   raw,
 	}, nil
 }
 
-// ParseMessage parses a JSON byte slice into a Message
+// NewReply creates a response to req of the given type
+func NewReply(req *Message, msgType MessageType, payload any) (*Message, error) {
+	msg, err := NewMessage(msgType, req.DeviceID, payload)
+	if err != nil {
+		return nil, err
+	}
+	msg.ReplyTo = req.ID
+	return msg, nil
+}
+
+// NewAck acknowledges req. A non-nil handleErr reports that it was rejected.
+func NewAck(req *Message, handleErr error) (*Message, error) {
+	payload := &AckPayload{OK: handleErr == nil}
+	if handleErr != nil {
+		payload.Error = handleErr.Error()
+	}
+	return NewReply(req, MessageTypeAck, payload)
+}
+
+// ParseMessage parses a JSON byte slice into a Message and validates it
 func ParseMessage(data []byte) (*Message, error) {
 	var parsedMsg Message
 
@@ -79,9 +303,52 @@ func ParseMessage(data []byte) (*Message, error) {
 		return nil, err
 	}
 
+	if err := parsedMsg.Validate(); err != nil {
+		return nil, err
+	}
+
 	return &parsedMsg, nil
 }
 
+// Validate checks the envelope fields of a received message
+func (m *Message) Validate() error {
+	if m.Type == "" {
+		return fmt.Errorf("%w: missing type", ErrInvalidMessage)
+	}
+	if len(m.ID) > MaxIDLength {
+		return fmt.Errorf("%w: id longer than %d characters", ErrInvalidMessage, MaxIDLength)
+	}
+	if len(m.ReplyTo) > MaxIDLength {
+		return fmt.Errorf("%w: reply_to longer than %d characters", ErrInvalidMessage, MaxIDLength)
+	}
+	if m.Timestamp < 0 {
+		return fmt.Errorf("%w: negative timestamp", ErrInvalidMessage)
+	}
+	if m.Sealed != nil && len(m.Payload) > 0 && string(m.Payload) != "null" {
+		return fmt.Errorf("%w: both payload and sealed are set", ErrInvalidMessage)
+	}
+	return nil
+}
+
+// IsReply reports whether the message answers an earlier one
+func (m *Message) IsReply() bool {
+	return m.ReplyTo != ""
+}
+
+// NeedsAck reports whether the receiver should acknowledge the message:
+// it has an ID and is neither an ack nor a response itself
+func (m *Message) NeedsAck() bool {
+	return m.ID != "" && !m.IsReply() && m.Type != MessageTypeAck
+}
+
+// Time returns when the message was created, or the zero time if unknown
+func (m *Message) Time() time.Time {
+	if m.Timestamp == 0 {
+		return time.Time{}
+	}
+	return time.UnixMilli(m.Timestamp)
+}
+
 // GetPayload unmarshals the Payload field into the target struct
 // Usage: msg.GetPayload(&clipboardPayload)
 func (m *Message) GetPayload(target any) error {
@@ -2,6 +2,8 @@ package protocol
 
 import (
 	"encoding/json"
+	"errors"
+	"strings"
 	"testing"
 )
 
@@ -10,7 +12,6 @@ func TestNewMessage(t *testing.T) {
 		name     string
 		msgType  MessageType
 		deviceID string
-		secret   string
 		payload  interface{}
 		wantErr  bool
 	}{
@@ -18,7 +19,6 @@ func TestNewMessage(t *testing.T) {
 			name:     "Valid clipboard message",
 			msgType:  MessageTypeClipboardSet,
 			deviceID: "test-device",
-			secret:   "test-secret",
 			payload:  &ClipboardPayload{Data: "Hello"},
 			wantErr:  false,
 		},
@@ -26,7 +26,6 @@ func TestNewMessage(t *testing.T) {
 			name:     "Valid notification message",
 			msgType:  MessageTypeNotificationPush,
 			deviceID: "test-device",
-			secret:   "test-secret",
 			payload:  &NotificationPayload{App: "Test", Title: "Title", Body: "Body"},
 			wantErr:  false,
 		},
@@ -34,7 +33,6 @@ func TestNewMessage(t *testing.T) {
 			name:     "Message with nil payload",
 			msgType:  MessageTypeDevicePing,
 			deviceID: "test-device",
-			secret:   "test-secret",
 			payload:  nil,
 			wantErr:  false,
 		},
@@ -42,7 +40,7 @@ func TestNewMessage(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			msg, err := NewMessage(tt.msgType, tt.deviceID, tt.secret, tt.payload)
+			msg, err := NewMessage(tt.msgType, tt.deviceID, tt.payload)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("NewMessage() error = %v, wantErr %v", err, tt.wantErr)
 				return
@@ -57,13 +55,57 @@ func TestNewMessage(t *testing.T) {
 			if msg.DeviceID != tt.deviceID {
 				t.Errorf("NewMessage() DeviceID = %v, want %v", msg.DeviceID, tt.deviceID)
 			}
-			if msg.Secret != tt.secret {
-				t.Errorf("NewMessage() Secret = %v, want %v", msg.Secret, tt.secret)
+			if len(msg.ID) != IDLength {
+				t.Errorf("NewMessage() ID = %q, want %d characters", msg.ID, IDLength)
+			}
+			if msg.Timestamp <= 0 {
+				t.Errorf("NewMessage() Timestamp = %v, want > 0", msg.Timestamp)
 			}
 		})
 	}
 }
 
+func TestNewMessageUniqueIDs(t *testing.T) {
+	a, _ := NewMessage(MessageTypeDevicePing, "test-device", nil)
+	b, _ := NewMessage(MessageTypeDevicePing, "test-device", nil)
+	if a.ID == b.ID {
+		t.Errorf("NewMessage() returned the same ID twice: %s", a.ID)
+	}
+}
+
+func TestNewReplyAndAck(t *testing.T) {
+	req, _ := NewMessage(MessageTypeClipboardGet, "test-device", nil)
+
+	reply, err := NewReply(req, MessageTypeClipboardContent, &ClipboardPayload{Data: "Hello"})
+	if err != nil {
+		t.Fatalf("NewReply() error = %v", err)
+	}
+	if reply.ReplyTo != req.ID || !reply.IsReply() {
+		t.Errorf("NewReply() ReplyTo = %v, want %v", reply.ReplyTo, req.ID)
+	}
+	if reply.NeedsAck() {
+		t.Error("NeedsAck() = true for a reply")
+	}
+
+	ack, err := NewAck(req, errors.New("busy"))
+	if err != nil {
+		t.Fatalf("NewAck() error = %v", err)
+	}
+	var payload AckPayload
+	if err := json.Unmarshal(ack.Payload, &payload); err != nil {
+		t.Fatalf("Failed to unmarshal ack payload: %v", err)
+	}
+	if ack.Type != MessageTypeAck || ack.ReplyTo != req.ID {
+		t.Errorf("NewAck() = %s reply_to %s, want ack reply_to %s", ack.Type, ack.ReplyTo, req.ID)
+	}
+	if payload.OK || payload.Error != "busy" {
+		t.Errorf("NewAck() payload = %+v, want ok=false error=busy", payload)
+	}
+	if !req.NeedsAck() {
+		t.Error("NeedsAck() = false for a request with an ID")
+	}
+}
+
 func TestParseMessage(t *testing.T) {
 	tests := []struct {
 		name    string
@@ -72,7 +114,7 @@ func TestParseMessage(t *testing.T) {
 	}{
 		{
 			name:    "Valid JSON message",
-			data:    []byte(`{"type":"clipboard.set","device_id":"test","secret":"secret","payload":{"data":"Hello"}}`),
+			data:    []byte(`{"type":"clipboard.set","device_id":"test","payload":{"data":"Hello"}}`),
 			wantErr: false,
 		},
 		{
@@ -85,6 +127,31 @@ func TestParseMessage(t *testing.T) {
 			data:    []byte{},
 			wantErr: true,
 		},
+		{
+			name:    "Message with id, seq and ts",
+			data:    []byte(`{"type":"clipboard.set","device_id":"test","id":"abc","seq":3,"ts":1700000000000,"payload":{"data":"Hello"}}`),
+			wantErr: false,
+		},
+		{
+			name:    "Missing type",
+			data:    []byte(`{"device_id":"test","payload":{}}`),
+			wantErr: true,
+		},
+		{
+			name:    "Negative timestamp",
+			data:    []byte(`{"type":"device.ping","ts":-1}`),
+			wantErr: true,
+		},
+		{
+			name:    "Oversized id",
+			data:    []byte(`{"type":"device.ping","id":"` + strings.Repeat("a", MaxIDLength+1) + `"}`),
+			wantErr: true,
+		},
+		{
+			name:    "Payload and sealed",
+			data:    []byte(`{"type":"device.ping","payload":{"data":"x"},"sealed":{"counter":1,"nonce":"","ciphertext":""}}`),
+			wantErr: true,
+		},
 	}
 
 	for _, tt := range tests {
@@ -106,7 +173,6 @@ func TestMessageJSONTags(t *testing.T) {
 	msg := &Message{
 		Type:     MessageTypeClipboardSet,
 		DeviceID: "test-device",
-		Secret:   "test-secret",
 	}
 	payload := &ClipboardPayload{Data: "Hello World"}
 	payloadBytes, _ := json.Marshal(payload)
@@ -129,6 +195,9 @@ func TestMessageJSONTags(t *testing.T) {
 	if _, ok := raw["DeviceID"]; ok {
 		t.Error("Found 'DeviceID' field (should be lowercase 'device_id')")
 	}
+	if _, ok := raw["secret"]; ok {
+		t.Error("Found 'secret' field (secrets must never be sent on the wire)")
+	}
 
 	// Verify payload has lowercase fields
 	if payloadRaw, ok := raw["payload"].(map[string]interface{}); ok {
@@ -167,6 +236,20 @@ func TestPayloadStructures(t *testing.T) {
 				"number": "+1234567890",
 			},
 		},
+		{
+			name:    "AuthChallengePayload lowercase",
+			payload: &AuthChallengePayload{Nonce: "abcd"},
+			expected: map[string]interface{}{
+				"nonce": "abcd",
+			},
+		},
+		{
+			name:    "AuthResponsePayload lowercase",
+			payload: &AuthResponsePayload{Proof: "1234"},
+			expected: map[string]interface{}{
+				"proof": "1234",
+			},
+		},
 		{
 			name:    "DevicePayload lowercase",
 			payload: &DevicePayload{DeviceName: "android"},
@@ -0,0 +1,64 @@
+package protocol
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+)
+
+// Requests from the desktop for the phone to do something, answered with an
+// ack once it has, or with the reason it couldn't. mobile.ring and
+// mobile.lock carry no payload.
+const (
+	MessageTypeMobileOpenApp MessageType = "mobile.open_app"
+	MessageTypeMobileOpenURL MessageType = "mobile.open_url"
+	MessageTypeMobileRing    MessageType = "mobile.ring"
+	MessageTypeMobileLock    MessageType = "mobile.lock"
+)
+
+// MaxMobileURL is the longest URL mobile.open_url takes
+const MaxMobileURL = 8192
+
+// packageName matches Android application IDs like com.spotify.music
+var packageName = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_]*(\.[A-Za-z][A-Za-z0-9_]*)+$`)
+
+// MobileOpenAppPayload launches the app with the package name Package
+type MobileOpenAppPayload struct {
+	Package string `json:"package"`
+}
+
+// Validate requires a well-formed package name
+func (p *MobileOpenAppPayload) Validate() error {
+	switch {
+	case p.Package == "":
+		return errors.New("app package is required")
+	case !packageName.MatchString(p.Package):
+		return fmt.Errorf("invalid app package %q", p.Package)
+	}
+	return nil
+}
+
+// MobileOpenURLPayload opens URL with the app the phone has for it, like a
+// browser for https or the dialer for tel
+type MobileOpenURLPayload struct {
+	URL string `json:"url"`
+}
+
+// Validate requires an absolute URL of at most MaxMobileURL bytes
+func (p *MobileOpenURLPayload) Validate() error {
+	if p.URL == "" {
+		return errors.New("url is required")
+	}
+	if len(p.URL) > MaxMobileURL {
+		return fmt.Errorf("url longer than %d bytes", MaxMobileURL)
+	}
+	u, err := url.Parse(p.URL)
+	if err != nil {
+		return err
+	}
+	if !u.IsAbs() {
+		return fmt.Errorf("url %q has no scheme", p.URL)
+	}
+	return nil
+}
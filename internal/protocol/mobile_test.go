@@ -0,0 +1,36 @@
+package protocol
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMobilePayloadValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		payload interface{ Validate() error }
+		wantErr bool
+	}{
+		{"App", &MobileOpenAppPayload{Package: "com.spotify.music"}, false},
+		{"App with underscore", &MobileOpenAppPayload{Package: "org.example.my_app2"}, false},
+		{"No app", &MobileOpenAppPayload{}, true},
+		{"App without dot", &MobileOpenAppPayload{Package: "spotify"}, true},
+		{"App with space", &MobileOpenAppPayload{Package: "com.spotify music"}, true},
+		{"App segment with digit first", &MobileOpenAppPayload{Package: "com.1spotify"}, true},
+		{"URL", &MobileOpenURLPayload{URL: "https://example.com/a?b=c"}, false},
+		{"Phone number", &MobileOpenURLPayload{URL: "tel:+15551234567"}, false},
+		{"No URL", &MobileOpenURLPayload{}, true},
+		{"Relative URL", &MobileOpenURLPayload{URL: "example.com"}, true},
+		{"Invalid URL", &MobileOpenURLPayload{URL: "https://exa mple.com/%zz"}, true},
+		{"Long URL", &MobileOpenURLPayload{URL: "https://example.com/" + strings.Repeat("a", MaxMobileURL)}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.payload.Validate()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,106 @@
+package protocol
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"eco/internal/crypto"
+)
+
+func sealedPair(t *testing.T) (Sealer, Sealer) {
+	t.Helper()
+
+	deviceNonce, _ := crypto.GenerateRandomBytes(crypto.SessionNonceSize)
+	daemonNonce, _ := crypto.GenerateRandomBytes(crypto.SessionNonceSize)
+	daemon, err := crypto.NewPayloadCipher("secret", "mobile-1", deviceNonce, daemonNonce, true)
+	if err != nil {
+		t.Fatalf("NewPayloadCipher() error = %v", err)
+	}
+	device, err := crypto.NewPayloadCipher("secret", "mobile-1", deviceNonce, daemonNonce, false)
+	if err != nil {
+		t.Fatalf("NewPayloadCipher() error = %v", err)
+	}
+	return daemon, device
+}
+
+func TestSealOpenRoundTrip(t *testing.T) {
+	daemon, device := sealedPair(t)
+
+	msg, _ := NewMessage(MessageTypeClipboardChanged, "mobile-1", &ClipboardPayload{Data: "top secret"})
+	sealed, err := msg.Seal(daemon)
+	if err != nil {
+		t.Fatalf("Seal() error = %v", err)
+	}
+
+	data, err := sealed.ToJSON()
+	if err != nil {
+		t.Fatalf("ToJSON() error = %v", err)
+	}
+	if strings.Contains(string(data), "top secret") {
+		t.Errorf("sealed JSON contains the payload: %s", data)
+	}
+
+	parsed, err := ParseMessage(data)
+	if err != nil {
+		t.Fatalf("ParseMessage() error = %v", err)
+	}
+	if !parsed.IsSealed() {
+		t.Fatal("IsSealed() = false after parsing a sealed message")
+	}
+
+	opened, err := parsed.Open(device)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	if opened.Type != msg.Type || opened.DeviceID != msg.DeviceID {
+		t.Errorf("Open() header = %s/%s, want %s/%s", opened.Type, opened.DeviceID, msg.Type, msg.DeviceID)
+	}
+	if opened.ID != msg.ID || opened.Timestamp != msg.Timestamp {
+		t.Errorf("Open() id/ts = %s/%d, want %s/%d", opened.ID, opened.Timestamp, msg.ID, msg.Timestamp)
+	}
+	if !bytes.Equal(opened.Payload, msg.Payload) {
+		t.Errorf("Open() payload = %s, want %s", opened.Payload, msg.Payload)
+	}
+}
+
+func TestOpenRejectsTamperedHeader(t *testing.T) {
+	tests := []struct {
+		name   string
+		tamper func(*Message)
+	}{
+		{"type", func(m *Message) { m.Type = MessageTypeClipboardSet }},
+		{"device id", func(m *Message) { m.DeviceID = "mobile-2" }},
+		{"counter", func(m *Message) { m.Sealed.Counter++ }},
+		{"id", func(m *Message) { m.ID = "other" }},
+		{"seq", func(m *Message) { m.Seq++ }},
+		{"timestamp", func(m *Message) { m.Timestamp-- }},
+		{"reply to", func(m *Message) { m.ReplyTo = "other" }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			daemon, device := sealedPair(t)
+
+			msg, _ := NewMessage(MessageTypeClipboardChanged, "mobile-1", &ClipboardPayload{Data: "x"})
+			sealed, err := msg.Seal(daemon)
+			if err != nil {
+				t.Fatalf("Seal() error = %v", err)
+			}
+			tt.tamper(sealed)
+
+			if _, err := sealed.Open(device); err == nil {
+				t.Error("Open() accepted a tampered message")
+			}
+		})
+	}
+}
+
+func TestOpenUnsealed(t *testing.T) {
+	_, device := sealedPair(t)
+
+	msg, _ := NewMessage(MessageTypeDevicePing, "mobile-1", nil)
+	if _, err := msg.Open(device); err == nil {
+		t.Error("Open() accepted a plain text message")
+	}
+}
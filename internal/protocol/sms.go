@@ -0,0 +1,78 @@
+package protocol
+
+import "errors"
+
+// SMS messages. The phone reports new messages, including the ones it sends,
+// with sms.received. The desktop asks it to send one with sms.send and for
+// the messages since a time with sms.sync, which the phone answers with an
+// sms.sync response.
+const (
+	MessageTypeSMSReceived MessageType = "sms.received"
+	MessageTypeSMSSend     MessageType = "sms.send"
+	MessageTypeSMSSync     MessageType = "sms.sync"
+)
+
+// SMSMessage is a text message on the phone
+type SMSMessage struct {
+	// ID is the phone's identifier for the message, unique on that phone
+	ID string `json:"id"`
+	// ThreadID is the phone's conversation the message belongs to
+	ThreadID string `json:"thread_id"`
+	// Address is the other party's phone number
+	Address string `json:"address"`
+	// ContactName is the address book name for Address, if there is one
+	ContactName string `json:"contact_name,omitempty"`
+	Body        string `json:"body"`
+	// Time is when the message was sent or received, in Unix milliseconds
+	Time int64 `json:"time"`
+	// Sent is set for messages sent from the phone
+	Sent bool `json:"sent,omitempty"`
+	Read bool `json:"read,omitempty"`
+}
+
+// Validate requires the fields that place a message in a conversation
+func (m *SMSMessage) Validate() error {
+	switch {
+	case m.ID == "":
+		return errors.New("sms id is required")
+	case m.ThreadID == "":
+		return errors.New("sms thread_id is required")
+	case m.Address == "":
+		return errors.New("sms address is required")
+	}
+	return nil
+}
+
+// SMSSendPayload asks the phone to send a text message
+type SMSSendPayload struct {
+	Address string `json:"address"`
+	Body    string `json:"body"`
+}
+
+// Validate requires a recipient and a body
+func (p *SMSSendPayload) Validate() error {
+	switch {
+	case p.Address == "":
+		return errors.New("sms address is required")
+	case p.Body == "":
+		return errors.New("sms body is required")
+	}
+	return nil
+}
+
+// SMSSyncPayload asks for the messages since Since, in Unix milliseconds,
+// and carries them in the phone's answer
+type SMSSyncPayload struct {
+	Since    int64        `json:"since,omitempty"`
+	Messages []SMSMessage `json:"messages,omitempty"`
+}
+
+// Validate checks every message
+func (p *SMSSyncPayload) Validate() error {
+	for i := range p.Messages {
+		if err := p.Messages[i].Validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
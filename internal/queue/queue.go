@@ -0,0 +1,292 @@
+package queue
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"eco/internal/config"
+	"eco/internal/protocol"
+)
+
+// Dir is the queue directory inside the config directory
+const Dir = "queue"
+
+// DefaultMaxPerDevice bounds how many messages are kept for one device
+const DefaultMaxPerDevice = 100
+
+// Policy controls how long messages of one type are kept and whether a newer
+// message replaces the ones already queued
+type Policy struct {
+	TTL      time.Duration
+	Coalesce bool
+}
+
+// DefaultPolicy applies to message types without their own policy
+var DefaultPolicy = Policy{TTL: 24 * time.Hour}
+
+// DefaultPolicies keeps only the latest clipboard and drops calls that have
+// long stopped ringing
+var DefaultPolicies = map[protocol.MessageType]Policy{
+	protocol.MessageTypeClipboardChanged: {TTL: 12 * time.Hour, Coalesce: true},
+	protocol.MessageTypeCallIncoming:     {TTL: 30 * time.Second},
+	protocol.MessageTypeDevicePing:       {TTL: 0},
+}
+
+// Entry is a queued message
+type Entry struct {
+	Message   *protocol.Message `json:"message"`
+	QueuedAt  time.Time         `json:"queued_at"`
+	ExpiresAt time.Time         `json:"expires_at"`
+}
+
+// Queue holds outbound messages for devices that are not connected. Each
+// device's queue is kept in its own file so it survives daemon restarts.
+type Queue struct {
+	mu       sync.Mutex
+	dir      string
+	max      int
+	policies map[protocol.MessageType]Policy
+	entries  map[string][]Entry
+	now      func() time.Time
+}
+
+// DefaultPath returns the queue directory next to the config file
+func DefaultPath() (string, error) {
+	cfgPath, err := config.ConfigPath()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(filepath.Dir(cfgPath), Dir), nil
+}
+
+// New opens the queue stored in dir, loading any messages left from a
+// previous run. max <= 0 uses DefaultMaxPerDevice.
+func New(dir string, max int) (*Queue, error) {
+	if max <= 0 {
+		max = DefaultMaxPerDevice
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+
+	q := &Queue{
+		dir:      dir,
+		max:      max,
+		policies: DefaultPolicies,
+		entries:  make(map[string][]Entry),
+		now:      time.Now,
+	}
+	if err := q.load(); err != nil {
+		return nil, err
+	}
+	return q, nil
+}
+
+// SetPolicy overrides the policy for msgType
+func (q *Queue) SetPolicy(msgType protocol.MessageType, p Policy) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	policies := make(map[protocol.MessageType]Policy, len(q.policies)+1)
+	for t, existing := range q.policies {
+		policies[t] = existing
+	}
+	policies[msgType] = p
+	q.policies = policies
+}
+
+// Enqueue stores msg for deviceID. Messages whose policy has no TTL are not
+// worth delivering late and are dropped.
+func (q *Queue) Enqueue(deviceID string, msg *protocol.Message) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	policy := q.policy(msg.Type)
+	if policy.TTL <= 0 {
+		return nil
+	}
+
+	now := q.now()
+	kept := q.live(deviceID, now)
+	if policy.Coalesce {
+		kept = dropType(kept, msg.Type)
+	}
+	kept = append(kept, Entry{
+		Message:   msg,
+		QueuedAt:  now,
+		ExpiresAt: now.Add(policy.TTL),
+	})
+	if over := len(kept) - q.max; over > 0 {
+		log.Printf("Queue: Dropping %d oldest messages for %s (limit %d)", over, deviceID, q.max)
+		kept = kept[over:]
+	}
+
+	q.entries[deviceID] = kept
+	return q.save(deviceID)
+}
+
+// Drain removes and returns the unexpired entries for deviceID, oldest first
+func (q *Queue) Drain(deviceID string) ([]Entry, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	kept := q.live(deviceID, q.now())
+	delete(q.entries, deviceID)
+	return kept, q.save(deviceID)
+}
+
+// Restore puts entries taken with Drain back in front of the queue for
+// deviceID, e.g. those that couldn't be sent. They keep their times, so
+// they still expire when they would have. Expired entries are dropped, and
+// so are coalesced types that a message queued since replaces.
+func (q *Queue) Restore(deviceID string, entries []Entry) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	now := q.now()
+	queued := q.live(deviceID, now)
+	var kept []Entry
+	for _, e := range entries {
+		if !now.Before(e.ExpiresAt) {
+			continue
+		}
+		replaced := slices.ContainsFunc(queued, func(n Entry) bool { return n.Message.Type == e.Message.Type })
+		if replaced && q.policy(e.Message.Type).Coalesce {
+			continue
+		}
+		kept = append(kept, e)
+	}
+	kept = append(kept, queued...)
+	if over := len(kept) - q.max; over > 0 {
+		log.Printf("Queue: Dropping %d oldest messages for %s (limit %d)", over, deviceID, q.max)
+		kept = kept[over:]
+	}
+
+	q.entries[deviceID] = kept
+	return q.save(deviceID)
+}
+
+// Pending returns how many unexpired messages are waiting for deviceID
+func (q *Queue) Pending(deviceID string) int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.live(deviceID, q.now()))
+}
+
+// PendingTotal returns how many unexpired messages are waiting for all devices
+func (q *Queue) PendingTotal() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	total := 0
+	now := q.now()
+	for deviceID := range q.entries {
+		total += len(q.live(deviceID, now))
+	}
+	return total
+}
+
+// Clear drops everything queued for deviceID, e.g. when it is unpaired
+func (q *Queue) Clear(deviceID string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	delete(q.entries, deviceID)
+	return q.save(deviceID)
+}
+
+func (q *Queue) policy(msgType protocol.MessageType) Policy {
+	if p, ok := q.policies[msgType]; ok {
+		return p
+	}
+	return DefaultPolicy
+}
+
+// live returns the unexpired entries for deviceID
+func (q *Queue) live(deviceID string, now time.Time) []Entry {
+	entries := q.entries[deviceID]
+	kept := entries[:0:0]
+	for _, e := range entries {
+		if now.Before(e.ExpiresAt) {
+			kept = append(kept, e)
+		}
+	}
+	return kept
+}
+
+func dropType(entries []Entry, msgType protocol.MessageType) []Entry {
+	kept := entries[:0]
+	for _, e := range entries {
+		if e.Message.Type != msgType {
+			kept = append(kept, e)
+		}
+	}
+	return kept
+}
+
+// path returns the file for deviceID. IDs are escaped so they can't leave dir.
+func (q *Queue) path(deviceID string) string {
+	return filepath.Join(q.dir, url.PathEscape(deviceID)+".json")
+}
+
+// save writes the queue for deviceID, removing the file once it is empty
+func (q *Queue) save(deviceID string) error {
+	path := q.path(deviceID)
+	entries := q.entries[deviceID]
+	if len(entries) == 0 {
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		return nil
+	}
+
+	data, err := json.Marshal(entries)
+	if err != nil {
+		return err
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// load reads every device queue in dir
+func (q *Queue) load() error {
+	files, err := os.ReadDir(q.dir)
+	if err != nil {
+		return err
+	}
+
+	for _, f := range files {
+		name, ok := strings.CutSuffix(f.Name(), ".json")
+		if !ok || f.IsDir() {
+			continue
+		}
+		deviceID, err := url.PathUnescape(name)
+		if err != nil {
+			continue
+		}
+
+		data, err := os.ReadFile(filepath.Join(q.dir, f.Name()))
+		if err != nil {
+			return err
+		}
+		var entries []Entry
+		if err := json.Unmarshal(data, &entries); err != nil {
+			log.Printf("Queue: Ignoring unreadable queue for %s: %v", deviceID, err)
+			continue
+		}
+		q.entries[deviceID] = entries
+	}
+	return nil
+}
@@ -0,0 +1,230 @@
+package queue
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"eco/internal/protocol"
+)
+
+func newTestQueue(t *testing.T, dir string, max int) *Queue {
+	t.Helper()
+	q, err := New(dir, max)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	return q
+}
+
+func message(t *testing.T, msgType protocol.MessageType, data string) *protocol.Message {
+	t.Helper()
+	msg, err := protocol.NewMessage(msgType, "mobile-1", &protocol.ClipboardPayload{Data: data})
+	if err != nil {
+		t.Fatalf("NewMessage() error = %v", err)
+	}
+	return msg
+}
+
+func payloads(t *testing.T, entries []Entry) []string {
+	t.Helper()
+	var out []string
+	for _, e := range entries {
+		m := e.Message
+		var p protocol.ClipboardPayload
+		if err := m.GetPayload(&p); err != nil {
+			t.Fatalf("GetPayload() error = %v", err)
+		}
+		out = append(out, string(m.Type)+":"+p.Data)
+	}
+	return out
+}
+
+func equal(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestEnqueueDrain(t *testing.T) {
+	tests := []struct {
+		name string
+		in   []*protocol.Message
+		want []string
+	}{
+		{
+			name: "keeps order",
+			in: []*protocol.Message{
+				message(t, protocol.MessageTypeNotificationPush, "a"),
+				message(t, protocol.MessageTypeNotificationPush, "b"),
+			},
+			want: []string{"notification.push:a", "notification.push:b"},
+		},
+		{
+			name: "coalesces clipboard",
+			in: []*protocol.Message{
+				message(t, protocol.MessageTypeClipboardChanged, "old"),
+				message(t, protocol.MessageTypeNotificationPush, "n"),
+				message(t, protocol.MessageTypeClipboardChanged, "new"),
+			},
+			want: []string{"notification.push:n", "clipboard.changed:new"},
+		},
+		{
+			name: "skips pings",
+			in: []*protocol.Message{
+				message(t, protocol.MessageTypeDevicePing, ""),
+			},
+			want: nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := newTestQueue(t, t.TempDir(), 0)
+			for _, m := range tt.in {
+				if err := q.Enqueue("mobile-1", m); err != nil {
+					t.Fatalf("Enqueue() error = %v", err)
+				}
+			}
+
+			if got := q.Pending("mobile-1"); got != len(tt.want) {
+				t.Errorf("Pending() = %d, want %d", got, len(tt.want))
+			}
+
+			msgs, err := q.Drain("mobile-1")
+			if err != nil {
+				t.Fatalf("Drain() error = %v", err)
+			}
+			if got := payloads(t, msgs); !equal(got, tt.want) {
+				t.Errorf("Drain() = %v, want %v", got, tt.want)
+			}
+			if got := q.Pending("mobile-1"); got != 0 {
+				t.Errorf("Pending() after Drain() = %d, want 0", got)
+			}
+		})
+	}
+}
+
+func TestQueueBound(t *testing.T) {
+	q := newTestQueue(t, t.TempDir(), 2)
+	for _, data := range []string{"1", "2", "3"} {
+		q.Enqueue("mobile-1", message(t, protocol.MessageTypeNotificationPush, data))
+	}
+
+	msgs, _ := q.Drain("mobile-1")
+	want := []string{"notification.push:2", "notification.push:3"}
+	if got := payloads(t, msgs); !equal(got, want) {
+		t.Errorf("Drain() = %v, want %v", got, want)
+	}
+}
+
+func TestQueueTTL(t *testing.T) {
+	q := newTestQueue(t, t.TempDir(), 0)
+	now := time.Now()
+	q.now = func() time.Time { return now }
+
+	q.Enqueue("mobile-1", message(t, protocol.MessageTypeCallIncoming, "call"))
+	q.Enqueue("mobile-1", message(t, protocol.MessageTypeNotificationPush, "note"))
+
+	now = now.Add(time.Minute)
+	if got := q.Pending("mobile-1"); got != 1 {
+		t.Errorf("Pending() after the call expired = %d, want 1", got)
+	}
+	if got := q.PendingTotal(); got != 1 {
+		t.Errorf("PendingTotal() = %d, want 1", got)
+	}
+
+	now = now.Add(DefaultPolicy.TTL)
+	msgs, _ := q.Drain("mobile-1")
+	if len(msgs) != 0 {
+		t.Errorf("Drain() returned %d expired messages", len(msgs))
+	}
+}
+
+func TestRestore(t *testing.T) {
+	q := newTestQueue(t, t.TempDir(), 0)
+	now := time.Now()
+	q.now = func() time.Time { return now }
+
+	q.Enqueue("mobile-1", message(t, protocol.MessageTypeNotificationPush, "old"))
+	q.Enqueue("mobile-1", message(t, protocol.MessageTypeClipboardChanged, "old"))
+	q.Enqueue("mobile-1", message(t, protocol.MessageTypeCallIncoming, "call"))
+	drained, _ := q.Drain("mobile-1")
+
+	// Newer messages queued meanwhile stay behind the restored ones, and a
+	// newer clipboard replaces the restored one
+	now = now.Add(time.Minute)
+	q.Enqueue("mobile-1", message(t, protocol.MessageTypeNotificationPush, "new"))
+	q.Enqueue("mobile-1", message(t, protocol.MessageTypeClipboardChanged, "new"))
+	if err := q.Restore("mobile-1", drained); err != nil {
+		t.Fatalf("Restore() error = %v", err)
+	}
+
+	entries, _ := q.Drain("mobile-1")
+	want := []string{"notification.push:old", "notification.push:new", "clipboard.changed:new"}
+	if got := payloads(t, entries); !equal(got, want) {
+		t.Errorf("Drain() after Restore() = %v, want %v", got, want)
+	}
+	if !entries[0].ExpiresAt.Equal(drained[0].ExpiresAt) {
+		t.Errorf("restored ExpiresAt = %v, want %v", entries[0].ExpiresAt, drained[0].ExpiresAt)
+	}
+}
+
+func TestSetPolicy(t *testing.T) {
+	q := newTestQueue(t, t.TempDir(), 0)
+	q.SetPolicy(protocol.MessageTypeNotificationPush, Policy{TTL: time.Hour, Coalesce: true})
+
+	q.Enqueue("mobile-1", message(t, protocol.MessageTypeNotificationPush, "a"))
+	q.Enqueue("mobile-1", message(t, protocol.MessageTypeNotificationPush, "b"))
+	if got := q.Pending("mobile-1"); got != 1 {
+		t.Errorf("Pending() = %d, want 1", got)
+	}
+
+	if DefaultPolicies[protocol.MessageTypeNotificationPush].Coalesce {
+		t.Error("SetPolicy() modified DefaultPolicies")
+	}
+}
+
+func TestQueuePersistence(t *testing.T) {
+	dir := t.TempDir()
+
+	q := newTestQueue(t, dir, 0)
+	q.Enqueue("mobile-1", message(t, protocol.MessageTypeClipboardChanged, "kept"))
+	q.Enqueue("../escape", message(t, protocol.MessageTypeNotificationPush, "other"))
+
+	// Device IDs can't point outside the queue directory
+	if _, err := os.Stat(filepath.Join(filepath.Dir(dir), "escape.json")); err == nil {
+		t.Error("queue file written outside its directory")
+	}
+
+	reopened := newTestQueue(t, dir, 0)
+	if got := reopened.PendingTotal(); got != 2 {
+		t.Fatalf("PendingTotal() after reopening = %d, want 2", got)
+	}
+
+	msgs, _ := reopened.Drain("mobile-1")
+	want := []string{"clipboard.changed:kept"}
+	if got := payloads(t, msgs); !equal(got, want) {
+		t.Errorf("Drain() = %v, want %v", got, want)
+	}
+
+	// Drained queues don't come back
+	if got := newTestQueue(t, dir, 0).Pending("mobile-1"); got != 0 {
+		t.Errorf("Pending() after Drain() and reopening = %d, want 0", got)
+	}
+
+	if err := reopened.Clear("../escape"); err != nil {
+		t.Fatalf("Clear() error = %v", err)
+	}
+	files, _ := os.ReadDir(dir)
+	if len(files) != 0 {
+		t.Errorf("queue directory has %d files after clearing everything", len(files))
+	}
+}
@@ -0,0 +1,110 @@
+package server
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"eco/internal/protocol"
+
+	"github.com/gorilla/websocket"
+)
+
+// pairTimeout bounds each step of the pairing exchange
+const pairTimeout = 30 * time.Second
+
+// handlePairing runs the SPAKE2 pairing exchange for a new device:
+//
+//	device -> pair.start    {device_name, message}
+//	server -> pair.reply    {message, confirm}
+//	device -> pair.confirm  {confirm}
+//	server -> pair.complete {ok, device_id}
+//
+// Neither the pairing code nor the resulting key crosses the wire.
+func (s *Server) handlePairing(w http.ResponseWriter, r *http.Request) {
+	conn, err := s.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Printf("Pair: Upgrade failed from %s: %v", r.RemoteAddr, err)
+		return
+	}
+	defer conn.Close()
+
+	deviceID, err := s.pair(conn)
+	if err != nil {
+		log.Printf("Pair: Pairing failed from %s: %v", r.RemoteAddr, err)
+		complete, _ := protocol.NewMessage(protocol.MessageTypePairComplete, "", &protocol.PairCompletePayload{
+			OK:    false,
+			Error: err.Error(),
+		})
+		conn.WriteJSON(complete)
+		return
+	}
+
+	log.Printf("Pair: Paired new device %s from %s", deviceID, r.RemoteAddr)
+	complete, _ := protocol.NewMessage(protocol.MessageTypePairComplete, deviceID, &protocol.PairCompletePayload{
+		OK:       true,
+		DeviceID: deviceID,
+	})
+	conn.WriteJSON(complete)
+}
+
+func (s *Server) pair(conn *websocket.Conn) (deviceID string, err error) {
+	var start protocol.PairStartPayload
+	if err := readPairMessage(conn, protocol.MessageTypePairStart, &start); err != nil {
+		return "", err
+	}
+
+	message, confirm, err := s.pairing.Start(start.DeviceName, start.Message)
+	if err != nil {
+		return "", err
+	}
+	// The code is spent now: if the device doesn't see the exchange
+	// through, fail the session so 'eco pair' stops waiting
+	defer func() {
+		if err != nil {
+			s.pairing.Abort(err.Error())
+		}
+	}()
+
+	reply, err := protocol.NewMessage(protocol.MessageTypePairReply, "", &protocol.PairReplyPayload{
+		Message: message,
+		Confirm: confirm,
+	})
+	if err != nil {
+		return "", err
+	}
+	if err := conn.WriteJSON(reply); err != nil {
+		return "", err
+	}
+
+	var deviceConfirm protocol.PairConfirmPayload
+	if err := readPairMessage(conn, protocol.MessageTypePairConfirm, &deviceConfirm); err != nil {
+		return "", err
+	}
+
+	dev, err := s.pairing.Confirm(deviceConfirm.Confirm)
+	if err != nil {
+		return "", err
+	}
+	return dev.ID, nil
+}
+
+// readPairMessage reads the next message, which must be of type want
+func readPairMessage(conn *websocket.Conn, want protocol.MessageType, payload any) error {
+	conn.SetReadDeadline(time.Now().Add(pairTimeout))
+	defer conn.SetReadDeadline(time.Time{})
+
+	_, data, err := conn.ReadMessage()
+	if err != nil {
+		return err
+	}
+	msg, err := protocol.ParseMessage(data)
+	if err != nil {
+		return err
+	}
+	if msg.Type != want {
+		return fmt.Errorf("expected %s, got %s", want, msg.Type)
+	}
+	return msg.GetPayload(payload)
+}
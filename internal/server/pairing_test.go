@@ -0,0 +1,99 @@
+package server
+
+import (
+	"bytes"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"eco/internal/crypto"
+	"eco/internal/pairing"
+	"eco/internal/protocol"
+)
+
+// TestPairingDeviceGoesAway starts an exchange and hangs up after
+// pair.reply, which must fail the session rather than leave 'eco pair'
+// waiting for it
+func TestPairingDeviceGoesAway(t *testing.T) {
+	s, srv := newTestServer(t)
+	session, err := s.Pairing().Begin("", time.Minute)
+	if err != nil {
+		t.Fatalf("Begin() error = %v", err)
+	}
+
+	exchange, _ := crypto.NewSPAKE2A([]byte(session.Code), pairing.DeviceIdentity, pairing.DaemonIdentity)
+	ws := dial(t, srv, "/pair")
+	send(t, ws, protocol.MessageTypePairStart, "", &protocol.PairStartPayload{
+		DeviceName: "Pixel",
+		Message:    hex.EncodeToString(exchange.Message()),
+	})
+	receive(t, ws, protocol.MessageTypePairReply, nil)
+	ws.Close()
+
+	deadline := time.Now().Add(2 * time.Second)
+	status, _ := s.Pairing().Status(session.Code)
+	for status.State == pairing.StateExchanging && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+		status, _ = s.Pairing().Status(session.Code)
+	}
+	if status.State != pairing.StateFailed {
+		t.Errorf("State = %s, want %s", status.State, pairing.StateFailed)
+	}
+}
+
+func TestQRCode(t *testing.T) {
+	tests := []struct {
+		name     string
+		ttl      time.Duration
+		code     func(active, replaced string) string
+		wantCode int
+	}{
+		{"Active code", time.Minute, func(active, _ string) string { return active }, http.StatusOK},
+		{"Missing code", time.Minute, func(string, string) string { return "" }, http.StatusNotFound},
+		{"Wrong code", time.Minute, func(string, string) string { return "not-the-code" }, http.StatusNotFound},
+		{"Replaced code", time.Minute, func(_, replaced string) string { return replaced }, http.StatusNotFound},
+		{"Expired code", time.Millisecond, func(active, _ string) string { return active }, http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, srv := newTestServer(t)
+			replaced, _ := s.Pairing().Begin("", time.Minute)
+			session, err := s.Pairing().Begin("", tt.ttl)
+			if err != nil {
+				t.Fatalf("Begin() error = %v", err)
+			}
+			time.Sleep(10 * time.Millisecond)
+
+			code := tt.code(session.Code, replaced.Code)
+			resp, err := http.Get(srv.URL + "/qr?code=" + url.QueryEscape(code))
+			if err != nil {
+				t.Fatalf("GET /qr error = %v", err)
+			}
+			defer resp.Body.Close()
+			body, _ := io.ReadAll(resp.Body)
+
+			if resp.StatusCode != tt.wantCode {
+				t.Fatalf("GET /qr?code=%s status = %d, want %d", code, resp.StatusCode, tt.wantCode)
+			}
+			if tt.wantCode != http.StatusOK {
+				if bytes.Contains(body, []byte(session.Code)) {
+					t.Errorf("error page contains the pairing code: %s", body)
+				}
+				return
+			}
+			if ct := resp.Header.Get("Content-Type"); ct != "image/png" {
+				t.Errorf("Content-Type = %q, want image/png", ct)
+			}
+			if cc := resp.Header.Get("Cache-Control"); cc != "no-store" {
+				t.Errorf("Cache-Control = %q, want no-store", cc)
+			}
+			if !bytes.HasPrefix(body, []byte("\x89PNG")) {
+				t.Error("body is not a PNG image")
+			}
+		})
+	}
+}
@@ -2,34 +2,95 @@ package server
 
 import (
 	"context"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
+	"net/url"
+	"os"
+	"runtime"
+	"slices"
+	"sync"
+	"time"
 
 	"eco/internal/auth"
+	"eco/internal/bus"
+	"eco/internal/clipboard"
 	"eco/internal/config"
+	"eco/internal/crypto"
 	"eco/internal/device"
+	"eco/internal/dispatch"
 	"eco/internal/events"
+	"eco/internal/input"
+	"eco/internal/notifications"
+	"eco/internal/pairing"
 	"eco/internal/protocol"
+	"eco/internal/queue"
+	"eco/internal/sms"
+	"eco/internal/tlscert"
+	"eco/internal/transfer"
 
 	"github.com/gorilla/websocket"
+	"github.com/skip2/go-qrcode"
 )
 
-// Server manages the WebSocket server and device connection
+// authTimeout bounds how long a new connection may take to answer the challenge
+const authTimeout = 10 * time.Second
+
+// daemonCapabilities are the features this daemon offers to devices
+var daemonCapabilities = []string{
+	protocol.CapabilityClipboard,
+	protocol.CapabilityNotifications,
+	protocol.CapabilityCalls,
+	protocol.CapabilitySMS,
+	protocol.CapabilityFiles,
+	protocol.CapabilityMobile,
+}
+
+// SMSSendTimeout bounds how long sending a text message waits for the phone
+const SMSSendTimeout = 15 * time.Second
+
+// Why a request couldn't be sent to a device. ErrNoDevice is wrapped when
+// no device was given and there isn't exactly one connected to pick.
+var (
+	ErrNoDevice     = errors.New("no device chosen")
+	ErrNotConnected = errors.New("not connected")
+	ErrUnsupported  = errors.New("not supported")
+)
+
+// errClipboardNeverSynced is why clipboard events skip a device with
+// NeverSyncClipboard set
+var errClipboardNeverSynced = errors.New("clipboard sync is turned off for this device")
+
+// Server manages the WebSocket server and device connections
 type Server struct {
 	config      *config.Config
-	deviceConn  *device.Connection
+	configMu    sync.Mutex
+	connsMu     sync.RWMutex
+	deviceConns map[string]*device.Connection
 	upgrader    websocket.Upgrader
 	eventRouter *events.Router
+	pairing     *pairing.Manager
 	httpServer  *http.Server
+	tls         *tlscert.Store
 	staticPath  string
 	pwaBaseURL  string
+	appVersion  string
+	smsStore    *sms.Store
+	transfers   *transfer.Manager
+	// input is nil when there is no virtual input device
+	input *input.Injector
+	// authTimeout bounds each step of the handshake of a new connection
+	authTimeout time.Duration
 }
 
-// NewServer creates a new WebSocket server
-func NewServer(cfg *config.Config) *Server {
-	return &Server{
-		config: cfg,
+// NewServer creates a new WebSocket server that routes events from eventBus
+// to the connected devices
+func NewServer(cfg *config.Config, eventBus *bus.Bus) *Server {
+	s := &Server{
+		config:      cfg,
+		deviceConns: make(map[string]*device.Connection),
 		upgrader: websocket.Upgrader{
 			ReadBufferSize:  1024,
 			WriteBufferSize: 1024,
@@ -37,9 +98,118 @@ func NewServer(cfg *config.Config) *Server {
 				return true
 			},
 		},
-		eventRouter: events.NewRouter(),
+		eventRouter: events.NewRouter(eventBus),
 		httpServer:  &http.Server{},
+		authTimeout: authTimeout,
+	}
+	s.pairing = pairing.NewManager(s.AddDevice)
+	s.eventRouter.SetCapabilityLookup(s.deviceCapabilities)
+	s.eventRouter.SetEventFilter(s.devicePolicy)
+	return s
+}
+
+// SetAppVersion sets the version the daemon reports in device.hello
+func (s *Server) SetAppVersion(version string) {
+	s.appVersion = version
+}
+
+// SetClipboard sets the clipboard that devices write to, recording their
+// writes in tracker
+func (s *Server) SetClipboard(backend clipboard.Backend, tracker *clipboard.Tracker) {
+	s.eventRouter.SetClipboard(backend, tracker)
+}
+
+// SetNotifier sets the notifier that shows notifications pushed by devices
+func (s *Server) SetNotifier(n *notifications.Notifier) {
+	s.eventRouter.SetNotifier(n)
+}
+
+// SetSMSStore sets the store for the text messages of devices, which are
+// synced when they connect
+func (s *Server) SetSMSStore(store *sms.Store) {
+	s.smsStore = store
+	s.eventRouter.SetSMSStore(store)
+}
+
+// SendSMS asks deviceID, or the only connected device if it is empty, to
+// send a text message and waits for it to accept
+func (s *Server) SendSMS(deviceID, address, body string) (string, error) {
+	resp, err := s.RequestFromDevice(deviceID, protocol.MessageTypeSMSSend, &protocol.SMSSendPayload{
+		Address: address,
+		Body:    body,
+	}, SMSSendTimeout)
+	if err != nil {
+		return "", err
+	}
+	return resp.DeviceID, nil
+}
+
+// SetTransfers sets the manager for file transfers, whose data devices
+// move over /upload and /download
+func (s *Server) SetTransfers(m *transfer.Manager) {
+	s.transfers = m
+	s.eventRouter.SetTransfers(m)
+}
+
+// SendFile offers the file or folder at path to deviceID, or the only
+// connected device if it is empty. Folders are zstd compressed if compress
+// is set.
+func (s *Server) SendFile(deviceID, path string, compress bool) (transfer.Transfer, error) {
+	conn, err := s.requestTarget(deviceID)
+	if err != nil {
+		return transfer.Transfer{}, err
+	}
+	if !conn.Supports(protocol.CapabilityFiles) {
+		return transfer.Transfer{}, fmt.Errorf("%s is %w by device %s", protocol.CapabilityFiles, ErrUnsupported, conn.GetDeviceID())
+	}
+	return s.eventRouter.OfferFile(conn, path, compress)
+}
+
+// AcceptFile accepts the file a device offered as id, saving it in dir or,
+// if it is empty, the default directory, with the conflict policy
+func (s *Server) AcceptFile(id, dir string, conflict transfer.Conflict) (transfer.Transfer, error) {
+	return s.eventRouter.AcceptFile(id, dir, conflict)
+}
+
+// SetInput sets the virtual keyboard and mouse that devices the user
+// allowed can control, and offers input to devices
+func (s *Server) SetInput(in *input.Injector) {
+	s.input = in
+	s.eventRouter.SetInput(in, s.inputAllowed)
+}
+
+// AllowInput lets deviceID control the keyboard and mouse, or stops it, and
+// persists the choice
+func (s *Server) AllowInput(deviceID string, allow bool) error {
+	err := s.updateConfig(func(cfg *config.Config) error {
+		return cfg.AllowDeviceInput(deviceID, allow)
+	})
+	if err != nil {
+		return err
+	}
+	if !allow && s.input != nil {
+		s.input.Release(deviceID)
+	}
+	return nil
+}
+
+// inputAllowed reports whether the user lets deviceID control the keyboard
+// and mouse
+func (s *Server) inputAllowed(deviceID string) bool {
+	s.configMu.Lock()
+	defer s.configMu.Unlock()
+
+	d := s.config.FindDevice(deviceID)
+	return d != nil && d.AllowInput
+}
+
+// capabilities returns the features offered to devices, with input only
+// when there is a virtual input device
+func (s *Server) capabilities() []string {
+	if s.input == nil {
+		return daemonCapabilities
 	}
+	return append(slices.Clip(daemonCapabilities), protocol.CapabilityInput)
 }
 
 // SetStaticPath sets the path to serve static PWA files from
@@ -52,6 +222,47 @@ func (s *Server) SetPWABaseURL(url string) {
 	s.pwaBaseURL = url
 }
 
+// SetTLS serves https:// and wss:// with the certificate from store
+func (s *Server) SetTLS(store *tlscert.Store) {
+	s.tls = store
+}
+
+// CertFingerprint returns the fingerprint clients should pin, or "" without TLS
+func (s *Server) CertFingerprint() string {
+	if s.tls == nil {
+		return ""
+	}
+	return s.tls.Fingerprint()
+}
+
+// ReloadCertificate picks up a certificate rotated on disk for new connections
+func (s *Server) ReloadCertificate() error {
+	if s.tls == nil {
+		return fmt.Errorf("TLS is disabled")
+	}
+	return s.tls.Reload()
+}
+
+// SetOfflineQueue keeps events for paired devices that are offline in q and
+// delivers them when the device reconnects
+func (s *Server) SetOfflineQueue(q *queue.Queue) {
+	s.eventRouter.SetOfflineQueue(q, s.enabledDeviceIDs)
+}
+
+// enabledDeviceIDs returns the paired devices that may receive events
+func (s *Server) enabledDeviceIDs() []string {
+	s.configMu.Lock()
+	defer s.configMu.Unlock()
+
+	ids := make([]string, 0, len(s.config.Devices))
+	for _, d := range s.config.Devices {
+		if d.Enabled {
+			ids = append(ids, d.ID)
+		}
+	}
+	return ids
+}
+
 // Start begins listening for WebSocket connections
 func (s *Server) Start() error {
 	// Serve PWA static files FIRST (lower priority)
@@ -69,7 +280,12 @@ func (s *Server) Start() error {
 
 	// API endpoints (higher priority)
 	http.HandleFunc("/ws", s.handleWebSocket)
+	http.HandleFunc("/pair", s.handlePairing)
 	http.HandleFunc("/qr", s.handleQRCode)
+	if s.transfers != nil {
+		http.HandleFunc("GET /download/{id}", s.transfers.HandleDownload)
+		http.HandleFunc("POST /upload/{id}", s.transfers.HandleUpload)
+	}
 
 	s.httpServer.Addr = ":4949"
 
@@ -78,8 +294,16 @@ func (s *Server) Start() error {
 		return err
 	}
 
-	s.httpServer.ListenAndServe()
-	fmt.Println("HTTP Server started on port 4949")
+	if s.tls != nil {
+		s.httpServer.TLSConfig = s.tls.TLSConfig()
+		err = s.httpServer.ListenAndServeTLS("", "")
+	} else {
+		err = s.httpServer.ListenAndServe()
+	}
+	if err != nil && err != http.ErrServerClosed {
+		log.Printf("Server: %v", err)
+		return err
+	}
 	return nil
 }
 
@@ -89,8 +313,8 @@ func (s *Server) Stop() error {
 	if err != nil {
 		return err
 	}
-	if s.deviceConn != nil && s.deviceConn.IsConnected() {
-		s.deviceConn.Stop()
+	for _, conn := range s.GetDeviceConnections() {
+		conn.Stop()
 	}
 	err = s.httpServer.Shutdown(context.TODO())
 	if err != nil {
@@ -101,11 +325,6 @@ func (s *Server) Stop() error {
 
 // handleWebSocket upgrades HTTP to WebSocket and handles the connection
 func (s *Server) handleWebSocket(w http.ResponseWriter, r *http.Request) {
-	if s.deviceConn != nil && s.deviceConn.IsConnected() {
-		http.Error(w, "Device already connected", http.StatusServiceUnavailable)
-		return
-	}
-
 	conn, err := s.upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		log.Printf("WS: Upgrade failed from %s: %v", r.RemoteAddr, err)
@@ -113,59 +332,524 @@ func (s *Server) handleWebSocket(w http.ResponseWriter, r *http.Request) {
 	}
 	log.Printf("WS: Connection upgraded from %s", r.RemoteAddr)
 
-	// Read the first message for authentication
-	_, data, err := conn.ReadMessage()
+	deviceID, err := s.authenticate(conn)
 	if err != nil {
-		log.Println("Failed to read auth message:", err)
+		log.Printf("WS: Authentication failed from %s: %v", r.RemoteAddr, err)
 		conn.Close()
 		return
 	}
 
-	msg, err := protocol.ParseMessage(data)
+	log.Printf("WS: Authentication successful for device: %s", deviceID)
+
+	cipher, hello, err := s.negotiate(conn, deviceID)
 	if err != nil {
-		log.Println("Failed to parse message:", err)
+		log.Printf("WS: Negotiation failed for device %s: %v", deviceID, err)
 		conn.Close()
 		return
 	}
 
-	if auth.NewAuthenticator(s.config).ValidateCredentials(msg.DeviceID, msg.Secret) {
-		log.Printf("WS: Authentication successful for device: %s", msg.DeviceID)
-		s.deviceConn = device.NewConnection(msg.DeviceID, conn)
-		s.deviceConn.SetHandler(s.eventRouter.CreateMessageHandler())
-		s.eventRouter.SetDeviceConnection(s.deviceConn)
-		go s.deviceConn.Start()
+	deviceConn := device.NewConnection(deviceID, conn)
+	if cipher != nil {
+		deviceConn.SetCipher(cipher)
 	} else {
-		log.Printf("WS: Authentication failed for device: %s (ID: %s, Secret: [REDACTED])", msg.DeviceID, msg.DeviceID)
-		conn.Close()
+		log.Printf("WS: Device %s did not offer encryption, payloads are sent in plain text", deviceID)
+	}
+	deviceConn.SetCapabilities(protocol.IntersectCapabilities(hello.AdvertisedCapabilities(), s.capabilities()))
+	log.Printf("WS: Device %s (%s %s, protocol %d) negotiated: %v", deviceID, hello.Platform, hello.AppVersion, hello.ProtocolVersion, deviceConn.Capabilities())
+	deviceConn.SetHandler(s.eventRouter.CreateMessageHandler(deviceConn))
+	deviceConn.Start()
+	s.addDeviceConnection(deviceConn)
+	s.touchDevice(deviceID, hello)
+}
+
+// authenticate runs the challenge-response handshake on a new connection:
+//
+//	server -> auth.challenge {nonce}
+//	device -> auth.response  {proof} with its device_id
+//	server -> auth.result    {ok}
+//
+// The shared secret itself never crosses the wire.
+func (s *Server) authenticate(conn *websocket.Conn) (string, error) {
+	nonce, err := auth.NewChallenge()
+	if err != nil {
+		return "", err
+	}
+
+	challenge, err := protocol.NewMessage(protocol.MessageTypeAuthChallenge, "", &protocol.AuthChallengePayload{
+		Nonce: hex.EncodeToString(nonce),
+	})
+	if err != nil {
+		return "", err
+	}
+	if err := conn.WriteJSON(challenge); err != nil {
+		return "", fmt.Errorf("failed to send challenge: %w", err)
+	}
+
+	conn.SetReadDeadline(time.Now().Add(s.authTimeout))
+	defer conn.SetReadDeadline(time.Time{})
+
+	_, data, err := conn.ReadMessage()
+	if err != nil {
+		return "", fmt.Errorf("failed to read auth response: %w", err)
+	}
+
+	msg, err := protocol.ParseMessage(data)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse auth response: %w", err)
+	}
+	if msg.Type != protocol.MessageTypeAuthResponse {
+		return "", fmt.Errorf("expected %s, got %s", protocol.MessageTypeAuthResponse, msg.Type)
+	}
+
+	var response protocol.AuthResponsePayload
+	if err := msg.GetPayload(&response); err != nil {
+		return "", fmt.Errorf("invalid auth response payload: %w", err)
+	}
+
+	s.configMu.Lock()
+	valid := auth.NewAuthenticator(s.config).VerifyProof(msg.DeviceID, nonce, response.Proof)
+	s.configMu.Unlock()
+
+	result := &protocol.AuthResultPayload{OK: valid}
+	if !valid {
+		result.Error = "authentication failed"
+	}
+	reply, err := protocol.NewMessage(protocol.MessageTypeAuthResult, msg.DeviceID, result)
+	if err != nil {
+		return "", err
+	}
+	if err := conn.WriteJSON(reply); err != nil {
+		return "", fmt.Errorf("failed to send auth result: %w", err)
+	}
+
+	if !valid {
+		return "", fmt.Errorf("invalid proof for device %s", msg.DeviceID)
+	}
+	return msg.DeviceID, nil
+}
+
+// negotiate reads the device's hello and agrees on payload encryption:
+//
+//	device -> device.hello {device_name, encryption, nonce}
+//	server -> device.hello {encryption, nonce}
+//
+// The session keys are derived from the device's secret and both nonces. A
+// device that doesn't offer a supported cipher gets a plain text session
+// (nil cipher), unless the config requires encryption.
+//
+// The daemon's answer also carries its own version, platform and
+// capabilities. The device's hello is returned for the caller to record.
+func (s *Server) negotiate(conn *websocket.Conn, deviceID string) (*crypto.PayloadCipher, *protocol.DevicePayload, error) {
+	conn.SetReadDeadline(time.Now().Add(s.authTimeout))
+	defer conn.SetReadDeadline(time.Time{})
+
+	_, data, err := conn.ReadMessage()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read hello: %w", err)
+	}
+
+	msg, err := protocol.ParseMessage(data)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to parse hello: %w", err)
+	}
+	if msg.Type != protocol.MessageTypeDeviceHello {
+		return nil, nil, fmt.Errorf("expected %s, got %s", protocol.MessageTypeDeviceHello, msg.Type)
+	}
+
+	var hello protocol.DevicePayload
+	if err := msg.GetPayload(&hello); err != nil {
+		return nil, nil, fmt.Errorf("invalid hello payload: %w", err)
+	}
+	answer := s.helloPayload()
+
+	if !slices.Contains(hello.Encryption, crypto.CipherXChaCha20Poly1305) {
+		s.configMu.Lock()
+		required := s.config.RequireEncryption
+		s.configMu.Unlock()
+
+		if required {
+			refusal, err := protocol.NewMessage(protocol.MessageTypeDeviceDisconnect, deviceID, &protocol.DisconnectPayload{
+				Reason: "payload encryption is required",
+			})
+			if err == nil {
+				conn.WriteJSON(refusal)
+			}
+			return nil, nil, fmt.Errorf("device does not support payload encryption")
+		}
+
+		reply, err := protocol.NewMessage(protocol.MessageTypeDeviceHello, deviceID, answer)
+		if err != nil {
+			return nil, nil, err
+		}
+		return nil, &hello, conn.WriteJSON(reply)
+	}
+
+	deviceNonce, err := hex.DecodeString(hello.Nonce)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid hello nonce: %w", err)
+	}
+	daemonNonce, err := crypto.GenerateRandomBytes(crypto.SessionNonceSize)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	s.configMu.Lock()
+	var secret string
+	if d := s.config.FindDevice(deviceID); d != nil {
+		secret = d.Secret
+	}
+	s.configMu.Unlock()
+	if secret == "" {
+		return nil, nil, fmt.Errorf("device %s is no longer paired", deviceID)
+	}
+
+	cipher, err := crypto.NewPayloadCipher(secret, deviceID, deviceNonce, daemonNonce, true)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	answer.Encryption = []string{crypto.CipherXChaCha20Poly1305}
+	answer.Nonce = hex.EncodeToString(daemonNonce)
+	reply, err := protocol.NewMessage(protocol.MessageTypeDeviceHello, deviceID, answer)
+	if err != nil {
+		return nil, nil, err
 	}
+	if err := conn.WriteJSON(reply); err != nil {
+		return nil, nil, fmt.Errorf("failed to send hello: %w", err)
+	}
+	return cipher, &hello, nil
 }
 
-// GetDeviceConnection returns the current device connection (if any)
-func (s *Server) GetDeviceConnection() *device.Connection {
-	return s.deviceConn
+// helloPayload describes the daemon in its device.hello answer
+func (s *Server) helloPayload() *protocol.DevicePayload {
+	hostname, _ := os.Hostname()
+	return &protocol.DevicePayload{
+		DeviceName:      hostname,
+		ProtocolVersion: protocol.ProtocolVersion,
+		AppVersion:      s.appVersion,
+		Platform:        runtime.GOOS,
+		Capabilities:    s.capabilities(),
+	}
 }
 
-// IsDeviceConnected returns true if a device is currently connected
-func (s *Server) IsDeviceConnected() bool {
-	return s.deviceConn != nil && s.deviceConn.IsConnected()
+// addDeviceConnection registers conn, replacing any stale connection for the same device
+func (s *Server) addDeviceConnection(conn *device.Connection) {
+	s.connsMu.Lock()
+	old := s.deviceConns[conn.GetDeviceID()]
+	s.deviceConns[conn.GetDeviceID()] = conn
+	s.connsMu.Unlock()
+
+	if old != nil {
+		log.Printf("WS: Replacing existing connection for device: %s", conn.GetDeviceID())
+		old.Stop()
+	}
+	s.eventRouter.AddDeviceConnection(conn)
+
+	go func() {
+		<-conn.Done()
+		s.removeDeviceConnection(conn)
+	}()
+
+	if s.smsStore != nil && conn.Supports(protocol.CapabilitySMS) {
+		go func() {
+			if err := s.eventRouter.SyncSMS(conn); err != nil {
+				log.Printf("WS: Failed to sync text messages from %s: %v", conn.GetDeviceID(), err)
+			}
+		}()
+	}
 }
 
-// BroadcastEvent sends an event to the connected device
-func (s *Server) BroadcastEvent(eventType protocol.MessageType, payload any) error {
-	if !s.IsDeviceConnected() {
-		return fmt.Errorf("no device connected")
+// removeDeviceConnection unregisters conn once it has shut down
+func (s *Server) removeDeviceConnection(conn *device.Connection) {
+	s.connsMu.Lock()
+	if s.deviceConns[conn.GetDeviceID()] == conn {
+		delete(s.deviceConns, conn.GetDeviceID())
+	}
+	s.connsMu.Unlock()
+
+	s.eventRouter.RemoveDeviceConnection(conn)
+	log.Printf("WS: Device disconnected: %s", conn.GetDeviceID())
+}
+
+// touchDevice records the last time a device was seen, and what it
+// advertised in its hello, and persists it
+func (s *Server) touchDevice(deviceID string, hello *protocol.DevicePayload) {
+	s.configMu.Lock()
+	defer s.configMu.Unlock()
+
+	d := s.config.FindDevice(deviceID)
+	if d == nil {
+		return
+	}
+	d.LastSeen = time.Now()
+	d.Platform = hello.Platform
+	d.AppVersion = hello.AppVersion
+	d.ProtocolVersion = hello.ProtocolVersion
+	d.Capabilities = hello.AdvertisedCapabilities()
+	if err := s.config.Save(); err != nil {
+		log.Printf("Server: Failed to save config: %v", err)
+	}
+}
+
+// deviceCapabilities returns what deviceID advertised when it last connected
+func (s *Server) deviceCapabilities(deviceID string) []string {
+	s.configMu.Lock()
+	defer s.configMu.Unlock()
+
+	if d := s.config.FindDevice(deviceID); d != nil {
+		return d.Capabilities
+	}
+	return nil
+}
+
+// devicePolicy applies the per-device settings in the config to events
+// before they are sent
+func (s *Server) devicePolicy(deviceID string, event bus.Event) error {
+	s.configMu.Lock()
+	defer s.configMu.Unlock()
+
+	d := s.config.FindDevice(deviceID)
+	if d != nil && d.NeverSyncClipboard && event.Type.Capability() == protocol.CapabilityClipboard {
+		return errClipboardNeverSynced
+	}
+	return nil
+}
+
+// AddDevice adds a newly paired device to the config and persists it
+func (s *Server) AddDevice(d *config.Device) error {
+	return s.updateConfig(func(cfg *config.Config) error {
+		return cfg.AddDevice(d)
+	})
+}
+
+// RemoveDevice unpairs deviceID, disconnects it and drops the messages
+// queued for it
+func (s *Server) RemoveDevice(deviceID string) error {
+	err := s.updateConfig(func(cfg *config.Config) error {
+		return cfg.RemoveDevice(deviceID)
+	})
+	if err != nil {
+		return err
 	}
+	s.DisconnectDevice(deviceID)
+	return s.eventRouter.ClearQueue(deviceID)
+}
 
-	//check appropriate secret later
-	_, err := protocol.NewMessage(eventType, s.deviceConn.GetDeviceID(), s.config.SharedSecret, payload)
+// RenameDevice changes the display name of deviceID
+func (s *Server) RenameDevice(deviceID, name string) error {
+	return s.updateConfig(func(cfg *config.Config) error {
+		return cfg.RenameDevice(deviceID, name)
+	})
+}
+
+// EnableDevice lets deviceID connect, or disconnects it and keeps it out
+func (s *Server) EnableDevice(deviceID string, enabled bool) error {
+	err := s.updateConfig(func(cfg *config.Config) error {
+		return cfg.EnableDevice(deviceID, enabled)
+	})
 	if err != nil {
 		return err
 	}
+	if !enabled {
+		s.DisconnectDevice(deviceID)
+	}
+	return nil
+}
+
+// SyncClipboard sends desktop clipboard changes to deviceID, or stops
+func (s *Server) SyncClipboard(deviceID string, sync bool) error {
+	return s.updateConfig(func(cfg *config.Config) error {
+		return cfg.SyncDeviceClipboard(deviceID, sync)
+	})
+}
+
+// updateConfig applies change to the config and persists it. The CLI edits
+// devices through here while the daemon runs, as the daemon would overwrite
+// changes made to the file.
+func (s *Server) updateConfig(change func(cfg *config.Config) error) error {
+	s.configMu.Lock()
+	defer s.configMu.Unlock()
+
+	if err := change(s.config); err != nil {
+		return err
+	}
+	return s.config.Save()
+}
+
+// Pairing returns the pairing session manager
+func (s *Server) Pairing() *pairing.Manager {
+	return s.pairing
+}
+
+// GetDeviceConnection returns the connection for deviceID (if any)
+func (s *Server) GetDeviceConnection(deviceID string) *device.Connection {
+	s.connsMu.RLock()
+	defer s.connsMu.RUnlock()
+	return s.deviceConns[deviceID]
+}
+
+// GetDeviceConnections returns all current device connections
+func (s *Server) GetDeviceConnections() []*device.Connection {
+	s.connsMu.RLock()
+	defer s.connsMu.RUnlock()
+
+	conns := make([]*device.Connection, 0, len(s.deviceConns))
+	for _, conn := range s.deviceConns {
+		conns = append(conns, conn)
+	}
+	return conns
+}
+
+// IsDeviceConnected returns true if deviceID is currently connected
+func (s *Server) IsDeviceConnected(deviceID string) bool {
+	conn := s.GetDeviceConnection(deviceID)
+	return conn != nil && conn.IsConnected()
+}
+
+// DisconnectDevice closes the connection for deviceID
+func (s *Server) DisconnectDevice(deviceID string) error {
+	conn := s.GetDeviceConnection(deviceID)
+	if conn == nil {
+		return fmt.Errorf("device %s is not connected", deviceID)
+	}
+	conn.Stop()
 	return nil
 }
 
-// handleQRCode generates a QR code for easy PWA connection
+// PairedDevices returns a snapshot of the paired device registry
+func (s *Server) PairedDevices() []config.Device {
+	s.configMu.Lock()
+	defer s.configMu.Unlock()
+
+	devices := make([]config.Device, 0, len(s.config.Devices))
+	for _, d := range s.config.Devices {
+		devices = append(devices, *d)
+	}
+	return devices
+}
+
+// LastSync returns when a message was last exchanged with any device
+func (s *Server) LastSync() time.Time {
+	return s.eventRouter.LastSync()
+}
+
+// QueueDepth returns the number of events waiting to be routed to devices
+func (s *Server) QueueDepth() int {
+	return s.eventRouter.QueueDepth()
+}
+
+// HandlerStats returns per message type counters of handled device messages
+func (s *Server) HandlerStats() map[protocol.MessageType]dispatch.Stats {
+	return s.eventRouter.HandlerStats()
+}
+
+// PendingMessages returns how many messages are queued for deviceID while it is offline
+func (s *Server) PendingMessages(deviceID string) int {
+	return s.eventRouter.PendingMessages(deviceID)
+}
+
+// PendingTotal returns how many messages are queued for offline devices
+func (s *Server) PendingTotal() int {
+	return s.eventRouter.PendingTotal()
+}
+
+// BroadcastEvent sends an event to every connected device
+func (s *Server) BroadcastEvent(eventType protocol.MessageType, payload any) error {
+	conns := s.GetDeviceConnections()
+	if len(conns) == 0 {
+		return fmt.Errorf("no device connected")
+	}
+
+	for _, conn := range conns {
+		if !conn.Supports(eventType.Capability()) {
+			continue
+		}
+		msg, err := protocol.NewMessage(eventType, conn.GetDeviceID(), payload)
+		if err != nil {
+			return err
+		}
+		if err := conn.Send(msg); err != nil {
+			log.Printf("Server: Failed to send %s to %s: %v", eventType, conn.GetDeviceID(), err)
+		}
+	}
+	return nil
+}
+
+// AnswerCall asks the phone to answer its ringing call. An empty deviceID
+// picks the only device with a call.
+func (s *Server) AnswerCall(deviceID string) (events.Call, error) {
+	return s.eventRouter.AnswerCall(deviceID)
+}
+
+// HangupCall asks the phone to decline or end its call. An empty deviceID
+// picks the only device with a call.
+func (s *Server) HangupCall(deviceID string) (events.Call, error) {
+	return s.eventRouter.HangupCall(deviceID)
+}
+
+// RequestFromDevice sends a request to deviceID and waits for its response.
+// An empty deviceID picks the connected device when there is exactly one.
+func (s *Server) RequestFromDevice(deviceID string, msgType protocol.MessageType, payload any, timeout time.Duration) (*protocol.Message, error) {
+	conn, err := s.requestTarget(deviceID)
+	if err != nil {
+		return nil, err
+	}
+	if capability := msgType.Capability(); !conn.Supports(capability) {
+		return nil, fmt.Errorf("%s is %w by device %s", capability, ErrUnsupported, conn.GetDeviceID())
+	}
+
+	msg, err := protocol.NewMessage(msgType, conn.GetDeviceID(), payload)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := conn.Request(msg, timeout)
+	if err != nil {
+		return nil, err
+	}
+	// Devices may leave their ID out of replies
+	resp.DeviceID = conn.GetDeviceID()
+	return resp, nil
+}
+
+// requestTarget returns the connection a request for deviceID goes to
+func (s *Server) requestTarget(deviceID string) (*device.Connection, error) {
+	if deviceID != "" {
+		conn := s.GetDeviceConnection(deviceID)
+		if conn == nil || !conn.IsConnected() {
+			return nil, fmt.Errorf("device %s is %w", deviceID, ErrNotConnected)
+		}
+		return conn, nil
+	}
+
+	var connected []*device.Connection
+	for _, conn := range s.GetDeviceConnections() {
+		if conn.IsConnected() {
+			connected = append(connected, conn)
+		}
+	}
+	switch len(connected) {
+	case 0:
+		return nil, fmt.Errorf("%w: none is connected", ErrNoDevice)
+	case 1:
+		return connected[0], nil
+	default:
+		return nil, fmt.Errorf("%w: %d are connected, choose one with --device", ErrNoDevice, len(connected))
+	}
+}
+
+// qrSize is the width and height of pairing QR codes in pixels
+const qrSize = 300
+
+// handleQRCode renders the QR code of the pairing code given as ?code=, for
+// the mobile app to scan. Only the active code is encoded, so the page tells
+// nothing to someone who doesn't already know it.
 func (s *Server) handleQRCode(w http.ResponseWriter, r *http.Request) {
+	code := r.URL.Query().Get("code")
+	if code == "" || code != s.pairing.ActiveCode() {
+		http.Error(w, "Unknown or expired pairing code", http.StatusNotFound)
+		return
+	}
+
 	// Determine the base URL for the PWA
 	baseURL := s.pwaBaseURL
 	if baseURL == "" {
@@ -178,17 +862,26 @@ func (s *Server) handleQRCode(w http.ResponseWriter, r *http.Request) {
 		baseURL = fmt.Sprintf("%s://%s", scheme, host)
 	}
 
-	// Create QR data with server info
-	qrData := fmt.Sprintf("eco://connect?server=%s&secret=%s", baseURL, s.config.SharedSecret)
-
-	// Generate QR code as PNG using an external API (qrserver.com)
-	qrURL := fmt.Sprintf("https://api.qrserver.com/v1/create-qr-code/?size=300x300&data=%s", qrData)
+	qrData := fmt.Sprintf("eco://pair?server=%s&code=%s", url.QueryEscape(baseURL), code)
+	// Let the device pin the certificate it is about to trust
+	if fp := s.CertFingerprint(); fp != "" {
+		qrData += "&fp=" + fp
+	}
 
-	// Redirect to QR code image
-	http.Redirect(w, r, qrURL, http.StatusFound)
+	png, err := qrcode.Encode(qrData, qrcode.Medium, qrSize)
+	if err != nil {
+		http.Error(w, "Failed to render QR code", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Write(png)
 }
 
 // GetConnectionURL returns the WebSocket URL for clients
 func (s *Server) GetConnectionURL() string {
-	return fmt.Sprintf("ws://localhost:4949/ws")
+	if s.tls != nil {
+		return "wss://localhost:4949/ws"
+	}
+	return "ws://localhost:4949/ws"
 }
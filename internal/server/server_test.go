@@ -0,0 +1,330 @@
+package server
+
+import (
+	"encoding/hex"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"eco/internal/auth"
+	"eco/internal/bus"
+	"eco/internal/config"
+	"eco/internal/crypto"
+	"eco/internal/device"
+	"eco/internal/protocol"
+
+	"github.com/gorilla/websocket"
+)
+
+// newTestServer returns a server for devices, with its config saved in a
+// temporary home, serving the device endpoints over plain HTTP
+func newTestServer(t *testing.T, devices ...*config.Device) (*Server, *httptest.Server) {
+	t.Helper()
+	t.Setenv("HOME", t.TempDir())
+
+	cfg := &config.Config{Devices: devices}
+	eventBus := bus.New()
+	t.Cleanup(eventBus.Close)
+	s := NewServer(cfg, eventBus)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ws", s.handleWebSocket)
+	mux.HandleFunc("/pair", s.handlePairing)
+	mux.HandleFunc("/qr", s.handleQRCode)
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return s, srv
+}
+
+// dial opens a WebSocket to path on srv
+func dial(t *testing.T, srv *httptest.Server, path string) *websocket.Conn {
+	t.Helper()
+	ws, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+path, nil)
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	t.Cleanup(func() { ws.Close() })
+	return ws
+}
+
+// send writes a message of msgType from deviceID to ws
+func send(t *testing.T, ws *websocket.Conn, msgType protocol.MessageType, deviceID string, payload any) {
+	t.Helper()
+	msg, err := protocol.NewMessage(msgType, deviceID, payload)
+	if err != nil {
+		t.Fatalf("NewMessage() error = %v", err)
+	}
+	if err := ws.WriteJSON(msg); err != nil {
+		t.Fatalf("WriteJSON() error = %v", err)
+	}
+}
+
+// receive reads the next message from ws, which must be of type want
+func receive(t *testing.T, ws *websocket.Conn, want protocol.MessageType, payload any) *protocol.Message {
+	t.Helper()
+	ws.SetReadDeadline(time.Now().Add(2 * time.Second))
+	var msg protocol.Message
+	if err := ws.ReadJSON(&msg); err != nil {
+		t.Fatalf("waiting for %s: %v", want, err)
+	}
+	if msg.Type != want {
+		t.Fatalf("got %s, want %s", msg.Type, want)
+	}
+	if payload != nil {
+		if err := msg.GetPayload(payload); err != nil {
+			t.Fatalf("GetPayload() error = %v", err)
+		}
+	}
+	return &msg
+}
+
+// testSecret is the shared secret of the devices of the tests
+const testSecret = "000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f"
+
+// pairedDevices returns an enabled phone and a disabled tablet
+func pairedDevices() []*config.Device {
+	return []*config.Device{
+		{ID: "mobile-1", Name: "Phone", Secret: testSecret, Enabled: true},
+		{ID: "tablet-1", Name: "Tablet", Secret: testSecret, Enabled: false},
+	}
+}
+
+// challenge reads the auth.challenge from ws and returns its nonce
+func challenge(t *testing.T, ws *websocket.Conn) []byte {
+	t.Helper()
+	var payload protocol.AuthChallengePayload
+	receive(t, ws, protocol.MessageTypeAuthChallenge, &payload)
+	nonce, err := hex.DecodeString(payload.Nonce)
+	if err != nil || len(nonce) != auth.NonceSize {
+		t.Fatalf("challenge nonce = %q, want %d hex encoded bytes", payload.Nonce, auth.NonceSize)
+	}
+	return nonce
+}
+
+// respond answers the challenge as deviceID with proof and returns the
+// auth.result
+func respond(t *testing.T, ws *websocket.Conn, deviceID, proof string) protocol.AuthResultPayload {
+	t.Helper()
+	send(t, ws, protocol.MessageTypeAuthResponse, deviceID, &protocol.AuthResponsePayload{Proof: proof})
+	var result protocol.AuthResultPayload
+	receive(t, ws, protocol.MessageTypeAuthResult, &result)
+	return result
+}
+
+// expectClosed fails unless the server hangs up on ws
+func expectClosed(t *testing.T, ws *websocket.Conn) {
+	t.Helper()
+	ws.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for {
+		if _, _, err := ws.ReadMessage(); err != nil {
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				t.Fatal("connection still open")
+			}
+			return
+		}
+	}
+}
+
+func TestAuthenticate(t *testing.T) {
+	tests := []struct {
+		name     string
+		deviceID string
+		secret   string
+		wantOK   bool
+	}{
+		{"Paired device", "mobile-1", testSecret, true},
+		{"Wrong proof", "mobile-1", "not the secret", false},
+		{"Unknown device", "mobile-2", testSecret, false},
+		{"Disabled device", "tablet-1", testSecret, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, srv := newTestServer(t, pairedDevices()...)
+			ws := dial(t, srv, "/ws")
+
+			nonce := challenge(t, ws)
+			result := respond(t, ws, tt.deviceID, auth.ComputeProof(tt.secret, nonce, tt.deviceID))
+			if result.OK != tt.wantOK {
+				t.Fatalf("auth.result = %+v, want ok %v", result, tt.wantOK)
+			}
+			if !tt.wantOK {
+				expectClosed(t, ws)
+			}
+		})
+	}
+}
+
+func TestAuthenticateReplay(t *testing.T) {
+	_, srv := newTestServer(t, pairedDevices()...)
+
+	first := dial(t, srv, "/ws")
+	nonce := challenge(t, first)
+	proof := auth.ComputeProof(testSecret, nonce, "mobile-1")
+	if result := respond(t, first, "mobile-1", proof); !result.OK {
+		t.Fatalf("auth.result = %+v, want ok", result)
+	}
+
+	// A proof seen on the wire is no good for another connection, which
+	// gets a nonce of its own
+	second := dial(t, srv, "/ws")
+	if again := challenge(t, second); hex.EncodeToString(again) == hex.EncodeToString(nonce) {
+		t.Fatal("second connection got the same nonce")
+	}
+	if result := respond(t, second, "mobile-1", proof); result.OK {
+		t.Error("auth.result of a replayed proof is ok")
+	}
+	expectClosed(t, second)
+}
+
+func TestAuthenticateTimeout(t *testing.T) {
+	s, srv := newTestServer(t, pairedDevices()...)
+	s.authTimeout = 50 * time.Millisecond
+
+	// The challenge expires with the connection when it isn't answered
+	ws := dial(t, srv, "/ws")
+	challenge(t, ws)
+	expectClosed(t, ws)
+	if s.IsDeviceConnected("mobile-1") {
+		t.Error("device connected without answering in time")
+	}
+}
+
+// hello authenticates ws as mobile-1, sends its device.hello and returns the
+// server's answer
+func hello(t *testing.T, ws *websocket.Conn, payload *protocol.DevicePayload) protocol.DevicePayload {
+	t.Helper()
+	nonce := challenge(t, ws)
+	if result := respond(t, ws, "mobile-1", auth.ComputeProof(testSecret, nonce, "mobile-1")); !result.OK {
+		t.Fatalf("auth.result = %+v, want ok", result)
+	}
+	send(t, ws, protocol.MessageTypeDeviceHello, "mobile-1", payload)
+	var answer protocol.DevicePayload
+	receive(t, ws, protocol.MessageTypeDeviceHello, &answer)
+	return answer
+}
+
+// connection waits for the server to register the connection of deviceID
+func connection(t *testing.T, s *Server, deviceID string) *device.Connection {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		if conn := s.GetDeviceConnection(deviceID); conn != nil {
+			return conn
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("%s never connected", deviceID)
+	return nil
+}
+
+func TestNegotiateEncryption(t *testing.T) {
+	s, srv := newTestServer(t, pairedDevices()...)
+	ws := dial(t, srv, "/ws")
+
+	deviceNonce, _ := crypto.GenerateRandomBytes(crypto.SessionNonceSize)
+	answer := hello(t, ws, &protocol.DevicePayload{
+		ProtocolVersion: protocol.ProtocolVersion,
+		Encryption:      []string{crypto.CipherXChaCha20Poly1305},
+		Nonce:           hex.EncodeToString(deviceNonce),
+		Capabilities:    []string{protocol.CapabilityNotifications},
+	})
+	if !slices.Equal(answer.Encryption, []string{crypto.CipherXChaCha20Poly1305}) {
+		t.Fatalf("hello encryption = %v, want %s", answer.Encryption, crypto.CipherXChaCha20Poly1305)
+	}
+	daemonNonce, err := hex.DecodeString(answer.Nonce)
+	if err != nil {
+		t.Fatalf("hello nonce = %q: %v", answer.Nonce, err)
+	}
+	cipher, err := crypto.NewPayloadCipher(testSecret, "mobile-1", deviceNonce, daemonNonce, false)
+	if err != nil {
+		t.Fatalf("NewPayloadCipher() error = %v", err)
+	}
+
+	conn := connection(t, s, "mobile-1")
+	if !conn.IsEncrypted() {
+		t.Fatal("IsEncrypted() = false, want true")
+	}
+
+	// Payloads go out sealed with the session keys
+	msg, _ := protocol.NewMessage(protocol.MessageTypeNotificationPush, "", &protocol.NotificationPayload{Title: "secret title"})
+	if err := conn.Send(msg); err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+	sealed := receive(t, ws, protocol.MessageTypeNotificationPush, nil)
+	if !sealed.IsSealed() || strings.Contains(string(sealed.Payload), "secret") {
+		t.Fatalf("notification sent in plain text: %s", sealed.Payload)
+	}
+	opened, err := sealed.Open(cipher)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	var notification protocol.NotificationPayload
+	if err := opened.GetPayload(&notification); err != nil || notification.Title != "secret title" {
+		t.Errorf("opened notification = %+v, %v, want the secret title", notification, err)
+	}
+}
+
+func TestNegotiatePlaintext(t *testing.T) {
+	s, srv := newTestServer(t, pairedDevices()...)
+	ws := dial(t, srv, "/ws")
+
+	answer := hello(t, ws, &protocol.DevicePayload{ProtocolVersion: protocol.ProtocolVersion})
+	if len(answer.Encryption) != 0 || answer.Nonce != "" {
+		t.Errorf("hello = %+v, want no encryption", answer)
+	}
+	if conn := connection(t, s, "mobile-1"); conn.IsEncrypted() {
+		t.Error("IsEncrypted() = true, want false")
+	}
+}
+
+func TestNegotiateRequireEncryption(t *testing.T) {
+	s, srv := newTestServer(t, pairedDevices()...)
+	s.config.RequireEncryption = true
+	ws := dial(t, srv, "/ws")
+
+	// A device that doesn't offer the cipher is turned away, not downgraded
+	nonce := challenge(t, ws)
+	if result := respond(t, ws, "mobile-1", auth.ComputeProof(testSecret, nonce, "mobile-1")); !result.OK {
+		t.Fatalf("auth.result = %+v, want ok", result)
+	}
+	send(t, ws, protocol.MessageTypeDeviceHello, "mobile-1", &protocol.DevicePayload{ProtocolVersion: protocol.ProtocolVersion})
+
+	var refusal protocol.DisconnectPayload
+	receive(t, ws, protocol.MessageTypeDeviceDisconnect, &refusal)
+	if refusal.Reason != "payload encryption is required" {
+		t.Errorf("disconnect reason = %q", refusal.Reason)
+	}
+	expectClosed(t, ws)
+	if s.IsDeviceConnected("mobile-1") {
+		t.Error("device connected without encryption")
+	}
+}
+
+func TestNegotiateCapabilities(t *testing.T) {
+	s, srv := newTestServer(t, pairedDevices()...)
+	ws := dial(t, srv, "/ws")
+
+	// Without a virtual input device, input is neither offered nor agreed
+	answer := hello(t, ws, &protocol.DevicePayload{
+		ProtocolVersion: protocol.ProtocolVersion,
+		Capabilities:    []string{protocol.CapabilityClipboard, protocol.CapabilityInput},
+	})
+	if slices.Contains(answer.Capabilities, protocol.CapabilityInput) {
+		t.Errorf("hello capabilities = %v, want no %s", answer.Capabilities, protocol.CapabilityInput)
+	}
+	if !slices.Contains(answer.Capabilities, protocol.CapabilityClipboard) {
+		t.Errorf("hello capabilities = %v, want %s", answer.Capabilities, protocol.CapabilityClipboard)
+	}
+
+	conn := connection(t, s, "mobile-1")
+	if got, want := conn.Capabilities(), []string{protocol.CapabilityClipboard}; !slices.Equal(got, want) {
+		t.Errorf("Capabilities() = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,294 @@
+package sms
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+
+	"eco/internal/config"
+	"eco/internal/protocol"
+)
+
+// File is the conversation store inside the config directory
+const File = "sms.json"
+
+// DefaultMaxPerThread bounds how many messages are kept for one conversation
+const DefaultMaxPerThread = 500
+
+// ErrNotFound is returned when no conversation matches a lookup
+var ErrNotFound = errors.New("no such conversation")
+
+// Thread is a conversation with one contact on one device
+type Thread struct {
+	DeviceID    string `json:"device_id"`
+	ID          string `json:"id"`
+	Address     string `json:"address"`
+	ContactName string `json:"contact_name,omitempty"`
+	// Messages are oldest first
+	Messages []protocol.SMSMessage `json:"messages"`
+}
+
+// Key identifies the thread across devices
+func (t *Thread) Key() string {
+	return t.DeviceID + "/" + t.ID
+}
+
+// Name returns the contact name, or the address if there is none
+func (t *Thread) Name() string {
+	if t.ContactName != "" {
+		return t.ContactName
+	}
+	return t.Address
+}
+
+// Last returns the newest message
+func (t *Thread) Last() protocol.SMSMessage {
+	if len(t.Messages) == 0 {
+		return protocol.SMSMessage{}
+	}
+	return t.Messages[len(t.Messages)-1]
+}
+
+// Unread counts the received messages not read on the phone
+func (t *Thread) Unread() int {
+	n := 0
+	for _, m := range t.Messages {
+		if !m.Sent && !m.Read {
+			n++
+		}
+	}
+	return n
+}
+
+// storeFile is the on-disk form of the store
+type storeFile struct {
+	Threads []*Thread `json:"threads"`
+}
+
+// Store keeps the text messages of the paired phones, indexed by thread and
+// by contact, in a file that survives daemon restarts
+type Store struct {
+	mu      sync.Mutex
+	path    string
+	max     int
+	threads map[string]*Thread
+	// contacts maps normalized addresses to the keys of their threads
+	contacts map[string][]string
+}
+
+// DefaultPath returns the store file next to the config file
+func DefaultPath() (string, error) {
+	cfgPath, err := config.ConfigPath()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(filepath.Dir(cfgPath), File), nil
+}
+
+// Open loads the store at path, keeping at most max messages per thread.
+// max <= 0 uses DefaultMaxPerThread.
+func Open(path string, max int) (*Store, error) {
+	if max <= 0 {
+		max = DefaultMaxPerThread
+	}
+	s := &Store{
+		path:     path,
+		max:      max,
+		threads:  make(map[string]*Thread),
+		contacts: make(map[string][]string),
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var file storeFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("reading %s: %w", path, err)
+	}
+	for _, t := range file.Threads {
+		s.threads[t.Key()] = t
+		s.index(t)
+	}
+	return s, nil
+}
+
+// Add stores messages from deviceID, skipping the ones already kept, and
+// returns how many were new
+func (s *Store) Add(deviceID string, messages ...protocol.SMSMessage) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	added := 0
+	changed := map[*Thread]bool{}
+	for _, m := range messages {
+		key := deviceID + "/" + m.ThreadID
+		t := s.threads[key]
+		if t == nil {
+			t = &Thread{DeviceID: deviceID, ID: m.ThreadID, Address: m.Address}
+			s.threads[key] = t
+			s.index(t)
+		}
+		if m.ContactName != "" && m.ContactName != t.ContactName {
+			t.ContactName = m.ContactName
+			changed[t] = true
+		}
+
+		i := slices.IndexFunc(t.Messages, func(old protocol.SMSMessage) bool { return old.ID == m.ID })
+		if i >= 0 {
+			// The phone reports messages again when they are read
+			if m.Read && !t.Messages[i].Read {
+				t.Messages[i].Read = true
+				changed[t] = true
+			}
+			continue
+		}
+		t.Messages = append(t.Messages, m)
+		changed[t] = true
+		added++
+	}
+	if len(changed) == 0 {
+		return 0, nil
+	}
+
+	for t := range changed {
+		slices.SortStableFunc(t.Messages, func(a, b protocol.SMSMessage) int {
+			return cmp.Compare(a.Time, b.Time)
+		})
+		if len(t.Messages) > s.max {
+			t.Messages = slices.Delete(t.Messages, 0, len(t.Messages)-s.max)
+		}
+	}
+	return added, s.save()
+}
+
+// Threads returns every conversation, the most recently active first
+func (s *Store) Threads() []Thread {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	threads := make([]Thread, 0, len(s.threads))
+	for _, t := range s.threads {
+		threads = append(threads, t.clone())
+	}
+	slices.SortFunc(threads, func(a, b Thread) int {
+		return cmp.Or(cmp.Compare(b.Last().Time, a.Last().Time), strings.Compare(a.Key(), b.Key()))
+	})
+	return threads
+}
+
+// Lookup finds the conversation query refers to: its key, the phone's thread
+// ID, the contact's number or, ignoring case, name. Several matches are an
+// error unless deviceID, if set, narrows them down to one.
+func (s *Store) Lookup(query, deviceID string) (Thread, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if t, ok := s.threads[query]; ok {
+		return t.clone(), nil
+	}
+
+	var matches []*Thread
+	for _, key := range s.contacts[NormalizeNumber(query)] {
+		matches = append(matches, s.threads[key])
+	}
+	for _, t := range s.threads {
+		if (t.ID == query || strings.EqualFold(t.ContactName, query)) && !slices.Contains(matches, t) {
+			matches = append(matches, t)
+		}
+	}
+	if deviceID != "" {
+		matches = slices.DeleteFunc(matches, func(t *Thread) bool { return t.DeviceID != deviceID })
+	}
+	switch len(matches) {
+	case 0:
+		return Thread{}, fmt.Errorf("%w with %q", ErrNotFound, query)
+	case 1:
+		return matches[0].clone(), nil
+	}
+	return Thread{}, fmt.Errorf("%q matches %d conversations, choose a device with --device", query, len(matches))
+}
+
+// Since returns the time of the newest message from deviceID, in Unix
+// milliseconds, to sync from
+func (s *Store) Since(deviceID string) int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var since int64
+	for _, t := range s.threads {
+		if t.DeviceID == deviceID && t.Last().Time > since {
+			since = t.Last().Time
+		}
+	}
+	return since
+}
+
+// index adds t to the contact index. Callers hold s.mu.
+func (s *Store) index(t *Thread) {
+	number := NormalizeNumber(t.Address)
+	if !slices.Contains(s.contacts[number], t.Key()) {
+		s.contacts[number] = append(s.contacts[number], t.Key())
+	}
+}
+
+// save writes the store to disk, replacing the old file atomically. Callers
+// hold s.mu.
+func (s *Store) save() error {
+	file := storeFile{Threads: make([]*Thread, 0, len(s.threads))}
+	for _, t := range s.threads {
+		file.Threads = append(file.Threads, t)
+	}
+	slices.SortFunc(file.Threads, func(a, b *Thread) int { return strings.Compare(a.Key(), b.Key()) })
+
+	data, err := json.Marshal(&file)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path), 0700); err != nil {
+		return err
+	}
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
+
+func (t *Thread) clone() Thread {
+	c := *t
+	c.Messages = slices.Clone(t.Messages)
+	return c
+}
+
+// NormalizeNumber reduces a phone number to its digits and a leading +, so
+// different spellings of a number match. Addresses without digits, like
+// the names some senders use, are only lowercased.
+func NormalizeNumber(address string) string {
+	var b strings.Builder
+	for i, r := range strings.TrimSpace(address) {
+		switch {
+		case r >= '0' && r <= '9':
+			b.WriteRune(r)
+		case r == '+' && i == 0:
+			b.WriteRune(r)
+		case r == ' ' || r == '-' || r == '(' || r == ')' || r == '.':
+		default:
+			return strings.ToLower(strings.TrimSpace(address))
+		}
+	}
+	if b.Len() == 0 {
+		return strings.ToLower(strings.TrimSpace(address))
+	}
+	return b.String()
+}
@@ -0,0 +1,178 @@
+package sms
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"eco/internal/protocol"
+)
+
+func openTestStore(t *testing.T, path string, max int) *Store {
+	t.Helper()
+	s, err := Open(path, max)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	return s
+}
+
+func sms(id, thread, address, body string, time int64) protocol.SMSMessage {
+	return protocol.SMSMessage{ID: id, ThreadID: thread, Address: address, Body: body, Time: time}
+}
+
+func bodies(t Thread) []string {
+	var out []string
+	for _, m := range t.Messages {
+		out = append(out, m.Body)
+	}
+	return out
+}
+
+func equal(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestAdd(t *testing.T) {
+	s := openTestStore(t, filepath.Join(t.TempDir(), File), 3)
+
+	added, err := s.Add("mobile-1",
+		sms("2", "t1", "+15551234", "second", 200),
+		sms("1", "t1", "+15551234", "first", 100),
+		sms("3", "t2", "+15559876", "other", 150),
+	)
+	if err != nil || added != 3 {
+		t.Fatalf("Add() = %d, %v, want 3, nil", added, err)
+	}
+
+	// Messages already kept are skipped, but a read flag is picked up
+	read := sms("1", "t1", "+15551234", "first", 100)
+	read.Read = true
+	if added, _ := s.Add("mobile-1", read, sms("4", "t1", "+15551234", "third", 300)); added != 1 {
+		t.Errorf("Add() of a known message = %d, want 1", added)
+	}
+
+	threads := s.Threads()
+	if len(threads) != 2 || threads[0].ID != "t1" || threads[1].ID != "t2" {
+		t.Fatalf("Threads() = %v, want t1 then t2", threads)
+	}
+	if got, want := bodies(threads[0]), []string{"first", "second", "third"}; !equal(got, want) {
+		t.Errorf("t1 messages = %v, want %v", got, want)
+	}
+	if got := threads[0].Unread(); got != 2 {
+		t.Errorf("Unread() = %d, want 2", got)
+	}
+
+	// Beyond max, the oldest messages go
+	s.Add("mobile-1", sms("5", "t1", "+15551234", "fourth", 400))
+	thread, _ := s.Lookup("t1", "")
+	if got, want := bodies(thread), []string{"second", "third", "fourth"}; !equal(got, want) {
+		t.Errorf("t1 messages after trim = %v, want %v", got, want)
+	}
+}
+
+func TestPersistence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), File)
+
+	s := openTestStore(t, path, 0)
+	m := sms("1", "t1", "+15551234", "hello", 100)
+	m.ContactName = "Ana"
+	if _, err := s.Add("mobile-1", m); err != nil {
+		t.Fatalf("Add() error = %v", err)
+	}
+
+	reopened := openTestStore(t, path, 0)
+	thread, err := reopened.Lookup("ana", "")
+	if err != nil {
+		t.Fatalf("Lookup() after reopening error = %v", err)
+	}
+	if thread.Key() != "mobile-1/t1" || !equal(bodies(thread), []string{"hello"}) {
+		t.Errorf("Lookup() after reopening = %+v", thread)
+	}
+	if got := reopened.Since("mobile-1"); got != 100 {
+		t.Errorf("Since() after reopening = %d, want 100", got)
+	}
+}
+
+func TestLookup(t *testing.T) {
+	s := openTestStore(t, filepath.Join(t.TempDir(), File), 0)
+	ana := sms("1", "t1", "+1 (555) 123-4", "hi", 100)
+	ana.ContactName = "Ana"
+	s.Add("mobile-1", ana, sms("2", "t2", "+15559876", "yo", 200))
+	s.Add("tablet-1", sms("3", "t9", "+15551234", "hey", 300))
+
+	tests := []struct {
+		query    string
+		deviceID string
+		want     string
+		wantErr  bool
+	}{
+		{"mobile-1/t2", "", "mobile-1/t2", false},
+		{"t2", "", "mobile-1/t2", false},
+		{"+1 555 987 6", "", "mobile-1/t2", false},
+		{"ANA", "", "mobile-1/t1", false},
+		{"+15551234", "tablet-1", "tablet-1/t9", false},
+		{"+15551234", "", "", true},
+		{"Bob", "", "", true},
+	}
+	for _, tt := range tests {
+		thread, err := s.Lookup(tt.query, tt.deviceID)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("Lookup(%q, %q) error = %v, wantErr %v", tt.query, tt.deviceID, err, tt.wantErr)
+			continue
+		}
+		if err == nil && thread.Key() != tt.want {
+			t.Errorf("Lookup(%q, %q) = %s, want %s", tt.query, tt.deviceID, thread.Key(), tt.want)
+		}
+	}
+
+	if _, err := s.Lookup("Bob", ""); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Lookup() of an unknown contact error = %v, want %v", err, ErrNotFound)
+	}
+}
+
+func TestSince(t *testing.T) {
+	s := openTestStore(t, filepath.Join(t.TempDir(), File), 0)
+	s.Add("mobile-1", sms("1", "t1", "+15551234", "a", 100), sms("2", "t2", "+15559876", "b", 250))
+	s.Add("tablet-1", sms("3", "t1", "+15551234", "c", 900))
+
+	tests := []struct {
+		deviceID string
+		want     int64
+	}{
+		{"mobile-1", 250},
+		{"tablet-1", 900},
+		{"unknown", 0},
+	}
+	for _, tt := range tests {
+		if got := s.Since(tt.deviceID); got != tt.want {
+			t.Errorf("Since(%q) = %d, want %d", tt.deviceID, got, tt.want)
+		}
+	}
+}
+
+func TestNormalizeNumber(t *testing.T) {
+	tests := []struct {
+		address string
+		want    string
+	}{
+		{"+1 (555) 123-4567", "+15551234567"},
+		{"555.123.4567", "5551234567"},
+		{" +15551234567 ", "+15551234567"},
+		{"ACME Bank", "acme bank"},
+		{"1+2", "1+2"},
+	}
+	for _, tt := range tests {
+		if got := NormalizeNumber(tt.address); got != tt.want {
+			t.Errorf("NormalizeNumber(%q) = %q, want %q", tt.address, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,276 @@
+package tlscert
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"eco/internal/config"
+)
+
+const (
+	CertFile = "cert.pem"
+	KeyFile  = "key.pem"
+)
+
+// Validity is how long a generated certificate is valid for. Clients pin the
+// fingerprint rather than trusting a CA, so the expiry only bounds how long a
+// leaked key stays useful; 'eco tls rotate' replaces it at any time.
+const Validity = 2 * 365 * 24 * time.Hour
+
+// Paths returns the certificate and key paths next to the config file
+func Paths() (certPath, keyPath string, err error) {
+	cfgPath, err := config.ConfigPath()
+	if err != nil {
+		return "", "", err
+	}
+	dir := filepath.Dir(cfgPath)
+	return filepath.Join(dir, CertFile), filepath.Join(dir, KeyFile), nil
+}
+
+// Generate creates a new self-signed ECDSA P-256 certificate for the daemon
+// and writes it to certPath and keyPath, replacing any existing pair
+func Generate(certPath, keyPath string) (*tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: "eco daemon"},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(Validity),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		DNSNames:              []string{"localhost"},
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
+	}
+	if host, err := os.Hostname(); err == nil && host != "" {
+		template.DNSNames = append(template.DNSNames, host)
+	}
+	template.IPAddresses = append(template.IPAddresses, localIPs()...)
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, err
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+
+	if err := os.MkdirAll(filepath.Dir(certPath), 0700); err != nil {
+		return nil, err
+	}
+	// Key first: a reader that sees the new cert must also find its key
+	if err := writeFileAtomic(keyPath, keyPEM, 0600); err != nil {
+		return nil, err
+	}
+	if err := writeFileAtomic(certPath, certPEM, 0644); err != nil {
+		return nil, err
+	}
+
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return nil, err
+	}
+	return &cert, nil
+}
+
+// Load reads the certificate pair from disk
+func Load(certPath, keyPath string) (*tls.Certificate, error) {
+	cert, err := tls.LoadX509KeyPair(certPath, keyPath)
+	if err != nil {
+		return nil, err
+	}
+	return &cert, nil
+}
+
+// LoadOrGenerate loads the certificate pair, generating a new one if it is
+// missing or has expired
+func LoadOrGenerate(certPath, keyPath string) (*tls.Certificate, error) {
+	cert, err := Load(certPath, keyPath)
+	if err == nil && time.Now().Before(cert.Leaf.NotAfter) {
+		return cert, nil
+	}
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	return Generate(certPath, keyPath)
+}
+
+// Fingerprint returns the hex SHA-256 of the certificate's DER encoding
+func Fingerprint(cert *tls.Certificate) string {
+	if cert == nil || len(cert.Certificate) == 0 {
+		return ""
+	}
+	sum := sha256.Sum256(cert.Certificate[0])
+	return hex.EncodeToString(sum[:])
+}
+
+// ReadFingerprint returns the fingerprint of the certificate at certPath
+func ReadFingerprint(certPath string) (string, error) {
+	data, err := os.ReadFile(certPath)
+	if err != nil {
+		return "", err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return "", fmt.Errorf("no certificate in %s", certPath)
+	}
+	sum := sha256.Sum256(block.Bytes)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// FormatFingerprint renders a hex fingerprint as colon-separated uppercase
+// bytes, the way browsers and openssl show it
+func FormatFingerprint(fp string) string {
+	fp = strings.ToUpper(fp)
+	parts := make([]string, 0, len(fp)/2)
+	for i := 0; i+2 <= len(fp); i += 2 {
+		parts = append(parts, fp[i:i+2])
+	}
+	return strings.Join(parts, ":")
+}
+
+// VerifyFingerprint returns a tls.Config.VerifyPeerCertificate callback that
+// accepts only a leaf certificate with the pinned fingerprint. Use it together
+// with InsecureSkipVerify, since the certificate is self-signed.
+func VerifyFingerprint(fp string) func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+	want := strings.ToLower(strings.ReplaceAll(fp, ":", ""))
+	return func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+		if len(rawCerts) == 0 {
+			return errors.New("no server certificate")
+		}
+		sum := sha256.Sum256(rawCerts[0])
+		if hex.EncodeToString(sum[:]) != want {
+			return errors.New("server certificate does not match the pinned fingerprint")
+		}
+		return nil
+	}
+}
+
+// Store holds the daemon's current certificate. The TLS listener asks it for
+// the certificate on every handshake, so Reload takes effect for new
+// connections without restarting the server.
+type Store struct {
+	mu       sync.RWMutex
+	certPath string
+	keyPath  string
+	cert     *tls.Certificate
+}
+
+// NewStore loads (or generates) the certificate at certPath and keyPath
+func NewStore(certPath, keyPath string) (*Store, error) {
+	cert, err := LoadOrGenerate(certPath, keyPath)
+	if err != nil {
+		return nil, err
+	}
+	return &Store{
+		certPath: certPath,
+		keyPath:  keyPath,
+		cert:     cert,
+	}, nil
+}
+
+// Reload re-reads the certificate pair from disk, e.g. after 'eco tls rotate'
+func (s *Store) Reload() error {
+	cert, err := Load(s.certPath, s.keyPath)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cert = cert
+	return nil
+}
+
+// Fingerprint returns the fingerprint of the certificate being served
+func (s *Store) Fingerprint() string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return Fingerprint(s.cert)
+}
+
+// GetCertificate implements tls.Config.GetCertificate
+func (s *Store) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.cert, nil
+}
+
+// TLSConfig returns a server config that serves the store's certificate
+func (s *Store) TLSConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: s.GetCertificate,
+	}
+}
+
+// localIPs returns the non-loopback addresses of this machine, so clients on
+// the LAN can also verify the certificate by name
+func localIPs() []net.IP {
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return nil
+	}
+
+	var ips []net.IP
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if !ok || ipNet.IP.IsLoopback() || ipNet.IP.IsLinkLocalUnicast() {
+			continue
+		}
+		ips = append(ips, ipNet.IP)
+	}
+	return ips
+}
+
+// writeFileAtomic writes data to a temporary file and renames it into place
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,157 @@
+package tlscert
+
+import (
+	"crypto/tls"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func testPaths(t *testing.T) (string, string) {
+	dir := t.TempDir()
+	return filepath.Join(dir, CertFile), filepath.Join(dir, KeyFile)
+}
+
+func TestLoadOrGenerate(t *testing.T) {
+	certPath, keyPath := testPaths(t)
+
+	first, err := LoadOrGenerate(certPath, keyPath)
+	if err != nil {
+		t.Fatalf("LoadOrGenerate() error = %v", err)
+	}
+
+	info, err := os.Stat(keyPath)
+	if err != nil {
+		t.Fatalf("key file not written: %v", err)
+	}
+	if perm := info.Mode().Perm(); perm != 0600 {
+		t.Errorf("key file mode = %o, want 600", perm)
+	}
+
+	second, err := LoadOrGenerate(certPath, keyPath)
+	if err != nil {
+		t.Fatalf("LoadOrGenerate() second call error = %v", err)
+	}
+	if Fingerprint(first) != Fingerprint(second) {
+		t.Error("LoadOrGenerate() replaced an existing certificate")
+	}
+
+	onDisk, err := ReadFingerprint(certPath)
+	if err != nil {
+		t.Fatalf("ReadFingerprint() error = %v", err)
+	}
+	if onDisk != Fingerprint(first) {
+		t.Errorf("ReadFingerprint() = %s, want %s", onDisk, Fingerprint(first))
+	}
+	if len(onDisk) != 64 {
+		t.Errorf("fingerprint length = %d, want 64 hex chars", len(onDisk))
+	}
+}
+
+func TestStoreReload(t *testing.T) {
+	certPath, keyPath := testPaths(t)
+
+	store, err := NewStore(certPath, keyPath)
+	if err != nil {
+		t.Fatalf("NewStore() error = %v", err)
+	}
+	before := store.Fingerprint()
+
+	// Another process (eco tls rotate) replaces the files, then the daemon reloads
+	if _, err := Generate(certPath, keyPath); err != nil {
+		t.Fatalf("Generate() error = %v", err)
+	}
+	if store.Fingerprint() != before {
+		t.Error("store changed certificate before Reload()")
+	}
+	if err := store.Reload(); err != nil {
+		t.Fatalf("Reload() error = %v", err)
+	}
+	onDisk, _ := ReadFingerprint(certPath)
+	if store.Fingerprint() != onDisk || onDisk == before {
+		t.Errorf("Fingerprint() after Reload() = %s, want the new %s", store.Fingerprint(), onDisk)
+	}
+}
+
+func TestPinnedHandshake(t *testing.T) {
+	certPath, keyPath := testPaths(t)
+	store, err := NewStore(certPath, keyPath)
+	if err != nil {
+		t.Fatalf("NewStore() error = %v", err)
+	}
+
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", store.TLSConfig())
+	if err != nil {
+		t.Fatalf("tls.Listen() error = %v", err)
+	}
+	defer ln.Close()
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conn.(*tls.Conn).Handshake()
+			conn.Close()
+		}
+	}()
+
+	tests := []struct {
+		name    string
+		pin     string
+		wantErr bool
+	}{
+		{"matching pin", store.Fingerprint(), false},
+		{"formatted pin", FormatFingerprint(store.Fingerprint()), false},
+		{"wrong pin", strings.Repeat("00", 32), true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn, err := tls.Dial("tcp", ln.Addr().String(), &tls.Config{
+				InsecureSkipVerify:    true,
+				VerifyPeerCertificate: VerifyFingerprint(tt.pin),
+			})
+			if conn != nil {
+				conn.Close()
+			}
+			if (err != nil) != tt.wantErr {
+				t.Errorf("tls.Dial() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCertificateNames(t *testing.T) {
+	certPath, keyPath := testPaths(t)
+	cert, err := Generate(certPath, keyPath)
+	if err != nil {
+		t.Fatalf("Generate() error = %v", err)
+	}
+
+	if err := cert.Leaf.VerifyHostname("localhost"); err != nil {
+		t.Errorf("certificate not valid for localhost: %v", err)
+	}
+	if err := cert.Leaf.VerifyHostname(net.IPv4(127, 0, 0, 1).String()); err != nil {
+		t.Errorf("certificate not valid for 127.0.0.1: %v", err)
+	}
+}
+
+func TestFormatFingerprint(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"ab01ff", "AB:01:FF"},
+		{"", ""},
+	}
+
+	for _, tt := range tests {
+		if got := FormatFingerprint(tt.in); got != tt.want {
+			t.Errorf("FormatFingerprint(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
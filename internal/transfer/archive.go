@@ -0,0 +1,253 @@
+package transfer
+
+import (
+	"archive/tar"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"eco/internal/protocol"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Conflict is what happens to a received file whose name is taken
+type Conflict string
+
+const (
+	// ConflictRename saves the file under a free name like "photo (1).jpg"
+	ConflictRename Conflict = "rename"
+	// ConflictOverwrite replaces the existing file
+	ConflictOverwrite Conflict = "overwrite"
+	// ConflictSkip keeps the existing file and drops the received one
+	ConflictSkip Conflict = "skip"
+)
+
+// ParseConflict parses a conflict policy; "" is ConflictRename
+func ParseConflict(s string) (Conflict, error) {
+	switch c := Conflict(s); c {
+	case "":
+		return ConflictRename, nil
+	case ConflictRename, ConflictOverwrite, ConflictSkip:
+		return c, nil
+	}
+	return "", fmt.Errorf("unknown conflict policy %q, use rename, overwrite or skip", s)
+}
+
+// writeArchive writes the folder at dir to w as a tar stream, zstd
+// compressed for protocol.FileArchiveTarZstd. Entries are named relative to dir and
+// walked in lexical order, so the stream is the same every time as long as
+// the folder doesn't change. Symlinks and special files are left out.
+// onFile is called as each regular file starts, and stops the archive if
+// it fails.
+func writeArchive(w io.Writer, dir, format string, onFile func(index int, name string, size int64) error) error {
+	if format == protocol.FileArchiveTarZstd {
+		enc, err := zstd.NewWriter(w, zstd.WithEncoderConcurrency(1))
+		if err != nil {
+			return err
+		}
+		if err := writeTar(enc, dir, onFile); err != nil {
+			enc.Close()
+			return err
+		}
+		return enc.Close()
+	}
+	return writeTar(w, dir, onFile)
+}
+
+func writeTar(w io.Writer, dir string, onFile func(index int, name string, size int64) error) error {
+	tw := tar.NewWriter(w)
+	index := 0
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil || rel == "." {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		hdr := &tar.Header{
+			Name:    filepath.ToSlash(rel),
+			Mode:    int64(info.Mode().Perm()),
+			ModTime: info.ModTime().Truncate(1e9),
+		}
+		switch {
+		case info.IsDir():
+			hdr.Typeflag = tar.TypeDir
+			hdr.Name += "/"
+			return tw.WriteHeader(hdr)
+		case !info.Mode().IsRegular():
+			return nil
+		}
+
+		f, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		hdr.Typeflag = tar.TypeReg
+		hdr.Size = info.Size()
+		if err := onFile(index, hdr.Name, hdr.Size); err != nil {
+			return err
+		}
+		index++
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		_, err = io.Copy(tw, f)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return tw.Close()
+}
+
+// extract unpacks the archive at src into dest, resolving names taken by
+// existing files with conflict, and returns how many files were skipped.
+// Entries can't leave dest: absolute names and ".." are refused, files are
+// created through an os.Root so symlinks inside dest aren't followed out of
+// it, and links in the archive are left out. The files may add up to at
+// most limit bytes. onFile is called as each file starts, and stops the
+// unpacking if it fails.
+func extract(src, dest, format string, conflict Conflict, limit int64, onFile func(index int, name string) error) (int, error) {
+	f, err := os.Open(src)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	var r io.Reader = f
+	if format == protocol.FileArchiveTarZstd {
+		dec, err := zstd.NewReader(f)
+		if err != nil {
+			return 0, err
+		}
+		defer dec.Close()
+		r = dec
+	}
+
+	if err := os.MkdirAll(dest, 0755); err != nil {
+		return 0, err
+	}
+	root, err := os.OpenRoot(dest)
+	if err != nil {
+		return 0, err
+	}
+	defer root.Close()
+
+	tr := tar.NewReader(r)
+	skipped, index := 0, 0
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return skipped, nil
+		}
+		if err != nil {
+			return skipped, fmt.Errorf("reading archive: %w", err)
+		}
+
+		name := path.Clean(strings.TrimSuffix(hdr.Name, "/"))
+		if !filepath.IsLocal(filepath.FromSlash(name)) {
+			return skipped, fmt.Errorf("unsafe path %q in archive", hdr.Name)
+		}
+		name = filepath.FromSlash(name)
+
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := root.MkdirAll(name, 0755); err != nil {
+				return skipped, err
+			}
+			continue
+		case tar.TypeReg:
+		default:
+			log.Printf("Transfer: Leaving out %s, not a regular file", hdr.Name)
+			continue
+		}
+
+		if hdr.Size > limit {
+			return skipped, errors.New("archive is larger than the offered size")
+		}
+		limit -= hdr.Size
+		if err := onFile(index, filepath.ToSlash(name)); err != nil {
+			return skipped, err
+		}
+		index++
+
+		if dir := filepath.Dir(name); dir != "." {
+			if err := root.MkdirAll(dir, 0755); err != nil {
+				return skipped, err
+			}
+		}
+		target, skip, err := resolveConflict(name, conflict, func(p string) bool {
+			_, err := root.Lstat(p)
+			return err == nil
+		}, root.Remove)
+		if err != nil {
+			return skipped, err
+		}
+		if skip {
+			skipped++
+			continue
+		}
+
+		perm := fs.FileMode(hdr.Mode).Perm()
+		if perm == 0 {
+			perm = 0644
+		}
+		out, err := root.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+		if err != nil {
+			return skipped, err
+		}
+		_, err = io.Copy(out, tr)
+		if closeErr := out.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return skipped, err
+		}
+	}
+}
+
+// resolveConflict returns the name to save a received file as, or skip if
+// the file is to be dropped. An existing file is removed with remove when
+// conflict is ConflictOverwrite.
+func resolveConflict(name string, conflict Conflict, exists func(string) bool, remove func(string) error) (string, bool, error) {
+	if !exists(name) {
+		return name, false, nil
+	}
+	switch conflict {
+	case ConflictSkip:
+		return "", true, nil
+	case ConflictOverwrite:
+		return name, false, remove(name)
+	}
+
+	ext := filepath.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+	for i := 1; ; i++ {
+		free := fmt.Sprintf("%s (%d)%s", base, i, ext)
+		if !exists(free) {
+			return free, false, nil
+		}
+	}
+}
+
+// counter counts the bytes written to it
+type counter int64
+
+func (c *counter) Write(p []byte) (int, error) {
+	*c += counter(len(p))
+	return len(p), nil
+}
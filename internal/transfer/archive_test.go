@@ -0,0 +1,300 @@
+package transfer
+
+import (
+	"archive/tar"
+	"bytes"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"eco/internal/protocol"
+)
+
+// tarEntry is a file, or a symlink if link is set, of an archive built by
+// makeTar
+type tarEntry struct {
+	name, body, link string
+}
+
+func makeTar(t *testing.T, entries ...tarEntry) string {
+	t.Helper()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, e := range entries {
+		hdr := &tar.Header{Name: e.name, Mode: 0644, Typeflag: tar.TypeReg, Size: int64(len(e.body))}
+		if e.link != "" {
+			hdr.Typeflag, hdr.Linkname = tar.TypeSymlink, e.link
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatalf("WriteHeader() error = %v", err)
+		}
+		tw.Write([]byte(e.body))
+	}
+	tw.Close()
+	return buf.String()
+}
+
+// writeFiles creates the files, given as path and content pairs, under dir
+func writeFiles(t *testing.T, dir string, files ...string) {
+	t.Helper()
+	for i := 0; i+1 < len(files); i += 2 {
+		path := filepath.Join(dir, files[i])
+		os.MkdirAll(filepath.Dir(path), 0755)
+		if err := os.WriteFile(path, []byte(files[i+1]), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func readFile(path string) string {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "<" + err.Error() + ">"
+	}
+	return string(data)
+}
+
+func TestFolder(t *testing.T) {
+	for _, compress := range []bool{false, true} {
+		t.Run(fmt.Sprintf("compress=%v", compress), func(t *testing.T) {
+			src := filepath.Join(t.TempDir(), "Photos")
+			writeFiles(t, src, "a.jpg", "first photo", "trip/b.jpg", "second photo", "trip/c/d.txt", "")
+			os.Mkdir(filepath.Join(src, "empty"), 0755)
+			os.Symlink("/etc/passwd", filepath.Join(src, "passwd"))
+
+			sender := NewManager(t.TempDir())
+			offer, err := sender.Offer("mobile-1", src, compress)
+			if err != nil {
+				t.Fatalf("Offer() error = %v", err)
+			}
+			if offer.Name != "Photos" || offer.Files != 3 || offer.TotalSize != 23 {
+				t.Errorf("Offer() = %s with %d files of %d bytes, want Photos with 3 of 23", offer.Name, offer.Files, offer.TotalSize)
+			}
+			if want := map[bool]string{false: protocol.FileArchiveTar, true: protocol.FileArchiveTarZstd}[compress]; offer.Archive != want {
+				t.Errorf("Offer() archive = %s, want %s", offer.Archive, want)
+			}
+
+			srv := serve(t, sender)
+			resp, archive := request(t, "GET", srv.URL+"/download/"+offer.ID, "mobile-1", offer.Token, "")
+			if resp.StatusCode != http.StatusOK || int64(len(archive)) != offer.Size || digest(archive) != offer.SHA256 {
+				t.Fatalf("GET = %d with %d bytes, want 200 with the %d offered", resp.StatusCode, len(archive), offer.Size)
+			}
+			if got, _ := sender.Get(offer.ID); got.State != StateComplete || got.FilesDone != 3 {
+				t.Errorf("Get() after the download = %s with %d files done, want complete with 3", got.State, got.FilesDone)
+			}
+
+			// The archive is generated again for every download, so a
+			// resumed download continues the same stream
+			again, _ := sender.Offer("mobile-1", src, compress)
+			resp, tail := request(t, "GET", srv.URL+"/download/"+again.ID, "mobile-1", again.Token, "", "Range", "bytes=10-")
+			if resp.StatusCode != http.StatusPartialContent || tail != archive[10:] {
+				t.Errorf("GET with Range = %d, want 206 with the rest of the same archive", resp.StatusCode)
+			}
+
+			// A folder changed after it was offered fails the transfer
+			changed, _ := sender.Offer("mobile-1", src, compress)
+			writeFiles(t, src, "a.jpg", "first photo, edited")
+			request(t, "GET", srv.URL+"/download/"+changed.ID, "mobile-1", changed.Token, "")
+			if got, _ := sender.Get(changed.ID); got.State != StateFailed {
+				t.Errorf("Get() of a changed folder = %s, want %s", got.State, StateFailed)
+			}
+
+			// The device sends the folder back
+			dir := t.TempDir()
+			receiver := NewManager(dir)
+			in, err := receiver.Incoming("mobile-1", &protocol.FileOfferPayload{
+				ID: "folder-1", Name: "Photos", Size: offer.Size, SHA256: offer.SHA256,
+				Archive: offer.Archive, Files: offer.Files, TotalSize: offer.TotalSize,
+			})
+			if err != nil {
+				t.Fatalf("Incoming() error = %v", err)
+			}
+			accepted, _ := receiver.Accept(in.ID, "", "")
+			resp, _ = request(t, "POST", serve(t, receiver).URL+"/upload/"+in.ID, "mobile-1", accepted.Token, archive)
+			if resp.StatusCode != http.StatusCreated {
+				t.Fatalf("POST = %d, want %d", resp.StatusCode, http.StatusCreated)
+			}
+
+			got, _ := receiver.Get(in.ID)
+			root := filepath.Join(dir, "Photos")
+			if got.State != StateComplete || got.Path != root {
+				t.Fatalf("Get() after the upload = %s at %s, want complete at %s", got.State, got.Path, root)
+			}
+			for name, want := range map[string]string{"a.jpg": "first photo", "trip/b.jpg": "second photo", "trip/c/d.txt": ""} {
+				if got := readFile(filepath.Join(root, name)); got != want {
+					t.Errorf("%s = %q, want %q", name, got, want)
+				}
+			}
+			if info, err := os.Stat(filepath.Join(root, "empty")); err != nil || !info.IsDir() {
+				t.Errorf("empty folder wasn't created: %v", err)
+			}
+			if _, err := os.Lstat(filepath.Join(root, "passwd")); !os.IsNotExist(err) {
+				t.Errorf("symlink was sent: %v", err)
+			}
+			if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+				t.Errorf("files next to the folder: %v", entries)
+			}
+		})
+	}
+}
+
+func TestExtractUnsafe(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries []tarEntry
+		// link is a symlink to the parent of the destination, made there
+		// before unpacking
+		link string
+	}{
+		{"Parent", []tarEntry{{name: "../evil.txt", body: "x"}}, ""},
+		{"Nested parent", []tarEntry{{name: "a/../../evil.txt", body: "x"}}, ""},
+		{"Absolute", []tarEntry{{name: "/tmp/evil.txt", body: "x"}}, ""},
+		{"Through symlink", []tarEntry{{name: "out/evil.txt", body: "x"}}, "out"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			base := t.TempDir()
+			archive := filepath.Join(base, "archive.tar")
+			os.WriteFile(archive, []byte(makeTar(t, tt.entries...)), 0644)
+
+			dest := filepath.Join(base, "dest", "folder")
+			if tt.link != "" {
+				os.MkdirAll(dest, 0755)
+				os.Symlink("..", filepath.Join(dest, tt.link))
+			}
+			if _, err := extract(archive, dest, protocol.FileArchiveTar, ConflictRename, 100, func(int, string) error { return nil }); err == nil {
+				t.Error("extract() succeeded")
+			}
+			for _, path := range []string{filepath.Join(base, "evil.txt"), filepath.Join(base, "dest", "evil.txt"), "/tmp/evil.txt"} {
+				if _, err := os.Stat(path); err == nil {
+					t.Errorf("%s was written", path)
+				}
+			}
+		})
+	}
+
+	// Links in the archive are left out
+	base := t.TempDir()
+	archive := filepath.Join(base, "archive.tar")
+	os.WriteFile(archive, []byte(makeTar(t, tarEntry{name: "passwd", link: "/etc/passwd"})), 0644)
+	if _, err := extract(archive, filepath.Join(base, "dest"), protocol.FileArchiveTar, ConflictRename, 100, func(int, string) error { return nil }); err != nil {
+		t.Errorf("extract() error = %v", err)
+	}
+	if _, err := os.Lstat(filepath.Join(base, "dest", "passwd")); !os.IsNotExist(err) {
+		t.Errorf("symlink was created: %v", err)
+	}
+}
+
+func TestExtractLimit(t *testing.T) {
+	base := t.TempDir()
+	archive := filepath.Join(base, "archive.tar")
+	os.WriteFile(archive, []byte(makeTar(t, tarEntry{name: "a", body: "12345"}, tarEntry{name: "b", body: "67890"})), 0644)
+
+	if _, err := extract(archive, filepath.Join(base, "dest"), protocol.FileArchiveTar, ConflictRename, 9, func(int, string) error { return nil }); err == nil {
+		t.Error("extract() of more than the offered size succeeded")
+	}
+}
+
+func TestExtractConflict(t *testing.T) {
+	tests := []struct {
+		conflict    Conflict
+		wantSkipped int
+		want        map[string]string
+	}{
+		{ConflictRename, 0, map[string]string{"a.txt": "old", "a (1).txt": "new", "sub/b.txt": "new b"}},
+		{ConflictOverwrite, 0, map[string]string{"a.txt": "new", "sub/b.txt": "new b"}},
+		{ConflictSkip, 1, map[string]string{"a.txt": "old", "sub/b.txt": "new b"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.conflict), func(t *testing.T) {
+			base := t.TempDir()
+			archive := filepath.Join(base, "archive.tar")
+			os.WriteFile(archive, []byte(makeTar(t, tarEntry{name: "a.txt", body: "new"}, tarEntry{name: "sub/b.txt", body: "new b"})), 0644)
+			dest := filepath.Join(base, "dest")
+			writeFiles(t, dest, "a.txt", "old")
+
+			var files []string
+			skipped, err := extract(archive, dest, protocol.FileArchiveTar, tt.conflict, 100, func(index int, name string) error {
+				files = append(files, fmt.Sprintf("%d %s", index, name))
+				return nil
+			})
+			if err != nil || skipped != tt.wantSkipped {
+				t.Fatalf("extract() = %d, %v, want %d skipped", skipped, err, tt.wantSkipped)
+			}
+			if len(files) != 2 || files[0] != "0 a.txt" || files[1] != "1 sub/b.txt" {
+				t.Errorf("files reported = %v", files)
+			}
+			for name, want := range tt.want {
+				if got := readFile(filepath.Join(dest, name)); got != want {
+					t.Errorf("%s = %q, want %q", name, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestUploadConflict(t *testing.T) {
+	tests := []struct {
+		conflict    Conflict
+		wantName    string
+		wantData    string
+		wantSkipped int
+		wantFiles   int
+	}{
+		{ConflictRename, "a (1).txt", "new", 0, 2},
+		{ConflictOverwrite, "a.txt", "new", 0, 1},
+		{ConflictSkip, "a.txt", "old", 1, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.conflict), func(t *testing.T) {
+			dir := t.TempDir()
+			writeFiles(t, dir, "a.txt", "old")
+			m := NewManager(dir)
+			m.SetConflict(tt.conflict)
+			srv := serve(t, m)
+
+			m.Incoming("mobile-1", &protocol.FileOfferPayload{ID: "file-1", Name: "a.txt", Size: 3, SHA256: digest("new")})
+			accepted, _ := m.Accept("file-1", "", "")
+			if resp, _ := request(t, "POST", srv.URL+"/upload/file-1", "mobile-1", accepted.Token, "new"); resp.StatusCode != http.StatusCreated {
+				t.Fatalf("POST = %d, want %d", resp.StatusCode, http.StatusCreated)
+			}
+
+			got, _ := m.Get("file-1")
+			if want := filepath.Join(dir, tt.wantName); got.Path != want || got.Skipped != tt.wantSkipped {
+				t.Errorf("Get() = %s with %d skipped, want %s with %d", got.Path, got.Skipped, want, tt.wantSkipped)
+			}
+			if data := readFile(filepath.Join(dir, tt.wantName)); data != tt.wantData {
+				t.Errorf("%s = %q, want %q", tt.wantName, data, tt.wantData)
+			}
+			if entries, _ := os.ReadDir(dir); len(entries) != tt.wantFiles {
+				t.Errorf("files in the directory = %v, want %d", entries, tt.wantFiles)
+			}
+		})
+	}
+}
+
+func TestParseConflict(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    Conflict
+		wantErr bool
+	}{
+		{"", ConflictRename, false},
+		{"rename", ConflictRename, false},
+		{"overwrite", ConflictOverwrite, false},
+		{"skip", ConflictSkip, false},
+		{"ask", "", true},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseConflict(tt.input)
+		if got != tt.want || (err != nil) != tt.wantErr {
+			t.Errorf("ParseConflict(%q) = %v, %v, want %v", tt.input, got, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,372 @@
+package transfer
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Headers of the data plane
+const (
+	// DeviceHeader names the device making a request. The bearer token in
+	// the Authorization header must have been issued to it.
+	DeviceHeader = "X-Eco-Device"
+	// OffsetHeader tells the device how much of its upload has arrived
+	OffsetHeader = "Upload-Offset"
+)
+
+// chunkSize is how much data is copied between two progress updates
+const chunkSize = 64 * 1024
+
+// errChanged fails the download of a file or folder that isn't what was
+// offered anymore
+var errChanged = errors.New("the file changed since it was offered")
+
+// HandleDownload serves GET /download/{id}: the file offered to the device,
+// or the archive of a folder. A "bytes=N-" Range header resumes an
+// interrupted download from offset N.
+func (m *Manager) HandleDownload(w http.ResponseWriter, r *http.Request) {
+	t, err := m.begin(r, Outgoing)
+	if err != nil {
+		httpError(w, err)
+		return
+	}
+
+	start, err := parseRange(r.Header.Get("Range"), t.Size)
+	if err != nil {
+		m.end(t, "", "")
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", t.Size))
+		http.Error(w, err.Error(), http.StatusRequestedRangeNotSatisfiable)
+		return
+	}
+
+	src, err := m.open(t, start)
+	if err != nil {
+		m.end(t, StateFailed, err.Error())
+		httpError(w, err)
+		return
+	}
+	defer src.Close()
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": t.Name}))
+	w.Header().Set("Content-Length", strconv.FormatInt(t.Size-start, 10))
+	w.Header().Set("Accept-Ranges", "bytes")
+	if start > 0 {
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, t.Size-1, t.Size))
+		w.WriteHeader(http.StatusPartialContent)
+	}
+
+	if err := m.copy(t, start, w, io.LimitReader(src, t.Size-start)); err != nil {
+		// The device resumes with a Range request
+		log.Printf("Transfer: Download of %s interrupted: %v", t.ID, err)
+		m.end(t, "", "")
+		return
+	}
+
+	m.mu.Lock()
+	sent := t.Bytes
+	m.mu.Unlock()
+	if n, err := src.Read(make([]byte, 1)); sent != t.Size || n > 0 || err != io.EOF {
+		m.end(t, StateFailed, errChanged.Error())
+		return
+	}
+	m.end(t, StateComplete, "")
+}
+
+// open returns the data of busy outgoing transfer t from offset start: the
+// data offered from memory, the file, or the archive of the folder, generated again up to start. The
+// archive ends in errChanged instead of io.EOF if it doesn't match the
+// offered digest.
+func (m *Manager) open(t *Transfer, start int64) (io.ReadCloser, error) {
+	m.mu.Lock()
+	data := t.data
+	m.mu.Unlock()
+	if data != nil {
+		return io.NopCloser(bytes.NewReader(data[start:])), nil
+	}
+	if t.Archive == "" {
+		f, err := os.Open(t.Path)
+		if err != nil {
+			return nil, err
+		}
+		if info, err := f.Stat(); err != nil || info.Size() != t.Size {
+			f.Close()
+			return nil, errChanged
+		}
+		if _, err := f.Seek(start, io.SeekStart); err != nil {
+			f.Close()
+			return nil, err
+		}
+		return f, nil
+	}
+
+	pr, pw := io.Pipe()
+	go func() {
+		h := sha256.New()
+		err := writeArchive(io.MultiWriter(pw, h), t.Path, t.Archive, func(index int, name string, size int64) error {
+			return m.startFile(t, index, name)
+		})
+		if err == nil && hex.EncodeToString(h.Sum(nil)) != t.SHA256 {
+			err = errChanged
+		}
+		pw.CloseWithError(err)
+	}()
+	if _, err := io.CopyN(io.Discard, pr, start); err != nil {
+		pr.Close()
+		return nil, errChanged
+	}
+	return pr, nil
+}
+
+// HandleUpload serves POST /upload/{id}: a chunk of the file the device
+// offered, placed by a Content-Range header like "bytes 0-1023/4096".
+// Without the header the body is the whole file. A chunk must start where
+// the received data ends, otherwise it is refused with 409 Conflict; every
+// answer carries the length received so far in the Upload-Offset header.
+// The last chunk is answered once the file matched its digest.
+func (m *Manager) HandleUpload(w http.ResponseWriter, r *http.Request) {
+	t, err := m.begin(r, Incoming)
+	if err != nil {
+		httpError(w, err)
+		return
+	}
+
+	m.mu.Lock()
+	received := t.Bytes
+	m.mu.Unlock()
+
+	start, length, err := parseContentRange(r.Header.Get("Content-Range"), t.Size)
+	if err != nil {
+		m.end(t, "", "")
+		w.Header().Set(OffsetHeader, strconv.FormatInt(received, 10))
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if start != received {
+		m.end(t, "", "")
+		w.Header().Set(OffsetHeader, strconv.FormatInt(received, 10))
+		http.Error(w, fmt.Sprintf("upload continues at offset %d", received), http.StatusConflict)
+		return
+	}
+
+	if err := m.receive(t, start, length, r.Body); err != nil {
+		m.mu.Lock()
+		received = t.Bytes
+		m.mu.Unlock()
+		m.end(t, "", "")
+		w.Header().Set(OffsetHeader, strconv.FormatInt(received, 10))
+		httpError(w, err)
+		return
+	}
+
+	w.Header().Set(OffsetHeader, strconv.FormatInt(start+length, 10))
+	if start+length < t.Size {
+		m.end(t, "", "")
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	path, skipped, err := m.save(t)
+	if err != nil {
+		m.end(t, StateFailed, err.Error())
+		httpError(w, err)
+		return
+	}
+	m.mu.Lock()
+	t.Path = path
+	t.Skipped = skipped
+	m.mu.Unlock()
+	m.end(t, StateComplete, "")
+	w.WriteHeader(http.StatusCreated)
+}
+
+// begin authorizes a data plane request for the transfer in its path and
+// marks the transfer busy until the request calls end
+func (m *Manager) begin(r *http.Request, direction Direction) (*Transfer, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	t, err := m.lookup(r.PathValue("id"))
+	if err != nil || t.Direction != direction {
+		return nil, ErrNotFound
+	}
+	if t.DeviceID != r.Header.Get(DeviceHeader) {
+		return nil, ErrUnauthorized
+	}
+	if t.State == StateOffered && direction == Incoming {
+		return nil, ErrNotAccepted
+	}
+	token, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if t.Token == "" || subtle.ConstantTimeCompare([]byte(token), []byte(t.Token)) != 1 {
+		return nil, ErrUnauthorized
+	}
+	switch {
+	case t.Finished():
+		return nil, fmt.Errorf("%w: %s", ErrFinished, t.State)
+	case t.busy:
+		return nil, ErrBusy
+	}
+
+	t.busy = true
+	t.State = StateTransferring
+	return t, nil
+}
+
+// receive writes length bytes of body at offset start of the partial file
+// of busy transfer t. What arrives before an error is kept, to resume from.
+func (m *Manager) receive(t *Transfer, start, length int64, body io.Reader) error {
+	f, err := os.OpenFile(t.partial, os.O_WRONLY|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	// Drop whatever an interrupted write left past the received data
+	if err := f.Truncate(start); err != nil {
+		return err
+	}
+	if _, err := f.Seek(start, io.SeekStart); err != nil {
+		return err
+	}
+	if err := m.copy(t, start, f, io.LimitReader(body, length)); err != nil {
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	received := t.Bytes
+	m.mu.Unlock()
+	if received != start+length {
+		return fmt.Errorf("upload ended after %d of %d bytes", received-start, length)
+	}
+	if n, _ := body.Read(make([]byte, 1)); n > 0 {
+		return fmt.Errorf("upload is longer than %d bytes", length)
+	}
+	return nil
+}
+
+// save checks the partial file of t against the offered digest and moves
+// it where it was meant to go, or unpacks it there for a folder. It
+// returns the path and how many files were skipped by the conflict policy.
+func (m *Manager) save(t *Transfer) (string, int, error) {
+	sum, err := fileSHA256(t.partial)
+	if err != nil {
+		return "", 0, err
+	}
+	if sum != t.SHA256 {
+		return "", 0, ErrChecksum
+	}
+
+	if t.Archive != "" {
+		defer os.Remove(t.partial)
+		skipped, err := extract(t.partial, t.Path, t.Archive, t.conflict, t.TotalSize, func(index int, name string) error {
+			return m.startFile(t, index, name)
+		})
+		return t.Path, skipped, err
+	}
+
+	path, skip, err := resolveConflict(t.Path, t.conflict, func(p string) bool {
+		_, err := os.Lstat(p)
+		return err == nil
+	}, os.Remove)
+	if err != nil {
+		return "", 0, err
+	}
+	if skip {
+		os.Remove(t.partial)
+		return t.Path, 1, nil
+	}
+	if err := os.Rename(t.partial, path); err != nil {
+		return "", 0, err
+	}
+	return path, 0, nil
+}
+
+// copy moves data from src to dst, recording how far past offset it got
+func (m *Manager) copy(t *Transfer, offset int64, dst io.Writer, src io.Reader) error {
+	buf := make([]byte, chunkSize)
+	for {
+		n, readErr := src.Read(buf)
+		if n > 0 {
+			if _, err := dst.Write(buf[:n]); err != nil {
+				return err
+			}
+			offset += int64(n)
+			if err := m.advance(t, offset); err != nil {
+				return err
+			}
+		}
+		if readErr == io.EOF {
+			return nil
+		}
+		if readErr != nil {
+			return readErr
+		}
+	}
+}
+
+// parseRange returns the offset of a "bytes=N-" Range header, the form
+// used to resume a download
+func parseRange(header string, size int64) (int64, error) {
+	if header == "" {
+		return 0, nil
+	}
+	spec, ok := strings.CutPrefix(header, "bytes=")
+	start, open := strings.CutSuffix(spec, "-")
+	if !ok || !open {
+		return 0, fmt.Errorf("unsupported range %q, use bytes=N-", header)
+	}
+	offset, err := strconv.ParseInt(start, 10, 64)
+	if err != nil || offset < 0 || offset > size || (offset == size && size > 0) {
+		return 0, fmt.Errorf("range %q is outside the %d byte file", header, size)
+	}
+	return offset, nil
+}
+
+// parseContentRange returns the offset and length of the chunk described
+// by an upload's Content-Range header. Without one the chunk is the whole
+// file.
+func parseContentRange(header string, size int64) (int64, int64, error) {
+	if header == "" {
+		return 0, size, nil
+	}
+	var start, end, total int64
+	if _, err := fmt.Sscanf(header, "bytes %d-%d/%d", &start, &end, &total); err != nil {
+		return 0, 0, fmt.Errorf("invalid content range %q", header)
+	}
+	if total != size || start < 0 || end < start || end >= size {
+		return 0, 0, fmt.Errorf("content range %q is outside the %d byte file", header, size)
+	}
+	return start, end - start + 1, nil
+}
+
+// httpError answers a data plane request that failed with err
+func httpError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, ErrNotFound):
+		status = http.StatusNotFound
+	case errors.Is(err, ErrUnauthorized):
+		status = http.StatusForbidden
+	case errors.Is(err, ErrNotAccepted), errors.Is(err, ErrBusy):
+		status = http.StatusConflict
+	case errors.Is(err, ErrFinished):
+		status = http.StatusGone
+	case errors.Is(err, ErrChecksum):
+		status = http.StatusUnprocessableEntity
+	}
+	http.Error(w, err.Error(), status)
+}
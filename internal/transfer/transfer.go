@@ -0,0 +1,572 @@
+package transfer
+
+import (
+	"cmp"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"eco/internal/crypto"
+	"eco/internal/protocol"
+)
+
+// DefaultTTL is how long a transfer may sit idle before it expires, and how
+// long a finished one is remembered
+const DefaultTTL = time.Hour
+
+// IDLength is the length of the IDs of transfers offered by the desktop
+const IDLength = 16
+
+// TokenLength is the length of the one-time tokens for the data plane
+const TokenLength = 64
+
+// progressInterval is the least time between two progress reports
+const progressInterval = 500 * time.Millisecond
+
+// Direction tells which way a file goes
+type Direction string
+
+const (
+	// Outgoing files go from the desktop to a device
+	Outgoing Direction = "outgoing"
+	// Incoming files go from a device to the desktop
+	Incoming Direction = "incoming"
+)
+
+// State is the progress of a transfer
+type State string
+
+const (
+	StateOffered      State = "offered"
+	StateAccepted     State = "accepted"
+	StateTransferring State = "transferring"
+	StateComplete     State = "complete"
+	StateFailed       State = "failed"
+	StateCancelled    State = "cancelled"
+)
+
+var (
+	// ErrNotFound is returned for unknown or forgotten transfers
+	ErrNotFound = errors.New("no such transfer")
+	// ErrUnauthorized is returned when a request doesn't carry the token
+	// issued to its device
+	ErrUnauthorized = errors.New("invalid transfer token")
+	// ErrNotAccepted is returned for uploads of files that weren't accepted
+	ErrNotAccepted = errors.New("transfer has not been accepted")
+	// ErrBusy is returned while another request moves the same file
+	ErrBusy = errors.New("transfer already in progress")
+	// ErrFinished is returned for transfers that are over
+	ErrFinished = errors.New("transfer is over")
+	// ErrChecksum is returned when a received file doesn't match its digest
+	ErrChecksum = errors.New("checksum mismatch")
+)
+
+// Transfer is one file or folder on its way to or from a device
+type Transfer struct {
+	ID        string    `json:"id"`
+	DeviceID  string    `json:"device_id"`
+	Direction Direction `json:"direction"`
+	Name      string    `json:"name"`
+	Size      int64     `json:"size"`
+	SHA256    string    `json:"sha256"`
+	// Bytes is how much of the file has been transferred
+	Bytes int64 `json:"bytes"`
+	State State `json:"state"`
+	// Path is the file or folder being sent, or where a received one was
+	// saved
+	Path      string    `json:"path,omitempty"`
+	Error     string    `json:"error,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	// Token authorizes the device's HTTP requests for the transfer. It is
+	// issued with the offer for outgoing files and on accept for incoming
+	// ones, and stops working once the transfer is over.
+	Token string `json:"-"`
+
+	// Archive is the format a folder moves in, empty for a single file.
+	// Files and TotalSize count the files of the folder and their size.
+	Archive   string `json:"archive,omitempty"`
+	Files     int    `json:"files,omitempty"`
+	TotalSize int64  `json:"total_size,omitempty"`
+	// File is the file of a folder being transferred or unpacked, and
+	// FilesDone how many of the folder's files came before it
+	File      string `json:"file,omitempty"`
+	FilesDone int    `json:"files_done,omitempty"`
+	// Skipped counts the received files dropped because their name was taken
+	Skipped int `json:"skipped,omitempty"`
+
+	// data is the content of an outgoing transfer offered from memory,
+	// like a clipboard image, instead of from Path
+	data []byte
+	// partial is where an incoming file is written until it is verified
+	partial  string
+	conflict Conflict
+	busy     bool
+	updated  time.Time
+	reported time.Time
+}
+
+// Finished reports whether the transfer is over
+func (t *Transfer) Finished() bool {
+	return t.State == StateComplete || t.State == StateFailed || t.State == StateCancelled
+}
+
+// finish ends the transfer in state, dropping the data offered from memory
+// and the partial file of an unfinished upload. Callers hold the manager's
+// lock.
+func (t *Transfer) finish(state State, reason string) {
+	t.State = state
+	t.Error = reason
+	t.updated = time.Now()
+	t.data = nil
+	if state == StateComplete {
+		t.File = ""
+		t.FilesDone = t.Files
+	}
+	if state != StateComplete && t.partial != "" {
+		os.Remove(t.partial)
+	}
+}
+
+// Manager keeps the transfers of the daemon. The devices move the data over
+// HTTP with HandleDownload and HandleUpload.
+type Manager struct {
+	mu         sync.Mutex
+	dir        string
+	conflict   Conflict
+	ttl        time.Duration
+	transfers  map[string]*Transfer
+	onProgress func(Transfer)
+}
+
+// NewManager creates a manager that saves received files in dir
+func NewManager(dir string) *Manager {
+	return &Manager{
+		dir:       dir,
+		conflict:  ConflictRename,
+		ttl:       DefaultTTL,
+		transfers: make(map[string]*Transfer),
+	}
+}
+
+// DefaultDir returns the directory received files are saved in by default
+func DefaultDir() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, "Downloads"), nil
+}
+
+// SetConflict sets what happens to received files whose name is taken
+// when Accept isn't told otherwise
+func (m *Manager) SetConflict(conflict Conflict) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.conflict = conflict
+}
+
+// OnProgress sets the function called as the data of a transfer moves, at
+// most every progressInterval, and when it is over
+func (m *Manager) OnProgress(fn func(Transfer)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.onProgress = fn
+}
+
+// Offer prepares the file or folder at path to be sent to deviceID and
+// returns the transfer, whose token goes into the offer. A folder is sent
+// as a tar stream, zstd compressed if compress is set, which is generated
+// once here for its size and digest and again for every download.
+func (m *Manager) Offer(deviceID, path string, compress bool) (Transfer, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return Transfer{}, err
+	}
+
+	t := &Transfer{
+		Direction: Outgoing,
+		Name:      filepath.Base(path),
+		Path:      path,
+	}
+	h := sha256.New()
+	switch {
+	case info.IsDir():
+		t.Archive = protocol.FileArchiveTar
+		if compress {
+			t.Archive = protocol.FileArchiveTarZstd
+		}
+		var size counter
+		err = writeArchive(io.MultiWriter(h, &size), path, t.Archive, func(index int, name string, n int64) error {
+			t.Files++
+			t.TotalSize += n
+			return nil
+		})
+		t.Size = int64(size)
+	case info.Mode().IsRegular():
+		t.Size, err = copyFile(h, path)
+	default:
+		return Transfer{}, fmt.Errorf("%s is not a regular file or folder", path)
+	}
+	if err != nil {
+		return Transfer{}, err
+	}
+	t.SHA256 = hex.EncodeToString(h.Sum(nil))
+	return m.offer(deviceID, t)
+}
+
+// Prepared is a file, or data held in memory, hashed once to be offered to
+// several devices with OfferPrepared
+type Prepared struct {
+	Name   string
+	Size   int64
+	SHA256 string
+	path   string
+	data   []byte
+}
+
+// PrepareFile hashes the regular file at path
+func PrepareFile(path string) (Prepared, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return Prepared{}, err
+	}
+	if !info.Mode().IsRegular() {
+		return Prepared{}, fmt.Errorf("%s is not a regular file", path)
+	}
+	h := sha256.New()
+	size, err := copyFile(h, path)
+	if err != nil {
+		return Prepared{}, err
+	}
+	return Prepared{
+		Name:   filepath.Base(path),
+		Size:   size,
+		SHA256: hex.EncodeToString(h.Sum(nil)),
+		path:   path,
+	}, nil
+}
+
+// PrepareData hashes data to be sent as a file called name. The data must
+// not change until its transfers are over.
+func PrepareData(name string, data []byte) Prepared {
+	sum := sha256.Sum256(data)
+	return Prepared{
+		Name:   name,
+		Size:   int64(len(data)),
+		SHA256: hex.EncodeToString(sum[:]),
+		data:   data,
+	}
+}
+
+// OfferPrepared offers p to deviceID like Offer, without reading it again
+func (m *Manager) OfferPrepared(deviceID string, p Prepared) (Transfer, error) {
+	return m.offer(deviceID, &Transfer{
+		Direction: Outgoing,
+		Name:      p.Name,
+		Path:      p.path,
+		Size:      p.Size,
+		SHA256:    p.SHA256,
+		data:      p.data,
+	})
+}
+
+// offer records outgoing transfer t for deviceID with a new ID and token
+func (m *Manager) offer(deviceID string, t *Transfer) (Transfer, error) {
+	id, err := crypto.GenerateRandomString(IDLength)
+	if err != nil {
+		return Transfer{}, err
+	}
+	token, err := crypto.GenerateRandomString(TokenLength)
+	if err != nil {
+		return Transfer{}, err
+	}
+
+	t.ID = id
+	t.DeviceID = deviceID
+	t.State = StateOffered
+	t.Token = token
+	t.CreatedAt = time.Now()
+	t.updated = t.CreatedAt
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.expire()
+	m.transfers[id] = t
+	return *t, nil
+}
+
+// Incoming records a file or folder deviceID offers. An offer repeating an unfinished
+// one, e.g. from a device that reconnected, returns the transfer as it is so
+// its upload can resume.
+func (m *Manager) Incoming(deviceID string, offer *protocol.FileOfferPayload) (Transfer, error) {
+	name, err := safeName(offer.Name)
+	if err != nil {
+		return Transfer{}, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.expire()
+
+	if t := m.transfers[offer.ID]; t != nil {
+		if t.DeviceID != deviceID || t.Direction != Incoming || t.Archive != offer.Archive || t.Size != offer.Size || !strings.EqualFold(t.SHA256, offer.SHA256) {
+			return Transfer{}, fmt.Errorf("transfer id %s is already in use", offer.ID)
+		}
+		if t.Finished() {
+			return Transfer{}, fmt.Errorf("%w: %s", ErrFinished, t.State)
+		}
+		return *t, nil
+	}
+
+	now := time.Now()
+	t := &Transfer{
+		ID:        offer.ID,
+		DeviceID:  deviceID,
+		Direction: Incoming,
+		Name:      name,
+		Size:      offer.Size,
+		SHA256:    strings.ToLower(offer.SHA256),
+		State:     StateOffered,
+		Archive:   offer.Archive,
+		Files:     offer.Files,
+		TotalSize: offer.TotalSize,
+		CreatedAt: now,
+		updated:   now,
+	}
+	m.transfers[t.ID] = t
+	return *t, nil
+}
+
+// Accept takes the incoming file or folder offered as id, to be saved in dir
+// or, if it is empty, the manager's directory. Received files whose name is
+// taken are handled by conflict, or the manager's policy if it is empty.
+// The returned transfer carries the token for the upload.
+func (m *Manager) Accept(id, dir string, conflict Conflict) (Transfer, error) {
+	if dir == "" {
+		dir = m.dir
+	}
+	token, err := crypto.GenerateRandomString(TokenLength)
+	if err != nil {
+		return Transfer{}, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	t, err := m.lookup(id)
+	if err != nil {
+		return Transfer{}, err
+	}
+	if t.Direction != Incoming {
+		return Transfer{}, fmt.Errorf("transfer %s is not an incoming file", id)
+	}
+	if t.State != StateOffered {
+		return Transfer{}, fmt.Errorf("transfer %s is already %s", id, t.State)
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return Transfer{}, err
+	}
+
+	t.partial = filepath.Join(dir, ".eco-"+t.ID+".part")
+	t.Path = filepath.Join(dir, t.Name)
+	t.Token = token
+	t.conflict = cmp.Or(conflict, m.conflict)
+	t.State = StateAccepted
+	t.updated = time.Now()
+	return *t, nil
+}
+
+// Accepted records that deviceID took the file offered to it as id
+func (m *Manager) Accepted(deviceID, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	t, err := m.lookup(id)
+	if err != nil {
+		return err
+	}
+	if t.DeviceID != deviceID || t.Direction != Outgoing {
+		return ErrNotFound
+	}
+	if t.State == StateOffered {
+		t.State = StateAccepted
+		t.updated = time.Now()
+	}
+	return nil
+}
+
+// Cancel aborts transfer id for reason. deviceID is the device that gave
+// up on it, or "" when it was cancelled on the desktop.
+func (m *Manager) Cancel(deviceID, id, reason string) (Transfer, error) {
+	if reason == "" {
+		reason = "cancelled"
+	}
+
+	m.mu.Lock()
+	t, err := m.lookup(id)
+	if err == nil && deviceID != "" && t.DeviceID != deviceID {
+		err = ErrNotFound
+	}
+	if err == nil && t.Finished() {
+		err = fmt.Errorf("%w: %s", ErrFinished, t.State)
+	}
+	if err != nil {
+		m.mu.Unlock()
+		return Transfer{}, err
+	}
+	t.finish(StateCancelled, reason)
+	snapshot, report := *t, m.onProgress
+	m.mu.Unlock()
+
+	if report != nil {
+		report(snapshot)
+	}
+	return snapshot, nil
+}
+
+// Get returns transfer id
+func (m *Manager) Get(id string) (Transfer, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	t, err := m.lookup(id)
+	if err != nil {
+		return Transfer{}, err
+	}
+	return *t, nil
+}
+
+// List returns the transfers, oldest first
+func (m *Manager) List() []Transfer {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.expire()
+
+	transfers := make([]Transfer, 0, len(m.transfers))
+	for _, t := range m.transfers {
+		transfers = append(transfers, *t)
+	}
+	slices.SortFunc(transfers, func(a, b Transfer) int {
+		return a.CreatedAt.Compare(b.CreatedAt)
+	})
+	return transfers
+}
+
+// lookup returns transfer id. Callers hold m.mu.
+func (m *Manager) lookup(id string) (*Transfer, error) {
+	m.expire()
+	t := m.transfers[id]
+	if t == nil {
+		return nil, ErrNotFound
+	}
+	return t, nil
+}
+
+// expire fails transfers that sat idle for longer than the TTL and forgets
+// finished ones. Callers hold m.mu.
+func (m *Manager) expire() {
+	now := time.Now()
+	for id, t := range m.transfers {
+		switch {
+		case t.busy || now.Sub(t.updated) < m.ttl:
+		case t.Finished():
+			delete(m.transfers, id)
+		default:
+			t.finish(StateFailed, "expired")
+		}
+	}
+}
+
+// advance records that the data of busy transfer t reached offset, and
+// reports progress when it is due. It fails once t was cancelled.
+func (m *Manager) advance(t *Transfer, offset int64) error {
+	return m.update(t, func() { t.Bytes = offset })
+}
+
+// startFile records that busy transfer t got to the file of its folder at
+// index, like advance
+func (m *Manager) startFile(t *Transfer, index int, name string) error {
+	return m.update(t, func() {
+		t.File = name
+		t.FilesDone = index
+	})
+}
+
+// update changes busy transfer t with fn and reports progress when it is
+// due. It fails once t was cancelled.
+func (m *Manager) update(t *Transfer, fn func()) error {
+	m.mu.Lock()
+	if t.State != StateTransferring {
+		m.mu.Unlock()
+		return fmt.Errorf("%w: %s", ErrFinished, t.State)
+	}
+	fn()
+	t.updated = time.Now()
+	due := t.updated.Sub(t.reported) >= progressInterval
+	if due {
+		t.reported = t.updated
+	}
+	snapshot, report := *t, m.onProgress
+	m.mu.Unlock()
+
+	if due && report != nil {
+		report(snapshot)
+	}
+	return nil
+}
+
+// end finishes busy transfer t in state, or only releases it for the next
+// request if state is empty
+func (m *Manager) end(t *Transfer, state State, reason string) {
+	m.mu.Lock()
+	t.busy = false
+	t.updated = time.Now()
+	if state == "" || t.Finished() {
+		m.mu.Unlock()
+		return
+	}
+	t.finish(state, reason)
+	snapshot, report := *t, m.onProgress
+	m.mu.Unlock()
+
+	if report != nil {
+		report(snapshot)
+	}
+}
+
+// safeName reduces a file name from a device to its last element, so it
+// can't point outside the directory it is saved in
+func safeName(name string) (string, error) {
+	base := filepath.Base(strings.ReplaceAll(name, "\\", "/"))
+	if base == "." || base == ".." || base == "/" || strings.ContainsRune(base, 0) {
+		return "", fmt.Errorf("invalid file name %q", name)
+	}
+	return base, nil
+}
+
+// fileSHA256 returns the hex encoded SHA-256 digest of the file at path
+func fileSHA256(path string) (string, error) {
+	h := sha256.New()
+	if _, err := copyFile(h, path); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// copyFile writes the file at path to w
+func copyFile(w io.Writer, path string) (int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	return io.Copy(w, f)
+}
@@ -0,0 +1,382 @@
+package transfer
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"eco/internal/protocol"
+)
+
+// serve runs the data plane of m
+func serve(t *testing.T, m *Manager) *httptest.Server {
+	t.Helper()
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /download/{id}", m.HandleDownload)
+	mux.HandleFunc("POST /upload/{id}", m.HandleUpload)
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// request makes a data plane request as deviceID with token and the extra
+// headers, given as name and value pairs
+func request(t *testing.T, method, url, deviceID, token, body string, headers ...string) (*http.Response, string) {
+	t.Helper()
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("NewRequest() error = %v", err)
+	}
+	req.Header.Set(DeviceHeader, deviceID)
+	req.Header.Set("Authorization", "Bearer "+token)
+	for i := 0; i+1 < len(headers); i += 2 {
+		req.Header.Set(headers[i], headers[i+1])
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("%s %s error = %v", method, url, err)
+	}
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(resp.Body)
+	return resp, string(data)
+}
+
+func digest(data string) string {
+	sum := sha256.Sum256([]byte(data))
+	return hex.EncodeToString(sum[:])
+}
+
+// progressLog records the progress reports of a manager
+type progressLog struct {
+	mu     sync.Mutex
+	states []State
+}
+
+func (p *progressLog) record(t Transfer) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.states = append(p.states, t.State)
+}
+
+func (p *progressLog) last() State {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.states) == 0 {
+		return ""
+	}
+	return p.states[len(p.states)-1]
+}
+
+func TestDownload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "notes.txt")
+	os.WriteFile(path, []byte("hello, phone"), 0644)
+
+	m := NewManager(t.TempDir())
+	var progress progressLog
+	m.OnProgress(progress.record)
+	srv := serve(t, m)
+
+	offer, err := m.Offer("mobile-1", path, false)
+	if err != nil {
+		t.Fatalf("Offer() error = %v", err)
+	}
+	if offer.Name != "notes.txt" || offer.Size != 12 || offer.SHA256 != digest("hello, phone") || offer.Token == "" {
+		t.Fatalf("Offer() = %+v", offer)
+	}
+	url := srv.URL + "/download/" + offer.ID
+
+	// The token only works for the device it was issued to
+	for _, tt := range []struct{ device, token string }{
+		{"mobile-1", "wrong"},
+		{"mobile-1", ""},
+		{"mobile-2", offer.Token},
+	} {
+		if resp, _ := request(t, "GET", url, tt.device, tt.token, ""); resp.StatusCode != http.StatusForbidden {
+			t.Errorf("GET as %s with token %q = %d, want %d", tt.device, tt.token, resp.StatusCode, http.StatusForbidden)
+		}
+	}
+
+	if resp, _ := request(t, "GET", url, "mobile-1", offer.Token, "", "Range", "bytes=12-"); resp.StatusCode != http.StatusRequestedRangeNotSatisfiable {
+		t.Errorf("GET past the end = %d, want %d", resp.StatusCode, http.StatusRequestedRangeNotSatisfiable)
+	}
+
+	// An interrupted download resumes with a Range request
+	resp, body := request(t, "GET", url, "mobile-1", offer.Token, "", "Range", "bytes=7-")
+	if resp.StatusCode != http.StatusPartialContent || body != "phone" || resp.Header.Get("Content-Range") != "bytes 7-11/12" {
+		t.Errorf("GET with Range = %d %q (%s), want 206 %q", resp.StatusCode, body, resp.Header.Get("Content-Range"), "phone")
+	}
+	if got, _ := m.Get(offer.ID); got.State != StateComplete || got.Bytes != 12 {
+		t.Errorf("Get() after the download = %s with %d bytes, want complete with 12", got.State, got.Bytes)
+	}
+	if got := progress.last(); got != StateComplete {
+		t.Errorf("last progress report = %s, want %s", got, StateComplete)
+	}
+
+	// The token is spent once the file was downloaded
+	if resp, _ := request(t, "GET", url, "mobile-1", offer.Token, ""); resp.StatusCode != http.StatusGone {
+		t.Errorf("GET after completion = %d, want %d", resp.StatusCode, http.StatusGone)
+	}
+}
+
+func TestDownloadWhole(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "notes.txt")
+	os.WriteFile(path, []byte("hello, phone"), 0644)
+
+	m := NewManager(t.TempDir())
+	srv := serve(t, m)
+	offer, _ := m.Offer("mobile-1", path, false)
+
+	resp, body := request(t, "GET", srv.URL+"/download/"+offer.ID, "mobile-1", offer.Token, "")
+	if resp.StatusCode != http.StatusOK || body != "hello, phone" {
+		t.Errorf("GET = %d %q, want 200 with the file", resp.StatusCode, body)
+	}
+	if cd := resp.Header.Get("Content-Disposition"); cd != `attachment; filename=notes.txt` {
+		t.Errorf("Content-Disposition = %s", cd)
+	}
+
+	// A file changed after it was offered fails the transfer
+	changed, _ := m.Offer("mobile-1", path, false)
+	os.WriteFile(path, []byte("goodbye"), 0644)
+	if resp, _ := request(t, "GET", srv.URL+"/download/"+changed.ID, "mobile-1", changed.Token, ""); resp.StatusCode != http.StatusInternalServerError {
+		t.Errorf("GET of a changed file = %d, want %d", resp.StatusCode, http.StatusInternalServerError)
+	}
+	if got, _ := m.Get(changed.ID); got.State != StateFailed {
+		t.Errorf("Get() of a changed file = %s, want %s", got.State, StateFailed)
+	}
+}
+
+func TestDownloadPrepared(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "notes.txt")
+	os.WriteFile(path, []byte("hello, phone"), 0644)
+
+	m := NewManager(t.TempDir())
+	srv := serve(t, m)
+
+	file, err := PrepareFile(path)
+	if err != nil {
+		t.Fatalf("PrepareFile() error = %v", err)
+	}
+	for _, tt := range []struct {
+		prepared Prepared
+		name     string
+		data     string
+	}{
+		{file, "notes.txt", "hello, phone"},
+		{PrepareData("clipboard.png", []byte("png data")), "clipboard.png", "png data"},
+	} {
+		// Each device gets its own transfer of the same data
+		for _, deviceID := range []string{"mobile-1", "tablet-1"} {
+			offer, err := m.OfferPrepared(deviceID, tt.prepared)
+			if err != nil {
+				t.Fatalf("OfferPrepared() error = %v", err)
+			}
+			if offer.Name != tt.name || offer.Size != int64(len(tt.data)) || offer.SHA256 != digest(tt.data) {
+				t.Fatalf("OfferPrepared() = %+v", offer)
+			}
+
+			resp, body := request(t, "GET", srv.URL+"/download/"+offer.ID, deviceID, offer.Token, "", "Range", "bytes=4-")
+			if resp.StatusCode != http.StatusPartialContent || body != tt.data[4:] {
+				t.Errorf("GET %s with Range = %d %q, want 206 %q", tt.name, resp.StatusCode, body, tt.data[4:])
+			}
+			if got, _ := m.Get(offer.ID); got.State != StateComplete {
+				t.Errorf("Get() after the download = %s, want %s", got.State, StateComplete)
+			}
+		}
+	}
+
+	if _, err := PrepareFile(t.TempDir()); err == nil {
+		t.Error("PrepareFile() of a folder error = nil, want an error")
+	}
+}
+
+func TestUpload(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "photo.jpg"), []byte("older photo"), 0644)
+
+	m := NewManager(t.TempDir())
+	srv := serve(t, m)
+
+	data := "0123456789abcdef"
+	offer := &protocol.FileOfferPayload{ID: "file-1", Name: "../photo.jpg", Size: int64(len(data)), SHA256: digest(data)}
+	in, err := m.Incoming("mobile-1", offer)
+	if err != nil {
+		t.Fatalf("Incoming() error = %v", err)
+	}
+	url := srv.URL + "/upload/" + in.ID
+
+	if resp, _ := request(t, "POST", url, "mobile-1", "", data); resp.StatusCode != http.StatusConflict {
+		t.Errorf("POST before accepting = %d, want %d", resp.StatusCode, http.StatusConflict)
+	}
+
+	accepted, err := m.Accept(in.ID, dir, "")
+	if err != nil {
+		t.Fatalf("Accept() error = %v", err)
+	}
+
+	// The first chunk, then a chunk that doesn't continue where it ended
+	resp, _ := request(t, "POST", url, "mobile-1", accepted.Token, data[:6], "Content-Range", "bytes 0-5/16")
+	if resp.StatusCode != http.StatusNoContent || resp.Header.Get(OffsetHeader) != "6" {
+		t.Errorf("POST of the first chunk = %d, offset %s, want 204 and 6", resp.StatusCode, resp.Header.Get(OffsetHeader))
+	}
+	resp, _ = request(t, "POST", url, "mobile-1", accepted.Token, data[8:], "Content-Range", "bytes 8-15/16")
+	if resp.StatusCode != http.StatusConflict || resp.Header.Get(OffsetHeader) != "6" {
+		t.Errorf("POST of a gapped chunk = %d, offset %s, want 409 and 6", resp.StatusCode, resp.Header.Get(OffsetHeader))
+	}
+
+	// A repeated offer, e.g. after reconnecting, resumes the transfer
+	again, err := m.Incoming("mobile-1", offer)
+	if err != nil || again.Bytes != 6 || again.Token != accepted.Token {
+		t.Errorf("Incoming() again = %d bytes, %v, want the accepted transfer at 6", again.Bytes, err)
+	}
+
+	resp, _ = request(t, "POST", url, "mobile-1", accepted.Token, data[6:], "Content-Range", "bytes 6-15/16")
+	if resp.StatusCode != http.StatusCreated {
+		t.Errorf("POST of the last chunk = %d, want %d", resp.StatusCode, http.StatusCreated)
+	}
+
+	got, _ := m.Get(in.ID)
+	want := filepath.Join(dir, "photo (1).jpg")
+	if got.State != StateComplete || got.Path != want {
+		t.Fatalf("Get() after the upload = %s at %s, want complete at %s", got.State, got.Path, want)
+	}
+	if saved, _ := os.ReadFile(want); string(saved) != data {
+		t.Errorf("saved file = %q, want %q", saved, data)
+	}
+	if _, err := os.Stat(filepath.Join(dir, ".eco-file-1.part")); !os.IsNotExist(err) {
+		t.Errorf("partial file left behind: %v", err)
+	}
+}
+
+func TestUploadChecksum(t *testing.T) {
+	dir := t.TempDir()
+	m := NewManager(dir)
+	srv := serve(t, m)
+
+	m.Incoming("mobile-1", &protocol.FileOfferPayload{ID: "file-1", Name: "a.txt", Size: 5, SHA256: digest("hello")})
+	accepted, _ := m.Accept("file-1", "", "")
+
+	resp, _ := request(t, "POST", srv.URL+"/upload/file-1", "mobile-1", accepted.Token, "HELLO")
+	if resp.StatusCode != http.StatusUnprocessableEntity {
+		t.Errorf("POST of a corrupted file = %d, want %d", resp.StatusCode, http.StatusUnprocessableEntity)
+	}
+	if got, _ := m.Get("file-1"); got.State != StateFailed || got.Error != ErrChecksum.Error() {
+		t.Errorf("Get() = %s (%s), want failed with %v", got.State, got.Error, ErrChecksum)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("files left after a failed upload: %v", entries)
+	}
+}
+
+func TestIncoming(t *testing.T) {
+	m := NewManager(t.TempDir())
+	offer := &protocol.FileOfferPayload{ID: "file-1", Name: "a.txt", Size: 5, SHA256: digest("hello")}
+	m.Incoming("mobile-1", offer)
+
+	if _, err := m.Incoming("mobile-2", offer); err == nil {
+		t.Error("Incoming() with another device's transfer id succeeded")
+	}
+	if _, err := m.Incoming("mobile-1", &protocol.FileOfferPayload{ID: "file-2", Name: "..", SHA256: digest("")}); err == nil {
+		t.Error("Incoming() of a file named .. succeeded")
+	}
+	if _, err := m.Accept("file-1", "", ""); err != nil {
+		t.Fatalf("Accept() error = %v", err)
+	}
+	if _, err := m.Accept("file-1", "", ""); err == nil {
+		t.Error("Accept() twice succeeded")
+	}
+}
+
+func TestCancel(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "a.txt")
+	os.WriteFile(path, []byte("hello"), 0644)
+
+	m := NewManager(t.TempDir())
+	srv := serve(t, m)
+	offer, _ := m.Offer("mobile-1", path, false)
+
+	if _, err := m.Cancel("mobile-2", offer.ID, ""); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Cancel() by another device error = %v, want %v", err, ErrNotFound)
+	}
+	if err := m.Accepted("mobile-1", offer.ID); err != nil {
+		t.Errorf("Accepted() error = %v", err)
+	}
+	got, err := m.Cancel("mobile-1", offer.ID, "no space left")
+	if err != nil || got.State != StateCancelled || got.Error != "no space left" {
+		t.Errorf("Cancel() = %s (%s), %v, want cancelled", got.State, got.Error, err)
+	}
+	if _, err := m.Cancel("", offer.ID, ""); !errors.Is(err, ErrFinished) {
+		t.Errorf("Cancel() twice error = %v, want %v", err, ErrFinished)
+	}
+	if resp, _ := request(t, "GET", srv.URL+"/download/"+offer.ID, "mobile-1", offer.Token, ""); resp.StatusCode != http.StatusGone {
+		t.Errorf("GET after cancelling = %d, want %d", resp.StatusCode, http.StatusGone)
+	}
+}
+
+func TestExpire(t *testing.T) {
+	m := NewManager(t.TempDir())
+	m.ttl = 10 * time.Millisecond
+	m.Incoming("mobile-1", &protocol.FileOfferPayload{ID: "file-1", Name: "a.txt", Size: 5, SHA256: digest("hello")})
+
+	time.Sleep(20 * time.Millisecond)
+	if got, _ := m.Get("file-1"); got.State != StateFailed || got.Error != "expired" {
+		t.Errorf("Get() of an idle transfer = %s (%s), want failed with expired", got.State, got.Error)
+	}
+
+	time.Sleep(20 * time.Millisecond)
+	if transfers := m.List(); len(transfers) != 0 {
+		t.Errorf("List() = %v, want expired transfers forgotten", transfers)
+	}
+}
+
+func TestSafeName(t *testing.T) {
+	tests := []struct {
+		name    string
+		want    string
+		wantErr bool
+	}{
+		{"photo.jpg", "photo.jpg", false},
+		{"DCIM/photo.jpg", "photo.jpg", false},
+		{"../../.bashrc", ".bashrc", false},
+		{`C:\Users\me\photo.jpg`, "photo.jpg", false},
+		{"..", "", true},
+		{"", "", true},
+		{"/", "", true},
+	}
+	for _, tt := range tests {
+		got, err := safeName(tt.name)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("safeName(%q) = %q, %v, want %q", tt.name, got, err, tt.want)
+		}
+	}
+}
+
+func TestParseContentRange(t *testing.T) {
+	tests := []struct {
+		header      string
+		start, size int64
+		wantErr     bool
+	}{
+		{"", 0, 100, false},
+		{"bytes 0-49/100", 0, 50, false},
+		{"bytes 50-99/100", 50, 50, false},
+		{"bytes 50-100/100", 0, 0, true},
+		{"bytes 0-49/200", 0, 0, true},
+		{"bytes 9-3/100", 0, 0, true},
+		{"items 0-49/100", 0, 0, true},
+	}
+	for _, tt := range tests {
+		start, length, err := parseContentRange(tt.header, 100)
+		if (err != nil) != tt.wantErr || start != tt.start || length != tt.size {
+			t.Errorf("parseContentRange(%q) = %d, %d, %v, want %d, %d", tt.header, start, length, err, tt.start, tt.size)
+		}
+	}
+}
@@ -210,9 +210,32 @@ const EcoApp = {
     }
   },
   
+  async copyImageToClipboard(mime, base64) {
+    try {
+      const bytes = Uint8Array.from(atob(base64), (c) => c.charCodeAt(0));
+      const blob = new Blob([bytes], { type: mime });
+      await navigator.clipboard.write([new ClipboardItem({ [mime]: blob })]);
+      return true;
+    } catch (error) {
+      console.error('Clipboard error:', error);
+      return false;
+    }
+  },
+  
+  // Text to show for clipboard content: images and file lists are summarized
+  clipboardText(payload) {
+    if (payload.encoding === 'base64') {
+      return `[${payload.mime || 'binary'} image]`;
+    }
+    if (payload.mime === 'text/uri-list' && payload.files) {
+      return payload.files.map((f) => f.name).join('\n');
+    }
+    return payload.data;
+  },
+  
   handleClipboardChange(payload) {
     if (payload && payload.data) {
-      this.clipboardHistory.unshift(payload.data);
+      this.clipboardHistory.unshift(this.clipboardText(payload));
       if (this.clipboardHistory.length > 10) {
         this.clipboardHistory.pop();
       }
@@ -221,10 +244,13 @@ const EcoApp = {
   },
   
   handleClipboardSet(payload) {
-    if (payload && payload.data) {
+    if (!payload || !payload.data) return;
+    if (payload.encoding === 'base64' && (payload.mime || '').startsWith('image/')) {
+      this.copyImageToClipboard(payload.mime, payload.data);
+    } else {
       this.copyToClipboard(payload.data);
-      this.showToast('Clipboard updated from server', 'success');
     }
+    this.showToast('Clipboard updated from server', 'success');
   },
   
   handleNotification(payload) {
@@ -359,17 +385,33 @@ const EcoApp = {
     }
   },
   
+  // QR codes carry the daemon's base URL; the daemon serves wss:// when it
+  // serves https://
+  toWebSocketURL(server) {
+    if (server.startsWith('ws://') || server.startsWith('wss://')) {
+      return server;
+    }
+    if (server.startsWith('https://')) {
+      return 'wss://' + server.slice('https://'.length) + '/ws';
+    }
+    if (server.startsWith('http://')) {
+      return 'ws://' + server.slice('http://'.length) + '/ws';
+    }
+    return 'ws://' + server + '/ws';
+  },
+  
   checkQRParams() {
     const params = new URLSearchParams(window.location.search);
     const server = params.get('server');
     const secret = params.get('secret');
+    const deviceId = params.get('device_id');
+    
+    if (deviceId) {
+      localStorage.setItem('eco_device_id', deviceId);
+    }
     
     if (server) {
-      let wsUrl = server;
-      if (!server.startsWith('ws://') && !server.startsWith('wss://')) {
-        wsUrl = 'ws://' + server + '/ws';
-      }
-      this.elements.serverUrl.value = wsUrl;
+      this.elements.serverUrl.value = this.toWebSocketURL(server);
     }
     
     if (secret) {
@@ -454,13 +496,14 @@ const EcoApp = {
       const params = new URLSearchParams(data.replace('eco://connect?', ''));
       const server = params.get('server');
       const secret = params.get('secret');
+      const deviceId = params.get('device_id');
+      
+      if (deviceId) {
+        localStorage.setItem('eco_device_id', deviceId);
+      }
       
       if (server) {
-        let wsUrl = server;
-        if (!server.startsWith('ws://') && !server.startsWith('wss://')) {
-          wsUrl = 'ws://' + server + '/ws';
-        }
-        this.elements.serverUrl.value = wsUrl;
+        this.elements.serverUrl.value = this.toWebSocketURL(server);
       }
       
       if (secret) {
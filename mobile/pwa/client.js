@@ -42,20 +42,12 @@ class EcoClient {
         this.ws = new WebSocket(this.serverUrl);
 
         this.ws.onopen = () => {
-          console.log('[Eco] Connected to server');
-          this.connected = true;
-          this.reconnectAttempts = 0;
-          this.reconnecting = false;
-
-          this.sendHello();
-          this.flushQueue();
-          this.startHeartbeat();
-
-          resolve();
+          // Nothing is sent until the server's auth.challenge is answered
+          console.log('[Eco] Socket open, waiting for challenge');
         };
 
         this.ws.onmessage = (event) => {
-          this.handleMessage(event.data);
+          this.handleMessage(event.data, resolve, reject);
         };
 
         this.ws.onclose = (event) => {
@@ -82,7 +74,6 @@ class EcoClient {
       this.send({
         type: 'device.disconnect',
         device_id: this.deviceId,
-        secret: this.secret,
         payload: null
       });
       this.ws.close(1000, 'Client disconnect');
@@ -95,15 +86,17 @@ class EcoClient {
     this.send({
       type: 'device.hello',
       device_id: this.deviceId,
-      secret: this.secret,
       payload: {
-        device_name: this.deviceName
+        device_name: this.deviceName,
+        protocol_version: 1,
+        platform: 'web',
+        capabilities: ['clipboard', 'notifications']
       }
     });
   }
 
   send(data) {
-    if (!this.ws || this.ws.readyState !== WebSocket.OPEN) {
+    if (!this.connected || !this.ws || this.ws.readyState !== WebSocket.OPEN) {
       this.messageQueue.push(data);
       return false;
     }
@@ -125,11 +118,68 @@ class EcoClient {
     }
   }
 
-  handleMessage(data) {
+  // proof = hex(HMAC-SHA256(secret, "eco-auth-v1" || nonce || device_id)).
+  // WebCrypto is only available in secure contexts (https or localhost).
+  async computeAuthProof(nonceHex) {
+    const enc = new TextEncoder();
+    const nonce = new Uint8Array(nonceHex.match(/.{2}/g).map((b) => parseInt(b, 16)));
+    const context = enc.encode('eco-auth-v1');
+    const id = enc.encode(this.deviceId);
+
+    const message = new Uint8Array(context.length + nonce.length + id.length);
+    message.set(context);
+    message.set(nonce, context.length);
+    message.set(id, context.length + nonce.length);
+
+    const key = await crypto.subtle.importKey(
+      'raw', enc.encode(this.secret), { name: 'HMAC', hash: 'SHA-256' }, false, ['sign']
+    );
+    const mac = new Uint8Array(await crypto.subtle.sign('HMAC', key, message));
+    return Array.from(mac, (b) => b.toString(16).padStart(2, '0')).join('');
+  }
+
+  async answerChallenge(payload) {
+    const proof = await this.computeAuthProof(payload.nonce);
+    this.ws.send(JSON.stringify({
+      type: 'auth.response',
+      device_id: this.deviceId,
+      payload: { proof }
+    }));
+  }
+
+  handleMessage(data, resolve, reject) {
     try {
       const msg = JSON.parse(data);
       console.log('[Eco] Received:', msg.type);
 
+      if (msg.type === 'auth.challenge') {
+        this.answerChallenge(msg.payload).catch((error) => {
+          console.error('[Eco] Failed to answer challenge:', error);
+          if (reject) reject(error);
+        });
+        return;
+      }
+
+      if (msg.type === 'auth.result') {
+        if (!msg.payload.ok) {
+          console.error('[Eco] Authentication failed:', msg.payload.error);
+          if (reject) reject(new Error(msg.payload.error || 'Authentication failed'));
+          return;
+        }
+
+        console.log('[Eco] Authenticated');
+        this.connected = true;
+        this.reconnectAttempts = 0;
+        this.reconnecting = false;
+
+        this.sendHello();
+        this.flushQueue();
+        this.startHeartbeat();
+
+        if (resolve) resolve();
+        return;
+      }
+
       if (msg.type === 'device.ping') {
         this.sendPong();
         return;
@@ -153,7 +203,6 @@ class EcoClient {
     this.send({
       type: 'device.ping',
       device_id: this.deviceId,
-      secret: this.secret,
       payload: null
     });
   }
@@ -164,7 +213,7 @@ class EcoClient {
     this.heartbeatInterval = setInterval(() => {
       if (this.ws && this.ws.readyState === WebSocket.OPEN) {
         try {
-          this.ws.send(JSON.stringify({ type: 'device.ping', device_id: this.deviceId, secret: this.secret, payload: null }));
+          this.ws.send(JSON.stringify({ type: 'device.ping', device_id: this.deviceId, payload: null }));
         } catch (e) {}
       }
     }, 30000);
@@ -216,7 +265,6 @@ class EcoClient {
       this.send({
         type: 'clipboard.changed',
         device_id: this.deviceId,
-        secret: this.secret,
         payload: { data: text }
       });
       return true;
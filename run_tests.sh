@@ -109,6 +109,66 @@ if [ "$RUN_UNIT" = true ]; then
     if ! run_test "Config Tests" "go test ./internal/config/... -v"; then
         ALL_PASSED=false
     fi
+    
+    if ! run_test "Event Bus Tests" "go test ./internal/bus/... -v"; then
+        ALL_PASSED=false
+    fi
+    
+    if ! run_test "Control Socket Tests" "go test ./internal/control/... -v"; then
+        ALL_PASSED=false
+    fi
+    
+    if ! run_test "Auth Tests" "go test ./internal/auth/... -v"; then
+        ALL_PASSED=false
+    fi
+    
+    if ! run_test "Crypto Tests" "go test ./internal/crypto/... -v"; then
+        ALL_PASSED=false
+    fi
+    
+    if ! run_test "Pairing Tests" "go test ./internal/pairing/... -v"; then
+        ALL_PASSED=false
+    fi
+    
+    if ! run_test "TLS Certificate Tests" "go test ./internal/tlscert/... -v"; then
+        ALL_PASSED=false
+    fi
+    
+    if ! run_test "Offline Queue Tests" "go test ./internal/queue/... -v"; then
+        ALL_PASSED=false
+    fi
+    
+    if ! run_test "Device Connection Tests" "go test ./internal/device/... -v"; then
+        ALL_PASSED=false
+    fi
+    
+    if ! run_test "Message Dispatch Tests" "go test ./internal/dispatch/... -v"; then
+        ALL_PASSED=false
+    fi
+    
+    if ! run_test "Clipboard Tests" "go test ./internal/clipboard/... -v"; then
+        ALL_PASSED=false
+    fi
+    
+    if ! run_test "Event Router Tests" "go test ./internal/events/... -v"; then
+        ALL_PASSED=false
+    fi
+    
+    if ! run_test "Notification Tests" "go test ./internal/notifications/... -v"; then
+        ALL_PASSED=false
+    fi
+    
+    if ! run_test "SMS Tests" "go test ./internal/sms/... -v"; then
+        ALL_PASSED=false
+    fi
+    
+    if ! run_test "Transfer Tests" "go test ./internal/transfer/... -v"; then
+        ALL_PASSED=false
+    fi
+    
+    if ! run_test "Input Tests" "go test ./internal/input/... -v"; then
+        ALL_PASSED=false
+    fi
 fi
 
 # Run Go integration tests
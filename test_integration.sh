@@ -19,6 +19,11 @@ TESTS_FAILED=0
 TEMP_DIR=$(mktemp -d)
 BINARY_PATH="$TEMP_DIR/eco"
 ORIGINAL_HOME="$HOME"
+if [ -n "$XDG_RUNTIME_DIR" ]; then
+    SOCKET_PATH="$XDG_RUNTIME_DIR/eco/eco.sock"
+else
+    SOCKET_PATH="${TMPDIR:-/tmp}/eco-$(id -u)/eco.sock"
+fi
 
 # Cleanup function
 cleanup() {
@@ -26,10 +31,9 @@ cleanup() {
     echo "Cleaning up..."
     # Restore original home
     export HOME="$ORIGINAL_HOME"
-    # Kill any running daemon
-    if [ -f "/tmp/eco.pid" ]; then
-        kill $(cat /tmp/eco.pid) 2>/dev/null || true
-        rm -f /tmp/eco.pid
+    # Stop any running daemon
+    if [ -S "$SOCKET_PATH" ]; then
+        "$BINARY_PATH" stop &>/dev/null || true
     fi
     # Remove temp directory
     rm -rf "$TEMP_DIR"
@@ -186,20 +190,18 @@ test_daemon() {
     # Wait for daemon to start
     sleep 2
     
-    # Check PID file
-    if [ -f "/tmp/eco.pid" ]; then
-        pass "Daemon created PID file"
-        local pid_from_file
-        pid_from_file=$(cat /tmp/eco.pid)
+    # Check control socket
+    if [ -S "$SOCKET_PATH" ]; then
+        pass "Daemon created control socket"
         
-        # Verify process is running
-        if ps -p "$pid_from_file" > /dev/null 2>&1; then
-            pass "Daemon process is running (PID: $pid_from_file)"
+        # Verify daemon answers over the socket
+        if $BINARY_PATH status 2>&1 | grep -q "Daemon:      running"; then
+            pass "Daemon reports running status"
         else
-            fail "Daemon process not running"
+            fail "Daemon status not reported"
         fi
     else
-        fail "Daemon PID file not created"
+        fail "Daemon control socket not created"
         kill $daemon_pid 2>/dev/null || true
         return
     fi
@@ -218,10 +220,10 @@ test_daemon() {
     sleep 1
     
     # Verify daemon stopped
-    if [ ! -f "/tmp/eco.pid" ]; then
-        pass "PID file removed after stop"
+    if [ ! -S "$SOCKET_PATH" ]; then
+        pass "Control socket removed after stop"
     else
-        fail "PID file not removed after stop"
+        fail "Control socket not removed after stop"
     fi
 }
 
@@ -229,9 +231,6 @@ test_daemon() {
 test_stop_not_running() {
     info "Testing stop when daemon not running..."
     
-    # Ensure no PID file
-    rm -f /tmp/eco.pid
-    
     local output
     output=$($BINARY_PATH stop 2>&1)
     